@@ -3,6 +3,7 @@ package admin
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"sort"
 	"strconv"
 	"sync"
@@ -11,6 +12,8 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer/kazoo"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
 )
@@ -21,8 +24,19 @@ type (
 
 const (
 	ProtocolVer1 = 1 // Supported by Kafka v0.8.2 and later
+
+	deleteRecordsTimeout = 30 * time.Second
+	createTopicTimeout   = 30 * time.Second
 )
 
+// ErrRecordsDeletionDisabled is returned by DeleteRecords when the records
+// deletion API has not been enabled for the cluster.
+var ErrRecordsDeletionDisabled = errors.New("records deletion is disabled, set admin.allow_records_deletion to enable")
+
+// ErrTopicDeletionDisabled is returned by DeleteTopic when the topic
+// deletion API has not been enabled for the cluster.
+var ErrTopicDeletionDisabled = errors.New("topic deletion is disabled, set admin.allow_topic_deletion to enable")
+
 // T provides methods to perform administrative operations on a Kafka cluster.
 type T struct {
 	parentActDesc *actor.Descriptor
@@ -62,6 +76,15 @@ type PartitionOffset struct {
 	Metadata  string
 }
 
+// GroupPartitionLag describes how far the offset committed by a consumer
+// group for a partition is behind the log end offset of the partition.
+type GroupPartitionLag struct {
+	Partition int32
+	Offset    int64
+	End       int64
+	Lag       int64
+}
+
 type PartitionMetadata struct {
 	ID       int32
 	Leader   int32
@@ -241,9 +264,769 @@ func (a *T) setGroupOffsets(group, topic string, offsets []PartitionOffset) erro
 	return nil
 }
 
+// DeleteRecords deletes all records of a topic partition with offsets older
+// than beforeOffset and returns the new low watermark of the partition. The
+// operation is irreversible and must be explicitly enabled for the cluster
+// via the admin.allow_records_deletion parameter.
+func (a *T) DeleteRecords(topic string, partition int32, beforeOffset int64) (int64, error) {
+	if !a.cfg.Admin.AllowRecordsDeletion {
+		return 0, ErrRecordsDeletionDisabled
+	}
+	lowWatermark, err := a.deleteRecords(topic, partition, beforeOffset)
+	if err != nil {
+		a.ResetKafkaClt()
+		return a.deleteRecords(topic, partition, beforeOffset)
+	}
+	return lowWatermark, nil
+}
+
+func (a *T) deleteRecords(topic string, partition int32, beforeOffset int64) (int64, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return 0, err
+	}
+	broker, err := kafkaClt.Leader(topic, partition)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get partition leader, partition=%d", partition)
+	}
+	req := sarama.DeleteRecordsRequest{
+		Topics: map[string]*sarama.DeleteRecordsRequestTopic{
+			topic: {PartitionOffsets: map[int32]int64{partition: beforeOffset}},
+		},
+		Timeout: deleteRecordsTimeout,
+	}
+	res, err := broker.DeleteRecords(&req)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete records")
+	}
+	resTopic := res.Topics[topic]
+	if resTopic == nil {
+		return 0, errors.Errorf("delete records response block is missing, topic=%s", topic)
+	}
+	resPartition := resTopic.Partitions[partition]
+	if resPartition == nil {
+		return 0, errors.Errorf("delete records response block is missing, partition=%d", partition)
+	}
+	if resPartition.Err != sarama.ErrNoError {
+		return 0, errors.Wrapf(resPartition.Err, "failed to delete records, partition=%d", partition)
+	}
+	return resPartition.LowWatermark, nil
+}
+
+// ErrGroupHasOffsets is returned as an error cause when the destination
+// group of a clone operation already has offsets committed for the topic.
+var ErrGroupHasOffsets = errors.New("destination group already has committed offsets")
+
+// CloneGroupOffsets copies the offsets committed by the source group for a
+// topic into the destination group, so that a parallel consumer, e.g. for
+// shadow testing, can be spun up starting exactly where the source group is.
+// To prevent accidental clobbering of a live group the destination group must
+// not have any offsets committed for the topic yet. The offsets committed to
+// the destination group are returned.
+func (a *T) CloneGroupOffsets(fromGroup, toGroup, topic string) ([]PartitionOffset, error) {
+	offsets, err := a.cloneGroupOffsets(fromGroup, toGroup, topic)
+	if err != nil && errors.Cause(err) != ErrGroupHasOffsets {
+		a.ResetKafkaClt()
+		return a.cloneGroupOffsets(fromGroup, toGroup, topic)
+	}
+	return offsets, err
+}
+
+func (a *T) cloneGroupOffsets(fromGroup, toGroup, topic string) ([]PartitionOffset, error) {
+	toOffsets, err := a.getGroupOffsets(toGroup, topic)
+	if err != nil {
+		return nil, err
+	}
+	for _, po := range toOffsets {
+		if po.Offset >= 0 {
+			return nil, errors.Wrapf(ErrGroupHasOffsets, "group=%s, topic=%s, partition=%d",
+				toGroup, topic, po.Partition)
+		}
+	}
+	fromOffsets, err := a.getGroupOffsets(fromGroup, topic)
+	if err != nil {
+		return nil, err
+	}
+	// Only partitions that the source group has offsets committed for are
+	// cloned, for the others the destination group will follow its own
+	// consumption start policy.
+	cloned := fromOffsets[:0]
+	for _, po := range fromOffsets {
+		if po.Offset >= 0 {
+			cloned = append(cloned, po)
+		}
+	}
+	if err := a.setGroupOffsets(toGroup, topic, cloned); err != nil {
+		return nil, err
+	}
+	return cloned, nil
+}
+
+// OffsetTimePreview describes where a reset of the committed offset of a
+// partition to a point in time would move it, and how many messages the move
+// would replay or skip.
+type OffsetTimePreview struct {
+	Partition int32
+	Begin     int64
+	End       int64
+	Offset    int64
+	Target    int64
+	Replayed  int64
+	Skipped   int64
+}
+
+// PreviewGroupOffsets reports where a reset of the committed offsets of the
+// specified group to the given time would move each partition of a topic.
+// Nothing is committed, the function is a dry run for SetGroupOffsets that
+// lets operators sanity-check a reset before performing it.
+func (a *T) PreviewGroupOffsets(group, topic string, t time.Time) ([]OffsetTimePreview, error) {
+	previews, err := a.previewGroupOffsets(group, topic, t)
+	if err != nil {
+		a.ResetKafkaClt()
+		return a.previewGroupOffsets(group, topic, t)
+	}
+	return previews, nil
+}
+
+func (a *T) previewGroupOffsets(group, topic string, t time.Time) ([]OffsetTimePreview, error) {
+	offsets, err := a.getGroupOffsets(group, topic)
+	if err != nil {
+		return nil, err
+	}
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+	timeMs := t.UnixNano() / int64(time.Millisecond)
+	previews := make([]OffsetTimePreview, len(offsets))
+	for i, po := range offsets {
+		target, err := kafkaClt.GetOffset(topic, po.Partition, timeMs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get offset by time, partition=%d", po.Partition)
+		}
+		if target < 0 {
+			// No message was produced at or after t, a reset would skip to
+			// the high watermark.
+			target = po.End
+		}
+		previews[i] = OffsetTimePreview{
+			Partition: po.Partition,
+			Begin:     po.Begin,
+			End:       po.End,
+			Offset:    po.Offset,
+			Target:    target,
+		}
+		// If nothing has been committed for the partition yet, then there is
+		// no position to compare the target with.
+		if po.Offset < 0 {
+			continue
+		}
+		if target < po.Offset {
+			previews[i].Replayed = po.Offset - target
+		} else {
+			previews[i].Skipped = target - po.Offset
+		}
+	}
+	return previews, nil
+}
+
+// GroupExport is a portable snapshot of consumer group state intended for
+// disaster recovery, e.g. for migrating a group to another ZooKeeper ensemble
+// or Kafka-Pixy fleet.
+type GroupExport struct {
+	Group      string
+	ExportedAt time.Time
+
+	// Members maps IDs of the registered group members to the topics they
+	// are subscribed to. Only available when the admin backend is ZooKeeper.
+	Members map[string][]string
+
+	// Consumers maps consumed topics to member IDs to the partitions they
+	// own.
+	Consumers map[string]map[string][]int32
+
+	// Offsets maps topics to the offsets committed by the group. Only the
+	// Partition, Offset and Metadata fields of PartitionOffset are set.
+	Offsets map[string][]PartitionOffset
+}
+
+// ExportGroup snapshots the state of a consumer group: subscriptions of its
+// registered members, partition ownership, and committed offsets for all
+// topics. The committed offsets part of the snapshot can be applied to
+// another cluster or group with RestoreGroup.
+func (a *T) ExportGroup(group string) (GroupExport, error) {
+	exp, err := a.exportGroup(group)
+	if err != nil {
+		a.ResetKafkaClt()
+		return a.exportGroup(group)
+	}
+	return exp, nil
+}
+
+func (a *T) exportGroup(group string) (GroupExport, error) {
+	exp := GroupExport{Group: group, ExportedAt: time.Now().UTC()}
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return exp, err
+	}
+	coordinator, err := kafkaClt.Coordinator(group)
+	if err != nil {
+		return exp, errors.Wrap(err, "failed to get coordinator")
+	}
+	// An OffsetFetch v2 request without partitions returns offsets committed
+	// for all topic partitions of the group.
+	req := sarama.OffsetFetchRequest{ConsumerGroup: group, Version: 2}
+	res, err := coordinator.FetchOffset(&req)
+	if err != nil {
+		return exp, errors.Wrap(err, "failed to fetch offsets")
+	}
+	exp.Offsets = make(map[string][]PartitionOffset)
+	topicSet := make(map[string]bool)
+	for topic, blocks := range res.Blocks {
+		for partition, block := range blocks {
+			if block.Err != sarama.ErrNoError {
+				return exp, errors.Wrapf(block.Err, "failed to fetch offset, topic=%s, partition=%d",
+					topic, partition)
+			}
+			if block.Offset < 0 {
+				continue
+			}
+			exp.Offsets[topic] = append(exp.Offsets[topic], PartitionOffset{
+				Partition: partition,
+				Offset:    block.Offset,
+				Metadata:  block.Metadata,
+			})
+		}
+		topicSet[topic] = true
+	}
+	for _, offsets := range exp.Offsets {
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i].Partition < offsets[j].Partition })
+	}
+	if a.cfg.Admin.Backend != "kafka" {
+		if err := a.exportGroupZK(group, &exp, topicSet); err != nil {
+			return exp, err
+		}
+	}
+	// Collect partition ownership for every topic the group commits offsets
+	// for or owns partitions of.
+	exp.Consumers = make(map[string]map[string][]int32)
+	for topic := range topicSet {
+		topicConsumers, err := a.GetTopicConsumers(group, topic)
+		if err != nil {
+			// The group may be registered but not consuming the topic at the
+			// moment, that does not invalidate the rest of the snapshot.
+			if _, ok := err.(ErrInvalidParam); ok {
+				continue
+			}
+			return exp, errors.Wrapf(err, "failed to fetch topic consumers, topic=%s", topic)
+		}
+		if len(topicConsumers) > 0 {
+			exp.Consumers[topic] = topicConsumers
+		}
+	}
+	return exp, nil
+}
+
+// exportGroupZK adds member subscriptions and owned topics recorded in
+// ZooKeeper to the group snapshot. A group unknown to ZooKeeper is not an
+// error, for it may be consumed via the Kafka group API only.
+func (a *T) exportGroupZK(group string, exp *GroupExport, topicSet map[string]bool) error {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return err
+	}
+	membersPath := fmt.Sprintf("%s/consumers/%s/ids", a.cfg.ZooKeeper.Chroot, group)
+	members, _, err := zkConn.Children(membersPath)
+	if err != nil && err != zk.ErrNoNode {
+		return errors.Wrap(err, "failed to fetch group members")
+	}
+	exp.Members = make(map[string][]string)
+	for _, memberID := range members {
+		memberSpecJSON, _, err := zkConn.Get(fmt.Sprintf("%s/%s", membersPath, memberID))
+		if err != nil {
+			if err == zk.ErrNoNode {
+				continue
+			}
+			return errors.Wrapf(err, "failed to fetch member spec, member=%s", memberID)
+		}
+		var memberSpec struct {
+			Subscription map[string]int `json:"subscription"`
+		}
+		if err := json.Unmarshal(memberSpecJSON, &memberSpec); err != nil {
+			return errors.Wrapf(err, "while parsing member %s, data=%s", memberID, string(memberSpecJSON))
+		}
+		topics := make([]string, 0, len(memberSpec.Subscription))
+		for topic := range memberSpec.Subscription {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		exp.Members[memberID] = topics
+	}
+	ownersPath := fmt.Sprintf("%s/consumers/%s/owners", a.cfg.ZooKeeper.Chroot, group)
+	ownedTopics, _, err := zkConn.Children(ownersPath)
+	if err != nil && err != zk.ErrNoNode {
+		return errors.Wrap(err, "failed to fetch owned topics")
+	}
+	for _, topic := range ownedTopics {
+		topicSet[topic] = true
+	}
+	return nil
+}
+
+// RestoreGroup commits the offsets recorded in a group snapshot on behalf of
+// the specified group, which does not need to be the group the snapshot was
+// taken from. Member subscriptions and partition ownership are runtime state
+// that consumers recreate when they join the group, so only the offsets part
+// of the snapshot is applied.
+func (a *T) RestoreGroup(group string, exp GroupExport) error {
+	for topic, offsets := range exp.Offsets {
+		committed := offsets[:0]
+		for _, po := range offsets {
+			if po.Offset >= 0 {
+				committed = append(committed, po)
+			}
+		}
+		if len(committed) == 0 {
+			continue
+		}
+		if err := a.SetGroupOffsets(group, topic, committed); err != nil {
+			return errors.Wrapf(err, "failed to restore offsets, topic=%s", topic)
+		}
+	}
+	return nil
+}
+
+// CreateTopic creates a topic with the given number of partitions,
+// replication factor and optional topic level config entries. An attempt to
+// create a topic that already exists is not an error, so concurrent creation
+// by several Kafka-Pixy instances is safe.
+func (a *T) CreateTopic(topic string, numPartitions int32, replicationFactor int16, config map[string]string) error {
+	if err := a.createTopic(topic, numPartitions, replicationFactor, config); err != nil {
+		a.ResetKafkaClt()
+		return a.createTopic(topic, numPartitions, replicationFactor, config)
+	}
+	return nil
+}
+
+func (a *T) createTopic(topic string, numPartitions int32, replicationFactor int16, config map[string]string) error {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return err
+	}
+	controller, err := kafkaClt.Controller()
+	if err != nil {
+		return errors.Wrap(err, "failed to get cluster controller")
+	}
+	configEntries := make(map[string]*string, len(config))
+	for name, value := range config {
+		value := value
+		configEntries[name] = &value
+	}
+	req := sarama.CreateTopicsRequest{
+		TopicDetails: map[string]*sarama.TopicDetail{
+			topic: {NumPartitions: numPartitions, ReplicationFactor: replicationFactor, ConfigEntries: configEntries},
+		},
+		Timeout: createTopicTimeout,
+	}
+	res, err := controller.CreateTopics(&req)
+	if err != nil {
+		return errors.Wrap(err, "failed to create topic")
+	}
+	resTopic := res.TopicErrors[topic]
+	if resTopic == nil {
+		return errors.Errorf("create topics response block is missing, topic=%s", topic)
+	}
+	if resTopic.Err != sarama.ErrNoError && resTopic.Err != sarama.ErrTopicAlreadyExists {
+		return errors.Wrapf(resTopic.Err, "failed to create topic %s", topic)
+	}
+	return nil
+}
+
+// DeleteTopic deletes a topic along with all its messages. The operation is
+// irreversible and must be explicitly enabled for the cluster via the
+// admin.allow_topic_deletion parameter.
+func (a *T) DeleteTopic(topic string) error {
+	if !a.cfg.Admin.AllowTopicDeletion {
+		return ErrTopicDeletionDisabled
+	}
+	if err := a.deleteTopic(topic); err != nil {
+		a.ResetKafkaClt()
+		return a.deleteTopic(topic)
+	}
+	return nil
+}
+
+func (a *T) deleteTopic(topic string) error {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return err
+	}
+	controller, err := kafkaClt.Controller()
+	if err != nil {
+		return errors.Wrap(err, "failed to get cluster controller")
+	}
+	req := sarama.DeleteTopicsRequest{Topics: []string{topic}, Timeout: createTopicTimeout}
+	res, err := controller.DeleteTopics(&req)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete topic")
+	}
+	kerr, ok := res.TopicErrorCodes[topic]
+	if !ok {
+		return errors.Errorf("delete topics response block is missing, topic=%s", topic)
+	}
+	if kerr != sarama.ErrNoError {
+		return errors.Wrapf(kerr, "failed to delete topic %s", topic)
+	}
+	return nil
+}
+
+// AlterTopicConfig sets the specified topic level config entries, keeping
+// entries that are not mentioned intact.
+func (a *T) AlterTopicConfig(topic string, config map[string]string) error {
+	if err := a.alterTopicConfig(topic, config); err != nil {
+		a.ResetKafkaClt()
+		return a.alterTopicConfig(topic, config)
+	}
+	return nil
+}
+
+func (a *T) alterTopicConfig(topic string, config map[string]string) error {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return err
+	}
+	controller, err := kafkaClt.Controller()
+	if err != nil {
+		return errors.Wrap(err, "failed to get cluster controller")
+	}
+	return a.repairTopicConfig(controller, topic, config)
+}
+
+// EnsureTopic makes sure the topic exists with the specified number of
+// partitions, replication factor and topic level config entries. If the topic
+// is missing then it is created; if it already exists then its partition
+// count is grown and its config entries are altered to the desired values as
+// necessary. Partitions cannot be removed nor can the replication factor be
+// changed through the Kafka Admin API, so such differences are only reported
+// in the returned warnings.
+func (a *T) EnsureTopic(topic string, numPartitions int32, replicationFactor int16, config map[string]string) ([]string, error) {
+	warnings, err := a.ensureTopic(topic, numPartitions, replicationFactor, config)
+	if err != nil {
+		a.ResetKafkaClt()
+		return a.ensureTopic(topic, numPartitions, replicationFactor, config)
+	}
+	return warnings, nil
+}
+
+func (a *T) ensureTopic(topic string, numPartitions int32, replicationFactor int16, config map[string]string) ([]string, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+	controller, err := kafkaClt.Controller()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get cluster controller")
+	}
+	configEntries := make(map[string]*string, len(config))
+	for name, value := range config {
+		value := value
+		configEntries[name] = &value
+	}
+	req := sarama.CreateTopicsRequest{
+		TopicDetails: map[string]*sarama.TopicDetail{
+			topic: {
+				NumPartitions:     numPartitions,
+				ReplicationFactor: replicationFactor,
+				ConfigEntries:     configEntries,
+			},
+		},
+		Timeout: createTopicTimeout,
+	}
+	res, err := controller.CreateTopics(&req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create topic")
+	}
+	resTopic := res.TopicErrors[topic]
+	if resTopic == nil {
+		return nil, errors.Errorf("create topics response block is missing, topic=%s", topic)
+	}
+	if resTopic.Err == sarama.ErrNoError {
+		// The topic has just been created according to the template, there is
+		// nothing to verify.
+		return nil, nil
+	}
+	if resTopic.Err != sarama.ErrTopicAlreadyExists {
+		return nil, errors.Wrapf(resTopic.Err, "failed to create topic %s", topic)
+	}
+
+	// The topic already exists, verify that it matches the template and
+	// repair what can be repaired.
+	var warnings []string
+	if err := kafkaClt.RefreshMetadata(topic); err != nil {
+		return nil, errors.Wrap(err, "failed to refresh topic metadata")
+	}
+	partitions, err := kafkaClt.Partitions(topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get topic partitions")
+	}
+	if int32(len(partitions)) < numPartitions {
+		if err := a.growPartitions(controller, topic, numPartitions); err != nil {
+			return nil, err
+		}
+	} else if int32(len(partitions)) > numPartitions {
+		warnings = append(warnings, fmt.Sprintf(
+			"partitions cannot be removed: got=%d, want=%d", len(partitions), numPartitions))
+	}
+	if len(partitions) > 0 {
+		replicas, err := kafkaClt.Replicas(topic, partitions[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get partition replicas")
+		}
+		if int16(len(replicas)) != replicationFactor {
+			warnings = append(warnings, fmt.Sprintf(
+				"replication factor cannot be changed: got=%d, want=%d", len(replicas), replicationFactor))
+		}
+	}
+	if err := a.repairTopicConfig(controller, topic, config); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+// growPartitions increases the partition count of the topic. Partition
+// assignment is left to the controller.
+func (a *T) growPartitions(controller *sarama.Broker, topic string, numPartitions int32) error {
+	res, err := controller.CreatePartitions(&sarama.CreatePartitionsRequest{
+		TopicPartitions: map[string]*sarama.TopicPartition{
+			topic: {Count: numPartitions},
+		},
+		Timeout: createTopicTimeout,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create partitions")
+	}
+	resTopic := res.TopicPartitionErrors[topic]
+	if resTopic == nil {
+		return errors.Errorf("create partitions response block is missing, topic=%s", topic)
+	}
+	if resTopic.Err != sarama.ErrNoError {
+		return errors.Wrapf(resTopic.Err, "failed to create partitions, topic=%s", topic)
+	}
+	return nil
+}
+
+// repairTopicConfig alters the topic config entries that differ from the
+// desired values. The AlterConfigs API is not incremental, it replaces the
+// entire set of topic level overrides, so the desired entries are merged with
+// the current overrides to avoid resetting unrelated parameters.
+func (a *T) repairTopicConfig(controller *sarama.Broker, topic string, config map[string]string) error {
+	if len(config) == 0 {
+		return nil
+	}
+	res, err := controller.DescribeConfigs(&sarama.DescribeConfigsRequest{
+		Resources: []*sarama.ConfigResource{
+			{Type: sarama.TopicResource, Name: topic},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to describe configs")
+	}
+	if len(res.Resources) < 1 {
+		return errors.Errorf("topic config is missing, topic=%s", topic)
+	}
+	resource := res.Resources[0]
+	if resource.ErrorCode != int16(sarama.ErrNoError) {
+		return errors.Wrapf(sarama.KError(resource.ErrorCode), "failed to describe configs, topic=%s", topic)
+	}
+	merged := make(map[string]*string)
+	upToDate := true
+	for _, entry := range resource.Configs {
+		if desired, ok := config[entry.Name]; ok {
+			if entry.Value != desired {
+				upToDate = false
+			}
+			continue
+		}
+		if entry.Default || entry.ReadOnly {
+			continue
+		}
+		value := entry.Value
+		merged[entry.Name] = &value
+	}
+	if upToDate {
+		return nil
+	}
+	for name, value := range config {
+		value := value
+		merged[name] = &value
+	}
+	alterRes, err := controller.AlterConfigs(&sarama.AlterConfigsRequest{
+		Resources: []*sarama.AlterConfigsResource{
+			{Type: sarama.TopicResource, Name: topic, ConfigEntries: merged},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to alter configs")
+	}
+	for _, alterResource := range alterRes.Resources {
+		if alterResource.ErrorCode != int16(sarama.ErrNoError) {
+			return errors.Wrapf(sarama.KError(alterResource.ErrorCode),
+				"failed to alter configs, topic=%s: %s", topic, alterResource.ErrorMsg)
+		}
+	}
+	return nil
+}
+
+// GetGroupsLag returns group -> topic -> partition-lag-list mapping for every
+// consumer group in the cluster that has committed offsets, not only the
+// groups managed by this Kafka-Pixy instance. If a filter is given then only
+// groups whose name matches it are reported. Offset and limit paginate the
+// alphabetically sorted group list; both the filter and the pagination are
+// applied before any coordinator is queried, so a page costs only as many
+// coordinator round trips as it has groups. Warning, without a limit the
+// function queries the coordinator of every group and therefore can take a
+// lot of time.
+func (a *T) GetGroupsLag(filter *regexp.Regexp, offset, limit int) (map[string]map[string][]GroupPartitionLag, error) {
+	lags, err := a.getGroupsLag(filter, offset, limit)
+	if err != nil {
+		a.ResetKafkaClt()
+		return a.getGroupsLag(filter, offset, limit)
+	}
+	return lags, nil
+}
+
+func (a *T) getGroupsLag(filter *regexp.Regexp, offset, limit int) (map[string]map[string][]GroupPartitionLag, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := a.listGroups(kafkaClt)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(groups)
+	groups = selectPage(groups, filter, offset, limit)
+	lags := make(map[string]map[string][]GroupPartitionLag)
+	// Log end offsets are cached across groups, for many groups typically
+	// consume from the same topics.
+	endOffsets := make(map[string]map[int32]int64)
+	for _, group := range groups {
+		coordinator, err := kafkaClt.Coordinator(group)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get coordinator, group=%s", group)
+		}
+		// An OffsetFetch v2 request without partitions returns offsets
+		// committed for all topic partitions of the group.
+		req := sarama.OffsetFetchRequest{ConsumerGroup: group, Version: 2}
+		res, err := coordinator.FetchOffset(&req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch offsets, group=%s", group)
+		}
+		topicLags := make(map[string][]GroupPartitionLag)
+		for topic, blocks := range res.Blocks {
+			for partition, block := range blocks {
+				if block.Err != sarama.ErrNoError {
+					return nil, errors.Wrapf(block.Err, "failed to fetch offset, group=%s, topic=%s, partition=%d",
+						group, topic, partition)
+				}
+				if block.Offset < 0 {
+					continue
+				}
+				end, err := a.endOffset(kafkaClt, endOffsets, topic, partition)
+				if err != nil {
+					return nil, err
+				}
+				lag := end - block.Offset
+				if lag < 0 {
+					lag = 0
+				}
+				topicLags[topic] = append(topicLags[topic], GroupPartitionLag{
+					Partition: partition,
+					Offset:    block.Offset,
+					End:       end,
+					Lag:       lag,
+				})
+			}
+		}
+		for _, partitionLags := range topicLags {
+			sort.Slice(partitionLags, func(i, j int) bool {
+				return partitionLags[i].Partition < partitionLags[j].Partition
+			})
+		}
+		if len(topicLags) > 0 {
+			lags[group] = topicLags
+		}
+	}
+	return lags, nil
+}
+
+// listGroups returns names of all consumer groups known in the cluster. It
+// combines groups registered with the Kafka group coordinators and, unless
+// the admin backend is "kafka", groups registered in ZooKeeper.
+func (a *T) listGroups(kafkaClt sarama.Client) ([]string, error) {
+	groupSet := make(map[string]bool)
+	for _, broker := range kafkaClt.Brokers() {
+		if connected, _ := broker.Connected(); !connected {
+			if err := broker.Open(kafkaClt.Config()); err != nil && err != sarama.ErrAlreadyConnected {
+				return nil, errors.Wrapf(err, "failed to connect to broker, broker=%v", broker.ID())
+			}
+		}
+		res, err := broker.ListGroups(&sarama.ListGroupsRequest{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list groups, broker=%v", broker.ID())
+		}
+		if res.Err != sarama.ErrNoError {
+			return nil, errors.Wrapf(res.Err, "failed to list groups, broker=%v", broker.ID())
+		}
+		for group := range res.Groups {
+			groupSet[group] = true
+		}
+	}
+	if a.cfg.Admin.Backend != "kafka" {
+		zkConn, err := a.lazyZKConn()
+		if err != nil {
+			return nil, err
+		}
+		groupsPath := fmt.Sprintf("%s/consumers", a.cfg.ZooKeeper.Chroot)
+		groups, _, err := zkConn.Children(groupsPath)
+		if err != nil && err != zk.ErrNoNode {
+			return nil, errors.Wrap(err, "failed to fetch consumer groups")
+		}
+		for _, group := range groups {
+			groupSet[group] = true
+		}
+	}
+	groups := make([]string, 0, len(groupSet))
+	for group := range groupSet {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// endOffset returns the log end offset of a topic partition, consulting the
+// given cache first and updating it on a miss.
+func (a *T) endOffset(kafkaClt sarama.Client, cache map[string]map[int32]int64, topic string, partition int32) (int64, error) {
+	if end, ok := cache[topic][partition]; ok {
+		return end, nil
+	}
+	end, err := kafkaClt.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get log end offset, topic=%s, partition=%d", topic, partition)
+	}
+	if cache[topic] == nil {
+		cache[topic] = make(map[int32]int64)
+	}
+	cache[topic][partition] = end
+	return end, nil
+}
+
 // GetTopicConsumers returns client-id -> consumed-partitions-list mapping
 // for a clients from a particular consumer group and a particular topic.
 func (a *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
+	if a.cfg.Admin.Backend == "kafka" {
+		return a.getTopicConsumersKafka(group, topic)
+	}
 	zkConn, err := a.lazyZKConn()
 	if err != nil {
 		return nil, err
@@ -288,6 +1071,9 @@ func (a *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
 // mapping for a particular topic. Warning, the function performs scan of all
 // consumer groups registered in ZooKeeper and therefore can take a lot of time.
 func (a *T) GetAllTopicConsumers(topic string) (map[string]map[string][]int32, error) {
+	if a.cfg.Admin.Backend == "kafka" {
+		return a.getAllTopicConsumersKafka(topic)
+	}
 	kzConn, err := a.lazyZKConn()
 	if err != nil {
 		return nil, err
@@ -315,12 +1101,290 @@ func (a *T) GetAllTopicConsumers(topic string) (map[string]map[string][]int32, e
 	return consumers, nil
 }
 
+// GroupMemberInfo describes a member registered in a consumer group: the
+// topics it is subscribed to, the partitions it currently owns in every
+// topic, and the time it registered with the group. The registration time is
+// only known when `admin.backend` is "zookeeper", for the Kafka group API
+// does not report it.
+type GroupMemberInfo struct {
+	Subscription []string           `json:"subscription"`
+	Partitions   map[string][]int32 `json:"partitions,omitempty"`
+	SubscribedAt *time.Time         `json:"subscribed_at,omitempty"`
+}
+
+// ListConsumerGroups returns all consumer groups known to the cluster along
+// with their registered members, keyed by group name and member client id
+// respectively. Groups that have no registered members at the moment are
+// omitted.
+func (a *T) ListConsumerGroups() (map[string]map[string]GroupMemberInfo, error) {
+	if a.cfg.Admin.Backend == "kafka" {
+		return a.listConsumerGroupsKafka()
+	}
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return nil, err
+	}
+	groupsPath := fmt.Sprintf("%s/consumers", a.cfg.ZooKeeper.Chroot)
+	groups, _, err := zkConn.Children(groupsPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch consumer groups")
+	}
+	rs := make(map[string]map[string]GroupMemberInfo)
+	for _, group := range groups {
+		members, err := a.groupMembersZK(zkConn, group)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch group `%s` data", group)
+		}
+		if len(members) > 0 {
+			rs[group] = members
+		}
+	}
+	return rs, nil
+}
+
+// groupMembersZK reads the registered members of a group from ZooKeeper,
+// merging member subscriptions recorded under ids/ with partition ownership
+// recorded under owners/. The creation time of a member registration node is
+// reported as its subscription time.
+func (a *T) groupMembersZK(zkConn *zk.Conn, group string) (map[string]GroupMemberInfo, error) {
+	membersPath := fmt.Sprintf("%s/consumers/%s/ids", a.cfg.ZooKeeper.Chroot, group)
+	memberIDs, _, err := zkConn.Children(membersPath)
+	if err != nil && err != zk.ErrNoNode {
+		return nil, errors.Wrap(err, "failed to fetch group members")
+	}
+	members := make(map[string]GroupMemberInfo)
+	for _, memberID := range memberIDs {
+		memberSpecJSON, stat, err := zkConn.Get(fmt.Sprintf("%s/%s", membersPath, memberID))
+		if err != nil {
+			if err == zk.ErrNoNode {
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to fetch member spec, member=%s", memberID)
+		}
+		var memberSpec struct {
+			Subscription map[string]int `json:"subscription"`
+		}
+		if err := json.Unmarshal(memberSpecJSON, &memberSpec); err != nil {
+			return nil, errors.Wrapf(err, "while parsing member %s, data=%s", memberID, string(memberSpecJSON))
+		}
+		topics := make([]string, 0, len(memberSpec.Subscription))
+		for topic := range memberSpec.Subscription {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		subscribedAt := time.Unix(0, stat.Ctime*int64(time.Millisecond)).UTC()
+		members[memberID] = GroupMemberInfo{Subscription: topics, SubscribedAt: &subscribedAt}
+	}
+	ownersPath := fmt.Sprintf("%s/consumers/%s/owners", a.cfg.ZooKeeper.Chroot, group)
+	ownedTopics, _, err := zkConn.Children(ownersPath)
+	if err != nil && err != zk.ErrNoNode {
+		return nil, errors.Wrap(err, "failed to fetch owned topics")
+	}
+	for _, topic := range ownedTopics {
+		topicConsumers, err := a.GetTopicConsumers(group, topic)
+		if err != nil {
+			if _, ok := err.(ErrInvalidParam); ok {
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to fetch topic consumers, topic=%s", topic)
+		}
+		for clientID, partitions := range topicConsumers {
+			member := members[clientID]
+			if member.Partitions == nil {
+				member.Partitions = make(map[string][]int32)
+			}
+			member.Partitions[topic] = partitions
+			members[clientID] = member
+		}
+	}
+	return members, nil
+}
+
+// getTopicConsumersKafka is a Kafka Admin API based counterpart of
+// GetTopicConsumers used when `admin.backend` is set to "kafka". Group
+// membership is fetched from the group coordinator via DescribeGroups rather
+// then read from ZooKeeper.
+func (a *T) getTopicConsumersKafka(group, topic string) (map[string][]int32, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+	coordinator, err := kafkaClt.Coordinator(group)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get coordinator")
+	}
+	res, err := coordinator.DescribeGroups(&sarama.DescribeGroupsRequest{Groups: []string{group}})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to describe group")
+	}
+	if len(res.Groups) < 1 {
+		return nil, errors.Errorf("group description is missing, group=%s", group)
+	}
+	gd := res.Groups[0]
+	if gd.Err != sarama.ErrNoError {
+		return nil, errors.Wrapf(gd.Err, "failed to describe group, group=%s", group)
+	}
+	if gd.State == "Dead" {
+		return nil, ErrInvalidParam(errors.Errorf("unknown consumer group %v", group))
+	}
+
+	consumers := make(map[string][]int32)
+	for _, member := range gd.Members {
+		assignment, err := member.GetMemberAssignment()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse member assignment")
+		}
+		partitions := assignment.Topics[topic]
+		if len(partitions) == 0 {
+			continue
+		}
+		consumers[member.ClientId] = append(consumers[member.ClientId], partitions...)
+	}
+	if len(consumers) == 0 {
+		return nil, ErrInvalidParam(errors.Errorf("topic %s is not consumed by %s", topic, group))
+	}
+
+	for _, partitions := range consumers {
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	}
+	return consumers, nil
+}
+
+// getAllTopicConsumersKafka is a Kafka Admin API based counterpart of
+// GetAllTopicConsumers used when `admin.backend` is set to "kafka". Groups are
+// discovered via ListGroups on every broker, for a broker only returns groups
+// that it coordinates.
+func (a *T) getAllTopicConsumersKafka(topic string) (map[string]map[string][]int32, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+	consumers := make(map[string]map[string][]int32)
+	for _, broker := range kafkaClt.Brokers() {
+		if connected, _ := broker.Connected(); !connected {
+			if err := broker.Open(kafkaClt.Config()); err != nil && err != sarama.ErrAlreadyConnected {
+				return nil, errors.Wrapf(err, "failed to connect to broker, broker=%v", broker.ID())
+			}
+		}
+		res, err := broker.ListGroups(&sarama.ListGroupsRequest{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list groups, broker=%v", broker.ID())
+		}
+		if res.Err != sarama.ErrNoError {
+			return nil, errors.Wrapf(res.Err, "failed to list groups, broker=%v", broker.ID())
+		}
+		for group := range res.Groups {
+			groupConsumers, err := a.GetTopicConsumers(group, topic)
+			if err != nil {
+				if _, ok := err.(ErrInvalidParam); ok {
+					continue
+				}
+				return nil, errors.Wrapf(err, "failed to fetch group `%s` data", group)
+			}
+			if len(groupConsumers) > 0 {
+				consumers[group] = groupConsumers
+			}
+		}
+	}
+	return consumers, nil
+}
+
+// listConsumerGroupsKafka is a Kafka Admin API based counterpart of
+// ListConsumerGroups used when `admin.backend` is set to "kafka". Groups are
+// discovered via ListGroups on every broker and their membership is fetched
+// from the group coordinators via DescribeGroups.
+func (a *T) listConsumerGroupsKafka() (map[string]map[string]GroupMemberInfo, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := a.listGroups(kafkaClt)
+	if err != nil {
+		return nil, err
+	}
+	rs := make(map[string]map[string]GroupMemberInfo)
+	for _, group := range groups {
+		coordinator, err := kafkaClt.Coordinator(group)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get coordinator, group=%s", group)
+		}
+		res, err := coordinator.DescribeGroups(&sarama.DescribeGroupsRequest{Groups: []string{group}})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to describe group, group=%s", group)
+		}
+		if len(res.Groups) < 1 {
+			return nil, errors.Errorf("group description is missing, group=%s", group)
+		}
+		gd := res.Groups[0]
+		if gd.Err != sarama.ErrNoError || gd.State == "Dead" {
+			continue
+		}
+		members := make(map[string]GroupMemberInfo)
+		for _, member := range gd.Members {
+			var info GroupMemberInfo
+			if metadata, err := member.GetMemberMetadata(); err == nil {
+				info.Subscription = append(info.Subscription, metadata.Topics...)
+				sort.Strings(info.Subscription)
+			}
+			if assignment, err := member.GetMemberAssignment(); err == nil && len(assignment.Topics) > 0 {
+				info.Partitions = make(map[string][]int32, len(assignment.Topics))
+				for topic, partitions := range assignment.Topics {
+					partitions = append([]int32(nil), partitions...)
+					sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+					info.Partitions[topic] = partitions
+				}
+			}
+			members[member.ClientId] = info
+		}
+		if len(members) > 0 {
+			rs[group] = members
+		}
+	}
+	return rs, nil
+}
+
+// getTopicConfigKafka fetches topic configuration overrides via the
+// DescribeConfigs Admin API. Only non-default values are returned to match
+// what the ZooKeeper backend reads from the topic config node.
+func (a *T) getTopicConfigKafka(kafkaClt sarama.Client, topic string) (*TopicConfig, error) {
+	controller, err := kafkaClt.Controller()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get controller")
+	}
+	res, err := controller.DescribeConfigs(&sarama.DescribeConfigsRequest{
+		Resources: []*sarama.ConfigResource{
+			{Type: sarama.TopicResource, Name: topic},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to describe configs")
+	}
+	if len(res.Resources) < 1 {
+		return nil, errors.Errorf("topic config is missing, topic=%s", topic)
+	}
+	resource := res.Resources[0]
+	if resource.ErrorCode != int16(sarama.ErrNoError) {
+		return nil, errors.Wrapf(sarama.KError(resource.ErrorCode), "failed to describe configs, topic=%s", topic)
+	}
+	topicConfig := TopicConfig{Version: ProtocolVer1, Config: make(map[string]string)}
+	for _, entry := range resource.Configs {
+		if entry.Default {
+			continue
+		}
+		topicConfig.Config[entry.Name] = entry.Value
+	}
+	return &topicConfig, nil
+}
+
 func (a *T) lazyKafkaClt() (sarama.Client, error) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 	if a.kafkaClt == nil {
-		var err error
-		if a.kafkaClt, err = sarama.NewClient(a.cfg.Kafka.SeedPeers, a.cfg.SaramaClientCfg()); err != nil {
+		backend, err := kafkaclt.New(a.cfg)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create Kafka backend")
+		}
+		if a.kafkaClt, err = backend.NewClient(); err != nil {
 			return nil, errors.Wrap(err, "failed to create sarama.Client")
 		}
 	}
@@ -332,7 +1396,10 @@ func (a *T) lazyZKConn() (*zk.Conn, error) {
 	defer a.mtx.Unlock()
 	if a.zkConn == nil {
 		var err error
-		if a.zkConn, _, err = zk.Connect(a.cfg.ZooKeeper.SeedPeers, 1*time.Second); err != nil {
+		actDesc := a.parentActDesc.NewChild("admin")
+		if a.zkConn, err = kazoo.Connect(actDesc.String(), a.cfg.ZooKeeper.SeedPeers,
+			a.cfg.ZooKeeper.SessionTimeout, a.cfg.ZooKeeper.ConnectTimeout, a.cfg.Net.Proxy, actDesc.Log(),
+		); err != nil {
 			return nil, errors.Wrap(err, "failed to create zk.Conn")
 		}
 	}
@@ -353,8 +1420,13 @@ func getOffsetResult(res *sarama.OffsetResponse, topic string, partition int32)
 	return block.Offsets[0], nil
 }
 
-// ListTopics returns a list of all topics existing in the Kafka cluster.
-func (a *T) ListTopics(withPartitions, withConfig bool) ([]TopicMetadata, error) {
+// ListTopics returns a list of topics existing in the Kafka cluster, sorted
+// by name. If a filter is given then only topics whose name matches it are
+// reported, and offset and limit paginate the sorted list. Both the filter
+// and the pagination are applied before any per topic metadata is fetched,
+// so listing a page of a cluster with tens of thousands of topics stays
+// cheap.
+func (a *T) ListTopics(filter *regexp.Regexp, offset, limit int, withPartitions, withConfig bool) ([]TopicMetadata, error) {
 	kafkaClt, err := a.lazyKafkaClt()
 	if err != nil {
 		return nil, err
@@ -370,6 +1442,8 @@ func (a *T) ListTopics(withPartitions, withConfig bool) ([]TopicMetadata, error)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get topics")
 	}
+	sort.Strings(topics)
+	topics = selectPage(topics, filter, offset, limit)
 
 	topicMetadatas := make([]TopicMetadata, len(topics))
 	for i, topic := range topics {
@@ -379,10 +1453,35 @@ func (a *T) ListTopics(withPartitions, withConfig bool) ([]TopicMetadata, error)
 		}
 		topicMetadatas[i] = tm
 	}
-	sort.Slice(topicMetadatas, func(i, j int) bool { return topicMetadatas[i].Topic < topicMetadatas[j].Topic })
 	return topicMetadatas, nil
 }
 
+// selectPage narrows a sorted list of names down to the requested page: names
+// not matching the filter are dropped, then offset names are skipped and at
+// most limit names are kept. A nil filter matches everything, a limit of 0
+// means no limit.
+func selectPage(names []string, filter *regexp.Regexp, offset, limit int) []string {
+	if filter != nil {
+		filtered := make([]string, 0, len(names))
+		for _, name := range names {
+			if filter.MatchString(name) {
+				filtered = append(filtered, name)
+			}
+		}
+		names = filtered
+	}
+	if offset > 0 {
+		if offset >= len(names) {
+			return nil
+		}
+		names = names[offset:]
+	}
+	if limit > 0 && len(names) > limit {
+		names = names[:limit]
+	}
+	return names
+}
+
 // GetTopicMetadata returns a topic metadata. An optional partition metadata
 // can be requested and/or detailed topic configuration can be requested.
 func (a *T) GetTopicMetadata(topic string, withPartitions, withConfig bool) (TopicMetadata, error) {
@@ -425,6 +1524,14 @@ func (a *T) GetTopicMetadata(topic string, withPartitions, withConfig bool) (Top
 		}
 	}
 	if withConfig {
+		if a.cfg.Admin.Backend == "kafka" {
+			topicConfig, err := a.getTopicConfigKafka(kafkaClt, topic)
+			if err != nil {
+				return TopicMetadata{}, errors.Wrap(err, "failed to fetch topic configuration")
+			}
+			tm.Config = topicConfig
+			return tm, nil
+		}
 		kzConn, err := a.lazyZKConn()
 		if err != nil {
 			return TopicMetadata{}, errors.Wrap(err, "failed to connect to zookeeper")
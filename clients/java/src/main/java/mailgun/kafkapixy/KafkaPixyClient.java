@@ -0,0 +1,177 @@
+package mailgun.kafkapixy;
+
+import com.google.protobuf.ByteString;
+import io.grpc.ManagedChannel;
+import io.grpc.ManagedChannelBuilder;
+import io.grpc.Status;
+import io.grpc.StatusRuntimeException;
+
+import java.util.concurrent.TimeUnit;
+import java.util.function.BooleanSupplier;
+import java.util.function.Consumer;
+
+/**
+ * Thin idiomatic wrapper around the Kafka-Pixy gRPC API. It is hand written,
+ * not generated; the message and stub classes it builds upon are generated
+ * from kafkapixy.proto by `make grpc_java` in the repository root.
+ *
+ * <p>The client is thread safe, so one instance per application is enough.
+ */
+public class KafkaPixyClient implements AutoCloseable {
+
+    /**
+     * Produce calls must be given enough time to accommodate all internal
+     * retries, that is at least (producer.flush_frequency +
+     * producer.retry_backoff) * producer.retry_max as configured in the
+     * Kafka-Pixy config file.
+     */
+    public static final long DEFAULT_PRODUCE_TIMEOUT_MS = 30000;
+
+    /**
+     * Consume calls must be given more time than the
+     * consumer.long_polling_timeout Kafka-Pixy config parameter value.
+     */
+    public static final long DEFAULT_CONSUME_TIMEOUT_MS = 5000;
+
+    /**
+     * How long to back off when Kafka-Pixy keeps failing, e.g. while it is
+     * being restarted, before making another consume attempt.
+     */
+    public static final long DEFAULT_BACKOFF_TIMEOUT_MS = 1000;
+
+    private final ManagedChannel chan;
+    private final KafkaPixyGrpc.KafkaPixyBlockingStub stub;
+    private final String cluster;
+    private final long produceTimeoutMs;
+    private final long consumeTimeoutMs;
+    private final long backoffTimeoutMs;
+
+    public KafkaPixyClient() {
+        this("127.0.0.1", 19091, "");
+    }
+
+    public KafkaPixyClient(String host, int port, String cluster) {
+        this(host, port, cluster, DEFAULT_PRODUCE_TIMEOUT_MS,
+                DEFAULT_CONSUME_TIMEOUT_MS, DEFAULT_BACKOFF_TIMEOUT_MS);
+    }
+
+    public KafkaPixyClient(String host, int port, String cluster,
+                           long produceTimeoutMs, long consumeTimeoutMs,
+                           long backoffTimeoutMs) {
+        // Kafka-Pixy is supposed to run on the same host as its clients, so
+        // plaintext is the norm.
+        this.chan = ManagedChannelBuilder.forAddress(host, port)
+                .usePlaintext()
+                .build();
+        this.stub = KafkaPixyGrpc.newBlockingStub(chan);
+        this.cluster = cluster;
+        this.produceTimeoutMs = produceTimeoutMs;
+        this.consumeTimeoutMs = consumeTimeoutMs;
+        this.backoffTimeoutMs = backoffTimeoutMs;
+    }
+
+    /**
+     * Produces a message to a topic and returns the broker assigned
+     * partition and offset. If key is null then the message goes to a random
+     * partition.
+     */
+    public ProdRs produce(String topic, byte[] key, byte[] message) {
+        ProdRq.Builder rq = ProdRq.newBuilder()
+                .setCluster(cluster)
+                .setTopic(topic)
+                .setMessage(ByteString.copyFrom(message));
+        if (key == null) {
+            rq.setKeyUndefined(true);
+        } else {
+            rq.setKeyValue(ByteString.copyFrom(key));
+        }
+        return stub.withDeadlineAfter(produceTimeoutMs, TimeUnit.MILLISECONDS)
+                .produce(rq.build());
+    }
+
+    /**
+     * Acknowledges a message consumed earlier. It is only needed for the
+     * last message before shutdown; in all other cases acknowledgements
+     * piggyback on consume requests made by {@link #runConsumeNAck}.
+     */
+    public void ack(String topic, String group, int partition, long offset) {
+        AckRq rq = AckRq.newBuilder()
+                .setCluster(cluster)
+                .setTopic(topic)
+                .setGroup(group)
+                .setPartition(partition)
+                .setOffset(offset)
+                .build();
+        stub.withDeadlineAfter(consumeTimeoutMs, TimeUnit.MILLISECONDS).ack(rq);
+    }
+
+    /**
+     * Runs a consume-n-ack loop calling msgHandler with every consumed
+     * message, until keepRunning returns false. A message is acknowledged by
+     * the next consume request, or by an explicit ack on shutdown, only
+     * after msgHandler returns without throwing; if it throws, then the
+     * message is re-offered by Kafka-Pixy after the ack timeout elapses.
+     *
+     * <p>The loop blocks the calling thread. To increase throughput run it
+     * in several threads sharing the client, but select the exact number
+     * based on performance measurements in each particular case.
+     */
+    public void runConsumeNAck(String topic, String group,
+                               Consumer<ConsRs> msgHandler,
+                               BooleanSupplier keepRunning)
+            throws InterruptedException {
+        int ackPartition = 0;
+        long ackOffset = -1;
+
+        while (keepRunning.getAsBoolean()) {
+            ConsNAckRq.Builder rq = ConsNAckRq.newBuilder()
+                    .setCluster(cluster)
+                    .setTopic(topic)
+                    .setGroup(group);
+            if (ackOffset < 0) {
+                rq.setNoAck(true);
+            } else {
+                rq.setAckPartition(ackPartition);
+                rq.setAckOffset(ackOffset);
+            }
+
+            ConsRs rs;
+            try {
+                rs = stub.withDeadlineAfter(consumeTimeoutMs, TimeUnit.MILLISECONDS)
+                        .consumeNAck(rq.build());
+            } catch (StatusRuntimeException e) {
+                if (e.getStatus().getCode() == Status.Code.NOT_FOUND) {
+                    // Long polling timeout. The topic is empty. Just make
+                    // another request.
+                    ackOffset = -1;
+                    continue;
+                }
+                // Unexpected errors can be generated in rapid succession,
+                // e.g. when Kafka-Pixy is down, so back off before retrying.
+                Thread.sleep(backoffTimeoutMs);
+                ackOffset = -1;
+                continue;
+            }
+
+            try {
+                msgHandler.accept(rs);
+                ackPartition = rs.getPartition();
+                ackOffset = rs.getOffset();
+            } catch (RuntimeException e) {
+                // The handler failed, do not acknowledge so that the message
+                // is re-offered after the ack timeout.
+                ackOffset = -1;
+            }
+        }
+
+        // Acknowledge the last consumed message before returning.
+        if (ackOffset >= 0) {
+            ack(topic, group, ackPartition, ackOffset);
+        }
+    }
+
+    @Override
+    public void close() throws InterruptedException {
+        chan.shutdown().awaitTermination(5, TimeUnit.SECONDS);
+    }
+}
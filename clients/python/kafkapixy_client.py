@@ -0,0 +1,132 @@
+"""
+Thin idiomatic wrapper around the Kafka-Pixy gRPC API for Python
+applications. It is hand written, not generated; only the message and stub
+classes it builds upon come out of protoc.
+
+To use it copy this file together with the generated stubs
+(gen/python/kafkapixy_pb2.py and gen/python/kafkapixy_pb2_grpc.py) into a
+`kafkapixy` package of your application, and add `grpcio>=1.2.0` to your
+dependencies. See quick-start-python.md in the repository root for a more
+detailed walk-through of the underlying API.
+"""
+
+import time
+
+import grpc
+
+from kafkapixy_pb2 import AckRq, ConsNAckRq, ProdRq
+from kafkapixy_pb2_grpc import KafkaPixyStub
+
+# Produce calls must be given enough time to accommodate all internal retries,
+# that is at least (producer.flush_frequency + producer.retry_backoff) *
+# producer.retry_max as configured in the Kafka-Pixy config file.
+DEFAULT_PRODUCE_TIMEOUT = 30
+
+# Consume calls must be given more time than the
+# consumer.long_polling_timeout Kafka-Pixy config parameter value.
+DEFAULT_CONSUME_TIMEOUT = 5
+
+# How long to back off when Kafka-Pixy keeps failing, e.g. while it is being
+# restarted, before making another consume attempt.
+DEFAULT_BACKOFF_TIMEOUT = 1
+
+
+class KafkaPixyClient(object):
+    """
+    A client of a Kafka-Pixy instance. It is thread safe, so one instance per
+    application is enough.
+    """
+
+    def __init__(self, addr="127.0.0.1:19091", cluster="",
+                 produce_timeout=DEFAULT_PRODUCE_TIMEOUT,
+                 consume_timeout=DEFAULT_CONSUME_TIMEOUT,
+                 backoff_timeout=DEFAULT_BACKOFF_TIMEOUT):
+        self._chan = grpc.insecure_channel(addr)
+        self._stub = KafkaPixyStub(self._chan)
+        self._cluster = cluster
+        self._produce_timeout = produce_timeout
+        self._consume_timeout = consume_timeout
+        self._backoff_timeout = backoff_timeout
+
+    def produce(self, topic, message, key=None, async_mode=False):
+        """
+        Produces a message to a topic and returns the ProdRs response. If key
+        is None then the message goes to a random partition. Raises
+        grpc.RpcError on failure.
+        """
+        rq = ProdRq(cluster=self._cluster, topic=topic, message=message,
+                    async_mode=async_mode)
+        if key is None:
+            rq.key_undefined = True
+        else:
+            rq.key_value = key
+        return self._stub.Produce(rq, timeout=self._produce_timeout)
+
+    def ack(self, topic, group, partition, offset):
+        """
+        Acknowledges a message consumed earlier. It is only needed for the
+        last message before shutdown; in all other cases acknowledgements
+        piggyback on consume requests made by run_consume_n_ack.
+        """
+        rq = AckRq(cluster=self._cluster, topic=topic, group=group,
+                   partition=partition, offset=offset)
+        self._stub.Ack(rq, timeout=self._consume_timeout)
+
+    def run_consume_n_ack(self, topic, group, msg_handler,
+                          keep_running=lambda: True):
+        """
+        Runs a consume-n-ack loop calling msg_handler with every consumed
+        ConsRs response, until keep_running returns False. A message is
+        acknowledged by the next consume request, or by an explicit Ack on
+        shutdown, only after msg_handler returns without raising; if it
+        raises, then the message is re-offered by Kafka-Pixy after the ack
+        timeout elapses.
+
+        The loop blocks the calling thread. To increase throughput run it in
+        several threads sharing the client, but select the exact number based
+        on performance measurements in each particular case.
+        """
+        ack_partition = 0
+        ack_offset = None
+
+        rq = ConsNAckRq(cluster=self._cluster, topic=topic, group=group)
+        while keep_running():
+            if ack_offset is None:
+                rq.no_ack = True
+                rq.ack_partition = 0
+                rq.ack_offset = 0
+            else:
+                rq.no_ack = False
+                rq.ack_partition = ack_partition
+                rq.ack_offset = ack_offset
+
+            try:
+                rs = self._stub.ConsumeNAck(rq, timeout=self._consume_timeout)
+            except grpc.RpcError as err:
+                if hasattr(err, 'code') and \
+                        err.code() == grpc.StatusCode.NOT_FOUND:
+                    # Long polling timeout. The topic is empty. Just make
+                    # another request.
+                    ack_offset = None
+                    continue
+                # Unexpected errors can be generated in rapid succession,
+                # e.g. when Kafka-Pixy is down, so back off before retrying.
+                time.sleep(self._backoff_timeout)
+                ack_offset = None
+                continue
+
+            try:
+                msg_handler(rs)
+                ack_partition = rs.partition
+                ack_offset = rs.offset
+            except Exception:
+                # The handler failed, do not acknowledge so that the message
+                # is re-offered after the ack timeout.
+                ack_offset = None
+
+        # Acknowledge the last consumed message before returning.
+        if ack_offset is not None:
+            self.ack(topic, group, ack_partition, ack_offset)
+
+    def close(self):
+        self._chan.close()
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/thrawn01/args"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Bench drives a configurable produce/consume workload against a running
+// Kafka-Pixy instance and reports throughput and latency percentiles, so that
+// capacity tests do not require external tooling.
+func Bench(parser *args.ArgParser, cast interface{}) (int, error) {
+	client := cast.(pb.KafkaPixyClient)
+
+	desc := args.Dedent(`drive a produce/consume benchmark against a running kafka-pixy
+
+	Examples:
+	   Produce 10000 messages of 1 KiB with 8 concurrent producers
+	   $ kafka-pixy-cli bench my-topic -c 10000 -s 1024 -p 8
+
+	   Also consume what was produced with 2 consumer groups
+	   $ kafka-pixy-cli bench my-topic -g 2
+
+	   Limit each producer to 100 messages per second
+	   $ kafka-pixy-cli bench my-topic -r 100`)
+
+	parser.SetDesc(desc)
+	parser.AddArgument("topic").
+		Required().
+		Env("TOPIC").
+		Help("topic to run the benchmark against")
+
+	parser.AddOption("--count").
+		Alias("-c").
+		Default("1000").
+		IsInt().
+		Help("total number of messages to produce")
+
+	parser.AddOption("--size").
+		Alias("-s").
+		Default("512").
+		IsInt().
+		Help("message size in bytes")
+
+	parser.AddOption("--rate").
+		Alias("-r").
+		Default("0").
+		IsInt().
+		Help("messages per second per producer, 0 means unlimited")
+
+	parser.AddOption("--producers").
+		Alias("-p").
+		Default("4").
+		IsInt().
+		Help("number of concurrent producers")
+
+	parser.AddOption("--groups").
+		Alias("-g").
+		Default("0").
+		IsInt().
+		Help("number of consumer groups to consume with, 0 disables consuming")
+
+	opts := parser.ParseSimple(nil)
+	if opts == nil {
+		return 1, nil
+	}
+
+	topic := opts.String("topic")
+	count := opts.Int("count")
+	size := opts.Int("size")
+	rate := opts.Int("rate")
+	producers := opts.Int("producers")
+	groups := opts.Int("groups")
+	if count <= 0 || size <= 0 || producers <= 0 {
+		return 1, fmt.Errorf("--count, --size and --producers must be > 0")
+	}
+
+	message := make([]byte, size)
+	rand.Read(message)
+
+	// Start consumers first so that they are subscribed before messages
+	// start flowing.
+	var consumed int64
+	consumeDoneCh := make(chan struct{})
+	for i := 0; i < groups; i++ {
+		group := fmt.Sprintf("kafka-pixy-bench-%d", i)
+		go runBenchConsumer(client, topic, group, int64(count), &consumed, consumeDoneCh)
+	}
+
+	var (
+		latenciesMu sync.Mutex
+		latencies   = make([]time.Duration, 0, count)
+		produceErrs int64
+	)
+	var wg sync.WaitGroup
+	begin := time.Now()
+	for i := 0; i < producers; i++ {
+		producerCount := count / producers
+		if i < count%producers {
+			producerCount += 1
+		}
+		wg.Add(1)
+		go func(producerCount int) {
+			defer wg.Done()
+			var throttleCh <-chan time.Time
+			if rate > 0 {
+				ticker := time.NewTicker(time.Second / time.Duration(rate))
+				defer ticker.Stop()
+				throttleCh = ticker.C
+			}
+			for j := 0; j < producerCount; j++ {
+				if throttleCh != nil {
+					<-throttleCh
+				}
+				rqBegin := time.Now()
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				_, err := client.Produce(ctx, &pb.ProdRq{
+					Topic:        topic,
+					KeyUndefined: true,
+					Message:      message,
+				})
+				cancel()
+				if err != nil {
+					atomic.AddInt64(&produceErrs, 1)
+					continue
+				}
+				latency := time.Since(rqBegin)
+				latenciesMu.Lock()
+				latencies = append(latencies, latency)
+				latenciesMu.Unlock()
+			}
+		}(producerCount)
+	}
+	wg.Wait()
+	produceElapsed := time.Since(begin)
+
+	fmt.Fprintf(os.Stdout, "Produced: count=%d, errors=%d, elapsed=%v, rate=%.1f msg/s\n",
+		len(latencies), produceErrs, produceElapsed.Truncate(time.Millisecond),
+		float64(len(latencies))/produceElapsed.Seconds())
+	printPercentiles(latencies)
+
+	if groups == 0 {
+		return 0, nil
+	}
+	// Wait for all groups to drain what was produced.
+	for i := 0; i < groups; i++ {
+		<-consumeDoneCh
+	}
+	consumeElapsed := time.Since(begin)
+	fmt.Fprintf(os.Stdout, "Consumed: count=%d, groups=%d, elapsed=%v, rate=%.1f msg/s\n",
+		atomic.LoadInt64(&consumed), groups, consumeElapsed.Truncate(time.Millisecond),
+		float64(atomic.LoadInt64(&consumed))/consumeElapsed.Seconds())
+	return 0, nil
+}
+
+// runBenchConsumer consumes `count` messages from the topic on behalf of the
+// group and then signals completion on doneCh.
+func runBenchConsumer(client pb.KafkaPixyClient, topic, group string, count int64,
+	consumed *int64, doneCh chan<- struct{}) {
+
+	var groupConsumed int64
+	for groupConsumed < count {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, err := client.ConsumeNAck(ctx, &pb.ConsNAckRq{
+			Topic:   topic,
+			Group:   group,
+			AutoAck: true,
+		})
+		cancel()
+		if err != nil {
+			// Long polling timeouts are expected while producers ramp up.
+			if grpc.Code(err) == codes.NotFound {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "-- consume failed: group=%s, err=%s\n", group, err)
+			continue
+		}
+		groupConsumed += 1
+		atomic.AddInt64(consumed, 1)
+	}
+	doneCh <- struct{}{}
+}
+
+// printPercentiles reports the latency distribution of the recorded produce
+// round trips.
+func printPercentiles(latencies []time.Duration) {
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		idx := int(p * float64(len(latencies)-1))
+		return latencies[idx].Truncate(time.Microsecond)
+	}
+	fmt.Fprintf(os.Stdout, "Latency: p50=%v, p90=%v, p99=%v, max=%v\n",
+		percentile(0.50), percentile(0.90), percentile(0.99), percentile(1.0))
+}
@@ -40,6 +40,9 @@ func main() {
 	   Get partition offsets
 	   $ kafka-pixy-cli offsets my-topic
 
+	   Run a produce/consume benchmark
+	   $ kafka-pixy-cli bench my-topic -c 10000 -p 8
+
 	   Set partition offsets
 	   $ echo -n "[{"partition": 1, "offset": 1}]" | kafka-pixy-cli offsets my-topic -g my-group
 
@@ -68,6 +71,7 @@ func main() {
 	parser.AddCommand("list-topics", ListTopics)
 	parser.AddCommand("list-consumers", ListConsumers)
 	parser.AddCommand("topic", Topic)
+	parser.AddCommand("bench", Bench)
 	parser.AddCommand("version", func(_ *args.ArgParser, _ interface{}) (int, error) {
 		fmt.Fprintf(os.Stdout, "Version: %s\n", Version)
 		return 1, nil
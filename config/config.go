@@ -3,18 +3,24 @@ package config
 import (
 	"bufio"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/gorilla/mux"
+	"github.com/mailgun/kafka-pixy/features"
+	"github.com/mailgun/kafka-pixy/netproxy"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
@@ -22,6 +28,26 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// Valid consumer.assignment_strategy values.
+const (
+	AssignmentStrategyRange  = "range"
+	AssignmentStrategySticky = "sticky"
+)
+
+// Valid producer.unknown_topic_policy values.
+const (
+	UnknownTopicBroker = "broker"
+	UnknownTopicReject = "reject"
+	UnknownTopicCreate = "create"
+)
+
+// Valid consumer.first_offset values, besides a duration or an RFC3339
+// timestamp.
+const (
+	FirstOffsetEarliest = "earliest"
+	FirstOffsetLatest   = "latest"
+)
+
 // App defines Kafka-Pixy application configuration. It mirrors the structure
 // of the JSON configuration file.
 type App struct {
@@ -50,8 +76,258 @@ type App struct {
 	// Logging config
 	Logging []LoggerCfg
 
-	HTTPMiddlewareFuncs []mux.MiddlewareFunc
-	GRPCServerOpts      []grpc.ServerOption
+	// Pipelines is an arbitrary number of server-side consume→produce bridge
+	// pipelines. Each pipeline consumes messages from one topic on behalf of
+	// a consumer group, optionally filters/transforms them via a hook
+	// registered with pipeline.RegisterTransform, and produces the result to
+	// another topic. Source offsets are committed only after a successful
+	// produce to the destination topic.
+	Pipelines []PipelineCfg `yaml:"pipelines"`
+
+	// Features defines the startup state of the process wide feature flags
+	// gating optional behaviors, e.g. dead lettering, poison message
+	// skipping and pipeline transforms. Flags can be changed at run time
+	// via `POST /features/<flag>`, so risky features can be rolled out
+	// progressively per topic or per group.
+	Features FeaturesCfg `yaml:"features"`
+
+	// Startup defines how the service behaves at boot when the Kafka or
+	// ZooKeeper cluster behind a configured proxy is not reachable yet, e.g.
+	// in a fresh environment where brokers come up alongside Kafka-Pixy.
+	Startup StartupCfg `yaml:"startup"`
+
+	// ShutdownTimeout limits how long a graceful shutdown triggered by an OS
+	// signal may take. Within this time the service stops accepting new API
+	// requests, tells long polling consumers to re-poll another instance,
+	// flushes in-flight produce requests, commits acknowledged offsets and
+	// releases consumer group memberships. If the deadline is exceeded the
+	// process exits anyway risking offset commits to be lost. 0 means wait
+	// for shutdown to complete however long it takes.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Webhooks is an arbitrary number of push delivery subscriptions. Each
+	// webhook consumes messages from a topic on behalf of a consumer group
+	// and POSTs them to an HTTP(S) endpoint, treating 2xx responses as acks.
+	// Messages that are not accepted are re-offered by the consumer
+	// machinery and eventually dead-lettered if a dead letter topic is
+	// configured.
+	Webhooks []WebhookCfg `yaml:"webhooks"`
+
+	// Error reporting config
+	ErrorReporting struct {
+		// Sentry-compatible DSN, e.g. `https://<key>@<host>/<project-id>`.
+		// If set, then error, fatal and panic level log records are forwarded
+		// to the endpoint, with actor and proxy context attached as extra
+		// fields. Disabled by default.
+		SentryDSN string `yaml:"sentry_dsn"`
+	} `yaml:"error_reporting"`
+
+	HTTPMiddlewareFuncs []mux.MiddlewareFunc `yaml:"-"`
+	GRPCServerOpts      []grpc.ServerOption  `yaml:"-"`
+
+	// Path of the YAML file the configuration was loaded from, empty if the
+	// configuration was built programmatically. Used by the config dump API
+	// to diff the runtime configuration against the file on disk.
+	sourceFile string
+}
+
+// SourceFile returns the path of the YAML file the configuration was loaded
+// from, or an empty string if the configuration was built programmatically.
+func (a *App) SourceFile() string {
+	return a.sourceFile
+}
+
+// PipelineCfg represents a configuration of an individual consume→produce
+// bridge pipeline.
+type PipelineCfg struct {
+	// Name identifies the pipeline in logs and metrics.
+	Name string `yaml:"name"`
+
+	// Cluster is the name of the proxy to run the pipeline against. If empty
+	// then the default cluster is assumed.
+	Cluster string `yaml:"cluster"`
+
+	// Group is the consumer group to consume the source topic on behalf of.
+	Group string `yaml:"group"`
+
+	// FromTopic is the topic to consume messages from.
+	FromTopic string `yaml:"from_topic"`
+
+	// ToTopic is the topic to produce messages to.
+	ToTopic string `yaml:"to_topic"`
+
+	// Transform is the name of a filter/transform hook registered with
+	// pipeline.RegisterTransform by the embedding application. If empty then
+	// messages are bridged verbatim.
+	Transform string `yaml:"transform"`
+}
+
+func (pl *PipelineCfg) validate() error {
+	switch {
+	case pl.Name == "":
+		return errors.New("name must not be empty")
+	case pl.Group == "":
+		return errors.New("group must not be empty")
+	case pl.FromTopic == "":
+		return errors.New("from_topic must not be empty")
+	case pl.ToTopic == "":
+		return errors.New("to_topic must not be empty")
+	case pl.FromTopic == pl.ToTopic:
+		return errors.New("from_topic and to_topic must differ")
+	}
+	return nil
+}
+
+// WebhookCfg represents a configuration of an individual push delivery
+// subscription.
+type WebhookCfg struct {
+	// Name identifies the webhook in logs and metrics.
+	Name string `yaml:"name"`
+
+	// Cluster is the name of the proxy to run the webhook against. If empty
+	// then the default cluster is assumed.
+	Cluster string `yaml:"cluster"`
+
+	// Group is the consumer group to consume the topic on behalf of.
+	Group string `yaml:"group"`
+
+	// Topic is the topic to consume messages from.
+	Topic string `yaml:"topic"`
+
+	// URL is the HTTP(S) endpoint to POST consumed messages to.
+	URL string `yaml:"url"`
+
+	// ContentType is the Content-Type header sent with delivery requests.
+	ContentType string `yaml:"content_type"`
+
+	// Timeout limits how long a delivery request is allowed to take.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (w *WebhookCfg) validate() error {
+	switch {
+	case w.Name == "":
+		return errors.New("name must not be empty")
+	case w.Group == "":
+		return errors.New("group must not be empty")
+	case w.Topic == "":
+		return errors.New("topic must not be empty")
+	case w.Timeout <= 0:
+		return errors.New("timeout must be > 0")
+	}
+	endpoint, err := url.Parse(w.URL)
+	if err != nil {
+		return errors.Wrap(err, "url is invalid")
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return errors.Errorf("url scheme must be http or https: %q", w.URL)
+	}
+	return nil
+}
+
+// FeaturesCfg defines the startup state of the process wide feature flags,
+// see the features package for the known flag names.
+type FeaturesCfg struct {
+	// Global flag overrides, keyed by flag name.
+	Flags map[string]bool `yaml:"flags"`
+
+	// Per topic overrides of flags, keyed by topic then flag name. They take
+	// precedence over per group and global overrides.
+	FlagsByTopic map[string]map[string]bool `yaml:"flags_by_topic"`
+
+	// Per group overrides of flags, keyed by group then flag name. They take
+	// precedence over global overrides.
+	FlagsByGroup map[string]map[string]bool `yaml:"flags_by_group"`
+}
+
+func (f *FeaturesCfg) validate() error {
+	for flag := range f.Flags {
+		if !features.IsValid(flag) {
+			return errors.Errorf("unknown feature flag: %s", flag)
+		}
+	}
+	for topic, flags := range f.FlagsByTopic {
+		for flag := range flags {
+			if !features.IsValid(flag) {
+				return errors.Errorf("unknown feature flag: %s, topic=%s", flag, topic)
+			}
+		}
+	}
+	for group, flags := range f.FlagsByGroup {
+		for flag := range flags {
+			if !features.IsValid(flag) {
+				return errors.Errorf("unknown feature flag: %s, group=%s", flag, group)
+			}
+		}
+	}
+	return nil
+}
+
+// Valid StartupCfg.Mode values.
+const (
+	// StartupFailFast aborts the service start on the first proxy that fails
+	// to connect to its Kafka or ZooKeeper cluster. This is the historical
+	// behavior and the default.
+	StartupFailFast = "fail-fast"
+
+	// StartupWait keeps retrying to connect with exponential backoff for up
+	// to StartupCfg.MaxWait before giving up. The API servers are not exposed
+	// until every proxy is connected.
+	StartupWait = "wait"
+
+	// StartupDegraded exposes the API servers immediately and keeps retrying
+	// to connect proxies that failed at boot in the background. API calls
+	// addressed to a proxy that is not connected yet fail, and per proxy
+	// readiness is reported via `GET /_ready`.
+	StartupDegraded = "degraded"
+)
+
+// StartupCfg defines what happens at boot when a dependency of a configured
+// proxy is not reachable.
+type StartupCfg struct {
+	// Mode is one of fail-fast, wait, or degraded. An empty value is
+	// equivalent to fail-fast.
+	Mode string `yaml:"mode"`
+
+	// MaxWait limits how long the wait mode keeps retrying before the service
+	// start is aborted. It has no effect in other modes.
+	MaxWait time.Duration `yaml:"max_wait"`
+}
+
+func (c *StartupCfg) validate() error {
+	switch c.Mode {
+	case "", StartupFailFast, StartupWait, StartupDegraded:
+	default:
+		return errors.Errorf("mode is invalid: %q", c.Mode)
+	}
+	if c.MaxWait <= 0 {
+		return errors.New("max_wait must be > 0")
+	}
+	return nil
+}
+
+// TopicTemplate describes the desired layout and configuration of a topic
+// that Kafka-Pixy creates and maintains itself, e.g. the dead letter topic.
+type TopicTemplate struct {
+	// Number of partitions to create the topic with.
+	Partitions int32 `yaml:"partitions"`
+
+	// Replication factor to create the topic with.
+	ReplicationFactor int16 `yaml:"replication_factor"`
+
+	// How long records of the topic are retained. Maps to the retention.ms
+	// topic parameter. 0 means use the broker default.
+	Retention time.Duration `yaml:"retention"`
+}
+
+// ConfigEntries returns the topic level config parameters implied by the
+// template, in the format the Kafka Admin API expects them.
+func (t *TopicTemplate) ConfigEntries() map[string]string {
+	entries := make(map[string]string)
+	if t.Retention > 0 {
+		entries["retention.ms"] = strconv.FormatInt(int64(t.Retention/time.Millisecond), 10)
+	}
+	return entries
 }
 
 // LoggerCfg represents a configuration of an individual logger.
@@ -83,12 +359,30 @@ type Proxy struct {
 	// leave it like that.
 	ClientID string `yaml:"client_id"`
 
+	// If true, then the proxy serves its API from an in-process, in-memory
+	// topic store instead of a real Kafka/ZooKeeper cluster. It is intended
+	// for integration testing of applications that use Kafka-Pixy, so that
+	// they do not need to run Kafka/ZooKeeper containers. Note that the
+	// emulated cluster is a simplified test double: every topic has exactly
+	// one partition and consumed messages are committed immediately.
+	Emulator bool `yaml:"emulator"`
+
 	Kafka struct {
 
 		// List of seed Kafka peers that Kafka-Pixy should access to resolve
 		// the Kafka cluster topology.
 		SeedPeers []string `yaml:"seed_peers"`
 
+		// Kafka client library to use for talking to the cluster. The only
+		// supported value is "sarama", which is also the default. The
+		// parameter exists to allow alternative client backends to be
+		// introduced without a config format change.
+		Backend string `yaml:"backend"`
+
+		// How often the Kafka client refreshes the cached cluster metadata
+		// in the background.
+		MetadataRefreshFrequency time.Duration `yaml:"metadata_refresh_frequency"`
+
 		// Version of the Kafka cluster. Supported versions are 0.10.2.1 - 2.0.0
 		Version KafkaVersion
 
@@ -113,6 +407,27 @@ type Proxy struct {
 		//  In this mode, TLS is susceptible to man-in-the-middle attacks.
 		//  This should be used only for testing.
 		InsecureSkipVerify bool `yaml:"insecure"`
+
+		// SASL authentication to Kafka brokers. Unlike the legacy net.sasl
+		// parameters, which only support the PLAIN mechanism and only the
+		// consumer side clients, these apply to every Kafka client the
+		// proxy creates.
+		SASL struct {
+			// If true then SASL authentication is used when connecting to
+			// the brokers.
+			Enabled bool `yaml:"enabled"`
+
+			// SASL mechanism to authenticate with. Can be one of: "plain"
+			// (default), "scram-sha-256" or "scram-sha-512". Managed Kafka
+			// offerings typically require one of the SCRAM mechanisms.
+			Mechanism string `yaml:"mechanism"`
+
+			// Authentication identity.
+			Username string `yaml:"username"`
+
+			// Password of the authentication identity.
+			Password string `yaml:"password"`
+		} `yaml:"sasl"`
 	} `yaml:"kafka"`
 
 	ZooKeeper struct {
@@ -130,8 +445,62 @@ type Proxy struct {
 		//
 		// See http://zookeeper.apache.org/doc/trunk/zookeeperProgrammers.html#ch_zkSessions
 		SessionTimeout time.Duration `yaml:"session_timeout"`
+
+		// How long to wait for a TCP connection to an individual ZooKeeper
+		// peer to be established.
+		ConnectTimeout time.Duration `yaml:"connect_timeout"`
 	} `yaml:"zoo_keeper"`
 
+	Admin struct {
+		// Backend used by the admin module to fetch consumer group membership
+		// and topic configuration data. Can be one of:
+		//   "zookeeper" - read the data from ZooKeeper (default);
+		//   "kafka"     - use the broker Admin/Coordinator APIs, for clusters
+		//                 where Kafka-Pixy has no ZooKeeper access.
+		Backend string `yaml:"backend"`
+
+		// If true then the records deletion API is enabled for the cluster.
+		// Deleting records is irreversible, so the API is disabled by
+		// default.
+		AllowRecordsDeletion bool `yaml:"allow_records_deletion"`
+
+		// If true then the topic deletion API is enabled for the cluster.
+		// Deleting a topic is irreversible, so the API is disabled by
+		// default.
+		AllowTopicDeletion bool `yaml:"allow_topic_deletion"`
+	} `yaml:"admin"`
+
+	// Access lists restricting which topics and consumer groups may be
+	// accessed through this proxy instance, independent of broker ACLs, so
+	// that an edge-facing deployment can expose only a safe subset of the
+	// cluster.
+	Access struct {
+		// Regular expressions of topics that may be produced to or consumed
+		// from through this proxy. If the list is empty, then all topics not
+		// matched by denied_topics are accessible. Expressions are anchored,
+		// that is they must match the whole topic name.
+		AllowedTopics []string `yaml:"allowed_topics"`
+
+		// Regular expressions of topics that may not be accessed through
+		// this proxy. Denial takes precedence over allowed_topics.
+		DeniedTopics []string `yaml:"denied_topics"`
+
+		// Same as allowed_topics but applied to consumer group names.
+		AllowedGroups []string `yaml:"allowed_groups"`
+
+		// Same as denied_topics but applied to consumer group names.
+		DeniedGroups []string `yaml:"denied_groups"`
+	} `yaml:"access"`
+
+	// Fault injection facility used to test client resilience against a
+	// staging Kafka-Pixy deployment.
+	FaultInjection struct {
+		// If true, then faults can be registered at run time via the
+		// `/_faults` admin API to inject artificial latency or errors into
+		// produce/consume operations. Never enable this in production.
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"fault_injection"`
+
 	// Networking timeouts. These all pass through to sarama's `config.Net`
 	// field.
 	Net struct {
@@ -144,6 +513,22 @@ type Proxy struct {
 		// How long to wait for a transmit.
 		WriteTimeout time.Duration `yaml:"write_timeout"`
 
+		// How often to re-resolve the hostnames of kafka.seed_peers and
+		// zoo_keeper.seed_peers. If the set of addresses a hostname resolves
+		// to has changed, e.g. after a node replacement behind a Kubernetes
+		// headless service, then the proxy of the cluster is restarted to
+		// rebuild its connections, instead of keeping dialing addresses that
+		// no longer exist. 0 (default) disables the checks.
+		ReResolveInterval time.Duration `yaml:"re_resolve_interval"`
+
+		// URL of an egress proxy to establish connections to Kafka brokers
+		// and ZooKeeper through: "socks5://[user:password@]host:port" for a
+		// SOCKS5 proxy or "http://[user:password@]host:port" for an HTTP
+		// proxy speaking the CONNECT method. If empty (default) then
+		// connections are established directly. Intended for locked-down
+		// environments where the cluster is only reachable through a tunnel.
+		Proxy string `yaml:"proxy"`
+
 		// SASL support for SASL PLAIN
 		SASL struct {
 			// Whether or not to use SASL authentication when connecting to the broker (defaults to false).
@@ -174,6 +559,12 @@ type Proxy struct {
 		// The type of compression to use on messages.
 		Compression Compression `yaml:"compression"`
 
+		// The level of compression to use on messages. The meaning of the
+		// value depends on the codec: gzip takes 1-9, zstd takes 1-22. If 0
+		// then the default level of the codec is used. Ignored by snappy and
+		// lz4, that do not have levels.
+		CompressionLevel int `yaml:"compression_level"`
+
 		// The best-effort number of bytes needed to trigger a flush.
 		FlushBytes int `yaml:"flush_bytes"`
 
@@ -183,6 +574,35 @@ type Proxy struct {
 		// How long to wait for the cluster to settle between retries.
 		RetryBackoff time.Duration `yaml:"retry_backoff"`
 
+		// If enabled, then the time a message was accepted for production is
+		// stamped into a `kafka-pixy-produced-at` header, and the
+		// produce-to-consume latency is reported per topic in `GET /_metrics`
+		// when the message is later consumed through Kafka-Pixy. Requires
+		// Kafka 0.11+.
+		TimestampHeader bool `yaml:"timestamp_header"`
+
+		// If enabled, then a message bigger than max_message_bytes is not
+		// rejected but split into chunks of at most max_message_bytes each,
+		// correlated via `kafka-pixy-chunk-*` headers and produced as
+		// individual records sharing one partition key. Consuming through
+		// Kafka-Pixy reassembles the chunks transparently and delivers the
+		// original message, consumers that do not go through Kafka-Pixy see
+		// the individual chunk records. Requires Kafka 0.11+.
+		Chunking bool `yaml:"chunking"`
+
+		// If not empty, then messages that could not be submitted to Kafka,
+		// e.g. because they are too large or all retries are exhausted, are
+		// appended to this file as JSON records along with the error, instead
+		// of being dropped with just a log record.
+		DeadMessageFile string `yaml:"dead_message_file"`
+
+		// Pins topics to specific schema registry schema ids. Messages
+		// produced to a pinned topic must be in the Confluent wire format,
+		// a zero magic byte followed by a big-endian 32 bit schema id, and
+		// carry exactly the pinned id, otherwise the produce request is
+		// rejected. Topics that are not listed are not checked.
+		SchemaIDByTopic map[string]int32 `yaml:"schema_id_by_topic"`
+
 		// The total number of times to retry sending a message.
 		RetryMax int `yaml:"retry_max"`
 
@@ -194,15 +614,64 @@ type Proxy struct {
 		// a ZooKeeper leader election in your setup.
 		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
 
-		// How to assign incoming messages to a Kafka partition. Defaults to
-		// using a hash of the specified message key, or random if the key is
-		// unspecified.
+		// How to assign incoming messages to a Kafka partition. One of hash
+		// (FNV-1a of the message key), random, roundrobin, or murmur2 (the
+		// algorithm of the Java client, so that clients migrated from Java
+		// producers get identical partition placement). Defaults to hash,
+		// falling back to random if the key is unspecified. Regardless of the
+		// partitioner a partition requested explicitly in a produce API call
+		// always wins.
 		Partitioner PartitionerConstructor `yaml:"partitioner"`
 
 		// The timeout to specify on individual produce requests to the broker.
 		// The broker will wait for replication to complete up to this duration
 		// before returning an error.
 		Timeout time.Duration `yaml:"timeout"`
+
+		// If set, then a produce request that has not been responded to
+		// within this period is hedged: topic metadata is refreshed, so that
+		// a recovered or newly elected leader is picked up, and a copy of
+		// the message is submitted for production. The client is answered
+		// with whichever attempt settles first, cutting the p99 produce
+		// latency during transient broker hiccups. Hedging enables the
+		// idempotent producer, that guards against duplicates from
+		// broker-side retries, and therefore requires Kafka 0.11+ and
+		// required_acks of all (-1). A hedged copy is a separate produce
+		// request though, so in the rare case when both attempts succeed
+		// the message is duplicated.
+		HedgeTimeout time.Duration `yaml:"hedge_timeout"`
+
+		// If set to a positive number, then new produce requests are rejected
+		// with a throttle error once the count of messages pending broker
+		// acknowledgement reaches this watermark, instead of buffering
+		// unboundedly while the cluster is slow. Set to 0 (default) to
+		// disable produce admission control.
+		ThrottleHighWatermark int `yaml:"throttle_high_watermark"`
+
+		// The pending message count below which produce admission resumes
+		// after throttling has been engaged. The gap between the watermarks
+		// prevents admission from flapping around a single threshold.
+		// Defaults to half of throttle_high_watermark.
+		ThrottleLowWatermark int `yaml:"throttle_low_watermark"`
+
+		// What to do with a message produced to a topic that does not exist:
+		//
+		//   broker - submit the message as is and let the broker decide, so
+		//            the outcome follows the auto.create.topics.enable
+		//            setting of the cluster;
+		//   reject - refuse the message with a "topic does not exist" error;
+		//   create - create the topic with unknown_topic_partitions
+		//            partitions and unknown_topic_replication_factor before
+		//            producing.
+		UnknownTopicPolicy string `yaml:"unknown_topic_policy"`
+
+		// The number of partitions to create an unknown topic with. Only
+		// used when unknown_topic_policy is create.
+		UnknownTopicPartitions int32 `yaml:"unknown_topic_partitions"`
+
+		// The replication factor to create an unknown topic with. Only used
+		// when unknown_topic_policy is create.
+		UnknownTopicReplicationFactor int16 `yaml:"unknown_topic_replication_factor"`
 	} `yaml:"producer"`
 
 	Consumer struct {
@@ -210,10 +679,28 @@ type Proxy struct {
 		// call the consumer APIs will return an error.
 		Disabled bool `yaml:"disabled"`
 
+		// How consumer group membership is coordinated. Can be one of:
+		//   "zookeeper" - group membership and partition ownership are kept
+		//                 in ZooKeeper (default);
+		//   "kafka"     - group membership is maintained via the Kafka group
+		//                 coordinator protocol (JoinGroup/SyncGroup/
+		//                 Heartbeat), so that Kafka-Pixy can run against
+		//                 clusters with no ZooKeeper access at all.
+		Coordination string `yaml:"coordination"`
+
 		// Period of time that Kafka-Pixy should wait for an acknowledgement
 		// before retrying.
 		AckTimeout time.Duration `yaml:"ack_timeout"`
 
+		// If enabled then the HTTP API only accepts acknowledgements that
+		// present the opaque ack_token issued with the consumed message,
+		// and rejects raw partition/offset acknowledgements, including ack
+		// ranges, so a client cannot acknowledge offsets it never received.
+		// Tokens are issued either way, so clients can be migrated before
+		// flipping the switch. Disabled by default for backwards
+		// compatibility.
+		RequireAckToken bool `yaml:"require_ack_token"`
+
 		// Size of all buffered channels created by the consumer module.
 		ChannelBufferSize int `yaml:"channel_buffer_size"`
 
@@ -260,10 +747,184 @@ type Proxy struct {
 		// wait this long before retrying.
 		RetryBackoff time.Duration `yaml:"retry_backoff"`
 
+		// Strategy that defines how long an expired offered message waits
+		// before it is offered to a consumer again: "immediate" re-offers it
+		// as soon as the ack timeout expires, "fixed" waits retry_backoff
+		// first, and "exponential" doubles retry_backoff with every retry up
+		// to retry_backoff_max.
+		RetryStrategy string `yaml:"retry_strategy"`
+
+		// Upper bound of the re-offer delay when retry_strategy is
+		// "exponential".
+		RetryBackoffMax time.Duration `yaml:"retry_backoff_max"`
+
+		// Per topic overrides of retry_strategy.
+		RetryStrategyByTopic map[string]string `yaml:"retry_strategy_by_topic"`
+
+		// Where a brand-new group, one that has no offset committed for a
+		// topic yet, starts consuming the topic from: "earliest" starts
+		// from the oldest retained message, "latest" (default) starts from
+		// the next produced message, a duration such as "24h" starts from
+		// the first message produced at most that long ago, and an RFC3339
+		// timestamp such as "2019-07-12T15:04:05Z" starts from the first
+		// message produced at or after that time.
+		FirstOffset string `yaml:"first_offset"`
+
+		// Per topic overrides of first_offset, since different topics have
+		// very different replay needs.
+		FirstOffsetByTopic map[string]string `yaml:"first_offset_by_topic"`
+
+		// Topics that have log compaction enabled. Compaction leaves gaps in
+		// partition offsets, which is normal for such topics, so offset gaps
+		// in them are skipped rather than reported as possible data loss,
+		// and the skipped offsets do not hold the committed offset back.
+		CompactedTopics []string `yaml:"compacted_topics"`
+
+		// How partitions of a topic are divided among the group members
+		// consuming it. The "range" strategy splits sorted partitions into
+		// contiguous chunks, one per member, and is how the standard Java
+		// High-Level consumer behaves. The "sticky" strategy uses rendezvous
+		// hashing so that membership changes move close to the minimum
+		// number of partitions between members, at the price of slightly
+		// less even distribution. All members of a group must use the same
+		// strategy.
+		AssignmentStrategy string `yaml:"assignment_strategy"`
+
+		// The maximum number of members the consumer group may have. When
+		// the limit is reached an instance that is not a member yet refuses
+		// to register with a descriptive error and its consume requests
+		// fail, instead of joining the group and thrashing partition
+		// assignments, e.g. because of misconfigured autoscaling. All
+		// members of a group should use the same limit. 0 means no limit.
+		MaxGroupMembers int `yaml:"max_group_members"`
+
+		// Partitions that this instance prefers to be assigned, for
+		// workloads with partition-local caches and state. The assignment
+		// strategy honors the hint where it can do so without skewing the
+		// distribution: a preferred partition is traded for one of the
+		// instance's other partitions, so the balance guarantees of the
+		// strategy are preserved. When several instances prefer the same
+		// partition only one of them gets it. Unlike other consumer
+		// parameters preferences may differ between group members.
+		PreferredPartitions []int32 `yaml:"preferred_partitions"`
+
+		// If not empty, then messages that are given up on, because they
+		// have been offered and expired max_retries times, are produced to
+		// this topic along with headers that describe their origin and
+		// failure, instead of being dropped.
+		DeadLetterTopic string `yaml:"dead_letter_topic"`
+
+		// Template applied to the dead letter topic so that it does not
+		// depend on the broker topic defaults. If the topic does not exist
+		// when the proxy starts, then it is created according to the
+		// template; if it does, then its config is verified and repaired to
+		// match. Only used if dead_letter_topic is set.
+		DeadLetterTopicTemplate TopicTemplate `yaml:"dead_letter_topic_template"`
+
+		// If not empty, then every offset commit performed on behalf of a
+		// consumer group is appended to this file as a JSON record with the
+		// group, topic, partition, old and new offsets and what triggered
+		// the commit, to reconstruct offset movements during incident
+		// reviews.
+		OffsetCommitAuditFile string `yaml:"offset_commit_audit_file"`
+
 		// Period of time that Kafka-Pixy should keep subscription to
 		// a topic by a group in absence of requests from the consumer group.
 		SubscriptionTimeout time.Duration `yaml:"subscription_timeout"`
+
+		// How long a rebalance waits for a partition that is being revoked
+		// to drain its offered but not yet acknowledged messages before the
+		// partition consumer is stopped anyway and its final offset is
+		// committed. The bound keeps a client that sits on an offered
+		// message from stalling the rebalance, while making clean handoffs
+		// duplicate free. Set to 0 to hand partitions off immediately.
+		RebalanceDrainTimeout time.Duration `yaml:"rebalance_drain_timeout"`
+
+		// HTTP status code returned when a long polling consume request
+		// times out with no message available: 204, 404 or 408. Some client
+		// libraries treat particular codes as hard errors, so the default of
+		// 408 can be changed if it does not suit yours.
+		NoMessageStatusCode int `yaml:"no_message_status_code"`
+
+		// gRPC status code returned when a long polling consume request
+		// times out with no message available: "not_found" or
+		// "deadline_exceeded".
+		NoMessageGRPCCode string `yaml:"no_message_grpc_code"`
 	} `yaml:"consumer"`
+
+	// Advanced tuning knobs that map directly onto the remaining sarama
+	// Config fields and apply to both the producer and the consumer Kafka
+	// clients. They rarely need to be changed from the sarama defaults.
+	Advanced struct {
+
+		// How many outstanding requests a connection is allowed to have
+		// before sending blocks. Maps to sarama's Net.MaxOpenRequests.
+		MaxOpenRequests int `yaml:"max_open_requests"`
+
+		// Keep-alive period for broker connections, 0 disables keep-alives.
+		// Maps to sarama's Net.KeepAlive.
+		KeepAlive time.Duration `yaml:"keep_alive"`
+
+		// Total number of times to retry a metadata request. Maps to
+		// sarama's Metadata.Retry.Max.
+		MetadataRetryMax int `yaml:"metadata_retry_max"`
+
+		// How long to wait for a cluster leader election between metadata
+		// request retries. Maps to sarama's Metadata.Retry.Backoff.
+		MetadataRetryBackoff time.Duration `yaml:"metadata_retry_backoff"`
+
+		// Minimum number of message bytes to fetch in a consume request.
+		// Maps to sarama's Consumer.Fetch.Min.
+		FetchMin int32 `yaml:"fetch_min"`
+
+		// Default number of message bytes to fetch from a broker in each
+		// consume request. Maps to sarama's Consumer.Fetch.Default.
+		FetchDefault int32 `yaml:"fetch_default"`
+	} `yaml:"advanced"`
+}
+
+// ConsumerRetryStrategy returns the re-offer delay strategy effective for the
+// specified topic.
+func (p *Proxy) ConsumerRetryStrategy(topic string) string {
+	if strategy, ok := p.Consumer.RetryStrategyByTopic[topic]; ok {
+		return strategy
+	}
+	return p.Consumer.RetryStrategy
+}
+
+// IsCompactedTopic tells whether a topic is declared to have log compaction
+// enabled via consumer.compacted_topics.
+func (p *Proxy) IsCompactedTopic(topic string) bool {
+	for _, compactedTopic := range p.Consumer.CompactedTopics {
+		if compactedTopic == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// ConsumerFirstOffset returns the consumption start policy effective for the
+// specified topic, applied when the group has no offset committed for it.
+func (p *Proxy) ConsumerFirstOffset(topic string) string {
+	if firstOffset, ok := p.Consumer.FirstOffsetByTopic[topic]; ok {
+		return firstOffset
+	}
+	return p.Consumer.FirstOffset
+}
+
+// validFirstOffset tells whether a value is acceptable as a consumption start
+// policy: one of the FirstOffset* constants, a positive duration, or an
+// RFC3339 timestamp.
+func validFirstOffset(firstOffset string) bool {
+	switch firstOffset {
+	case FirstOffsetEarliest, FirstOffsetLatest:
+		return true
+	}
+	if ago, err := time.ParseDuration(firstOffset); err == nil {
+		return ago > 0
+	}
+	_, err := time.Parse(time.RFC3339, firstOffset)
+	return err == nil
 }
 
 type KafkaVersion struct {
@@ -323,6 +984,7 @@ func (c *Compression) UnmarshalText(text []byte) error {
 		"gzip":   sarama.CompressionGZIP,
 		"snappy": sarama.CompressionSnappy,
 		"lz4":    sarama.CompressionLZ4,
+		"zstd":   sarama.CompressionZSTD,
 	}[str]
 	if !ok {
 		return errors.Errorf("bad compression, %s", str)
@@ -354,6 +1016,7 @@ func (pc PartitionerConstructor) ToPartitionerConstructor() (sarama.PartitionerC
 		"hash":       sarama.NewHashPartitioner,
 		"random":     sarama.NewRandomPartitioner,
 		"roundrobin": sarama.NewRoundRobinPartitioner,
+		"murmur2":    sarama.NewCustomPartitioner(sarama.WithAbsFirst(), sarama.WithCustomHashFunction(newMurmur2Hash32)),
 	}[string(pc)]
 	if !ok {
 		return nil, errors.Errorf("bad partitioner: %s", pc)
@@ -367,33 +1030,44 @@ func (p *Proxy) SaramaProducerCfg() *sarama.Config {
 	saramaCfg.ChannelBufferSize = p.Producer.ChannelBufferSize
 	saramaCfg.ClientID = p.ClientID
 	saramaCfg.Version = p.Kafka.Version.v
+	saramaCfg.Metadata.RefreshFrequency = p.Kafka.MetadataRefreshFrequency
+	saramaCfg.Metadata.Retry.Max = p.Advanced.MetadataRetryMax
+	saramaCfg.Metadata.Retry.Backoff = p.Advanced.MetadataRetryBackoff
 
 	saramaCfg.Net.DialTimeout = p.Net.DialTimeout
 	saramaCfg.Net.ReadTimeout = p.Net.ReadTimeout
 	saramaCfg.Net.WriteTimeout = p.Net.WriteTimeout
-
-	if p.Net.SASL.Enable {
-		saramaCfg.Net.SASL.Enable = p.Net.SASL.Enable
-		saramaCfg.Net.SASL.Handshake = p.Net.SASL.Handshake
-		saramaCfg.Net.SASL.User = p.Net.SASL.User
-		saramaCfg.Net.SASL.Password = p.Net.SASL.Password
-	}
+	saramaCfg.Net.MaxOpenRequests = p.Advanced.MaxOpenRequests
+	saramaCfg.Net.KeepAlive = p.Advanced.KeepAlive
 
 	saramaCfg.Producer.MaxMessageBytes = p.Producer.MaxMessageBytes
 	saramaCfg.Producer.Compression = sarama.CompressionCodec(p.Producer.Compression)
+	if p.Producer.CompressionLevel != 0 {
+		saramaCfg.Producer.CompressionLevel = p.Producer.CompressionLevel
+	}
 	saramaCfg.Producer.Flush.Frequency = p.Producer.FlushFrequency
 	saramaCfg.Producer.Flush.Bytes = p.Producer.FlushBytes
 	saramaCfg.Producer.Retry.Backoff = p.Producer.RetryBackoff
 	saramaCfg.Producer.Retry.Max = p.Producer.RetryMax
 	saramaCfg.Producer.RequiredAcks = sarama.RequiredAcks(p.Producer.RequiredAcks)
-	saramaCfg.Producer.Partitioner, _ = p.Producer.Partitioner.ToPartitionerConstructor()
+	partitionerConstructor, _ := p.Producer.Partitioner.ToPartitionerConstructor()
+	// The wrapper honors an explicit partition requested by a produce API
+	// call, falling through to the configured partitioner otherwise.
+	saramaCfg.Producer.Partitioner = withManualOverride(partitionerConstructor)
 	saramaCfg.Producer.Timeout = p.Producer.Timeout
 
+	if p.Producer.HedgeTimeout > 0 {
+		saramaCfg.Producer.Idempotent = true
+		saramaCfg.Net.MaxOpenRequests = 1
+	}
+
 	if p.Kafka.TLSEnabled {
 		saramaCfg.Net.TLS.Enable = true
 		tlsCfg, _ := p.newTLSConfig() // Ok to ignore err since we validated
 		saramaCfg.Net.TLS.Config = tlsCfg
 	}
+	p.applySASLCfg(saramaCfg)
+	p.applyNetProxyCfg(saramaCfg)
 
 	return saramaCfg
 }
@@ -403,27 +1077,74 @@ func (p *Proxy) SaramaClientCfg() *sarama.Config {
 	saramaCfg.ChannelBufferSize = p.Consumer.ChannelBufferSize
 	saramaCfg.ClientID = p.ClientID
 	saramaCfg.Version = p.Kafka.Version.v
+	saramaCfg.Metadata.RefreshFrequency = p.Kafka.MetadataRefreshFrequency
+	saramaCfg.Metadata.Retry.Max = p.Advanced.MetadataRetryMax
+	saramaCfg.Metadata.Retry.Backoff = p.Advanced.MetadataRetryBackoff
 
 	saramaCfg.Net.DialTimeout = p.Net.DialTimeout
 	saramaCfg.Net.ReadTimeout = p.Net.ReadTimeout
 	saramaCfg.Net.WriteTimeout = p.Net.WriteTimeout
+	saramaCfg.Net.MaxOpenRequests = p.Advanced.MaxOpenRequests
+	saramaCfg.Net.KeepAlive = p.Advanced.KeepAlive
 
-	if p.Net.SASL.Enable {
-		saramaCfg.Net.SASL.Enable = p.Net.SASL.Enable
-		saramaCfg.Net.SASL.Handshake = p.Net.SASL.Handshake
-		saramaCfg.Net.SASL.User = p.Net.SASL.User
-		saramaCfg.Net.SASL.Password = p.Net.SASL.Password
-	}
+	saramaCfg.Consumer.Fetch.Min = p.Advanced.FetchMin
+	saramaCfg.Consumer.Fetch.Default = p.Advanced.FetchDefault
+
+	p.applySASLCfg(saramaCfg)
 
 	if p.Kafka.TLSEnabled {
 		saramaCfg.Net.TLS.Enable = true
 		tlsCfg, _ := p.newTLSConfig() // Ok to ignore err since we validated
 		saramaCfg.Net.TLS.Config = tlsCfg
 	}
+	p.applyNetProxyCfg(saramaCfg)
 
 	return saramaCfg
 }
 
+// applyNetProxyCfg points the sarama config at the egress proxy configured
+// via net.proxy, if any, so broker connections are established through it.
+func (p *Proxy) applyNetProxyCfg(saramaCfg *sarama.Config) {
+	if p.Net.Proxy == "" {
+		return
+	}
+	dialer, _ := netproxy.Dialer(p.Net.Proxy, p.Net.DialTimeout) // Ok to ignore err since we validated
+	saramaCfg.Net.Proxy.Enable = true
+	saramaCfg.Net.Proxy.Dialer = dialer
+}
+
+// applySASLCfg applies SASL authentication parameters to a sarama config. The
+// kafka.sasl section takes precedence; if it is disabled then the legacy
+// net.sasl parameters are honoured for backwards compatibility.
+func (p *Proxy) applySASLCfg(saramaCfg *sarama.Config) {
+	if !p.Kafka.SASL.Enabled {
+		if p.Net.SASL.Enable {
+			saramaCfg.Net.SASL.Enable = p.Net.SASL.Enable
+			saramaCfg.Net.SASL.Handshake = p.Net.SASL.Handshake
+			saramaCfg.Net.SASL.User = p.Net.SASL.User
+			saramaCfg.Net.SASL.Password = p.Net.SASL.Password
+		}
+		return
+	}
+	saramaCfg.Net.SASL.Enable = true
+	saramaCfg.Net.SASL.User = p.Kafka.SASL.Username
+	saramaCfg.Net.SASL.Password = p.Kafka.SASL.Password
+	switch p.Kafka.SASL.Mechanism {
+	case "scram-sha-256":
+		saramaCfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		saramaCfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashFn: sha256.New}
+		}
+	case "scram-sha-512":
+		saramaCfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		saramaCfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashFn: sha512.New}
+		}
+	default:
+		saramaCfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	}
+}
+
 func (p *Proxy) newTLSConfig() (*tls.Config, error) {
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: p.Kafka.InsecureSkipVerify,
@@ -489,6 +1210,7 @@ func FromYAMLFile(filename string) (*App, error) {
 	if err != nil {
 		return nil, err
 	}
+	appCfg.sourceFile = filename
 	return appCfg, nil
 }
 
@@ -529,6 +1251,16 @@ func FromYAML(data []byte) (*App, error) {
 		}
 	}
 
+	for i := range appCfg.Webhooks {
+		whCfg := &appCfg.Webhooks[i]
+		if whCfg.ContentType == "" {
+			whCfg.ContentType = "application/octet-stream"
+		}
+		if whCfg.Timeout == 0 {
+			whCfg.Timeout = 10 * time.Second
+		}
+	}
+
 	if err := appCfg.validate(); err != nil {
 		return nil, errors.Wrap(err, "invalid config parameter")
 	}
@@ -545,10 +1277,53 @@ func (a *App) validate() error {
 			return errors.Wrapf(err, "invalid config, cluster=%s", cluster)
 		}
 	}
+	for i := range a.Pipelines {
+		plCfg := &a.Pipelines[i]
+		if err := plCfg.validate(); err != nil {
+			return errors.Wrapf(err, "invalid pipeline config, name=%s", plCfg.Name)
+		}
+		if plCfg.Cluster != "" {
+			if _, ok := a.Proxies[plCfg.Cluster]; !ok {
+				return errors.Errorf("pipeline %s refers to unknown cluster %s",
+					plCfg.Name, plCfg.Cluster)
+			}
+		}
+	}
+	if err := a.Features.validate(); err != nil {
+		return errors.Wrap(err, "invalid features config")
+	}
+	if err := a.Startup.validate(); err != nil {
+		return errors.Wrap(err, "invalid startup config")
+	}
+	if a.ShutdownTimeout < 0 {
+		return errors.New("shutdown_timeout must be >= 0")
+	}
+	for i := range a.Webhooks {
+		whCfg := &a.Webhooks[i]
+		if err := whCfg.validate(); err != nil {
+			return errors.Wrapf(err, "invalid webhook config, name=%s", whCfg.Name)
+		}
+		if whCfg.Cluster != "" {
+			if _, ok := a.Proxies[whCfg.Cluster]; !ok {
+				return errors.Errorf("webhook %s refers to unknown cluster %s",
+					whCfg.Name, whCfg.Cluster)
+			}
+		}
+	}
 	return nil
 }
 
 func (p *Proxy) validate() error {
+	// Validate the Net parameters.
+	if p.Net.ReResolveInterval < 0 {
+		return errors.New("net.re_resolve_interval must be >= 0")
+	}
+	if p.Net.Proxy != "" {
+		if _, err := netproxy.Dialer(p.Net.Proxy, p.Net.DialTimeout); err != nil {
+			return errors.Wrap(err, "net.proxy is invalid")
+		}
+	}
+
 	// Validate the Producer parameters.
 	switch {
 	case p.Producer.ChannelBufferSize <= 0:
@@ -565,10 +1340,78 @@ func (p *Proxy) validate() error {
 		return errors.New("producer.shutdown_timeout must be >= 0")
 	case p.Producer.Timeout < 0:
 		return errors.New("producer.timeout must be >= 0")
+	case p.Producer.HedgeTimeout < 0:
+		return errors.New("producer.hedge_timeout must be >= 0")
+	}
+	if p.Producer.HedgeTimeout > 0 {
+		if !p.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+			return errors.New("producer.hedge_timeout requires kafka.version to be 0.11 or later")
+		}
+		if sarama.RequiredAcks(p.Producer.RequiredAcks) != sarama.WaitForAll {
+			return errors.New("producer.hedge_timeout requires producer.required_acks to be all (-1)")
+		}
+	}
+	if p.Producer.Chunking && !p.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return errors.New("producer.chunking requires kafka.version to be 0.11 or later")
+	}
+	if sarama.CompressionCodec(p.Producer.Compression) == sarama.CompressionZSTD && !p.Kafka.Version.IsAtLeast(sarama.V2_1_0_0) {
+		return errors.New("producer.compression zstd requires kafka.version to be 2.1 or later")
+	}
+	if p.Producer.CompressionLevel < 0 {
+		return errors.New("producer.compression_level must be >= 0")
+	}
+	switch {
+	case p.Producer.ThrottleHighWatermark < 0:
+		return errors.New("producer.throttle_high_watermark must be >= 0")
+	case p.Producer.ThrottleLowWatermark < 0:
+		return errors.New("producer.throttle_low_watermark must be >= 0")
+	case p.Producer.ThrottleLowWatermark > 0 && p.Producer.ThrottleHighWatermark == 0:
+		return errors.New("producer.throttle_low_watermark requires producer.throttle_high_watermark to be set")
+	case p.Producer.ThrottleLowWatermark >= p.Producer.ThrottleHighWatermark && p.Producer.ThrottleLowWatermark > 0:
+		return errors.New("producer.throttle_low_watermark must be < producer.throttle_high_watermark")
 	}
 	if _, err := p.Producer.Partitioner.ToPartitionerConstructor(); err != nil {
 		return fmt.Errorf("producer.partitioner is invalid: %q", err)
 	}
+	for topic, schemaID := range p.Producer.SchemaIDByTopic {
+		if schemaID < 0 {
+			return errors.Errorf("producer.schema_id_by_topic.%s must be >= 0", topic)
+		}
+	}
+	switch p.Producer.UnknownTopicPolicy {
+	case UnknownTopicBroker, UnknownTopicReject:
+	case UnknownTopicCreate:
+		if p.Producer.UnknownTopicPartitions < 1 {
+			return errors.New("producer.unknown_topic_partitions must be > 0")
+		}
+		if p.Producer.UnknownTopicReplicationFactor < 1 {
+			return errors.New("producer.unknown_topic_replication_factor must be > 0")
+		}
+	default:
+		return errors.Errorf("producer.unknown_topic_policy is invalid: %q", p.Producer.UnknownTopicPolicy)
+	}
+	// Validate the ZooKeeper parameters.
+	switch {
+	case p.ZooKeeper.SessionTimeout <= 0:
+		return errors.New("zoo_keeper.session_timeout must be > 0")
+	case p.ZooKeeper.ConnectTimeout <= 0:
+		return errors.New("zoo_keeper.connect_timeout must be > 0")
+	}
+	// Validate the Admin parameters.
+	switch p.Admin.Backend {
+	case "zookeeper", "kafka":
+	default:
+		return errors.Errorf("admin.backend is invalid: %q", p.Admin.Backend)
+	}
+	// Validate the Kafka parameters.
+	switch p.Kafka.Backend {
+	case "", "sarama":
+	default:
+		return errors.Errorf("kafka.backend is invalid: %q", p.Kafka.Backend)
+	}
+	if p.Kafka.MetadataRefreshFrequency <= 0 {
+		return errors.New("kafka.metadata_refresh_frequency must be > 0")
+	}
 	// Validate the Consumer parameters.
 	switch {
 	case p.Consumer.AckTimeout <= 0:
@@ -587,8 +1430,103 @@ func (p *Proxy) validate() error {
 		return errors.New("consumer.offsets_commit_interval must be > 0")
 	case p.Consumer.SubscriptionTimeout <= 0:
 		return errors.New("consumer.subscription_timeout must be > 0")
+	case p.Consumer.RebalanceDrainTimeout < 0:
+		return errors.New("consumer.rebalance_drain_timeout must be >= 0")
 	case p.Consumer.RetryBackoff <= 0:
 		return errors.New("consumer.retry_backoff must be > 0")
+	case p.Consumer.RetryBackoffMax < p.Consumer.RetryBackoff:
+		return errors.New("consumer.retry_backoff_max must be >= consumer.retry_backoff")
+	}
+	switch p.Consumer.Coordination {
+	case "zookeeper", "kafka":
+	default:
+		return errors.Errorf("consumer.coordination is invalid: %q", p.Consumer.Coordination)
+	}
+	switch p.Consumer.NoMessageStatusCode {
+	case 204, 404, 408:
+	default:
+		return errors.Errorf("consumer.no_message_status_code is invalid: %d", p.Consumer.NoMessageStatusCode)
+	}
+	switch p.Consumer.NoMessageGRPCCode {
+	case "not_found", "deadline_exceeded":
+	default:
+		return errors.Errorf("consumer.no_message_grpc_code is invalid: %q", p.Consumer.NoMessageGRPCCode)
+	}
+	switch p.Consumer.AssignmentStrategy {
+	case AssignmentStrategyRange, AssignmentStrategySticky:
+	default:
+		return errors.Errorf("consumer.assignment_strategy is invalid: %q", p.Consumer.AssignmentStrategy)
+	}
+	switch p.Consumer.RetryStrategy {
+	case "immediate", "fixed", "exponential":
+	default:
+		return errors.Errorf("consumer.retry_strategy is invalid: %q", p.Consumer.RetryStrategy)
+	}
+	for _, partition := range p.Consumer.PreferredPartitions {
+		if partition < 0 {
+			return errors.Errorf("consumer.preferred_partitions is invalid: %d", partition)
+		}
+	}
+	if p.Consumer.MaxGroupMembers < 0 {
+		return errors.New("consumer.max_group_members must be >= 0")
+	}
+	for topic, strategy := range p.Consumer.RetryStrategyByTopic {
+		switch strategy {
+		case "immediate", "fixed", "exponential":
+		default:
+			return errors.Errorf("consumer.retry_strategy_by_topic is invalid: topic=%s, strategy=%q",
+				topic, strategy)
+		}
+	}
+	if !validFirstOffset(p.Consumer.FirstOffset) {
+		return errors.Errorf("consumer.first_offset is invalid: %q", p.Consumer.FirstOffset)
+	}
+	for topic, firstOffset := range p.Consumer.FirstOffsetByTopic {
+		if !validFirstOffset(firstOffset) {
+			return errors.Errorf("consumer.first_offset_by_topic is invalid: topic=%s, first_offset=%q",
+				topic, firstOffset)
+		}
+	}
+	if p.Consumer.DeadLetterTopic != "" {
+		switch {
+		case p.Consumer.DeadLetterTopicTemplate.Partitions < 1:
+			return errors.New("consumer.dead_letter_topic_template.partitions must be > 0")
+		case p.Consumer.DeadLetterTopicTemplate.ReplicationFactor < 1:
+			return errors.New("consumer.dead_letter_topic_template.replication_factor must be > 0")
+		case p.Consumer.DeadLetterTopicTemplate.Retention < 0:
+			return errors.New("consumer.dead_letter_topic_template.retention must be >= 0")
+		}
+	}
+
+	// Validate the Access parameters.
+	accessLists := map[string][]string{
+		"access.allowed_topics": p.Access.AllowedTopics,
+		"access.denied_topics":  p.Access.DeniedTopics,
+		"access.allowed_groups": p.Access.AllowedGroups,
+		"access.denied_groups":  p.Access.DeniedGroups,
+	}
+	for param, patterns := range accessLists {
+		for _, pattern := range patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return errors.Errorf("%s is invalid: %q", param, pattern)
+			}
+		}
+	}
+
+	// Validate the Advanced parameters.
+	switch {
+	case p.Advanced.MaxOpenRequests <= 0:
+		return errors.New("advanced.max_open_requests must be > 0")
+	case p.Advanced.KeepAlive < 0:
+		return errors.New("advanced.keep_alive must be >= 0")
+	case p.Advanced.MetadataRetryMax < 0:
+		return errors.New("advanced.metadata_retry_max must be >= 0")
+	case p.Advanced.MetadataRetryBackoff < 0:
+		return errors.New("advanced.metadata_retry_backoff must be >= 0")
+	case p.Advanced.FetchMin <= 0:
+		return errors.New("advanced.fetch_min must be > 0")
+	case p.Advanced.FetchDefault <= 0:
+		return errors.New("advanced.fetch_default must be > 0")
 	}
 
 	// Validate TLS configuration.
@@ -596,6 +1534,16 @@ func (p *Proxy) validate() error {
 		return fmt.Errorf("invalid tls configuration: %q", err)
 	}
 
+	// Validate SASL configuration.
+	switch p.Kafka.SASL.Mechanism {
+	case "", "plain", "scram-sha-256", "scram-sha-512":
+	default:
+		return errors.Errorf("kafka.sasl.mechanism is invalid: %q", p.Kafka.SASL.Mechanism)
+	}
+	if p.Kafka.SASL.Enabled && p.Kafka.SASL.Username == "" {
+		return errors.New("kafka.sasl.username must be specified when sasl is enabled")
+	}
+
 	return nil
 }
 
@@ -639,6 +1587,9 @@ func newApp() *App {
 	appCfg.GRPCAddr = "0.0.0.0:19091"
 	appCfg.TCPAddr = "0.0.0.0:19092"
 	appCfg.Proxies = make(map[string]*Proxy)
+	appCfg.Startup.Mode = StartupFailFast
+	appCfg.Startup.MaxWait = 2 * time.Minute
+	appCfg.ShutdownTimeout = 1 * time.Minute
 	return appCfg
 }
 
@@ -647,8 +1598,10 @@ func defaultProxyWithClientID(clientID string) *Proxy {
 	c.ClientID = clientID
 	c.ZooKeeper.SeedPeers = []string{"localhost:2181"}
 	c.ZooKeeper.SessionTimeout = 15 * time.Second
+	c.ZooKeeper.ConnectTimeout = 1 * time.Second
 
 	c.Kafka.SeedPeers = []string{"localhost:9092"}
+	c.Kafka.MetadataRefreshFrequency = 10 * time.Minute
 
 	c.Kafka.Version.v = sarama.V0_10_2_1
 	// If a valid Kafka version provided in an environment variable then use it
@@ -659,6 +1612,8 @@ func defaultProxyWithClientID(clientID string) *Proxy {
 		c.Kafka.Version = kv
 	}
 
+	c.Admin.Backend = "zookeeper"
+
 	c.Net.DialTimeout = 30 * time.Second
 	c.Net.ReadTimeout = 30 * time.Second
 	c.Net.WriteTimeout = 30 * time.Second
@@ -674,7 +1629,11 @@ func defaultProxyWithClientID(clientID string) *Proxy {
 	c.Producer.ShutdownTimeout = 30 * time.Second
 	c.Producer.Partitioner = PartitionerConstructor("hash")
 	c.Producer.Timeout = 10 * time.Second
+	c.Producer.UnknownTopicPolicy = UnknownTopicBroker
+	c.Producer.UnknownTopicPartitions = 1
+	c.Producer.UnknownTopicReplicationFactor = 1
 
+	c.Consumer.Coordination = "zookeeper"
 	c.Consumer.AckTimeout = 300 * time.Second
 	c.Consumer.ChannelBufferSize = 64
 	c.Consumer.FetchMaxBytes = 1024 * 1024
@@ -683,8 +1642,26 @@ func defaultProxyWithClientID(clientID string) *Proxy {
 	c.Consumer.MaxPendingMessages = 300
 	c.Consumer.MaxRetries = -1
 	c.Consumer.OffsetsCommitInterval = 500 * time.Millisecond
+	c.Consumer.RebalanceDrainTimeout = 3 * time.Second
 	c.Consumer.SubscriptionTimeout = 15 * time.Second
+	c.Consumer.NoMessageStatusCode = 408
+	c.Consumer.NoMessageGRPCCode = "not_found"
 	c.Consumer.RetryBackoff = 500 * time.Millisecond
+	c.Consumer.RetryStrategy = "immediate"
+	c.Consumer.AssignmentStrategy = AssignmentStrategyRange
+	c.Consumer.FirstOffset = FirstOffsetLatest
+	c.Consumer.RetryBackoffMax = 5 * time.Minute
+	c.Consumer.DeadLetterTopicTemplate.Partitions = 1
+	c.Consumer.DeadLetterTopicTemplate.ReplicationFactor = 1
+
+	// The advanced section defaults mirror the sarama defaults.
+	saramaDefaults := sarama.NewConfig()
+	c.Advanced.MaxOpenRequests = saramaDefaults.Net.MaxOpenRequests
+	c.Advanced.KeepAlive = saramaDefaults.Net.KeepAlive
+	c.Advanced.MetadataRetryMax = saramaDefaults.Metadata.Retry.Max
+	c.Advanced.MetadataRetryBackoff = saramaDefaults.Metadata.Retry.Backoff
+	c.Advanced.FetchMin = saramaDefaults.Consumer.Fetch.Min
+	c.Advanced.FetchDefault = saramaDefaults.Consumer.Fetch.Default
 	return c
 }
 
@@ -75,6 +75,44 @@ func (s *ConfigSuite) TestFromYAMLInvalid(c *C) {
 		"  line 9: cannot unmarshal !!str `Kaboom!` into time.Duration")
 }
 
+// Hedged produce requires a Kafka version with idempotent produce support
+// and acks from all in-sync replicas.
+func (s *ConfigSuite) TestHedgeTimeoutInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    kafka:\n" +
+		"      seed_peers:\n" +
+		"        - 192.168.19.2:9092\n" +
+		"    producer:\n" +
+		"      hedge_timeout: 500ms\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Assert(err.Error(), Equals, "invalid config parameter: invalid config, cluster=default: "+
+		"producer.hedge_timeout requires kafka.version to be 0.11 or later")
+
+	data = []byte("" +
+		"proxies:\n" +
+		"  default:\n" +
+		"    kafka:\n" +
+		"      seed_peers:\n" +
+		"        - 192.168.19.2:9092\n" +
+		"      version: 0.11.0.0\n" +
+		"    producer:\n" +
+		"      required_acks: wait_for_local\n" +
+		"      hedge_timeout: 500ms\n")
+
+	// When
+	_, err = FromYAML(data)
+
+	// Then
+	c.Assert(err.Error(), Equals, "invalid config parameter: invalid config, cluster=default: "+
+		"producer.hedge_timeout requires producer.required_acks to be all (-1)")
+}
+
 // The first proxy mentioned is returned as default.
 func (s *ConfigSuite) TestFromYAMLDefault(c *C) {
 	data := []byte("" +
@@ -105,6 +143,7 @@ func (s *ConfigSuite) TestFromYAMLFile(c *C) {
 	// Then
 	c.Assert(err, IsNil)
 	expected := DefaultApp("default")
+	expected.sourceFile = "../default.yaml"
 	expected.Proxies["default"].ClientID = "ID"
 	expected.Proxies["default"].Kafka.Version.Set(sarama.V0_10_2_1)
 	expected.Logging = append(expected.Logging, LoggerCfg{
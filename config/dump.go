@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// redactedValue is what values of secret parameters are replaced with in
+// configuration dumps.
+const redactedValue = "********"
+
+// Diff describes a single difference between two configuration dumps, e.g.
+// the runtime configuration of an instance and its configuration file on
+// disk. A parameter missing on one of the sides is reported with a nil value.
+type Diff struct {
+	Path    string      `json:"path"`
+	Runtime interface{} `json:"runtime"`
+	File    interface{} `json:"file"`
+}
+
+// RedactedMap returns the fully resolved configuration, defaults included, as
+// a generic map with values of secret parameters, e.g. SASL passwords and the
+// Sentry DSN, replaced with asterisks. It backs the config dump API.
+func (a *App) RedactedMap() (map[string]interface{}, error) {
+	data, err := yaml.Marshal(a)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal config")
+	}
+	var root map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, errors.Wrap(err, "failed to parse config")
+	}
+	m := normalizeYAML(root).(map[string]interface{})
+	redactSecrets(m)
+	return m, nil
+}
+
+// DiffMaps reports the differences between two configuration dumps produced
+// by RedactedMap, sorted by parameter path. Since secret values are redacted
+// on both sides before the comparison, a difference in a secret parameter
+// only shows up when the parameter is set on one side and not on the other.
+func DiffMaps(runtime, file map[string]interface{}) []Diff {
+	diffs := []Diff{}
+	diffMaps("", runtime, file, &diffs)
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs
+}
+
+func diffMaps(prefix string, runtime, file map[string]interface{}, diffs *[]Diff) {
+	keySet := make(map[string]bool, len(runtime)+len(file))
+	for key := range runtime {
+		keySet[key] = true
+	}
+	for key := range file {
+		keySet[key] = true
+	}
+	for key := range keySet {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		runtimeVal, fileVal := runtime[key], file[key]
+		runtimeMap, runtimeOk := runtimeVal.(map[string]interface{})
+		fileMap, fileOk := fileVal.(map[string]interface{})
+		if runtimeOk && fileOk {
+			diffMaps(path, runtimeMap, fileMap, diffs)
+			continue
+		}
+		if !reflect.DeepEqual(runtimeVal, fileVal) {
+			*diffs = append(*diffs, Diff{Path: path, Runtime: runtimeVal, File: fileVal})
+		}
+	}
+}
+
+// normalizeYAML converts maps produced by the YAML parser to string keyed
+// maps that the JSON encoder can handle.
+func normalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprintf("%v", key)] = normalizeYAML(value)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+	}
+	return v
+}
+
+// redactSecrets recursively replaces non-empty values of secret parameters
+// with asterisks.
+func redactSecrets(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if isSecretKey(key) {
+				if s, ok := value.(string); ok && s != "" {
+					v[key] = redactedValue
+				}
+				continue
+			}
+			redactSecrets(value)
+		}
+	case []interface{}:
+		for _, item := range v {
+			redactSecrets(item)
+		}
+	}
+}
+
+func isSecretKey(key string) bool {
+	switch key {
+	case "password", "sentry_dsn":
+		return true
+	}
+	return false
+}
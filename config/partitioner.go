@@ -0,0 +1,121 @@
+package config
+
+import (
+	"hash"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// newMurmur2Hash32 returns a hash.Hash32 computing the murmur2 algorithm of
+// the reference Java Kafka client, so that the murmur2 partitioner places
+// messages on exactly the same partitions as the Java DefaultPartitioner.
+func newMurmur2Hash32() hash.Hash32 {
+	return &murmur2Hash32{}
+}
+
+type murmur2Hash32 struct {
+	data []byte
+}
+
+func (h *murmur2Hash32) Write(p []byte) (int, error) {
+	h.data = append(h.data, p...)
+	return len(p), nil
+}
+
+func (h *murmur2Hash32) Reset() {
+	h.data = h.data[:0]
+}
+
+func (h *murmur2Hash32) Size() int {
+	return 4
+}
+
+func (h *murmur2Hash32) BlockSize() int {
+	return 4
+}
+
+func (h *murmur2Hash32) Sum(b []byte) []byte {
+	s := h.Sum32()
+	return append(b, byte(s>>24), byte(s>>16), byte(s>>8), byte(s))
+}
+
+func (h *murmur2Hash32) Sum32() uint32 {
+	return murmur2(h.data)
+}
+
+// murmur2 is a Go translation of the Java client's
+// org.apache.kafka.common.utils.Utils.murmur2, using uint32 arithmetic where
+// Java relies on int overflow.
+func murmur2(data []byte) uint32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	h := seed ^ uint32(len(data))
+	length4 := len(data) / 4
+	for i := 0; i < length4; i++ {
+		i4 := i * 4
+		k := uint32(data[i4]) | uint32(data[i4+1])<<8 | uint32(data[i4+2])<<16 | uint32(data[i4+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := len(data) &^ 3
+	switch len(data) % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// withManualOverride wraps a partitioner constructor so that messages
+// carrying an explicit partition (>= 0) go exactly there, while messages with
+// partition -1 are placed by the wrapped partitioner as usual.
+func withManualOverride(constructor sarama.PartitionerConstructor) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		return &manualOverridePartitioner{delegate: constructor(topic)}
+	}
+}
+
+type manualOverridePartitioner struct {
+	delegate sarama.Partitioner
+}
+
+func (p *manualOverridePartitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if msg.Partition >= 0 {
+		if msg.Partition >= numPartitions {
+			return -1, errors.Errorf("partition %d is out of range, topic has %d partitions",
+				msg.Partition, numPartitions)
+		}
+		return msg.Partition, nil
+	}
+	return p.delegate.Partition(msg, numPartitions)
+}
+
+func (p *manualOverridePartitioner) RequiresConsistency() bool {
+	return true
+}
+
+func (p *manualOverridePartitioner) MessageRequiresConsistency(msg *sarama.ProducerMessage) bool {
+	if msg.Partition >= 0 {
+		return true
+	}
+	if dp, ok := p.delegate.(sarama.DynamicConsistencyPartitioner); ok {
+		return dp.MessageRequiresConsistency(msg)
+	}
+	return p.delegate.RequiresConsistency()
+}
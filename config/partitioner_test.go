@@ -0,0 +1,62 @@
+package config
+
+import (
+	"github.com/Shopify/sarama"
+	. "gopkg.in/check.v1"
+)
+
+type PartitionerSuite struct{}
+
+var _ = Suite(&PartitionerSuite{})
+
+func (s *PartitionerSuite) TestMurmur2JavaVectors(c *C) {
+	// Expected values come from the Java client's
+	// org.apache.kafka.common.utils.UtilsTest.
+	for _, tc := range []struct {
+		key  string
+		hash int32
+	}{
+		{"21", -973932308},
+		{"foobar", -790332482},
+		{"a-little-bit-long-string", -985981536},
+		{"a-little-bit-longer-string", -1486304829},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971},
+		{"abc", 479470107},
+	} {
+		c.Assert(int32(murmur2([]byte(tc.key))), Equals, tc.hash, Commentf("key=%s", tc.key))
+	}
+}
+
+func (s *PartitionerSuite) TestMurmur2Placement(c *C) {
+	// The murmur2 partitioner must place keys exactly where the Java
+	// DefaultPartitioner does: toPositive(murmur2(key)) % numPartitions.
+	constructor, err := PartitionerConstructor("murmur2").ToPartitionerConstructor()
+	c.Assert(err, IsNil)
+	partitioner := constructor("foo")
+
+	msg := &sarama.ProducerMessage{Topic: "foo", Key: sarama.StringEncoder("foobar")}
+	partition, err := partitioner.Partition(msg, 8)
+	c.Assert(err, IsNil)
+	c.Assert(partition, Equals, int32((-790332482&0x7fffffff)%8))
+}
+
+func (s *PartitionerSuite) TestManualOverride(c *C) {
+	partitioner := withManualOverride(sarama.NewHashPartitioner)("foo")
+
+	// When: an explicit partition is requested, it wins over the key.
+	msg := &sarama.ProducerMessage{Topic: "foo", Partition: 3, Key: sarama.StringEncoder("bar")}
+	partition, err := partitioner.Partition(msg, 8)
+	c.Assert(err, IsNil)
+	c.Assert(partition, Equals, int32(3))
+
+	// When: the explicit partition is out of range, the message is rejected.
+	msg.Partition = 8
+	_, err = partitioner.Partition(msg, 8)
+	c.Assert(err, ErrorMatches, "partition 8 is out of range.*")
+
+	// When: no partition is requested, the wrapped partitioner chooses.
+	msg.Partition = -1
+	partition, err = partitioner.Partition(msg, 8)
+	c.Assert(err, IsNil)
+	c.Assert(partition >= 0 && partition < 8, Equals, true)
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// scramClient implements sarama.SCRAMClient performing the client side of a
+// SCRAM conversation as specified by RFC 5802. The hash function determines
+// the mechanism: sha256.New for SCRAM-SHA-256 and sha512.New for
+// SCRAM-SHA-512.
+type scramClient struct {
+	hashFn func() hash.Hash
+
+	username        string
+	password        string
+	clientNonce     string
+	clientFirstBare string
+	serverSignature []byte
+	done            bool
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %s", err)
+	}
+	c.username = saslname(userName)
+	c.password = password
+	c.clientNonce = base64.RawStdEncoding.EncodeToString(nonce)
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	switch {
+	case c.clientFirstBare == "":
+		// No challenge has been received yet, emit client-first-message.
+		c.clientFirstBare = fmt.Sprintf("n=%s,r=%s", c.username, c.clientNonce)
+		return "n,," + c.clientFirstBare, nil
+	case !c.done && c.serverSignature == nil:
+		return c.clientFinal(challenge)
+	default:
+		// server-final-message: verify the server signature to make sure we
+		// have been talking to a server that actually knows the password.
+		if !strings.HasPrefix(challenge, "v=") {
+			return "", fmt.Errorf("server rejected authentication: %s", challenge)
+		}
+		serverSignature, err := base64.StdEncoding.DecodeString(challenge[2:])
+		if err != nil {
+			return "", fmt.Errorf("bad server signature encoding: %s", err)
+		}
+		if !hmac.Equal(serverSignature, c.serverSignature) {
+			return "", fmt.Errorf("server signature mismatch")
+		}
+		c.done = true
+		return "", nil
+	}
+}
+
+func (c *scramClient) Done() bool {
+	return c.done
+}
+
+// clientFinal handles the server-first-message challenge and produces the
+// client-final-message including the client proof.
+func (c *scramClient) clientFinal(serverFirst string) (string, error) {
+	var serverNonce, salt64, iterationsStr string
+	for _, attr := range strings.Split(serverFirst, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			continue
+		}
+		switch attr[0] {
+		case 'r':
+			serverNonce = attr[2:]
+		case 's':
+			salt64 = attr[2:]
+		case 'i':
+			iterationsStr = attr[2:]
+		case 'e':
+			return "", fmt.Errorf("server rejected authentication: %s", attr[2:])
+		}
+	}
+	if !strings.HasPrefix(serverNonce, c.clientNonce) {
+		return "", fmt.Errorf("server nonce does not start with client nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return "", fmt.Errorf("bad salt encoding: %s", err)
+	}
+	iterations, err := strconv.Atoi(iterationsStr)
+	if err != nil || iterations <= 0 {
+		return "", fmt.Errorf("bad iteration count: %q", iterationsStr)
+	}
+
+	saltedPassword := pbkdf2.Key([]byte(c.password), salt, iterations, c.hashFn().Size(), c.hashFn)
+	clientKey := hmacSum(c.hashFn, saltedPassword, []byte("Client Key"))
+	storedKeyHash := c.hashFn()
+	storedKeyHash.Write(clientKey)
+	storedKey := storedKeyHash.Sum(nil)
+
+	clientFinalWithoutProof := fmt.Sprintf("c=%s,r=%s",
+		base64.StdEncoding.EncodeToString([]byte("n,,")), serverNonce)
+	authMessage := []byte(c.clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof)
+
+	clientSignature := hmacSum(c.hashFn, storedKey, authMessage)
+	clientProof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		clientProof[i] = clientKey[i] ^ clientSignature[i]
+	}
+	serverKey := hmacSum(c.hashFn, saltedPassword, []byte("Server Key"))
+	c.serverSignature = hmacSum(c.hashFn, serverKey, authMessage)
+
+	return fmt.Sprintf("%s,p=%s", clientFinalWithoutProof,
+		base64.StdEncoding.EncodeToString(clientProof)), nil
+}
+
+func hmacSum(hashFn func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(hashFn, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// saslname escapes a username for inclusion in a SCRAM message as required by
+// RFC 5802, section 5.1.
+func saslname(s string) string {
+	s = strings.Replace(s, "=", "=3D", -1)
+	return strings.Replace(s, ",", "=2C", -1)
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"crypto/sha256"
+
+	. "gopkg.in/check.v1"
+)
+
+type ScramSuite struct{}
+
+var _ = Suite(&ScramSuite{})
+
+// The SCRAM-SHA-256 exchange from RFC 7677, section 3, is reproduced exactly
+// when the client nonce is pinned to the one used in the RFC example.
+func (s *ScramSuite) TestRFC7677Exchange(c *C) {
+	cl := scramClient{
+		hashFn:      sha256.New,
+		username:    "user",
+		password:    "pencil",
+		clientNonce: "rOprNGfwEbeRWgbNEkqO",
+	}
+
+	clientFirst, err := cl.Step("")
+	c.Assert(err, IsNil)
+	c.Assert(clientFirst, Equals, "n,,n=user,r=rOprNGfwEbeRWgbNEkqO")
+
+	clientFinal, err := cl.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0," +
+		"s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	c.Assert(err, IsNil)
+	c.Assert(clientFinal, Equals, "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,"+
+		"p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=")
+
+	_, err = cl.Step("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")
+	c.Assert(err, IsNil)
+	c.Assert(cl.Done(), Equals, true)
+}
+
+func (s *ScramSuite) TestServerError(c *C) {
+	cl := scramClient{hashFn: sha256.New, username: "user", password: "pencil",
+		clientNonce: "nonce"}
+	_, err := cl.Step("")
+	c.Assert(err, IsNil)
+
+	// When
+	_, err = cl.Step("e=unknown-user")
+
+	// Then
+	c.Assert(err, ErrorMatches, "server rejected authentication: unknown-user")
+}
+
+func (s *ScramSuite) TestBadServerSignature(c *C) {
+	cl := scramClient{hashFn: sha256.New, username: "user", password: "pencil",
+		clientNonce: "rOprNGfwEbeRWgbNEkqO"}
+	_, err := cl.Step("")
+	c.Assert(err, IsNil)
+	_, err = cl.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0," +
+		"s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	c.Assert(err, IsNil)
+
+	// When
+	_, err = cl.Step("v=Zm9yZ2Vk")
+
+	// Then
+	c.Assert(err, ErrorMatches, "server signature mismatch")
+	c.Assert(cl.Done(), Equals, false)
+}
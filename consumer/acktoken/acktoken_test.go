@@ -0,0 +1,57 @@
+package acktoken
+
+import (
+	"encoding/base64"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+type AckTokenSuite struct {
+}
+
+var _ = Suite(&AckTokenSuite{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+func (s *AckTokenSuite) TestRoundTrip(c *C) {
+	token := Mint("foo", "bar", 7, 1013)
+
+	partition, offset, err := Parse(token, "foo", "bar")
+
+	c.Assert(err, IsNil)
+	c.Assert(partition, Equals, int32(7))
+	c.Assert(offset, Equals, int64(1013))
+}
+
+// A token only acknowledges on behalf of the subscription it was issued to.
+func (s *AckTokenSuite) TestSubscriptionBound(c *C) {
+	token := Mint("foo", "bar", 7, 1013)
+
+	_, _, err := Parse(token, "other", "bar")
+	c.Assert(err, ErrorMatches, "ack token is bound to another subscription")
+
+	_, _, err = Parse(token, "foo", "other")
+	c.Assert(err, ErrorMatches, "ack token is bound to another subscription")
+}
+
+func (s *AckTokenSuite) TestTampered(c *C) {
+	token := Mint("foo", "bar", 7, 1013)
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	c.Assert(err, IsNil)
+	raw[0] ^= 1
+	tampered := base64.RawURLEncoding.EncodeToString(raw)
+
+	_, _, err = Parse(tampered, "foo", "bar")
+
+	c.Assert(err, ErrorMatches, "bad ack token signature")
+}
+
+func (s *AckTokenSuite) TestMalformed(c *C) {
+	for _, token := range []string{"", "not-a-token", "%%%"} {
+		_, _, err := Parse(token, "foo", "bar")
+		c.Assert(err, ErrorMatches, "malformed ack token", Commentf("token=%q", token))
+	}
+}
@@ -67,8 +67,30 @@ type Message struct {
 	sarama.ConsumerMessage
 	HighWaterMark int64
 	EventsCh      chan<- Event
+
+	// Coordinates of the record batch the message was fetched in, for
+	// correlation with broker-side tooling. Messages read from the legacy
+	// message set format, and code paths that do not parse fetch responses
+	// themselves, carry a ProducerID of -1 and no batch coordinates.
+	ProducerID int64
+	BaseOffset int64
+	BatchIndex int32
+
+	// The number of offsets skipped right before this message because they
+	// had been deleted by the broker retention policy before they could be
+	// consumed. The deleted messages are gone along with their timestamps,
+	// so the exact time span of the loss is unknowable, but all of them were
+	// produced before this message was. Zero for the overwhelming majority
+	// of messages.
+	OffsetsLost int64
 }
 
+// DeadLetterFn is called when a message is given up on by the consumer
+// machinery, e.g. because it has been offered and expired too many times. It
+// is provided by the proxy layer and routes the message to a dead letter
+// topic. Implementations must not block.
+type DeadLetterFn func(msg Message, group string, failureCount int, reason string)
+
 func NewRequest(group, topic string) Request {
 	return Request{
 		Timestamp:  time.Now().UTC(),
@@ -7,6 +7,8 @@ import (
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
 	"github.com/mailgun/kafka-pixy/consumer/groupcsm"
+	"github.com/mailgun/kafka-pixy/consumer/kazoo"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
@@ -25,33 +27,48 @@ import (
 // implements `consumer.T`.
 // implements `dispatcher.Factory`.
 type t struct {
-	actDesc    *actor.Descriptor
-	cfg        *config.Proxy
-	dispatcher *dispatcher.T
-	kafkaClt   sarama.Client
-	zkConn     *zk.Conn
-	offsetMgrF offsetmgr.Factory
+	actDesc      *actor.Descriptor
+	cfg          *config.Proxy
+	dispatcher   *dispatcher.T
+	kafkaClt     sarama.Client
+	zkConn       *zk.Conn
+	offsetMgrF   offsetmgr.Factory
+	deadLetterFn consumer.DeadLetterFn
 }
 
 // Spawn creates a consumer instance with the specified configuration and
 // starts all its goroutines.
-func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy, offsetMgrF offsetmgr.Factory) (*t, error) {
-	kafkaClt, err := sarama.NewClient(cfg.Kafka.SeedPeers, cfg.SaramaClientCfg())
+func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy, offsetMgrF offsetmgr.Factory,
+	deadLetterFn consumer.DeadLetterFn,
+) (*t, error) {
+	backend, err := kafkaclt.New(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Kafka backend")
+	}
+	kafkaClt, err := backend.NewClient()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create Kafka client for message streams")
 	}
 
-	zkConn, _, err := zk.Connect(cfg.ZooKeeper.SeedPeers, cfg.ZooKeeper.SessionTimeout)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create kazoo.Kazoo")
+	actDesc := parentActDesc.NewChild("cons")
+	// In Kafka coordination mode group membership is managed via the Kafka
+	// group coordinator, so no ZooKeeper connection is needed at all.
+	var zkConn *zk.Conn
+	if cfg.Consumer.Coordination != "kafka" {
+		zkConn, err = kazoo.Connect(actDesc.String(), cfg.ZooKeeper.SeedPeers,
+			cfg.ZooKeeper.SessionTimeout, cfg.ZooKeeper.ConnectTimeout, cfg.Net.Proxy, actDesc.Log())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create kazoo.Kazoo")
+		}
 	}
 
 	c := &t{
-		actDesc:    parentActDesc.NewChild("cons"),
-		cfg:        cfg,
-		kafkaClt:   kafkaClt,
-		offsetMgrF: offsetMgrF,
-		zkConn:     zkConn,
+		actDesc:      actDesc,
+		cfg:          cfg,
+		kafkaClt:     kafkaClt,
+		offsetMgrF:   offsetMgrF,
+		zkConn:       zkConn,
+		deadLetterFn: deadLetterFn,
 	}
 	c.dispatcher = dispatcher.Spawn(c.actDesc, c, c.cfg)
 	return c, nil
@@ -73,7 +90,9 @@ func (c *t) AsyncConsume(group, topic string) <-chan consumer.Response {
 // implements `consumer.T`
 func (c *t) Stop() {
 	c.dispatcher.Stop()
-	c.zkConn.Close()
+	if c.zkConn != nil {
+		c.zkConn.Close()
+	}
 	c.kafkaClt.Close()
 }
 
@@ -84,7 +103,7 @@ func (c *t) KeyOf(rq consumer.Request) dispatcher.Key {
 
 // implements `dispatcher.Factory`.
 func (c *t) SpawnChild(childSpec dispatcher.ChildSpec) {
-	groupcsm.Spawn(c.actDesc, childSpec, c.cfg, c.kafkaClt, c.zkConn, c.offsetMgrF)
+	groupcsm.Spawn(c.actDesc, childSpec, c.cfg, c.kafkaClt, c.zkConn, c.offsetMgrF, c.deadLetterFn)
 }
 
 // String returns a string ID of this instance to be used in logs.
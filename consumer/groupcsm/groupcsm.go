@@ -2,6 +2,7 @@ package groupcsm
 
 import (
 	"fmt"
+	"hash/fnv"
 	"sort"
 	"sync"
 	"time"
@@ -11,34 +12,43 @@ import (
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
 	"github.com/mailgun/kafka-pixy/consumer/msgfetcher"
 	"github.com/mailgun/kafka-pixy/consumer/multiplexer"
 	"github.com/mailgun/kafka-pixy/consumer/partitioncsm"
 	"github.com/mailgun/kafka-pixy/consumer/subscriber"
 	"github.com/mailgun/kafka-pixy/consumer/topiccsm"
+	"github.com/mailgun/kafka-pixy/features"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/mailgun/kafka-pixy/prettyfmt"
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
 )
 
+// How often partition counts of the consumed topics are checked against the
+// cluster metadata to detect topics that have been expanded. When a change is
+// detected a rebalance is triggered, so that partition consumers for the new
+// partitions are created without a restart.
+const partitionCheckInterval = 30 * time.Second
+
 // groupConsumer manages a fleet of topic consumers and disposes of those that
 // have been inactive for the `Config.Consumer.DisposeAfter` period of time.
 //
 // implements `dispatcher.Factory`.
 // implements `dispatcher.Tier`.
 type T struct {
-	actDesc     *actor.Descriptor
-	cfg         *config.Proxy
-	group       string
-	dispatcher  *dispatcher.T
-	kafkaClt    sarama.Client
-	zkConn      *zk.Conn
-	msgFetcherF msgfetcher.Factory
-	offsetMgrF  offsetmgr.Factory
-	subscriber  *subscriber.T
-	topicCsmCh  chan *topiccsm.T
-	wg          sync.WaitGroup
+	actDesc      *actor.Descriptor
+	cfg          *config.Proxy
+	group        string
+	dispatcher   *dispatcher.T
+	kafkaClt     sarama.Client
+	zkConn       *zk.Conn
+	msgFetcherF  msgfetcher.Factory
+	offsetMgrF   offsetmgr.Factory
+	deadLetterFn consumer.DeadLetterFn
+	subscriber   *subscriber.T
+	topicCsmCh   chan *topiccsm.T
+	wg           sync.WaitGroup
 
 	multiplexersMu sync.Mutex
 	multiplexers   map[string]*multiplexer.T
@@ -46,6 +56,7 @@ type T struct {
 
 func Spawn(parentActDesc *actor.Descriptor, childSpec dispatcher.ChildSpec,
 	cfg *config.Proxy, kafkaClt sarama.Client, zkConn *zk.Conn, offsetMgrF offsetmgr.Factory,
+	deadLetterFn consumer.DeadLetterFn,
 ) *T {
 	group := string(childSpec.Key())
 	actDesc := parentActDesc.NewChild(fmt.Sprintf("%s", group))
@@ -57,6 +68,7 @@ func Spawn(parentActDesc *actor.Descriptor, childSpec dispatcher.ChildSpec,
 		kafkaClt:     kafkaClt,
 		zkConn:       zkConn,
 		offsetMgrF:   offsetMgrF,
+		deadLetterFn: deadLetterFn,
 		multiplexers: make(map[string]*multiplexer.T),
 		topicCsmCh:   make(chan *topiccsm.T, cfg.Consumer.ChannelBufferSize),
 	}
@@ -123,6 +135,10 @@ func (gc *T) run() {
 		rebalanceScheduled      = false
 		stopped                 = false
 		rebalanceResultCh       = make(chan error, 1)
+		partitionCounts         = make(map[string]int)
+		partitionCheckCh        = time.After(partitionCheckInterval)
+		partitionCheckPending   = false
+		partitionCheckResultCh  = make(chan bool, 1)
 	)
 	for {
 		select {
@@ -169,9 +185,37 @@ func (gc *T) run() {
 			}
 		case <-nilOrRetryCh:
 			rebalanceScheduled = false
+
+		case <-partitionCheckCh:
+			partitionCheckCh = time.After(partitionCheckInterval)
+			// The check makes a metadata request that can take a while with an
+			// unreachable broker, so it is dispatched to another goroutine the
+			// same way rebalancing is, lest it stalls the main loop.
+			if !partitionCheckPending && len(topics) > 0 {
+				partitionCheckPending = true
+				checkTopics := topics
+				checkActDesc := gc.actDesc.NewChild("partition_check")
+				actor.Spawn(checkActDesc, nil, func() {
+					partitionCheckResultCh <- gc.partitionCountsChanged(checkTopics, partitionCounts)
+				})
+			}
+
+		case changed := <-partitionCheckResultCh:
+			partitionCheckPending = false
+			if changed {
+				rebalanceRequired = true
+			}
 		}
 
 		if rebalanceRequired && !rebalancePending && !rebalanceScheduled {
+			// While the group is frozen the current assignments are kept and
+			// rebalancing is deferred. The deferred state is re-evaluated on
+			// every partition check tick, so a thawed group catches up within
+			// partitionCheckInterval.
+			if features.Enabled(features.Frozen, gc.group, "") {
+				gc.actDesc.Log().Info("Rebalancing deferred: group is frozen")
+				continue
+			}
 			rebalanceActDesc := gc.actDesc.NewChild("rebalance")
 			// Copy topicConsumers to make sure `rebalance` doesn't see any
 			// changes we make while it is running.
@@ -201,15 +245,45 @@ done:
 	wg.Wait()
 }
 
+// partitionCountsChanged refreshes metadata of the consumed topics and tells
+// whether the partition count of any of them has changed since the last call.
+// The passed partitionCounts map is updated with the latest values. It is
+// called from partition check goroutines that never overlap, at most one is
+// dispatched at a time, so the map needs no synchronization.
+func (gc *T) partitionCountsChanged(topics []string, partitionCounts map[string]int) bool {
+	if len(topics) == 0 {
+		return false
+	}
+	if err := gc.kafkaClt.RefreshMetadata(topics...); err != nil {
+		gc.actDesc.Log().WithError(err).Warn("Failed to refresh topic metadata")
+		return false
+	}
+	changed := false
+	for _, topic := range topics {
+		partitions, err := gc.kafkaClt.Partitions(topic)
+		if err != nil {
+			continue
+		}
+		if prevCount, ok := partitionCounts[topic]; ok && prevCount != len(partitions) {
+			gc.actDesc.Log().Infof("Partition count changed: topic=%s, was=%d, now=%d",
+				topic, prevCount, len(partitions))
+			changed = true
+		}
+		partitionCounts[topic] = len(partitions)
+	}
+	return changed
+}
+
 func (gc *T) rebalance(actDesc *actor.Descriptor, topicConsumers map[string]*topiccsm.T,
 	subscriptions map[string][]string, rebalanceResultCh chan<- error,
 ) {
-	assignedPartitions, err := gc.resolvePartitions(subscriptions, gc.kafkaClt.Partitions)
+	assignedPartitions, err := gc.resolvePartitions(subscriptions, gc.subscriber.GroupPreferences(), gc.kafkaClt.Partitions)
 	if err != nil {
 		rebalanceResultCh <- err
 		return
 	}
 	actDesc.Log().Infof("assigned partitions: %s", prettyfmt.Val(assignedPartitions))
+	groupevents.Record(gc.group, groupevents.Assign, "%s", prettyfmt.Val(assignedPartitions))
 	var wg sync.WaitGroup
 	// Stop consuming partitions that are no longer assigned to this group
 	// and start consuming newly assigned partitions for topics that has been
@@ -229,9 +303,10 @@ func (gc *T) rebalance(actDesc *actor.Descriptor, topicConsumers map[string]*top
 		topic := topic
 		spawnInFn := func(partition int32) multiplexer.In {
 			return partitioncsm.Spawn(gc.actDesc, gc.group, topic, partition,
-				gc.cfg, gc.subscriber, gc.msgFetcherF, gc.offsetMgrF)
+				gc.cfg, gc.subscriber, gc.msgFetcherF, gc.offsetMgrF, gc.deadLetterFn)
 		}
 		mux = multiplexer.New(gc.actDesc, spawnInFn)
+		mux.SetDrainTimeout(gc.cfg.Consumer.RebalanceDrainTimeout)
 		gc.rewireMuxAsync(topic, &wg, mux, tc, assignedTopicPartitions)
 		gc.multiplexers[topic] = mux
 	}
@@ -260,9 +335,10 @@ func (gc *T) rewireMuxAsync(topic string, wg *sync.WaitGroup, mux *multiplexer.T
 	})
 }
 
-// resolvePartitions given topic subscriptions of all consumer group members,
-// resolves what topic partitions are assigned to the specified group member.
-func (gc *T) resolvePartitions(subscriptions map[string][]string,
+// resolvePartitions given topic subscriptions and partition preferences of
+// all consumer group members, resolves what topic partitions are assigned to
+// the specified group member.
+func (gc *T) resolvePartitions(subscriptions map[string][]string, preferences map[string][]int32,
 	topicPartitionsFn func(string) ([]int32, error)) (map[string][]int32, error,
 ) {
 	// Convert members->topics to topic->members map.
@@ -288,7 +364,13 @@ func (gc *T) resolvePartitions(subscriptions map[string][]string,
 			}
 			return nil, errors.Wrapf(err, "failed to get partition list, topic=%s", topic)
 		}
-		subscribersToPartitions := assignTopicPartitions(topicPartitions, topicsToMembers[topic])
+		var subscribersToPartitions map[string][]int32
+		if gc.cfg.Consumer.AssignmentStrategy == config.AssignmentStrategySticky {
+			subscribersToPartitions = assignTopicPartitionsSticky(topicPartitions, topicsToMembers[topic])
+		} else {
+			subscribersToPartitions = assignTopicPartitions(topicPartitions, topicsToMembers[topic])
+		}
+		applyPartitionPreferences(subscribersToPartitions, preferences)
 		assignedTopicPartitions := subscribersToPartitions[gc.cfg.ClientID]
 		if len(assignedTopicPartitions) > 0 {
 			assignedPartitions[topic] = assignedTopicPartitions
@@ -332,6 +414,134 @@ func assignTopicPartitions(partitions []int32, subscribers []string) map[string]
 	return subscribersToPartitions
 }
 
+// assignTopicPartitionsSticky divides topic partitions among all consumer
+// group members subscribed to the topic using rendezvous (highest random
+// weight) hashing with a per-member capacity cap. Unlike the range algorithm
+// it keeps a partition on the same member across membership changes unless
+// that member is gone or over capacity, so scaling events move close to the
+// minimum number of partitions. All members compute the same assignment
+// independently, for the algorithm is a pure function of the partition and
+// member lists.
+func assignTopicPartitionsSticky(partitions []int32, subscribers []string) map[string][]int32 {
+	partitionCount := len(partitions)
+	subscriberCount := len(subscribers)
+	if partitionCount == 0 || subscriberCount == 0 {
+		return nil
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	sort.Strings(subscribers)
+
+	// No member gets more than its fair share rounded up.
+	capacity := (partitionCount + subscriberCount - 1) / subscriberCount
+
+	subscribersToPartitions := make(map[string][]int32, subscriberCount)
+	for _, partition := range partitions {
+		var bestMember string
+		var bestScore uint64
+		for _, groupMemberID := range subscribers {
+			if len(subscribersToPartitions[groupMemberID]) >= capacity {
+				continue
+			}
+			score := rendezvousScore(groupMemberID, partition)
+			if bestMember == "" || score > bestScore {
+				bestMember, bestScore = groupMemberID, score
+			}
+		}
+		subscribersToPartitions[bestMember] = append(subscribersToPartitions[bestMember], partition)
+	}
+	for groupMemberID, assigned := range subscribersToPartitions {
+		if len(assigned) == 0 {
+			delete(subscribersToPartitions, groupMemberID)
+		}
+	}
+	return subscribersToPartitions
+}
+
+// applyPartitionPreferences adjusts an assignment produced by either strategy
+// to honor partition preferences published by group members where possible.
+// A partition that a member prefers but does not own is traded for one of the
+// member's own non-preferred partitions, provided the current owner does not
+// prefer it too. Trades keep per-member partition counts intact, so the
+// balance guarantees of the underlying strategy are preserved. All members
+// compute the same adjustment independently, for trades are performed in a
+// deterministic order.
+func applyPartitionPreferences(subscribersToPartitions map[string][]int32, preferences map[string][]int32) {
+	if len(preferences) == 0 || len(subscribersToPartitions) < 2 {
+		return
+	}
+	// Index partition owners and preference sets for quick lookups.
+	owners := make(map[int32]string)
+	for groupMemberID, assigned := range subscribersToPartitions {
+		for _, partition := range assigned {
+			owners[partition] = groupMemberID
+		}
+	}
+	prefSets := make(map[string]map[int32]bool, len(preferences))
+	for groupMemberID, preferred := range preferences {
+		prefSet := make(map[int32]bool, len(preferred))
+		for _, partition := range preferred {
+			prefSet[partition] = true
+		}
+		prefSets[groupMemberID] = prefSet
+	}
+	groupMemberIDs := make([]string, 0, len(preferences))
+	for groupMemberID := range preferences {
+		groupMemberIDs = append(groupMemberIDs, groupMemberID)
+	}
+	sort.Strings(groupMemberIDs)
+	for _, groupMemberID := range groupMemberIDs {
+		assigned := subscribersToPartitions[groupMemberID]
+		if len(assigned) == 0 {
+			continue
+		}
+		preferred := append([]int32(nil), preferences[groupMemberID]...)
+		sort.Slice(preferred, func(i, j int) bool { return preferred[i] < preferred[j] })
+		for _, partition := range preferred {
+			ownerID, ok := owners[partition]
+			if !ok || ownerID == groupMemberID || prefSets[ownerID][partition] {
+				continue
+			}
+			// Trade the lowest owned partition that the member does not
+			// prefer itself.
+			tradeIdx := -1
+			for i, owned := range assigned {
+				if prefSets[groupMemberID][owned] {
+					continue
+				}
+				if tradeIdx < 0 || owned < assigned[tradeIdx] {
+					tradeIdx = i
+				}
+			}
+			if tradeIdx < 0 {
+				continue
+			}
+			traded := assigned[tradeIdx]
+			assigned[tradeIdx] = partition
+			owners[partition] = groupMemberID
+			ownerAssigned := subscribersToPartitions[ownerID]
+			for i := range ownerAssigned {
+				if ownerAssigned[i] == partition {
+					ownerAssigned[i] = traded
+					break
+				}
+			}
+			owners[traded] = ownerID
+		}
+	}
+	for _, assigned := range subscribersToPartitions {
+		sort.Slice(assigned, func(i, j int) bool { return assigned[i] < assigned[j] })
+	}
+}
+
+// rendezvousScore hashes a member-partition pair into a weight that is stable
+// for as long as both exist.
+func rendezvousScore(groupMemberID string, partition int32) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(groupMemberID))
+	fmt.Fprintf(h, "/%d", partition)
+	return h.Sum64()
+}
+
 func listTopics(topicConsumers map[string]*topiccsm.T) []string {
 	topics := make([]string, 0, len(topicConsumers))
 	for topic := range topicConsumers {
@@ -90,6 +90,99 @@ func (s *GroupConsumerSuite) TestAssignTopicPartitions(c *C) {
 		})
 }
 
+func (s *GroupConsumerSuite) TestAssignTopicPartitionsSticky(c *C) {
+	c.Assert(assignTopicPartitionsSticky(nil, []string{"a"}), IsNil)
+	c.Assert(assignTopicPartitionsSticky([]int32{1}, nil), IsNil)
+
+	partitions := []int32{6, 0, 3, 1, 2, 5, 4, 7}
+	members := []string{"b", "c", "a"}
+
+	// The assignment is a pure function of its arguments, so all group
+	// members independently arrive at the same result.
+	assigned := assignTopicPartitionsSticky(partitions, members)
+	c.Assert(assignTopicPartitionsSticky(partitions, members), DeepEquals, assigned)
+
+	// Every partition is assigned exactly once and nobody gets more than
+	// the fair share rounded up.
+	seen := make(map[int32]int)
+	for _, member := range members {
+		c.Assert(len(assigned[member]) <= 3, Equals, true)
+		for _, p := range assigned[member] {
+			seen[p]++
+		}
+	}
+	c.Assert(len(seen), Equals, len(partitions))
+	for _, count := range seen {
+		c.Assert(count, Equals, 1)
+	}
+
+	// When a member leaves, partitions of the remaining members largely stay
+	// put: everything that does not have to move because of the capacity cap
+	// remains with its previous owner.
+	without := assignTopicPartitionsSticky(partitions, []string{"b", "a"})
+	kept := 0
+	for _, member := range []string{"a", "b"} {
+		was := make(map[int32]bool)
+		for _, p := range assigned[member] {
+			was[p] = true
+		}
+		for _, p := range without[member] {
+			if was[p] {
+				kept++
+			}
+		}
+	}
+	c.Assert(kept >= len(assigned["a"])+len(assigned["b"])-1, Equals, true)
+}
+
+func (s *GroupConsumerSuite) TestApplyPartitionPreferences(c *C) {
+	// A preferred partition is traded for one of the member's own partitions,
+	// so per-member counts stay intact.
+	assigned := map[string][]int32{
+		"a": {0, 1},
+		"b": {2, 3},
+	}
+	applyPartitionPreferences(assigned, map[string][]int32{"b": {0}})
+	c.Assert(assigned, DeepEquals, map[string][]int32{
+		"a": {1, 2},
+		"b": {0, 3},
+	})
+
+	// An owner that prefers a partition itself keeps it.
+	assigned = map[string][]int32{
+		"a": {0, 1},
+		"b": {2, 3},
+	}
+	applyPartitionPreferences(assigned, map[string][]int32{"a": {0}, "b": {0}})
+	c.Assert(assigned, DeepEquals, map[string][]int32{
+		"a": {0, 1},
+		"b": {2, 3},
+	})
+
+	// A member that prefers all of its own partitions has nothing to trade.
+	assigned = map[string][]int32{
+		"a": {0, 1},
+		"b": {2, 3},
+	}
+	applyPartitionPreferences(assigned, map[string][]int32{"b": {0, 2, 3}})
+	c.Assert(assigned, DeepEquals, map[string][]int32{
+		"a": {0, 1},
+		"b": {2, 3},
+	})
+
+	// Preferences of members that are not part of the assignment, and
+	// preferences for partitions that do not exist, are ignored.
+	assigned = map[string][]int32{
+		"a": {0, 1},
+		"b": {2, 3},
+	}
+	applyPartitionPreferences(assigned, map[string][]int32{"b": {7}, "z": {0}})
+	c.Assert(assigned, DeepEquals, map[string][]int32{
+		"a": {0, 1},
+		"b": {2, 3},
+	})
+}
+
 func (s *GroupConsumerSuite) TestResolvePartitions(c *C) {
 	cfg := config.DefaultProxy()
 	cfg.ClientID = "c"
@@ -113,7 +206,7 @@ func (s *GroupConsumerSuite) TestResolvePartitions(c *C) {
 			"d": {"t1", "t4"},
 			"e": {},
 			"f": nil,
-		}, topicPartitionsFn)
+		}, nil, topicPartitionsFn)
 
 	// Then
 	c.Assert(err, IsNil)
@@ -133,7 +226,7 @@ func (s *GroupConsumerSuite) TestResolvePartitionsEmpty(c *C) {
 	}
 
 	// When
-	topicsToPartitions, err := gc.resolvePartitions(nil, topicPartitionsFn)
+	topicsToPartitions, err := gc.resolvePartitions(nil, nil, topicPartitionsFn)
 
 	// Then
 	c.Assert(err, IsNil)
@@ -149,7 +242,7 @@ func (s *GroupConsumerSuite) TestResolvePartitionsError(c *C) {
 	}
 
 	// When
-	topicsToPartitions, err := gc.resolvePartitions(map[string][]string{"c": {"t1"}}, topicPartitionsFn)
+	topicsToPartitions, err := gc.resolvePartitions(map[string][]string{"c": {"t1"}}, nil, topicPartitionsFn)
 
 	// Then
 	c.Assert(err.Error(), Equals, "failed to get partition list, topic=t1: Kaboom!")
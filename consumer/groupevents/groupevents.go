@@ -0,0 +1,88 @@
+// Package groupevents maintains a bounded in-memory log of consumer group
+// lifecycle events: member joins and leaves, topic subscription expiries,
+// partition assignments, offset commit failures and messages lost to the
+// broker retention policy. The log makes the last
+// hour of group churn reconstructable via `GET /groups/<group>/events`
+// without trawling log files.
+package groupevents
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kind classifies a group event.
+type Kind string
+
+const (
+	Join          Kind = "join"
+	Leave         Kind = "leave"
+	Expire        Kind = "expire"
+	Assign        Kind = "assign"
+	CommitFailure Kind = "commit-failure"
+	RetentionSkip Kind = "retention-skip"
+)
+
+// Event is a single entry of a group event log.
+type Event struct {
+	At      time.Time
+	Kind    Kind
+	Details string
+}
+
+const (
+	// Events older than retentionPeriod are dropped, and at most
+	// maxEventsPerGroup most recent events are kept per group, so that a
+	// flapping group cannot grow the log without bound.
+	retentionPeriod   = time.Hour
+	maxEventsPerGroup = 500
+)
+
+var (
+	mu   sync.Mutex
+	logs = make(map[string][]Event)
+)
+
+// Record appends an event to the event log of the group.
+func Record(group string, kind Kind, format string, args ...interface{}) {
+	now := time.Now()
+	mu.Lock()
+	events := prune(logs[group], now)
+	if len(events) >= maxEventsPerGroup {
+		events = events[len(events)-maxEventsPerGroup+1:]
+	}
+	logs[group] = append(events, Event{
+		At:      now,
+		Kind:    kind,
+		Details: fmt.Sprintf(format, args...),
+	})
+	mu.Unlock()
+}
+
+// Events returns a copy of the event log of the group, oldest event first.
+func Events(group string) []Event {
+	now := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	events := prune(logs[group], now)
+	if len(events) == 0 {
+		delete(logs, group)
+		return nil
+	}
+	logs[group] = events
+	copied := make([]Event, len(events))
+	copy(copied, events)
+	return copied
+}
+
+// prune drops events that fell out of the retention period. Events are
+// appended in chronological order, so only the head of the log is inspected.
+func prune(events []Event, now time.Time) []Event {
+	cutoff := now.Add(-retentionPeriod)
+	i := 0
+	for i < len(events) && events[i].At.Before(cutoff) {
+		i++
+	}
+	return events[i:]
+}
@@ -0,0 +1,48 @@
+package groupevents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndEvents(t *testing.T) {
+	Record("foo", Join, "member=%s, topics=%v", "kp_1", []string{"a", "b"})
+	Record("foo", Assign, "a=[0 1]")
+	Record("bar", Leave, "member=kp_2")
+
+	events := Events("foo")
+
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, Join, events[0].Kind)
+	assert.Equal(t, "member=kp_1, topics=[a b]", events[0].Details)
+	assert.Equal(t, Assign, events[1].Kind)
+
+	// Logs of different groups are independent.
+	assert.Equal(t, 1, len(Events("bar")))
+	assert.Nil(t, Events("unknown"))
+}
+
+func TestBounded(t *testing.T) {
+	for i := 0; i < maxEventsPerGroup+10; i++ {
+		Record("bounded", Join, "event %d", i)
+	}
+
+	events := Events("bounded")
+
+	assert.Equal(t, maxEventsPerGroup, len(events))
+	assert.Equal(t, "event 10", events[0].Details)
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	events := []Event{
+		{At: now.Add(-2 * retentionPeriod)},
+		{At: now.Add(-retentionPeriod - time.Second)},
+		{At: now.Add(-time.Minute)},
+	}
+
+	assert.Equal(t, 1, len(prune(events, now)))
+	assert.Equal(t, 0, len(prune(events[:2], now)))
+}
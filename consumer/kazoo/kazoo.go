@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
@@ -19,6 +21,11 @@ const (
 	versionAny = -1
 )
 
+// ErrGroupFull is returned as an error cause when a member cannot register
+// with a consumer group because the group has reached the configured maximum
+// number of members.
+var ErrGroupFull = errors.New("consumer group is full")
+
 // Model represent Kafka consumer group data model stored in ZooKeeper. It
 // provides high level functions to deal with group member subscriptions and
 // topic partition ownership. A model is bound to a particular member of a
@@ -31,10 +38,28 @@ type Model struct {
 	ownersPath  string
 	memberID    string
 	memberPath  string
+	settings    map[string]string
+	preferences []int32
+	maxMembers  int
+	groupPrefs  *groupPrefs
+}
+
+// groupPrefs holds partition preferences published by group members. It is
+// kept behind a pointer so that Model values remain copyable.
+type groupPrefs struct {
+	mu    sync.Mutex
+	prefs map[string][]int32
 }
 
 // NewModel creates a model instance bound to a member of a consumer group.
-func NewModel(zkConn *zk.Conn, chroot, group, memberID string, log *logrus.Entry) Model {
+// The settings map carries key consumer parameters of the bound member. It is
+// published along with the member subscription so that other group members
+// can detect configuration mismatches within the fleet. The preferences list
+// carries partitions the bound member prefers to be assigned, it is published
+// the same way so that all members take the hint in account when resolving
+// partition assignments. If maxMembers is greater than 0, then the bound
+// member refuses to register with the group once it has that many members.
+func NewModel(zkConn *zk.Conn, chroot, group, memberID string, settings map[string]string, preferences []int32, maxMembers int, log *logrus.Entry) Model {
 	groupPath := fmt.Sprintf("%s/consumers/%s", chroot, group)
 	membersPath := groupPath + "/ids"
 	return Model{
@@ -45,6 +70,10 @@ func NewModel(zkConn *zk.Conn, chroot, group, memberID string, log *logrus.Entry
 		ownersPath:  groupPath + "/owners",
 		memberID:    memberID,
 		memberPath:  membersPath + "/" + memberID,
+		settings:    settings,
+		preferences: preferences,
+		maxMembers:  maxMembers,
+		groupPrefs:  &groupPrefs{},
 	}
 }
 
@@ -62,7 +91,11 @@ func (m *Model) EnsureMemberSubscription(topics []string) error {
 		return nil
 	}
 
-	memberSpec := newMemberSpec(topics)
+	if err := m.checkGroupNotFull(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	memberSpec := newMemberSpec(topics, m.settings, m.preferences)
 	memberSpecJSON, err := json.Marshal(memberSpec)
 	if err != nil {
 		return errors.Wrapf(err, "while JSON encoding %s", spew.Sdump(memberSpec))
@@ -74,6 +107,30 @@ func (m *Model) EnsureMemberSubscription(topics []string) error {
 	return nil
 }
 
+// checkGroupNotFull returns an error with cause ErrGroupFull if the group has
+// reached the configured maximum number of members and the bound member is
+// not one of them.
+func (m *Model) checkGroupNotFull() error {
+	if m.maxMembers <= 0 {
+		return nil
+	}
+	members, _, err := m.zkConn.Children(m.membersPath)
+	if err != nil && err != zk.ErrNoNode {
+		return errors.Wrapf(err, "while getting %v children", m.membersPath)
+	}
+	for _, memberID := range members {
+		if memberID == m.memberID {
+			return nil
+		}
+	}
+	if len(members) >= m.maxMembers {
+		metrics.Inc("consumer.group_full")
+		return errors.Wrapf(ErrGroupFull, "%d members registered, consumer.max_group_members is %d",
+			len(members), m.maxMembers)
+	}
+	return nil
+}
+
 // FetchGroupSubscriptions retrieves bound group member specification records
 // and returns memberID-to-topic-list map, along with a channel that will be
 // sent a message when either the number of members or subscription of any of
@@ -86,6 +143,7 @@ func (m *Model) FetchGroupSubscriptions() (map[string][]string, <-chan none.T, c
 
 	memberUpdateWatchChs := make(map[string]<-chan zk.Event, len(members))
 	subscriptions := make(map[string][]string, len(members))
+	memberPrefs := make(map[string][]int32)
 	for _, memberID := range members {
 		memberPath := m.memberZNodePath(memberID)
 		jsonMemberSpec, _, memberUpdateWatchCh, err := m.zkConn.GetW(memberPath)
@@ -104,7 +162,14 @@ func (m *Model) FetchGroupSubscriptions() (map[string][]string, <-chan none.T, c
 
 		memberUpdateWatchChs[memberID] = memberUpdateWatchCh
 		subscriptions[memberID] = memberSpec.topics()
+		if len(memberSpec.Preferences) > 0 {
+			memberPrefs[memberID] = memberSpec.Preferences
+		}
+		m.checkMemberSettings(memberID, memberSpec.Settings)
 	}
+	m.groupPrefs.mu.Lock()
+	m.groupPrefs.prefs = memberPrefs
+	m.groupPrefs.mu.Unlock()
 	aggregateWatchCh := make(chan none.T)
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -115,6 +180,19 @@ func (m *Model) FetchGroupSubscriptions() (map[string][]string, <-chan none.T, c
 	return subscriptions, aggregateWatchCh, cancel, nil
 }
 
+// GroupPreferences returns partition preferences published by group members,
+// as retrieved by the most recent FetchGroupSubscriptions call. Members that
+// did not publish preferences are not represented in the returned map.
+func (m *Model) GroupPreferences() map[string][]int32 {
+	m.groupPrefs.mu.Lock()
+	defer m.groupPrefs.mu.Unlock()
+	memberPrefs := make(map[string][]int32, len(m.groupPrefs.prefs))
+	for memberID, preferences := range m.groupPrefs.prefs {
+		memberPrefs[memberID] = preferences
+	}
+	return memberPrefs
+}
+
 // CreatePartitionOwner creates a partition owner znode, but only if none
 // exists for the given topic-partition. An error is returned if a partition
 // owner znode exists but belongs to another member.
@@ -338,6 +416,26 @@ func (m *Model) watchZNodeChildren(path string) ([]string, <-chan zk.Event, erro
 	}
 }
 
+// checkMemberSettings compares the published consumer settings of another
+// group member with those of the bound member and logs every mismatch, since
+// inconsistently configured fleets cause subtle rebalance and redelivery
+// bugs. Members that do not publish settings, e.g. older Kafka-Pixy versions
+// or Java consumers, are not checked.
+func (m *Model) checkMemberSettings(memberID string, settings map[string]string) {
+	if memberID == m.memberID || len(settings) == 0 {
+		return
+	}
+	for name, value := range m.settings {
+		otherValue, ok := settings[name]
+		if !ok || otherValue == value {
+			continue
+		}
+		m.log.Errorf("Consumer settings mismatch: member=%s, setting=%s, ours=%s, theirs=%s",
+			memberID, name, value, otherValue)
+		metrics.Inc("consumer.settings_mismatch")
+	}
+}
+
 // memberSpec structure is inherited from Java consumer, but we only use
 // subscriptions and ignore the reset, besides only "static" pattern is
 // supported, and it is not compatible with Kafka consumer group API.
@@ -347,9 +445,19 @@ type memberSpec struct {
 	Pattern   string `json:"pattern"`
 	Timestamp int64  `json:"timestamp"`
 	Version   int    `json:"version"`
+
+	// Settings carries key consumer parameters of the member in a namespaced
+	// field that Java consumers ignore, so that group members can detect
+	// configuration mismatches within the fleet.
+	Settings map[string]string `json:"kafka-pixy-settings,omitempty"`
+
+	// Preferences carries partitions the member prefers to be assigned, in a
+	// namespaced field that Java consumers ignore, so that all group members
+	// take the hint in account when resolving partition assignments.
+	Preferences []int32 `json:"kafka-pixy-preferred-partitions,omitempty"`
 }
 
-func newMemberSpec(topics []string) memberSpec {
+func newMemberSpec(topics []string, settings map[string]string, preferences []int32) memberSpec {
 	subscription := make(map[string]int)
 	for _, topic := range topics {
 		subscription[topic] = 1
@@ -360,6 +468,9 @@ func newMemberSpec(topics []string) memberSpec {
 		Pattern:   "static",
 		Timestamp: time.Now().Unix(),
 		Version:   1,
+
+		Settings:    settings,
+		Preferences: preferences,
 	}
 }
 
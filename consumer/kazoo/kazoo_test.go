@@ -36,7 +36,7 @@ func (s *ModelSuite) SetUpSuite(c *C) {
 		zk.WithLogger(logrus.StandardLogger()))
 	c.Assert(err, IsNil)
 	log := logrus.StandardLogger().WithFields(nil)
-	s.kazoo = NewModel(zkConn, chroot, "g0", "m0", log)
+	s.kazoo = NewModel(zkConn, chroot, "g0", "m0", nil, nil, 0, log)
 }
 
 func (s *ModelSuite) TearDownSuite(c *C) {
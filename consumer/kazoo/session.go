@@ -0,0 +1,104 @@
+package kazoo
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/kafka-pixy/netproxy"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[string]*SessionInfo)
+)
+
+// SessionInfo describes the observed state of a ZooKeeper session. Silent
+// session flapping otherwise only shows up as mystery rebalances, so state
+// transitions are counted and exposed along with the current state.
+type SessionInfo struct {
+	Name           string
+	State          string
+	Transitions    int64
+	Reconnects     int64
+	LastTransition time.Time
+}
+
+// Connect establishes a ZooKeeper session with the specified session and
+// connect timeouts, and registers it under the given name for observability.
+// If proxyURL is not empty then connections to the ZooKeeper peers are
+// established through that egress proxy. Session state transitions are
+// counted in metrics and the current state of every session is available via
+// SessionSnapshot.
+func Connect(name string, peers []string, sessionTimeout, connectTimeout time.Duration,
+	proxyURL string, log *logrus.Entry,
+) (*zk.Conn, error) {
+	dialer := func(network, address string, _ time.Duration) (net.Conn, error) {
+		return net.DialTimeout(network, address, connectTimeout)
+	}
+	if proxyURL != "" {
+		pxyDialer, err := netproxy.Dialer(proxyURL, connectTimeout)
+		if err != nil {
+			return nil, err
+		}
+		dialer = func(network, address string, _ time.Duration) (net.Conn, error) {
+			return pxyDialer.Dial(network, address)
+		}
+	}
+	conn, eventsCh, err := zk.Connect(peers, sessionTimeout, zk.WithDialer(dialer))
+	if err != nil {
+		return nil, err
+	}
+	go monitorSession(name, eventsCh, log)
+	return conn, nil
+}
+
+// SessionSnapshot returns a copy of the observed state of all registered
+// ZooKeeper sessions.
+func SessionSnapshot() []SessionInfo {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	snapshot := make([]SessionInfo, 0, len(sessions))
+	for _, si := range sessions {
+		snapshot = append(snapshot, *si)
+	}
+	return snapshot
+}
+
+// monitorSession consumes session events until the connection is closed,
+// keeping the session registry up to date. Only state change events are
+// recorded, watch events are delivered on dedicated channels and do not pass
+// through here.
+func monitorSession(name string, eventsCh <-chan zk.Event, log *logrus.Entry) {
+	hadSession := false
+	for ev := range eventsCh {
+		if ev.Type != zk.EventSession {
+			continue
+		}
+		log.Infof("ZooKeeper session state changed: session=%s, state=%s", name, ev.State)
+		metrics.Inc("zookeeper.state." + ev.State.String())
+		sessionsMu.Lock()
+		si := sessions[name]
+		if si == nil {
+			si = &SessionInfo{Name: name}
+			sessions[name] = si
+		}
+		si.State = ev.State.String()
+		si.Transitions += 1
+		si.LastTransition = time.Now().UTC()
+		if ev.State == zk.StateHasSession {
+			if hadSession {
+				si.Reconnects += 1
+				metrics.Inc("zookeeper.reconnects")
+			}
+			hadSession = true
+		}
+		sessionsMu.Unlock()
+	}
+	sessionsMu.Lock()
+	delete(sessions, name)
+	sessionsMu.Unlock()
+}
@@ -153,12 +153,13 @@ func (f *factory) SpawnExecutor(brokerConn *sarama.Broker) mapper.Executor {
 
 // chooseStartingOffset returns a real offset value selected based on the
 // suggested offset. The real offset value is selected as follows:
-//  * if the suggested offset equals to sarama.OffsetOldest or it is smaller
-//    then the oldest partition offset, then the oldest partition offset is
-//    selected;
-//  * if the suggested offset equals to sarama.OffsetNewest, or it is larger
-//    then the newest partition offset, then the newest partition offset is
-//    selected.
+//   - if the suggested offset equals to sarama.OffsetOldest or it is smaller
+//     then the oldest partition offset, then the oldest partition offset is
+//     selected;
+//   - if the suggested offset equals to sarama.OffsetNewest, or it is larger
+//     then the newest partition offset, then the newest partition offset is
+//     selected.
+//
 // Note that by the time the fetcher starts reading, the offset can become
 // invalid, e.g. when the selected offset belongs to an expired segment. In
 // this case fetcher will terminate gracefully. The fetcher user can detect
@@ -174,7 +175,11 @@ func (f *factory) chooseStartingOffset(topic string, partition int32, offset int
 	}
 
 	switch {
-	case offset == sarama.OffsetNewest || offset > newestOffset:
+	case offset == sarama.OffsetNewest:
+		// There is no offset committed for the topic yet, so the effective
+		// consumer.first_offset policy decides where to start.
+		return f.firstOffset(topic, partition, oldestOffset, newestOffset)
+	case offset > newestOffset:
 		return newestOffset, nil
 	case offset == sarama.OffsetOldest || offset < oldestOffset:
 		return oldestOffset, nil
@@ -183,6 +188,40 @@ func (f *factory) chooseStartingOffset(topic string, partition int32, offset int
 	}
 }
 
+// firstOffset resolves the consumption start policy configured for the topic
+// to a concrete offset in [oldestOffset, newestOffset]. A duration or
+// timestamp policy is resolved by a by-time offset lookup; if no message was
+// produced at or after the resolved time then consumption starts from the
+// newest offset.
+func (f *factory) firstOffset(topic string, partition int32, oldestOffset, newestOffset int64) (int64, error) {
+	firstOffset := f.cfg.ConsumerFirstOffset(topic)
+	switch firstOffset {
+	case config.FirstOffsetLatest:
+		return newestOffset, nil
+	case config.FirstOffsetEarliest:
+		return oldestOffset, nil
+	}
+	var t time.Time
+	if ago, err := time.ParseDuration(firstOffset); err == nil {
+		t = time.Now().Add(-ago)
+	} else if t, err = time.Parse(time.RFC3339, firstOffset); err != nil {
+		// Must never happen, the value is validated by the config.
+		return 0, errors.Errorf("bad first offset %q, topic=%s", firstOffset, topic)
+	}
+	timeMs := t.UnixNano() / int64(time.Millisecond)
+	offset, err := f.kafkaClt.GetOffset(topic, partition, timeMs)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get offset by time, partition=%d", partition)
+	}
+	switch {
+	case offset < oldestOffset:
+		return oldestOffset, nil
+	case offset > newestOffset:
+		return newestOffset, nil
+	}
+	return offset, nil
+}
+
 func (f *factory) onMsgIStreamSpawned(mf *msgFetcher) {
 	f.mapper.OnWorkerSpawned(mf)
 }
@@ -370,6 +409,9 @@ func (mf *msgFetcher) parseMessageSet(messageSet *sarama.MessageSet, highWaterMa
 					Timestamp: msg.Msg.Timestamp,
 				},
 				HighWaterMark: highWaterMarkOffset,
+				ProducerID:    -1,
+				BaseOffset:    -1,
+				BatchIndex:    -1,
 			}
 			fetchedMessages = append(fetchedMessages, consumerMsg)
 		}
@@ -392,7 +434,7 @@ func (mf *msgFetcher) parseRecordBatch(recordBatch *sarama.RecordBatch, highWate
 	}
 
 	var fetchedMessages []consumer.Message
-	for _, record := range recordBatch.Records {
+	for i, record := range recordBatch.Records {
 		offset := recordBatch.FirstOffset + record.OffsetDelta
 		if offset < mf.offset {
 			continue
@@ -409,6 +451,9 @@ func (mf *msgFetcher) parseRecordBatch(recordBatch *sarama.RecordBatch, highWate
 				Timestamp: recordBatch.FirstTimestamp.Add(record.TimestampDelta),
 			},
 			HighWaterMark: highWaterMarkOffset,
+			ProducerID:    recordBatch.ProducerID,
+			BaseOffset:    recordBatch.FirstOffset,
+			BatchIndex:    int32(i),
 		}
 		fetchedMessages = append(fetchedMessages, consumerMsg)
 	}
@@ -433,9 +478,12 @@ func (mf *msgFetcher) String() string {
 
 // brokerExecutor maintains a connection with a particular Kafka broker. It
 // processes fetch requests from message stream instances and sends responses
-// back. The dispatcher goroutine of the message stream factory is responsible
-// for keeping a broker executor alive while it is assigned to at least one
-// message stream instance.
+// back. All partitions lead by the broker share one executor and their fetch
+// requests are combined into a single Kafka fetch request, so the number of
+// in-flight requests and timers is bound by the number of brokers rather
+// than the number of partitions. The dispatcher goroutine of the message
+// stream factory is responsible for keeping a broker executor alive while it
+// is assigned to at least one message stream instance.
 //
 // implements `mapper.Executor`.
 type brokerExecutor struct {
@@ -24,6 +24,8 @@ type T struct {
 	stopCh    chan none.T
 	wg        sync.WaitGroup
 
+	drainTimeout time.Duration
+
 	sortedInsMu sync.Mutex
 	sortedIns   []*input
 }
@@ -56,10 +58,11 @@ type SpawnInFn func(partition int32) In
 // New creates a new multiplexer instance.
 func New(parentActDesc *actor.Descriptor, spawnInFn SpawnInFn) *T {
 	return &T{
-		actDesc:   parentActDesc.NewChild("mux"),
-		inputs:    make(map[int32]*input),
-		spawnInFn: spawnInFn,
-		stopCh:    make(chan none.T),
+		actDesc:      parentActDesc.NewChild("mux"),
+		inputs:       make(map[int32]*input),
+		spawnInFn:    spawnInFn,
+		stopCh:       make(chan none.T),
+		drainTimeout: handoffDrainTimeout,
 	}
 }
 
@@ -120,12 +123,15 @@ func (m *T) WireUp(output Out, assigned []int32) {
 		return
 	}
 
-	// Stop inputs that are not assigned anymore.
+	// Stop inputs that are not assigned anymore, but let each of them drain
+	// its offered messages first, so that a partition handed off to another
+	// group member is not redelivered from the last committed offset.
 	for p, in := range m.inputs {
 		if !hasPartition(p, assigned) {
 			wg.Add(1)
 			go func(in *input) {
 				defer wg.Done()
+				m.waitSafe2Stop(in)
 				in.Stop()
 			}(in)
 			delete(m.inputs, p)
@@ -156,6 +162,39 @@ func (m *T) WireUp(output Out, assigned []int32) {
 	m.actDesc.Log().Infof("Wiring completed: took=%v, waited=%v", wiringTook, waitedFor)
 }
 
+// How long WireUp waits for an unassigned input to drain offered messages
+// before stopping it anyway unless overridden via SetDrainTimeout, and how
+// often the check is repeated.
+const (
+	handoffDrainTimeout  = 3 * time.Second
+	handoffDrainInterval = 100 * time.Millisecond
+)
+
+// SetDrainTimeout overrides how long WireUp waits for an unassigned input to
+// drain its offered messages before stopping it anyway. Zero makes inputs
+// stop immediately. It must be called before the first WireUp.
+func (m *T) SetDrainTimeout(timeout time.Duration) {
+	m.drainTimeout = timeout
+}
+
+// waitSafe2Stop waits for the input to have no messages offered but not yet
+// acknowledged, so that it can be stopped without causing redeliveries. The
+// wait is bounded, a client that sits on an offered message must not be able
+// to stall a group rebalance indefinitely.
+func (m *T) waitSafe2Stop(in *input) {
+	if m.drainTimeout <= 0 {
+		return
+	}
+	deadline := time.Now().Add(m.drainTimeout)
+	for !in.IsSafe2Stop() {
+		if time.Now().After(deadline) {
+			m.actDesc.Log().Warnf("Giving up waiting for input to drain: partition=%d", in.partition)
+			return
+		}
+		time.Sleep(handoffDrainInterval)
+	}
+}
+
 // Stop synchronously stops the multiplexer.
 func (m *T) Stop() {
 	m.WireUp(nil, nil)
@@ -262,11 +301,12 @@ func makeSortedIns(inputs map[int32]*input) []*input {
 }
 
 func hasPartition(partition int32, partitions []int32) bool {
-	count := len(partitions)
-	if count == 0 {
-		return false
+	for _, p := range partitions {
+		if p == partition {
+			return true
+		}
 	}
-	return partitions[0] <= partition && partition <= partitions[count-1]
+	return false
 }
 
 // selectInput picks an input that should be multiplexed next. It prefers the
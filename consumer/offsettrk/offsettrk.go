@@ -14,6 +14,12 @@ import (
 
 const (
 	base64EncodeMap = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+	// Kafka rejects offset commits whose metadata exceeds
+	// offset.metadata.max.bytes, that is 4096 by default, and ZooKeeper has a
+	// similar node size limit. Encoded acked ranges are capped at this size
+	// so that a very wide ack window does not make offset commits fail.
+	maxEncodedAckedRangesLen = 4096
 )
 
 var (
@@ -38,11 +44,38 @@ func init() {
 type T struct {
 	actDesc      *actor.Descriptor
 	offerTimeout time.Duration
+	retryPolicy  RetryPolicy
 	offset       offsetmgr.Offset
 	ackedRanges  []offsetRange
 	offers       []offer
 }
 
+// RetryPolicy defines how long an expired offer waits before the message is
+// offered to a consumer again. The zero value re-offers immediately, which
+// matches the historical behavior.
+type RetryPolicy struct {
+	Strategy   string
+	Backoff    time.Duration
+	BackoffMax time.Duration
+}
+
+func (rp RetryPolicy) delay(retryNo int) time.Duration {
+	switch rp.Strategy {
+	case "fixed":
+		return rp.Backoff
+	case "exponential":
+		delay := rp.Backoff
+		for i := 0; i < retryNo; i++ {
+			delay *= 2
+			if delay >= rp.BackoffMax {
+				return rp.BackoffMax
+			}
+		}
+		return delay
+	}
+	return 0
+}
+
 // SparseAcks2Str returns human readable representation of sparsely committed
 // ranges encoded in the specified offset metadata.
 func SparseAcks2Str(offset offsetmgr.Offset) string {
@@ -76,6 +109,12 @@ func New(actDesc *actor.Descriptor, offset offsetmgr.Offset, offerTimeout time.D
 	return &ot
 }
 
+// SetRetryPolicy sets the policy that defines how long expired offers wait
+// before being re-offered.
+func (ot *T) SetRetryPolicy(retryPolicy RetryPolicy) {
+	ot.retryPolicy = retryPolicy
+}
+
 // Adjust adjusts the tracked offset. Offers with offsets lower then the new
 // offset value are dropped.
 func (ot *T) Adjust(offset int64) (offsetmgr.Offset, int) {
@@ -131,8 +170,47 @@ func (ot *T) OnAcked(offset int64) (offsetmgr.Offset, int) {
 			offset, !offerRemoved, !ackedRangesUpdated)
 	}
 	if ackedRangesUpdated {
-		ot.offset.Meta = encodeAckedRanges(ot.offset.Val, ot.ackedRanges)
+		ot.offset.Meta = ot.encodeMeta()
+	}
+	return ot.offset, len(ot.offers)
+}
+
+// MarkSkipped marks the [from, to) offset range as acknowledged without the
+// messages ever being offered. It is meant for offsets that do not exist in
+// the partition, e.g. removed by log compaction, so that they do not hold
+// the committed offset back forever. It returns an offset to be submitted
+// and a total number of offered messages.
+func (ot *T) MarkSkipped(from, to int64) (offsetmgr.Offset, int) {
+	if to <= ot.offset.Val {
+		return ot.offset, len(ot.offers)
+	}
+	if from < ot.offset.Val {
+		from = ot.offset.Val
+	}
+	// Find the first acked range that ends at or after the skipped range,
+	// then subsume every acked range the skipped one overlaps or touches.
+	i := 0
+	for i < len(ot.ackedRanges) && ot.ackedRanges[i].to < from {
+		i++
+	}
+	j := i
+	for j < len(ot.ackedRanges) && ot.ackedRanges[j].from <= to {
+		if ot.ackedRanges[j].from < from {
+			from = ot.ackedRanges[j].from
+		}
+		if ot.ackedRanges[j].to > to {
+			to = ot.ackedRanges[j].to
+		}
+		j++
+	}
+	tail := append([]offsetRange{{from, to}}, ot.ackedRanges[j:]...)
+	ot.ackedRanges = append(ot.ackedRanges[:i], tail...)
+	// If the first acked range reaches the tracked offset, then merge it in.
+	if ot.ackedRanges[0].from <= ot.offset.Val {
+		ot.offset.Val = ot.ackedRanges[0].to
+		ot.ackedRanges = ot.ackedRanges[1:]
 	}
+	ot.offset.Meta = ot.encodeMeta()
 	return ot.offset, len(ot.offers)
 }
 
@@ -157,6 +235,16 @@ func (ot *T) IsAcked(offset int64) (bool, int64) {
 	return false, offset + 1
 }
 
+// IsOffered checks if an offset is currently in the offer list, that is it
+// has been offered to a consumer but not acknowledged yet.
+func (ot *T) IsOffered(offset int64) bool {
+	offersCount := len(ot.offers)
+	i := sort.Search(offersCount, func(i int) bool {
+		return ot.offers[i].offset >= offset
+	})
+	return i < offersCount && ot.offers[i].offset == offset
+}
+
 // NextRetry returns a next message to be retried along with the retry attempt
 // number. If there are no messages to be retried then nil is returned.
 func (ot *T) NextRetry() (consumer.Message, int, bool) {
@@ -165,7 +253,7 @@ func (ot *T) NextRetry() (consumer.Message, int, bool) {
 func (ot *T) nextRetry(now time.Time) (consumer.Message, int, bool) {
 	for i := range ot.offers {
 		o := &ot.offers[i]
-		if o.deadline.Before(now) {
+		if o.deadline.Add(ot.retryPolicy.delay(o.retryNo)).Before(now) {
 			o.deadline = now.Add(ot.offerTimeout)
 			o.retryNo += 1
 			return o.msg, o.retryNo, true
@@ -183,6 +271,64 @@ func (ot *T) nextRetry(now time.Time) (consumer.Message, int, bool) {
 	return consumer.Message{}, -1, false
 }
 
+// Snapshot is a point-in-time diagnostic view of an offset tracker state. It
+// tells why a committed offset is not moving forward although consumers keep
+// acking: the gaps list names the exact not acked offset ranges that hold it
+// back.
+type Snapshot struct {
+	// Offset is the offset that would be committed at this point.
+	Offset int64
+	// WindowWidth is the distance from the committed offset to the end of
+	// the ack window, that is the highest acked or offered offset plus one.
+	WindowWidth int64
+	// SparseRanges is the number of acked ranges that cannot be merged into
+	// the committed offset yet because of gaps before them.
+	SparseRanges int
+	// Gaps lists not acked offset ranges lying between acked ranges.
+	Gaps []Gap
+	// OldestOffset is the oldest offered but not acked offset, or -1 if
+	// there are no pending offers.
+	OldestOffset int64
+	// OldestAge tells for how long the oldest offer has been outstanding.
+	OldestAge time.Duration
+}
+
+// Gap is a range of not acked offsets, from inclusive, to exclusive.
+type Gap struct {
+	From int64
+	To   int64
+}
+
+// Snapshot returns a diagnostic view of the current tracker state. It must
+// only be called by the goroutine that owns the tracker.
+func (ot *T) Snapshot() Snapshot {
+	return ot.snapshot(time.Now())
+}
+func (ot *T) snapshot(now time.Time) Snapshot {
+	sn := Snapshot{
+		Offset:       ot.offset.Val,
+		SparseRanges: len(ot.ackedRanges),
+		OldestOffset: -1,
+	}
+	windowEnd := ot.offset.Val
+	gapFrom := ot.offset.Val
+	for _, ar := range ot.ackedRanges {
+		sn.Gaps = append(sn.Gaps, Gap{gapFrom, ar.from})
+		gapFrom = ar.to
+		windowEnd = ar.to
+	}
+	if offersCount := len(ot.offers); offersCount > 0 {
+		oldest := ot.offers[0]
+		sn.OldestOffset = oldest.offset
+		sn.OldestAge = now.Sub(oldest.offeredAt)
+		if end := ot.offers[offersCount-1].offset + 1; end > windowEnd {
+			windowEnd = end
+		}
+	}
+	sn.WindowWidth = windowEnd - ot.offset.Val
+	return sn
+}
+
 // ShouldWait4Ack tells how much time until all offers expire.
 func (ot *T) ShouldWait4Ack() time.Duration {
 	return ot.shouldWait4Ack(time.Now())
@@ -212,7 +358,8 @@ func (ot *T) shouldWait4Ack(now time.Time) time.Duration {
 }
 
 func (ot *T) newOffer(msg consumer.Message) offer {
-	return offer{msg, msg.Offset, 0, time.Now().Add(ot.offerTimeout)}
+	now := time.Now()
+	return offer{msg, msg.Offset, 0, now, now.Add(ot.offerTimeout)}
 }
 
 // removeOffer if there is an offer with the specified offset in the list, then
@@ -273,7 +420,7 @@ func (ot *T) correctOffset(offset int64) {
 		ot.ackedRanges = ot.ackedRanges[drop:]
 	}
 	ot.offset.Val = offset
-	ot.offset.Meta = encodeAckedRanges(offset, ot.ackedRanges)
+	ot.offset.Meta = ot.encodeMeta()
 }
 
 // updateAckedRanges updates acked ranges with a new acked offset. It returns
@@ -323,6 +470,22 @@ func (ot *T) updateAckedRanges(offset int64) bool {
 	return true
 }
 
+// encodeMeta encodes the acked ranges to be committed as offset metadata. If
+// the encoding exceeds maxEncodedAckedRangesLen then the highest acked ranges
+// are dropped until it fits. Messages from the dropped ranges may be consumed
+// again after a rebalance or restart, but the ack state that is committed is
+// never silently truncated by the offset broker.
+func (ot *T) encodeMeta() string {
+	encoded := encodeAckedRanges(ot.offset.Val, ot.ackedRanges)
+	for len(encoded) > maxEncodedAckedRangesLen && len(ot.ackedRanges) > 0 {
+		dropped := ot.ackedRanges[len(ot.ackedRanges)-1]
+		ot.ackedRanges = ot.ackedRanges[:len(ot.ackedRanges)-1]
+		ot.actDesc.Log().Warnf("Acked ranges capped: dropped=%d-%d", dropped.from, dropped.to)
+		encoded = encodeAckedRanges(ot.offset.Val, ot.ackedRanges)
+	}
+	return encoded
+}
+
 func encodeAckedRanges(base int64, ackedRanges []offsetRange) string {
 	ackedRangesCount := len(ackedRanges)
 	if ackedRangesCount == 0 {
@@ -412,8 +575,9 @@ func decodeInt64(buf []byte) (int64, []byte, error) {
 }
 
 type offer struct {
-	msg      consumer.Message
-	offset   int64
-	retryNo  int
-	deadline time.Time
+	msg       consumer.Message
+	offset    int64
+	retryNo   int
+	offeredAt time.Time
+	deadline  time.Time
 }
@@ -139,6 +139,61 @@ func (s *OffsetTrkSuite) TestAdjust(c *C) {
 	}
 }
 
+// Offset ranges skipped due to log compaction are merged into acked ranges
+// and do not hold the committed offset back.
+func (s *OffsetTrkSuite) TestMarkSkipped(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
+	ot.OnOffered(msg(302))
+	ot.OnAcked(302)
+	ot.OnOffered(msg(305))
+	ot.OnAcked(305)
+	c.Assert(SparseAcks2Str(ot.offset), Equals, "2-3,5-6")
+
+	for i, tc := range []struct {
+		from      int64
+		to        int64
+		committed int64
+		ranges    string
+	}{
+		// A skipped range subsumes the acked ranges it touches.
+		0: {from: 303, to: 305, committed: 300, ranges: "2-6"},
+		// A range starting at the committed offset advances it.
+		1: {from: 300, to: 301, committed: 301, ranges: "1-5"},
+		// A range bridging the committed offset and an acked range merges both.
+		2: {from: 301, to: 302, committed: 306, ranges: ""},
+		// A range entirely below the committed offset makes no difference.
+		3: {from: 200, to: 250, committed: 306, ranges: ""},
+		4: {from: 310, to: 320, committed: 306, ranges: "4-14"},
+		5: {from: 308, to: 310, committed: 306, ranges: "2-14"},
+	} {
+		// When
+		offset, _ := ot.MarkSkipped(tc.from, tc.to)
+
+		// Then
+		c.Assert(offset.Val, Equals, tc.committed, Commentf("case #%d", i))
+		c.Assert(SparseAcks2Str(offset), Equals, tc.ranges, Commentf("case #%d", i))
+	}
+}
+
+// A very wide sparse ack window does not grow the encoded offset metadata
+// beyond the broker size limit. The highest acked ranges are sacrificed to
+// keep the encoding within bounds.
+func (s *OffsetTrkSuite) TestEncodedMetaCapped(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
+	var offset offsetmgr.Offset
+	// When: every other offset of a wide window is acked.
+	for i := int64(1); i < 4000; i++ {
+		offset, _ = ot.OnAcked(300 + 2*i)
+	}
+	// Then: the encoding stays within the limit and remains valid.
+	c.Assert(len(offset.Meta) <= maxEncodedAckedRangesLen, Equals, true)
+	_, err := decodeAckedRanges(offset.Val, offset.Meta)
+	c.Assert(err, IsNil)
+	// The lowest ranges are preserved.
+	isAcked, _ := ot.IsAcked(302)
+	c.Assert(isAcked, Equals, true)
+}
+
 func (s *OffsetTrkSuite) TestRangeEncodeDecode(c *C) {
 	for i, tc := range []struct {
 		base int64
@@ -305,6 +360,75 @@ func (s *OffsetTrkSuite) TestOfferAckLoop(c *C) {
 	}
 }
 
+// An offset is reported as offered from the moment it is offered until it is
+// acknowledged.
+func (s *OffsetTrkSuite) TestIsOffered(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
+	for _, offset := range []int64{300, 301, 302, 304} {
+		ot.OnOffered(msg(offset))
+	}
+	ot.OnAcked(301)
+	for i, tc := range []struct {
+		offset    int64
+		isOffered bool
+	}{
+		0: {offset: 299, isOffered: false},
+		1: {offset: 300, isOffered: true},
+		2: {offset: 301, isOffered: false},
+		3: {offset: 302, isOffered: true},
+		4: {offset: 303, isOffered: false},
+		5: {offset: 304, isOffered: true},
+		6: {offset: 305, isOffered: false},
+	} {
+		c.Assert(ot.IsOffered(tc.offset), Equals, tc.isOffered, Commentf("case #%d", i))
+	}
+}
+
+func (s *OffsetTrkSuite) TestSnapshot(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second)
+
+	// An idle tracker has an empty window and no gaps.
+	sn := ot.Snapshot()
+	c.Assert(sn, DeepEquals, Snapshot{
+		Offset:       300,
+		OldestOffset: -1,
+	})
+
+	// Offer a batch and ack sparsely leaving gaps at 301 and 303-304.
+	for offset := int64(300); offset < 306; offset++ {
+		ot.OnOffered(msg(offset))
+	}
+	ot.OnAcked(300)
+	ot.OnAcked(302)
+	ot.OnAcked(305)
+
+	sn = ot.Snapshot()
+	c.Assert(sn.Offset, Equals, int64(301))
+	c.Assert(sn.WindowWidth, Equals, int64(5))
+	c.Assert(sn.SparseRanges, Equals, 2)
+	c.Assert(sn.Gaps, DeepEquals, []Gap{{301, 302}, {303, 305}})
+	c.Assert(sn.OldestOffset, Equals, int64(301))
+	c.Assert(sn.OldestAge > 0, Equals, true)
+
+	// Offers above the last acked range extend the window but are not gaps.
+	ot.OnOffered(msg(307))
+	sn = ot.Snapshot()
+	c.Assert(sn.WindowWidth, Equals, int64(7))
+	c.Assert(sn.Gaps, DeepEquals, []Gap{{301, 302}, {303, 305}})
+
+	// When the gaps are acked the window collapses to the pending offer.
+	ot.OnAcked(301)
+	ot.OnAcked(303)
+	ot.OnAcked(304)
+	sn = ot.Snapshot()
+	c.Assert(sn, DeepEquals, Snapshot{
+		Offset:       306,
+		WindowWidth:  2,
+		OldestOffset: 307,
+		OldestAge:    sn.OldestAge,
+	})
+}
+
 func (s *OffsetTrkSuite) TestNextRetry(c *C) {
 	ot := New(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second)
 	msgs := []consumer.Message{
@@ -361,6 +485,24 @@ func (s *OffsetTrkSuite) TestNextRetry(c *C) {
 	}
 }
 
+func (s *OffsetTrkSuite) TestRetryPolicyDelay(c *C) {
+	for i, tc := range []struct {
+		policy  RetryPolicy
+		retryNo int
+		delay   time.Duration
+	}{
+		0: {RetryPolicy{}, 3, 0},
+		1: {RetryPolicy{Strategy: "immediate"}, 3, 0},
+		2: {RetryPolicy{Strategy: "fixed", Backoff: time.Second}, 0, time.Second},
+		3: {RetryPolicy{Strategy: "fixed", Backoff: time.Second}, 5, time.Second},
+		4: {RetryPolicy{Strategy: "exponential", Backoff: time.Second, BackoffMax: time.Minute}, 0, time.Second},
+		5: {RetryPolicy{Strategy: "exponential", Backoff: time.Second, BackoffMax: time.Minute}, 3, 8 * time.Second},
+		6: {RetryPolicy{Strategy: "exponential", Backoff: time.Second, BackoffMax: time.Minute}, 10, time.Minute},
+	} {
+		c.Assert(tc.policy.delay(tc.retryNo), Equals, tc.delay, Commentf("case #%d", i))
+	}
+}
+
 func (s *OffsetTrkSuite) TestMaxOfferTimeout(c *C) {
 	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
 	msgs := []consumer.Message{
@@ -3,6 +3,7 @@ package partitioncsm
 import (
 	"encoding/base64"
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -10,9 +11,12 @@ import (
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
 	"github.com/mailgun/kafka-pixy/consumer/msgfetcher"
 	"github.com/mailgun/kafka-pixy/consumer/offsettrk"
 	"github.com/mailgun/kafka-pixy/consumer/subscriber"
+	"github.com/mailgun/kafka-pixy/features"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/pkg/errors"
@@ -27,26 +31,143 @@ var (
 
 	// Sets an interval for periodical checks for messages to retry.
 	check4RetryInterval = time.Second
+
+	// All partition consumers of the process share one retry check timer, so
+	// that a topic with thousands of partitions does not keep thousands of
+	// mostly idle tickers around.
+	retryPulse pulseT
+
+	trackerMu        sync.Mutex
+	trackerSnapshots = make(map[trackerKey]offsettrk.Snapshot)
+
+	liveMu        sync.Mutex
+	liveConsumers = make(map[trackerKey]*T)
 )
 
+// pulseT broadcasts a wake-up signal to an arbitrary number of subscribers
+// every check4RetryInterval by closing the current subscription channel and
+// replacing it with a fresh one. The backing ticker goroutine is started
+// lazily by the first subscription.
+type pulseT struct {
+	mu sync.Mutex
+	ch chan none.T
+}
+
+// subscribe returns a channel that is closed on the next tick. A subscriber
+// must call subscribe again after every wake-up to get a new channel.
+func (p *pulseT) subscribe() <-chan none.T {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ch == nil {
+		p.ch = make(chan none.T)
+		go p.run()
+	}
+	return p.ch
+}
+
+func (p *pulseT) run() {
+	ticker := time.NewTicker(check4RetryInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.mu.Lock()
+		close(p.ch)
+		p.ch = make(chan none.T)
+		p.mu.Unlock()
+	}
+}
+
+type trackerKey struct {
+	group     string
+	topic     string
+	partition int32
+}
+
+// TrackerSnapshot returns the most recently reported offset tracker state of
+// the specified group-topic-partition, or false if the partition is not
+// consumed by this instance. Like per-topic traffic stats the registry is
+// process wide and is not scoped to a cluster.
+func TrackerSnapshot(group, topic string, partition int32) (offsettrk.Snapshot, bool) {
+	trackerMu.Lock()
+	defer trackerMu.Unlock()
+	sn, ok := trackerSnapshots[trackerKey{group, topic, partition}]
+	return sn, ok
+}
+
+// GroupTrackerSnapshots returns the most recently reported offset tracker
+// state of all partitions of the specified group that are consumed by this
+// instance, keyed by topic and partition.
+func GroupTrackerSnapshots(group string) map[string]map[int32]offsettrk.Snapshot {
+	trackerMu.Lock()
+	defer trackerMu.Unlock()
+	sns := make(map[string]map[int32]offsettrk.Snapshot)
+	for key, sn := range trackerSnapshots {
+		if key.group != group {
+			continue
+		}
+		topicSns := sns[key.topic]
+		if topicSns == nil {
+			topicSns = make(map[int32]offsettrk.Snapshot)
+			sns[key.topic] = topicSns
+		}
+		topicSns[key.partition] = sn
+	}
+	return sns
+}
+
+// ResetOffsets rewinds partition consumers of the specified group-topic that
+// are running in this process to the given offsets. A partition consumer that
+// accepts a reset drops the message it is about to offer, commits the reset
+// offset and resumes fetching from it. Partitions that are not consumed by
+// this process, are missing from the offsets map, or whose consumer has a
+// reset pending already are left alone. The partitions actually rewound are
+// returned in ascending order.
+func ResetOffsets(group, topic string, offsets map[int32]int64) []int32 {
+	liveMu.Lock()
+	var pcs []*T
+	for key, pc := range liveConsumers {
+		if key.group != group || key.topic != topic {
+			continue
+		}
+		if _, ok := offsets[key.partition]; ok {
+			pcs = append(pcs, pc)
+		}
+	}
+	liveMu.Unlock()
+	var rewound []int32
+	for _, pc := range pcs {
+		select {
+		case pc.resetCh <- offsets[pc.partition]:
+			rewound = append(rewound, pc.partition)
+		default:
+		}
+	}
+	sort.Slice(rewound, func(i, j int) bool { return rewound[i] < rewound[j] })
+	return rewound
+}
+
 // T ensures exclusive consumption of messages from a topic
 // partition within a particular group. It ensures that a partition is consumed
 // exclusively by first claiming the partition in ZooKeeper. When a fetched
 // message is pulled from the `messages()` channel, it is considered to be
 // consumed and its offset is committed.
 type T struct {
-	actDesc     *actor.Descriptor
-	cfg         *config.Proxy
-	group       string
-	topic       string
-	partition   int32
-	groupMember *subscriber.T
-	msgFetcherF msgfetcher.Factory
-	offsetMgrF  offsetmgr.Factory
-	messagesCh  chan consumer.Message
-	eventsCh    chan consumer.Event
-	stopCh      chan none.T
-	wg          sync.WaitGroup
+	actDesc      *actor.Descriptor
+	cfg          *config.Proxy
+	group        string
+	topic        string
+	partition    int32
+	groupMember  *subscriber.T
+	msgFetcherF  msgfetcher.Factory
+	offsetMgrF   offsetmgr.Factory
+	deadLetterFn consumer.DeadLetterFn
+	messagesCh   chan consumer.Message
+	eventsCh     chan consumer.Event
+	resetCh      chan int64
+	stopCh       chan none.T
+	dupMetric    string
+	gapMetric    string
+	compacted    bool
+	wg           sync.WaitGroup
 
 	offsetMgr       offsetmgr.T
 	committedOffset offsetmgr.Offset
@@ -55,6 +176,11 @@ type T struct {
 	offsetTrk       *offsettrk.T
 	offerCount      int32
 
+	// Offsets skipped over due to the broker retention policy that have not
+	// been reported to a consumer yet. Attached to the next fetched message
+	// so the client learns about the loss in band.
+	offsetsLost int64
+
 	// For tests only!
 	firstMsgFetched bool
 }
@@ -62,23 +188,29 @@ type T struct {
 // Spawn creates a partition consumer instance and starts its goroutines.
 func Spawn(parentActDesc *actor.Descriptor, group, topic string, partition int32, cfg *config.Proxy,
 	groupMember *subscriber.T, msgFetcherF msgfetcher.Factory, offsetMgrF offsetmgr.Factory,
+	deadLetterFn consumer.DeadLetterFn,
 ) *T {
 	actDesc := parentActDesc.NewChild(fmt.Sprintf("%s.p%d", topic, partition))
 	actDesc.AddLogField("kafka.group", group)
 	actDesc.AddLogField("kafka.topic", topic)
 	actDesc.AddLogField("kafka.partition", partition)
 	pc := &T{
-		actDesc:     actDesc,
-		cfg:         cfg,
-		group:       group,
-		topic:       topic,
-		partition:   partition,
-		groupMember: groupMember,
-		msgFetcherF: msgFetcherF,
-		offsetMgrF:  offsetMgrF,
-		messagesCh:  make(chan consumer.Message, 1),
-		eventsCh:    make(chan consumer.Event, 1),
-		stopCh:      make(chan none.T),
+		actDesc:      actDesc,
+		cfg:          cfg,
+		group:        group,
+		topic:        topic,
+		partition:    partition,
+		groupMember:  groupMember,
+		msgFetcherF:  msgFetcherF,
+		offsetMgrF:   offsetMgrF,
+		deadLetterFn: deadLetterFn,
+		messagesCh:   make(chan consumer.Message, 1),
+		eventsCh:     make(chan consumer.Event, 1),
+		resetCh:      make(chan int64, 1),
+		stopCh:       make(chan none.T),
+		dupMetric:    fmt.Sprintf("consumer.%s.%s.duplicates", group, topic),
+		gapMetric:    fmt.Sprintf("consumer.%s.%s.offset_gaps", group, topic),
+		compacted:    cfg.IsCompactedTopic(topic),
 	}
 	actor.Spawn(pc.actDesc, &pc.wg, pc.run)
 	return pc
@@ -122,9 +254,13 @@ func (pc *T) run() {
 		return
 	}
 	pc.actDesc.Log().Infof("Initial offset: %s", offsetRepr(pc.committedOffset))
-	pc.offsetTrk = offsettrk.New(pc.actDesc, pc.committedOffset, pc.cfg.Consumer.AckTimeout)
+	pc.offsetTrk = pc.newOffsetTrk(pc.committedOffset)
 	pc.submittedOffset = pc.committedOffset
 	pc.offsetsOk = true
+	pc.reportTrackerState()
+	defer pc.unreportTrackerState()
+	pc.registerLive()
+	defer pc.unregisterLive()
 	pc.notifyTestInitialized(pc.committedOffset)
 
 	// Run a fetch loop until the partition consumer is signalled to stop.
@@ -166,15 +302,22 @@ func (pc *T) runFetchLoop() bool {
 		pc.offsetMgr.SubmitOffset(pc.submittedOffset)
 		pc.actDesc.Log().Errorf("Adjusted offset: new=%s, old=%s",
 			offsetRepr(pc.submittedOffset), offsetRepr(pc.committedOffset))
+		// If the committed offset was adjusted forward, then messages the
+		// group had yet to consume were deleted by the broker retention
+		// policy. That is data loss, so make it count rather than bury it in
+		// a log line.
+		if pc.committedOffset.Val >= 0 && realOffsetVal > pc.committedOffset.Val {
+			pc.onRetentionSkip(pc.committedOffset.Val, realOffsetVal)
+		}
 	}
 	var (
-		nilOrMsgInCh  = mf.Messages()
-		nilOrMsgOutCh chan consumer.Message
-		retryTicker   = time.NewTicker(check4RetryInterval)
-		msg           consumer.Message
-		msgOk         bool
+		nilOrMsgInCh    = mf.Messages()
+		nilOrMsgOutCh   chan consumer.Message
+		retryPulseCh    = retryPulse.subscribe()
+		msg             consumer.Message
+		msgOk           bool
+		nextFetchOffset = realOffsetVal
 	)
-	defer retryTicker.Stop()
 	for {
 		select {
 		case msg, msgOk = <-nilOrMsgInCh:
@@ -183,18 +326,35 @@ func (pc *T) runFetchLoop() bool {
 			if !msgOk {
 				return true
 			}
+			if msg.Offset > nextFetchOffset {
+				pc.onOffsetGap(nextFetchOffset, msg.Offset)
+			}
+			nextFetchOffset = msg.Offset + 1
 			// If a fetched message has already been acked, then skip it.
 			if ok, _ := pc.offsetTrk.IsAcked(msg.Offset); ok {
 				msgOk = false
 				continue
 			}
+			// If the message has been offered before, e.g. it was fetched
+			// again after a fetcher restart, then the consumer is about to
+			// see it a second time. Count the duplicate to quantify the
+			// at-least-once overhead.
+			if pc.offsetTrk.IsOffered(msg.Offset) {
+				metrics.Inc(pc.dupMetric)
+			}
 			msg.EventsCh = pc.eventsCh
+			if pc.offsetsLost > 0 {
+				msg.OffsetsLost = pc.offsetsLost
+				pc.offsetsLost = 0
+			}
 			pc.notifyTestFetched()
 			nilOrMsgOutCh = pc.messagesCh
 			// Stop fetching messages until this one is offered to a client.
 			nilOrMsgInCh = nil
 
-		case <-retryTicker.C:
+		case <-retryPulseCh:
+			retryPulseCh = retryPulse.subscribe()
+			pc.reportTrackerState()
 			if msgOk {
 				continue
 			}
@@ -234,12 +394,88 @@ func (pc *T) runFetchLoop() bool {
 				}
 			}
 		case pc.committedOffset = <-pc.offsetMgr.CommittedOffsets():
+		case offsetVal := <-pc.resetCh:
+			pc.onReset(offsetVal)
+			return true
 		case <-pc.stopCh:
 			return false
 		}
 	}
 }
 
+// onReset is called when an operator requested offset reset reaches the
+// partition consumer via ResetOffsets. The partition consumer starts over
+// from the reset offset as if it had just been spawned with it as the
+// committed position: the offset tracker is recreated, dropping pending
+// offers and retries, the reset offset is committed, and the fetch loop is
+// restarted so the fetcher is respawned from it. Messages offered before the
+// reset may still be acknowledged afterwards; such offsets are recorded by
+// the new tracker and are not offered again, but they never move the
+// committed offset before the reset point.
+func (pc *T) onReset(offsetVal int64) {
+	pc.actDesc.Log().Infof("Reset offset: new=%d, old=%s",
+		offsetVal, offsetRepr(pc.submittedOffset))
+	pc.committedOffset = offsetmgr.Offset{Val: offsetVal}
+	pc.submittedOffset = pc.committedOffset
+	pc.offsetTrk = pc.newOffsetTrk(pc.committedOffset)
+	atomic.StoreInt32(&pc.offerCount, 0)
+	pc.offsetMgr.SubmitOffset(pc.submittedOffset)
+	pc.reportTrackerState()
+}
+
+// newOffsetTrk creates an offset tracker starting from the given committed
+// offset, with the retry policy configured for the topic.
+func (pc *T) newOffsetTrk(committed offsetmgr.Offset) *offsettrk.T {
+	trk := offsettrk.New(pc.actDesc, committed, pc.cfg.Consumer.AckTimeout)
+	trk.SetRetryPolicy(offsettrk.RetryPolicy{
+		Strategy:   pc.cfg.ConsumerRetryStrategy(pc.topic),
+		Backoff:    pc.cfg.Consumer.RetryBackoff,
+		BackoffMax: pc.cfg.Consumer.RetryBackoffMax,
+	})
+	return trk
+}
+
+// onOffsetGap is called when fetched offsets turn out not to be consecutive.
+// Single offset gaps are routinely left by transaction control records and
+// are ignored. For topics declared compacted larger gaps are normal too: they
+// are counted, and the skipped offsets are marked acknowledged so that they
+// do not hold the committed offset back waiting for messages that no longer
+// exist. On any other topic a gap means probable data loss, e.g. messages
+// expired by the retention policy before being consumed, and is reported as
+// such.
+func (pc *T) onOffsetGap(from, to int64) {
+	if pc.compacted {
+		metrics.Inc(pc.gapMetric)
+		var offerCount int
+		pc.submittedOffset, offerCount = pc.offsetTrk.MarkSkipped(from, to)
+		atomic.StoreInt32(&pc.offerCount, int32(offerCount))
+		pc.offsetMgr.SubmitOffset(pc.submittedOffset)
+		return
+	}
+	if to-from < 2 {
+		return
+	}
+	pc.actDesc.Log().Errorf("Offset gap, possible data loss: from=%d, to=%d, width=%d",
+		from, to, to-from)
+	pc.onRetentionSkip(from, to)
+}
+
+// onRetentionSkip is called when offsets in the range [from, to) that were
+// never consumed by the group turn out to be deleted by the broker retention
+// policy. The deleted messages are gone along with their timestamps, so only
+// the count is known for sure; the time span of the loss is bounded by the
+// timestamp of the next message fetched, which carries the count to the
+// client (see consumer.Message.OffsetsLost). Besides that the loss is
+// counted in a metric and recorded in the group event log.
+func (pc *T) onRetentionSkip(from, to int64) {
+	lost := to - from
+	pc.offsetsLost += lost
+	metrics.Add("consumer.offsets_lost", lost)
+	groupevents.Record(pc.group, groupevents.RetentionSkip,
+		"topic=%s, partition=%d, offsets=[%d, %d), lost=%d",
+		pc.topic, pc.partition, from, to, lost)
+}
+
 // nextRetry checks with the offset tracker if there is a message ready to be
 // retried. If it gets a message that has already been retried maxRetries times,
 // then it acks the message and asks the offset tracker for another one. It
@@ -247,21 +483,79 @@ func (pc *T) runFetchLoop() bool {
 // returned or there are no more messages to be retried.
 func (pc *T) nextRetry() (consumer.Message, bool) {
 	msg, retryNo, ok := pc.offsetTrk.NextRetry()
-	for ok && pc.cfg.Consumer.MaxRetries >= 0 && retryNo > pc.cfg.Consumer.MaxRetries {
-		pc.actDesc.Log().Errorf("Too many retries: retryNo=%d, offset=%d, key=%s, msg=%s",
-			retryNo, msg.Offset, string(msg.Key), base64.StdEncoding.EncodeToString(msg.Value))
+	for ok && pc.cfg.Consumer.MaxRetries >= 0 && retryNo > pc.cfg.Consumer.MaxRetries &&
+		features.Enabled(features.PoisonSkipping, pc.group, pc.topic) {
+		metrics.Inc("consumer.poison_skipped")
+		pc.actDesc.Log().Errorf("Too many retries, skipping poison message: group=%s, topic=%s, partition=%d, retryNo=%d, offset=%d, key=%s, msg=%s",
+			pc.group, pc.topic, pc.partition, retryNo, msg.Offset, string(msg.Key), base64.StdEncoding.EncodeToString(msg.Value))
+		if pc.deadLetterFn != nil && features.Enabled(features.DeadLetter, pc.group, pc.topic) {
+			pc.deadLetterFn(msg, pc.group, retryNo, "too many retries")
+		}
 		pc.submittedOffset, _ = pc.offsetTrk.OnAcked(msg.Offset)
 		pc.offsetMgr.SubmitOffset(pc.submittedOffset)
-		// TODO: Dump expired messages to a long term storage?
 		msg, retryNo, ok = pc.offsetTrk.NextRetry()
 	}
 	if ok {
+		metrics.Inc(pc.dupMetric)
 		pc.actDesc.Log().Warnf("Retrying: retryNo=%d, offset=%d, key=%s",
 			retryNo, msg.Offset, string(msg.Key))
 	}
 	return msg, ok
 }
 
+// reportTrackerState publishes the current offset tracker state to the
+// diagnostic registry and the per-partition gauges. It is called periodically
+// from the fetch loop, so the published state may be up to
+// check4RetryInterval behind.
+// registerLive makes the partition consumer discoverable by ResetOffsets for
+// the time it owns the partition.
+func (pc *T) registerLive() {
+	liveMu.Lock()
+	liveConsumers[trackerKey{pc.group, pc.topic, pc.partition}] = pc
+	liveMu.Unlock()
+}
+
+func (pc *T) unregisterLive() {
+	key := trackerKey{pc.group, pc.topic, pc.partition}
+	liveMu.Lock()
+	if liveConsumers[key] == pc {
+		delete(liveConsumers, key)
+	}
+	liveMu.Unlock()
+}
+
+func (pc *T) reportTrackerState() {
+	sn := pc.offsetTrk.Snapshot()
+	trackerMu.Lock()
+	trackerSnapshots[trackerKey{pc.group, pc.topic, pc.partition}] = sn
+	trackerMu.Unlock()
+	gaugePrefix := pc.gaugePrefix()
+	metrics.Set(gaugePrefix+"window_width", sn.WindowWidth)
+	metrics.Set(gaugePrefix+"sparse_ranges", int64(sn.SparseRanges))
+	var oldestAgeMs int64
+	if sn.OldestOffset >= 0 {
+		oldestAgeMs = int64(sn.OldestAge / time.Millisecond)
+	}
+	metrics.Set(gaugePrefix+"oldest_unacked_age_ms", oldestAgeMs)
+}
+
+// unreportTrackerState removes the registry entry and the gauges of this
+// partition, so that stale state is not reported after the partition consumer
+// is gone.
+func (pc *T) unreportTrackerState() {
+	trackerMu.Lock()
+	delete(trackerSnapshots, trackerKey{pc.group, pc.topic, pc.partition})
+	trackerMu.Unlock()
+	gaugePrefix := pc.gaugePrefix()
+	metrics.Unset(gaugePrefix + "window_width")
+	metrics.Unset(gaugePrefix + "sparse_ranges")
+	metrics.Unset(gaugePrefix + "oldest_unacked_age_ms")
+}
+
+func (pc *T) gaugePrefix() string {
+	return fmt.Sprintf("offsettrk.%s.%s.%d.", pc.group, pc.topic, pc.partition)
+}
+
 func (pc *T) stopOffsetMgr() {
 	pc.offsetMgr.Stop()
 	if !pc.offsetsOk {
@@ -0,0 +1,351 @@
+package subscriber
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The name of the group protocol that kafka-pixy members negotiate during a
+// JoinGroup round. It is deliberately different from the protocols used by
+// Java consumers, for kafka-pixy resolves partition assignments on every
+// member rather than on the leader only.
+const kafkaGroupProtocol = "kafka-pixy"
+
+// kafkaModel implements consumer group membership on top of the Kafka group
+// coordinator protocol (JoinGroup/SyncGroup/Heartbeat), so that kafka-pixy
+// can run against clusters that have no ZooKeeper at all. Unlike the Java
+// consumer protocol, where the leader resolves partition assignments, here
+// the leader merely distributes the full membership map to every member via
+// SyncGroup, and each member resolves partition assignments deterministically
+// the same way it does in ZooKeeper mode. Partition ownership exclusivity is
+// provided by the coordinator generation mechanism, so partition owner
+// operations are no-ops.
+type kafkaModel struct {
+	group string
+	cfg   *config.Proxy
+	log   *logrus.Entry
+
+	mu           sync.Mutex
+	kafkaClt     sarama.Client
+	memberID     string
+	generationID int32
+	topics       []string
+	groupPrefs   map[string][]int32
+}
+
+// kafkaMemberMeta is the JoinGroup metadata published by every kafka-pixy
+// group member. It carries the same information as the ZooKeeper member spec.
+type kafkaMemberMeta struct {
+	MemberID    string            `json:"member_id"`
+	Topics      []string          `json:"topics"`
+	Settings    map[string]string `json:"settings,omitempty"`
+	Preferences []int32           `json:"preferences,omitempty"`
+}
+
+// kafkaGroupAssignment is distributed by the group leader to every member via
+// SyncGroup. It aggregates the metadata of all joined members so that each of
+// them can resolve partition assignments locally.
+type kafkaGroupAssignment struct {
+	Subscriptions map[string][]string `json:"subscriptions"`
+	Preferences   map[string][]int32  `json:"preferences,omitempty"`
+}
+
+func newKafkaModel(group string, cfg *config.Proxy, log *logrus.Entry) *kafkaModel {
+	return &kafkaModel{
+		group: group,
+		cfg:   cfg,
+		log:   log,
+	}
+}
+
+// EnsureMemberSubscription records the topics to be published in the next
+// JoinGroup round, that FetchGroupSubscriptions performs. If the topic list
+// is empty then the bound member leaves the group.
+func (m *kafkaModel) EnsureMemberSubscription(topics []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(topics) == 0 {
+		return m.leaveGroup()
+	}
+	m.topics = topics
+	return nil
+}
+
+// FetchGroupSubscriptions performs a JoinGroup/SyncGroup round with the group
+// coordinator and returns memberID-to-topic-list map, along with a channel
+// that will be sent a message when the coordinator initiates a rebalance.
+func (m *kafkaModel) FetchGroupSubscriptions() (map[string][]string, <-chan none.T, context.CancelFunc, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	coordinator, err := m.coordinator()
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "while getting coordinator")
+	}
+	assignment, err := m.joinGroup(coordinator)
+	if err != nil {
+		m.resetCoordinator()
+		return nil, nil, nil, errors.WithStack(err)
+	}
+
+	m.groupPrefs = assignment.Preferences
+	watchCh := make(chan none.T, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go m.runHeartbeats(ctx, coordinator, m.memberID, m.generationID, watchCh)
+	return assignment.Subscriptions, watchCh, cancel, nil
+}
+
+// GroupPreferences returns partition preferences published by group members,
+// as distributed by the group leader in the most recent SyncGroup round.
+func (m *kafkaModel) GroupPreferences() map[string][]int32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	memberPrefs := make(map[string][]int32, len(m.groupPrefs))
+	for memberID, preferences := range m.groupPrefs {
+		memberPrefs[memberID] = preferences
+	}
+	return memberPrefs
+}
+
+// CreatePartitionOwner is a no-op in Kafka coordination mode, for the group
+// generation mechanism guarantees that partition assignments resolved from
+// the same generation membership do not overlap.
+func (m *kafkaModel) CreatePartitionOwner(topic string, partition int32) error {
+	return nil
+}
+
+// DeletePartitionOwner is a no-op in Kafka coordination mode, see
+// CreatePartitionOwner.
+func (m *kafkaModel) DeletePartitionOwner(topic string, partition int32) error {
+	return nil
+}
+
+// DeleteGroupIfEmpty is a no-op in Kafka coordination mode, for the group
+// coordinator garbage collects empty groups on its own.
+func (m *kafkaModel) DeleteGroupIfEmpty() error {
+	return nil
+}
+
+// joinGroup performs a JoinGroup/SyncGroup round. If the bound member turns
+// out to be the group leader, then it distributes the aggregated membership
+// metadata to all members. It is called with m.mu held.
+func (m *kafkaModel) joinGroup(coordinator *sarama.Broker) (kafkaGroupAssignment, error) {
+	meta := kafkaMemberMeta{
+		MemberID:    m.cfg.ClientID,
+		Topics:      m.topics,
+		Settings:    memberSettings(m.cfg),
+		Preferences: m.cfg.Consumer.PreferredPartitions,
+	}
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return kafkaGroupAssignment{}, errors.Wrap(err, "while encoding metadata")
+	}
+	joinRq := &sarama.JoinGroupRequest{
+		GroupId:        m.group,
+		MemberId:       m.memberID,
+		SessionTimeout: int32(m.cfg.Consumer.SubscriptionTimeout / time.Millisecond),
+		ProtocolType:   "consumer",
+	}
+	if m.cfg.SaramaClientCfg().Version.IsAtLeast(sarama.V0_10_1_0) {
+		joinRq.Version = 1
+		joinRq.RebalanceTimeout = int32(m.cfg.Consumer.SubscriptionTimeout / time.Millisecond)
+	}
+	joinRq.AddGroupProtocol(kafkaGroupProtocol, metaJSON)
+	joinRs, err := coordinator.JoinGroup(joinRq)
+	if err != nil {
+		return kafkaGroupAssignment{}, errors.Wrap(err, "while joining group")
+	}
+	if joinRs.Err != sarama.ErrNoError {
+		if joinRs.Err == sarama.ErrUnknownMemberId {
+			m.memberID = ""
+		}
+		return kafkaGroupAssignment{}, errors.Wrap(joinRs.Err, "while joining group")
+	}
+	m.memberID = joinRs.MemberId
+	m.generationID = joinRs.GenerationId
+
+	syncRq := &sarama.SyncGroupRequest{
+		GroupId:      m.group,
+		GenerationId: joinRs.GenerationId,
+		MemberId:     joinRs.MemberId,
+	}
+	if joinRs.LeaderId == joinRs.MemberId {
+		groupAssignment, err := m.resolveGroupAssignment(joinRs.Members)
+		if err != nil {
+			return kafkaGroupAssignment{}, errors.WithStack(err)
+		}
+		syncRq.GroupAssignments = groupAssignment
+	}
+	syncRs, err := coordinator.SyncGroup(syncRq)
+	if err != nil {
+		return kafkaGroupAssignment{}, errors.Wrap(err, "while syncing group")
+	}
+	if syncRs.Err != sarama.ErrNoError {
+		if syncRs.Err == sarama.ErrUnknownMemberId {
+			m.memberID = ""
+		}
+		return kafkaGroupAssignment{}, errors.Wrap(syncRs.Err, "while syncing group")
+	}
+	var assignment kafkaGroupAssignment
+	if err := json.Unmarshal(syncRs.MemberAssignment, &assignment); err != nil {
+		return kafkaGroupAssignment{}, errors.Wrapf(err, "while parsing assignment, data=%s",
+			string(syncRs.MemberAssignment))
+	}
+	return assignment, nil
+}
+
+// resolveGroupAssignment aggregates the JoinGroup metadata of all members
+// into a group assignment that is distributed to every one of them. It is
+// performed by the group leader only.
+func (m *kafkaModel) resolveGroupAssignment(members map[string][]byte) (map[string][]byte, error) {
+	assignment := kafkaGroupAssignment{
+		Subscriptions: make(map[string][]string, len(members)),
+		Preferences:   make(map[string][]int32),
+	}
+	for kafkaMemberID, metaJSON := range members {
+		var meta kafkaMemberMeta
+		if err := json.Unmarshal(metaJSON, &meta); err != nil {
+			return nil, errors.Wrapf(err, "while parsing member %s metadata, data=%s",
+				kafkaMemberID, string(metaJSON))
+		}
+		assignment.Subscriptions[meta.MemberID] = meta.Topics
+		if len(meta.Preferences) > 0 {
+			assignment.Preferences[meta.MemberID] = meta.Preferences
+		}
+		m.checkMemberSettings(meta.MemberID, meta.Settings)
+	}
+	assignmentJSON, err := json.Marshal(assignment)
+	if err != nil {
+		return nil, errors.Wrap(err, "while encoding assignment")
+	}
+	groupAssignment := make(map[string][]byte, len(members))
+	for kafkaMemberID := range members {
+		groupAssignment[kafkaMemberID] = assignmentJSON
+	}
+	return groupAssignment, nil
+}
+
+// checkMemberSettings compares the published consumer settings of another
+// group member with those of the bound member and logs every mismatch, since
+// inconsistently configured fleets cause subtle rebalance and redelivery bugs.
+func (m *kafkaModel) checkMemberSettings(memberID string, settings map[string]string) {
+	if memberID == m.cfg.ClientID || len(settings) == 0 {
+		return
+	}
+	for name, value := range memberSettings(m.cfg) {
+		otherValue, ok := settings[name]
+		if !ok || otherValue == value {
+			continue
+		}
+		m.log.Errorf("Consumer settings mismatch: member=%s, setting=%s, ours=%s, theirs=%s",
+			memberID, name, value, otherValue)
+		metrics.Inc("consumer.settings_mismatch")
+	}
+}
+
+// runHeartbeats keeps sending heartbeats to the group coordinator until
+// either the context is canceled or the coordinator initiates a rebalance, in
+// which case a message is sent to watchCh to make the subscriber re-fetch
+// group subscriptions.
+func (m *kafkaModel) runHeartbeats(ctx context.Context, coordinator *sarama.Broker, memberID string, generationID int32, watchCh chan<- none.T) {
+	heartbeatInterval := m.cfg.Consumer.SubscriptionTimeout / 3
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		heartbeatRs, err := coordinator.Heartbeat(&sarama.HeartbeatRequest{
+			GroupId:      m.group,
+			GenerationId: generationID,
+			MemberId:     memberID,
+		})
+		if err != nil {
+			m.log.WithError(err).Error("Failed to send heartbeat")
+			m.mu.Lock()
+			m.resetCoordinator()
+			m.mu.Unlock()
+		} else if heartbeatRs.Err == sarama.ErrNoError {
+			continue
+		} else {
+			m.log.Infof("Rejoin triggered by heartbeat: err=%v", heartbeatRs.Err)
+		}
+		select {
+		case watchCh <- none.V:
+		case <-ctx.Done():
+		}
+		return
+	}
+}
+
+// leaveGroup makes the bound member leave the group and releases the lazily
+// created Kafka client. It is called with m.mu held.
+func (m *kafkaModel) leaveGroup() error {
+	m.topics = nil
+	if m.memberID == "" {
+		return nil
+	}
+	coordinator, err := m.coordinator()
+	if err != nil {
+		return errors.Wrap(err, "while getting coordinator")
+	}
+	leaveRs, err := coordinator.LeaveGroup(&sarama.LeaveGroupRequest{
+		GroupId:  m.group,
+		MemberId: m.memberID,
+	})
+	if err != nil {
+		m.resetCoordinator()
+		return errors.Wrap(err, "while leaving group")
+	}
+	if leaveRs.Err != sarama.ErrNoError && leaveRs.Err != sarama.ErrUnknownMemberId {
+		return errors.Wrap(leaveRs.Err, "while leaving group")
+	}
+	m.memberID = ""
+	if m.kafkaClt != nil {
+		m.kafkaClt.Close()
+		m.kafkaClt = nil
+	}
+	return nil
+}
+
+// coordinator lazily creates a Kafka client and resolves the group
+// coordinator broker. It is called with m.mu held.
+func (m *kafkaModel) coordinator() (*sarama.Broker, error) {
+	if m.kafkaClt == nil {
+		backend, err := kafkaclt.New(m.cfg)
+		if err != nil {
+			return nil, errors.Wrap(err, "while creating Kafka backend")
+		}
+		kafkaClt, err := backend.NewClient()
+		if err != nil {
+			return nil, errors.Wrap(err, "while creating Kafka client")
+		}
+		m.kafkaClt = kafkaClt
+	}
+	coordinator, err := m.kafkaClt.Coordinator(m.group)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return coordinator, nil
+}
+
+// resetCoordinator makes the next coordinator call re-resolve the group
+// coordinator broker, e.g. after it failed over to another broker. It is
+// called with m.mu held.
+func (m *kafkaModel) resetCoordinator() {
+	if m.kafkaClt != nil {
+		m.kafkaClt.RefreshCoordinator(m.group)
+	}
+}
@@ -2,14 +2,18 @@ package subscriber
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
 	"github.com/mailgun/kafka-pixy/consumer/kazoo"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/prettyfmt"
 	"github.com/samuel/go-zookeeper/zk"
@@ -22,11 +26,12 @@ const (
 	safeClaimRetriesCount = 10
 )
 
-// T is a subscriber implementation based on ZooKeeper. It maintains consumer
-// group membership and topic subscriptions, watches for other members to join,
-// leave and update their subscriptions, and generates notifications of such
-// changes. It also provides an API to for a partition consumer to claim and
-// release a group-topic-partition.
+// T maintains consumer group membership and topic subscriptions, watches for
+// other members to join, leave and update their subscriptions, and generates
+// notifications of such changes. It also provides an API to for a partition
+// consumer to claim and release a group-topic-partition. Group membership is
+// managed either in ZooKeeper or via the Kafka group coordinator protocol,
+// depending on the consumer.coordination setting.
 //
 // FIXME: It is assumed that all members of the group are registered with the
 // FIXME: `static` pattern. If a member that pattern is either `white_list` or
@@ -35,7 +40,7 @@ type T struct {
 	actDesc         *actor.Descriptor
 	cfg             *config.Proxy
 	group           string
-	kazooModel      kazoo.Model
+	model           groupModel
 	registered      bool
 	topicsCh        chan []string
 	subscriptionsCh chan map[string][]string
@@ -44,21 +49,43 @@ type T struct {
 	wg              sync.WaitGroup
 }
 
+// groupModel abstracts the group membership backend. It is implemented by
+// kazoo.Model for ZooKeeper based coordination and by kafkaModel for
+// coordination via the Kafka group coordinator protocol, selectable with
+// consumer.coordination.
+type groupModel interface {
+	EnsureMemberSubscription(topics []string) error
+	FetchGroupSubscriptions() (map[string][]string, <-chan none.T, context.CancelFunc, error)
+	GroupPreferences() map[string][]int32
+	CreatePartitionOwner(topic string, partition int32) error
+	DeletePartitionOwner(topic string, partition int32) error
+	DeleteGroupIfEmpty() error
+}
+
 // Spawn creates a subscriber instance and starts its goroutine.
 func Spawn(parentActDesc *actor.Descriptor, group string, cfg *config.Proxy, zkConn *zk.Conn) *T {
 	actDesc := parentActDesc.NewChild("member")
 	actDesc.AddLogField("kafka.group", group)
-	kazooModel := kazoo.NewModel(
-		zkConn,
-		cfg.ZooKeeper.Chroot,
-		group,
-		cfg.ClientID,
-		actDesc.Log())
+	var model groupModel
+	if cfg.Consumer.Coordination == "kafka" {
+		model = newKafkaModel(group, cfg, actDesc.Log())
+	} else {
+		kazooModel := kazoo.NewModel(
+			zkConn,
+			cfg.ZooKeeper.Chroot,
+			group,
+			cfg.ClientID,
+			memberSettings(cfg),
+			cfg.Consumer.PreferredPartitions,
+			cfg.Consumer.MaxGroupMembers,
+			actDesc.Log())
+		model = &kazooModel
+	}
 	ss := &T{
 		actDesc:         actDesc,
 		cfg:             cfg,
 		group:           group,
-		kazooModel:      kazooModel,
+		model:           model,
 		topicsCh:        make(chan []string),
 		subscriptionsCh: make(chan map[string][]string),
 		stopCh:          make(chan none.T),
@@ -68,6 +95,18 @@ func Spawn(parentActDesc *actor.Descriptor, group string, cfg *config.Proxy, zkC
 	return ss
 }
 
+// memberSettings collects the consumer parameters that all members of a
+// group must agree on, to be published along with the member subscription.
+func memberSettings(cfg *config.Proxy) map[string]string {
+	return map[string]string{
+		"ack_timeout":             cfg.Consumer.AckTimeout.String(),
+		"assignment_strategy":     cfg.Consumer.AssignmentStrategy,
+		"offsets_commit_interval": cfg.Consumer.OffsetsCommitInterval.String(),
+		"retry_strategy":          cfg.Consumer.RetryStrategy,
+		"max_retries":             strconv.Itoa(cfg.Consumer.MaxRetries),
+	}
+}
+
 // Topics returns a channel to receive a list of topics the member should
 // subscribe to. To make the member unsubscribe from all topics either nil or
 // an empty topic list can be sent.
@@ -82,6 +121,12 @@ func (s *T) Subscriptions() <-chan map[string][]string {
 	return s.subscriptionsCh
 }
 
+// GroupPreferences returns partition preferences published by group members,
+// as of the last subscriptions update.
+func (s *T) GroupPreferences() map[string][]int32 {
+	return s.model.GroupPreferences()
+}
+
 // ClaimPartition claims a topic/partition to be consumed by this member of the
 // consumer group. It blocks until either succeeds or canceled by the caller. It
 // returns a function that should be called to release the claim.
@@ -106,7 +151,7 @@ func (s *T) Stop() {
 // DeleteGroupIfEmpty deletes the consumer group data structures from ZooKeeper
 // if there are no more members registered.
 func (s *T) DeleteGroupIfEmpty() {
-	if err := s.kazooModel.DeleteGroupIfEmpty(); err != nil {
+	if err := s.model.DeleteGroupIfEmpty(); err != nil {
 		s.actDesc.Log().WithError(err).Errorf("Failed to delete empty group %s", s.group)
 		return
 	}
@@ -116,6 +161,8 @@ func (s *T) DeleteGroupIfEmpty() {
 func (s *T) run() {
 	defer close(s.subscriptionsCh)
 	defer s.deleteMemberSubscription()
+	memberCountGauge := fmt.Sprintf("consumer.%s.members", s.group)
+	defer metrics.Unset(memberCountGauge)
 	var (
 		nilOrSubscriptionsCh     chan<- map[string][]string
 		nilOrWatchCh             <-chan none.T
@@ -159,13 +206,20 @@ func (s *T) run() {
 		}
 
 		if shouldSubmitTopics {
-			if err = s.kazooModel.EnsureMemberSubscription(topics); err != nil {
+			if err = s.model.EnsureMemberSubscription(topics); err != nil {
 				s.actDesc.Log().WithError(err).Error("Failed to submit topics")
 				nilOrTimeoutCh = time.After(s.cfg.Consumer.RetryBackoff)
 				continue
 			}
 			submittedAt = time.Now()
 			s.actDesc.Log().Infof("Submitted: topics=%v", topics)
+			if len(topics) > 0 {
+				groupevents.Record(s.group, groupevents.Join,
+					"member=%s, topics=%v", s.cfg.ClientID, topics)
+			} else {
+				groupevents.Record(s.group, groupevents.Leave,
+					"member=%s", s.cfg.ClientID)
+			}
 			shouldSubmitTopics = false
 			if cancelWatch != nil {
 				cancelWatch()
@@ -174,13 +228,14 @@ func (s *T) run() {
 		}
 
 		if shouldFetchSubscriptions {
-			subscriptions, nilOrWatchCh, cancelWatch, err = s.kazooModel.FetchGroupSubscriptions()
+			subscriptions, nilOrWatchCh, cancelWatch, err = s.model.FetchGroupSubscriptions()
 			if err != nil {
 				s.actDesc.Log().WithError(err).Error("Failed to fetch subscriptions")
 				nilOrTimeoutCh = time.After(s.cfg.Consumer.RetryBackoff)
 				continue
 			}
 			shouldFetchSubscriptions = false
+			metrics.Set(memberCountGauge, int64(len(subscriptions)))
 			s.actDesc.Log().Infof("Fetched subscriptions: %s", prettyfmt.Val(subscriptions))
 			nilOrSubscriptionsCh = s.subscriptionsCh
 
@@ -199,13 +254,14 @@ func (s *T) run() {
 // deleteMemberSubscription reliably unsubscribes from all topics.
 func (s *T) deleteMemberSubscription() {
 	for {
-		err := s.kazooModel.EnsureMemberSubscription(nil)
+		err := s.model.EnsureMemberSubscription(nil)
 		if err == nil {
 			break
 		}
 		s.actDesc.Log().WithError(err).Error("Failed to unregister")
 		<-time.After(s.cfg.Consumer.RetryBackoff)
 	}
+	groupevents.Record(s.group, groupevents.Leave, "member=%s", s.cfg.ClientID)
 }
 
 type partitionClaimer struct {
@@ -220,7 +276,7 @@ func (pc *partitionClaimer) claim() func() {
 	beginAt := time.Now()
 	retries := 0
 	for {
-		err := pc.subscriber.kazooModel.CreatePartitionOwner(pc.topic, pc.partition)
+		err := pc.subscriber.model.CreatePartitionOwner(pc.topic, pc.partition)
 		if err == nil {
 			break
 		}
@@ -253,7 +309,7 @@ func (pc *partitionClaimer) release() {
 	beginAt := time.Now()
 	retries := 0
 	for {
-		err := pc.subscriber.kazooModel.DeletePartitionOwner(pc.topic, pc.partition)
+		err := pc.subscriber.model.DeletePartitionOwner(pc.topic, pc.partition)
 		if err == nil {
 			break
 		}
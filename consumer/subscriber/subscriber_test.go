@@ -8,6 +8,7 @@ import (
 
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer/kazoo"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	"github.com/samuel/go-zookeeper/zk"
@@ -247,7 +248,7 @@ func (s *SubscriberSuite) TestMissingSubscriptionBug(c *C) {
 
 	// When: brute-force remove g1/m1 subscription to simulate session
 	// expiration due to ZooKeeper connection loss.
-	ss1.kazooModel.EnsureMemberSubscription(nil)
+	ss1.model.(*kazoo.Model).EnsureMemberSubscription(nil)
 
 	// Then
 	// Both nodes see the group state without m1:
@@ -284,7 +285,7 @@ func (s *SubscriberSuite) TestMissingOutdatedSubscription(c *C) {
 
 	// When: Modify the m1 subscriptions to simulate stale data session
 	// expiration due to ZooKeeper connection loss.
-	ss1.kazooModel.EnsureMemberSubscription([]string{"foo", "bazz"})
+	ss1.model.(*kazoo.Model).EnsureMemberSubscription([]string{"foo", "bazz"})
 
 	// Then
 	// Both nodes see the group state with the incorrect m1 subscription first:
@@ -308,7 +309,7 @@ func (s *SubscriberSuite) TestClaimPartition(c *C) {
 	defer ss.Stop()
 	cancelCh := make(chan none.T)
 
-	owner, err := ss.kazooModel.GetPartitionOwner("foo", 1)
+	owner, err := ss.model.(*kazoo.Model).GetPartitionOwner("foo", 1)
 	c.Assert(err, IsNil)
 	c.Assert(owner, Equals, "")
 
@@ -317,7 +318,7 @@ func (s *SubscriberSuite) TestClaimPartition(c *C) {
 	defer claim1()
 
 	// Then
-	owner, err = ss.kazooModel.GetPartitionOwner("foo", 1)
+	owner, err = ss.model.(*kazoo.Model).GetPartitionOwner("foo", 1)
 	c.Assert(err, IsNil)
 	c.Assert(owner, Equals, "m1")
 }
@@ -344,7 +345,7 @@ func (s *SubscriberSuite) TestClaimPartitionClaimed(c *C) {
 	defer claim2()
 
 	// Then
-	owner, err := ss1.kazooModel.GetPartitionOwner("foo", 1)
+	owner, err := ss1.model.(*kazoo.Model).GetPartitionOwner("foo", 1)
 	c.Assert(err, IsNil)
 	c.Assert(owner, Equals, "m1")
 }
@@ -363,7 +364,7 @@ func (s *SubscriberSuite) TestClaimPartitionTwice(c *C) {
 	defer claim2()
 
 	// Then
-	owner, err := ss.kazooModel.GetPartitionOwner("foo", 1)
+	owner, err := ss.model.(*kazoo.Model).GetPartitionOwner("foo", 1)
 	c.Assert(err, IsNil)
 	c.Assert(owner, Equals, "m1")
 }
@@ -382,7 +383,7 @@ func (s *SubscriberSuite) TestReleasePartition(c *C) {
 	claim2() // the second claim is revoked here but it could have been any.
 
 	// Then
-	owner, err := ss.kazooModel.GetPartitionOwner("foo", 1)
+	owner, err := ss.model.(*kazoo.Model).GetPartitionOwner("foo", 1)
 	c.Assert(err, IsNil)
 	c.Assert(owner, Equals, "")
 
@@ -412,7 +413,7 @@ func (s *SubscriberSuite) TestClaimPartitionParallel(c *C) {
 	defer claim2()
 
 	// Then: the partition is claimed by m2.
-	owner, err := ss2.kazooModel.GetPartitionOwner("foo", 1)
+	owner, err := ss2.model.(*kazoo.Model).GetPartitionOwner("foo", 1)
 	c.Assert(err, IsNil)
 	c.Assert(owner, Equals, "m2")
 }
@@ -451,7 +452,7 @@ func (s *SubscriberSuite) TestClaimPartitionCanceled(c *C) {
 	defer claim2()
 
 	// Then: the partition is still claimed by m1.
-	owner, err := ss2.kazooModel.GetPartitionOwner("foo", 1)
+	owner, err := ss2.model.(*kazoo.Model).GetPartitionOwner("foo", 1)
 	c.Assert(err, IsNil)
 	c.Assert(owner, Equals, "m1")
 
@@ -474,7 +475,7 @@ func (s *SubscriberSuite) TestReleasePartitionNotOwner(c *C) {
 	// Hijack the claim to simulate connection loss.
 	_, err := s.zkConn.Set(chroot+"/consumers/g1/owners/foo/1", []byte("m2"), -1)
 	c.Assert(err, IsNil)
-	owner, err := ss.kazooModel.GetPartitionOwner("foo", 1)
+	owner, err := ss.model.(*kazoo.Model).GetPartitionOwner("foo", 1)
 	c.Assert(err, IsNil)
 	c.Assert(owner, Equals, "m2")
 
@@ -10,6 +10,8 @@ import (
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
+	"github.com/mailgun/kafka-pixy/features"
 )
 
 var (
@@ -99,7 +101,9 @@ func (tc *T) run() {
 				sinceLatestRq := clock.Now().UTC().Sub(latestRqTime)
 				subscriptionTTL := tc.cfg.Consumer.SubscriptionTimeout - sinceLatestRq
 				if subscriptionTTL <= 0 {
-					tc.actDesc.Log().Info("Topic subscription expired")
+					tc.actDesc.Log().Infof("Topic subscription expired: reason=idle, idle=%v", sinceLatestRq)
+					groupevents.Record(tc.group, groupevents.Expire,
+						"topic=%s, idle=%v", tc.topic, sinceLatestRq)
 					goto wait4SafeStop
 				}
 				expireTimer.Reset(subscriptionTTL)
@@ -151,6 +155,15 @@ func (tc *T) serveRequest(consumeRq consumer.Request) time.Time {
 		consumeRq.ResponseCh <- requestTimeoutRs
 		return latestRqTime
 	}
+	// While the group is frozen no messages are offered, so the request waits
+	// out its long polling timeout as if the topic were idle. The requests
+	// themselves keep the subscription alive, therefore freezing does not
+	// change group membership.
+	if features.Enabled(features.Frozen, tc.group, tc.topic) {
+		<-clock.After(requestTTL)
+		consumeRq.ResponseCh <- requestTimeoutRs
+		return latestRqTime
+	}
 	select {
 	case msg := <-tc.messagesCh:
 		msg.EventsCh <- consumer.Event{T: consumer.EvOffered, Offset: msg.Offset}
@@ -0,0 +1,218 @@
+// Package emulator implements an in-process, in-memory substitute for a Kafka
+// cluster. When a proxy is started in emulator mode it serves the regular
+// HTTP/gRPC API from the in-memory topic store, so that applications can run
+// integration tests against Kafka-Pixy without Kafka/ZooKeeper containers.
+//
+// The emulator intentionally keeps the model simple: every topic has exactly
+// one partition, topics are auto created on first produce or consume, and a
+// consumed message is considered committed right away. It is a test double,
+// not a Kafka implementation.
+package emulator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+)
+
+// T is an in-memory topic store that mimics the subset of Kafka behavior
+// needed to serve the Kafka-Pixy API.
+type T struct {
+	cfg    *config.Proxy
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+type topic struct {
+	messages []message
+	cursors  map[string]int64
+	// updatedCh is closed and replaced whenever a message is appended, to
+	// wake up long polling consume requests.
+	updatedCh chan struct{}
+}
+
+type message struct {
+	key     []byte
+	value   []byte
+	headers []*sarama.RecordHeader
+}
+
+// New creates an emulator instance with an empty topic store.
+func New(cfg *config.Proxy) *T {
+	return &T{
+		cfg:    cfg,
+		topics: make(map[string]*topic),
+	}
+}
+
+// Produce appends a message to the specified topic creating the topic if it
+// does not exist yet.
+func (e *T) Produce(topicName string, key, value kafkaclt.Encoder, headers []kafkaclt.Header) (*kafkaclt.ProducedMsg, error) {
+	keyBytes, err := encodeMaybeNil(key)
+	if err != nil {
+		return nil, err
+	}
+	valueBytes, err := encodeMaybeNil(value)
+	if err != nil {
+		return nil, err
+	}
+	msg := message{key: keyBytes, value: valueBytes}
+	for _, h := range headers {
+		msg.headers = append(msg.headers, &sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+
+	e.mu.Lock()
+	t := e.getOrCreateTopic(topicName)
+	offset := int64(len(t.messages))
+	t.messages = append(t.messages, msg)
+	close(t.updatedCh)
+	t.updatedCh = make(chan struct{})
+	e.mu.Unlock()
+
+	return &kafkaclt.ProducedMsg{
+		Topic:  topicName,
+		Offset: offset,
+	}, nil
+}
+
+// Consume returns the next message of the specified topic on behalf of the
+// specified group and advances the group cursor. If there are no new messages
+// then it blocks for at most `consumer.long_polling_timeout` before returning
+// consumer.ErrRequestTimeout.
+func (e *T) Consume(group, topicName string) (consumer.Message, error) {
+	timeoutCh := time.After(e.cfg.Consumer.LongPollingTimeout)
+	for {
+		e.mu.Lock()
+		t := e.getOrCreateTopic(topicName)
+		next := t.cursors[group]
+		if next < int64(len(t.messages)) {
+			msg := t.messages[next]
+			t.cursors[group] = next + 1
+			highWaterMark := int64(len(t.messages))
+			e.mu.Unlock()
+			return consumer.Message{
+				ConsumerMessage: sarama.ConsumerMessage{
+					Key:       msg.key,
+					Value:     msg.value,
+					Topic:     topicName,
+					Partition: 0,
+					Offset:    next,
+					Headers:   msg.headers,
+				},
+				HighWaterMark: highWaterMark,
+				ProducerID:    -1,
+				BaseOffset:    -1,
+				BatchIndex:    -1,
+			}, nil
+		}
+		updatedCh := t.updatedCh
+		e.mu.Unlock()
+		select {
+		case <-updatedCh:
+		case <-timeoutCh:
+			return consumer.Message{}, consumer.ErrRequestTimeout
+		}
+	}
+}
+
+// Ack is a no-op provided for API compatibility. The emulator commits a
+// message as soon as it is consumed.
+func (e *T) Ack(group, topic string) error {
+	return nil
+}
+
+// GetGroupOffsets returns the offset range of the specified topic along with
+// the group cursor position.
+func (e *T) GetGroupOffsets(group, topicName string) ([]admin.PartitionOffset, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, ok := e.topics[topicName]
+	if !ok {
+		return nil, sarama.ErrUnknownTopicOrPartition
+	}
+	return []admin.PartitionOffset{{
+		Partition: 0,
+		Begin:     0,
+		End:       int64(len(t.messages)),
+		Offset:    t.cursors[group],
+	}}, nil
+}
+
+// SetGroupOffsets moves the group cursor of the specified topic.
+func (e *T) SetGroupOffsets(group, topicName string, offsets []admin.PartitionOffset) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, ok := e.topics[topicName]
+	if !ok {
+		return sarama.ErrUnknownTopicOrPartition
+	}
+	for _, po := range offsets {
+		if po.Partition != 0 {
+			continue
+		}
+		offset := po.Offset
+		if end := int64(len(t.messages)); offset > end {
+			offset = end
+		}
+		if offset < 0 {
+			offset = 0
+		}
+		t.cursors[group] = offset
+	}
+	return nil
+}
+
+// ListTopics returns metadata for all topics in the store.
+func (e *T) ListTopics() ([]admin.TopicMetadata, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	tms := make([]admin.TopicMetadata, 0, len(e.topics))
+	for topicName := range e.topics {
+		tms = append(tms, topicMetadata(topicName))
+	}
+	return tms, nil
+}
+
+// GetTopicMetadata returns metadata of the specified topic.
+func (e *T) GetTopicMetadata(topicName string) (admin.TopicMetadata, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.topics[topicName]; !ok {
+		return admin.TopicMetadata{}, sarama.ErrUnknownTopicOrPartition
+	}
+	return topicMetadata(topicName), nil
+}
+
+// getOrCreateTopic returns the named topic creating it if it does not exist.
+// The caller must be holding e.mu.
+func (e *T) getOrCreateTopic(topicName string) *topic {
+	t, ok := e.topics[topicName]
+	if !ok {
+		t = &topic{
+			cursors:   make(map[string]int64),
+			updatedCh: make(chan struct{}),
+		}
+		e.topics[topicName] = t
+	}
+	return t
+}
+
+func topicMetadata(topicName string) admin.TopicMetadata {
+	return admin.TopicMetadata{
+		Topic:      topicName,
+		Config:     &admin.TopicConfig{Config: map[string]string{}},
+		Partitions: []admin.PartitionMetadata{{ID: 0}},
+	}
+}
+
+func encodeMaybeNil(e kafkaclt.Encoder) ([]byte, error) {
+	if e == nil {
+		return nil, nil
+	}
+	return e.Encode()
+}
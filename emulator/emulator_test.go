@@ -0,0 +1,115 @@
+package emulator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	. "gopkg.in/check.v1"
+)
+
+type EmulatorSuite struct {
+	cfg *config.Proxy
+}
+
+var _ = Suite(&EmulatorSuite{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+func (s *EmulatorSuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultProxy()
+	s.cfg.Consumer.LongPollingTimeout = 100 * time.Millisecond
+}
+
+func (s *EmulatorSuite) TestProduceConsume(c *C) {
+	e := New(s.cfg)
+
+	// When
+	prodMsg, err := e.Produce("foo", sarama.StringEncoder("k"), sarama.StringEncoder("v"), nil)
+	c.Assert(err, IsNil)
+	c.Assert(prodMsg.Offset, Equals, int64(0))
+
+	// Then
+	consMsg, err := e.Consume("bar", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(consMsg.Key), Equals, "k")
+	c.Assert(string(consMsg.Value), Equals, "v")
+	c.Assert(consMsg.Offset, Equals, int64(0))
+	c.Assert(consMsg.HighWaterMark, Equals, int64(1))
+}
+
+func (s *EmulatorSuite) TestConsumeTimeout(c *C) {
+	e := New(s.cfg)
+
+	// When/Then
+	_, err := e.Consume("bar", "foo")
+	c.Assert(err, Equals, consumer.ErrRequestTimeout)
+}
+
+func (s *EmulatorSuite) TestConsumeWakesUpOnProduce(c *C) {
+	e := New(s.cfg)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		e.Produce("foo", nil, sarama.StringEncoder("v"), nil)
+	}()
+
+	// When
+	consMsg, err := e.Consume("bar", "foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(string(consMsg.Value), Equals, "v")
+}
+
+func (s *EmulatorSuite) TestGroupCursorsAreIndependent(c *C) {
+	e := New(s.cfg)
+	e.Produce("foo", nil, sarama.StringEncoder("v1"), nil)
+	e.Produce("foo", nil, sarama.StringEncoder("v2"), nil)
+
+	// When/Then
+	consMsg, err := e.Consume("bar", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(consMsg.Value), Equals, "v1")
+
+	consMsg, err = e.Consume("bazz", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(consMsg.Value), Equals, "v1")
+}
+
+func (s *EmulatorSuite) TestGetSetGroupOffsets(c *C) {
+	e := New(s.cfg)
+	e.Produce("foo", nil, sarama.StringEncoder("v1"), nil)
+	e.Produce("foo", nil, sarama.StringEncoder("v2"), nil)
+	_, err := e.Consume("bar", "foo")
+	c.Assert(err, IsNil)
+
+	// When/Then
+	offsets, err := e.GetGroupOffsets("bar", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(offsets, DeepEquals, []admin.PartitionOffset{
+		{Partition: 0, Begin: 0, End: 2, Offset: 1}})
+
+	err = e.SetGroupOffsets("bar", "foo", []admin.PartitionOffset{
+		{Partition: 0, Offset: 0}})
+	c.Assert(err, IsNil)
+
+	consMsg, err := e.Consume("bar", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(consMsg.Value), Equals, "v1")
+}
+
+func (s *EmulatorSuite) TestUnknownTopic(c *C) {
+	e := New(s.cfg)
+
+	// When/Then
+	_, err := e.GetGroupOffsets("bar", "no-such-topic")
+	c.Assert(err, Equals, sarama.ErrUnknownTopicOrPartition)
+
+	_, err = e.GetTopicMetadata("no-such-topic")
+	c.Assert(err, Equals, sarama.ErrUnknownTopicOrPartition)
+}
@@ -0,0 +1,157 @@
+// Package features implements a process wide feature flag registry gating
+// optional behaviors, e.g. dead lettering, poison message skipping and
+// pipeline transforms. The startup state of the flags is defined in the
+// config file and can be changed at run time via the features API, so risky
+// features can be rolled out progressively per topic or per group.
+package features
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Names of the known feature flags.
+const (
+	// Produce messages that are given up on to the configured dead letter
+	// topic, see consumer.dead_letter_topic.
+	DeadLetter = "dead_letter"
+
+	// Skip messages that have been offered and expired max_retries times,
+	// see consumer.max_retries.
+	PoisonSkipping = "poison_skipping"
+
+	// Apply filter/transform hooks to messages bridged by pipelines, see
+	// pipeline.RegisterTransform. When disabled messages are bridged
+	// verbatim.
+	Transforms = "transforms"
+
+	// Freeze consumption: group members keep their registrations and
+	// partition assignments, but no new messages are offered and membership
+	// changes do not trigger rebalancing until the flag is cleared. Meant
+	// for broker maintenance windows where churn would be harmful, typically
+	// scoped to a group.
+	Frozen = "frozen"
+)
+
+// flagDefaults tells what the known flags default to when neither the config
+// file nor the features API overrides them. The behavior gating flags default
+// to enabled to keep the historical behavior of the respective settings,
+// while the freeze flag defaults to disabled, for it is a temporary
+// operational measure rather than a behavior switch.
+var flagDefaults = map[string]bool{
+	DeadLetter:     true,
+	PoisonSkipping: true,
+	Transforms:     true,
+	Frozen:         false,
+}
+
+type overrideKey struct {
+	flag  string
+	topic string
+	group string
+}
+
+var (
+	mu        sync.RWMutex
+	overrides = make(map[overrideKey]bool)
+)
+
+// IsValid tells whether a flag name is known.
+func IsValid(flag string) bool {
+	_, ok := flagDefaults[flag]
+	return ok
+}
+
+// Load sets flag overrides defined in the config file. Flag names must have
+// been validated by the caller, unknown ones are silently dropped.
+func Load(flags map[string]bool, flagsByTopic, flagsByGroup map[string]map[string]bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	for flag, enabled := range flags {
+		if IsValid(flag) {
+			overrides[overrideKey{flag: flag}] = enabled
+		}
+	}
+	for topic, flags := range flagsByTopic {
+		for flag, enabled := range flags {
+			if IsValid(flag) {
+				overrides[overrideKey{flag: flag, topic: topic}] = enabled
+			}
+		}
+	}
+	for group, flags := range flagsByGroup {
+		for flag, enabled := range flags {
+			if IsValid(flag) {
+				overrides[overrideKey{flag: flag, group: group}] = enabled
+			}
+		}
+	}
+}
+
+// Enabled tells whether a flag is in effect for the given group and topic.
+// The most specific override wins: topic overrides beat group overrides,
+// that beat the global flag state, that beats the compiled in default.
+func Enabled(flag, group, topic string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	if topic != "" {
+		if enabled, ok := overrides[overrideKey{flag: flag, topic: topic}]; ok {
+			return enabled
+		}
+	}
+	if group != "" {
+		if enabled, ok := overrides[overrideKey{flag: flag, group: group}]; ok {
+			return enabled
+		}
+	}
+	if enabled, ok := overrides[overrideKey{flag: flag}]; ok {
+		return enabled
+	}
+	return flagDefaults[flag]
+}
+
+// Set overrides a flag globally, or for a particular topic or group if one
+// of them is given. At most one of topic and group may be specified.
+func Set(flag, topic, group string, enabled bool) error {
+	if !IsValid(flag) {
+		return errors.Errorf("unknown feature flag: %s", flag)
+	}
+	if topic != "" && group != "" {
+		return errors.New("a feature flag override may be scoped to either a topic or a group, not both")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	overrides[overrideKey{flag: flag, topic: topic, group: group}] = enabled
+	return nil
+}
+
+// Override is a view of a single feature flag override.
+type Override struct {
+	Flag    string
+	Topic   string
+	Group   string
+	Enabled bool
+}
+
+// Snapshot returns the effective global state of all known flags along with
+// the list of per topic and per group overrides.
+func Snapshot() (map[string]bool, []Override) {
+	mu.RLock()
+	defer mu.RUnlock()
+	flags := make(map[string]bool, len(flagDefaults))
+	for flag, enabled := range flagDefaults {
+		if override, ok := overrides[overrideKey{flag: flag}]; ok {
+			enabled = override
+		}
+		flags[flag] = enabled
+	}
+	var scoped []Override
+	for key, enabled := range overrides {
+		if key.topic == "" && key.group == "" {
+			continue
+		}
+		scoped = append(scoped, Override{key.flag, key.topic, key.group, enabled})
+	}
+	return flags, scoped
+}
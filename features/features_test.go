@@ -0,0 +1,67 @@
+package features
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func reset() {
+	mu.Lock()
+	overrides = make(map[overrideKey]bool)
+	mu.Unlock()
+}
+
+func TestDefaults(t *testing.T) {
+	reset()
+	assert.True(t, Enabled(DeadLetter, "g", "t"))
+	assert.True(t, Enabled(PoisonSkipping, "g", "t"))
+	assert.True(t, Enabled(Transforms, "", ""))
+}
+
+// The most specific override wins: topic beats group, group beats global.
+func TestEnabledPrecedence(t *testing.T) {
+	reset()
+	assert.NoError(t, Set(PoisonSkipping, "", "", false))
+	assert.False(t, Enabled(PoisonSkipping, "g", "t"))
+
+	assert.NoError(t, Set(PoisonSkipping, "", "g", true))
+	assert.True(t, Enabled(PoisonSkipping, "g", "t"))
+	assert.False(t, Enabled(PoisonSkipping, "other", "t"))
+
+	assert.NoError(t, Set(PoisonSkipping, "t", "", false))
+	assert.False(t, Enabled(PoisonSkipping, "g", "t"))
+	assert.True(t, Enabled(PoisonSkipping, "g", "other"))
+}
+
+func TestSetErrors(t *testing.T) {
+	reset()
+	assert.Error(t, Set("bogus", "", "", true))
+	assert.Error(t, Set(DeadLetter, "t", "g", true))
+}
+
+func TestLoadAndSnapshot(t *testing.T) {
+	reset()
+	Load(
+		map[string]bool{DeadLetter: false, "bogus": true},
+		map[string]map[string]bool{"t": {Transforms: false}},
+		map[string]map[string]bool{"g": {Transforms: true}})
+
+	flags, scoped := Snapshot()
+	assert.Equal(t, map[string]bool{
+		DeadLetter:     false,
+		PoisonSkipping: true,
+		Transforms:     true,
+		Frozen:         false,
+	}, flags)
+	assert.ElementsMatch(t, []Override{
+		{Flag: Transforms, Topic: "t", Enabled: false},
+		{Flag: Transforms, Group: "g", Enabled: true},
+	}, scoped)
+
+	assert.False(t, Enabled(DeadLetter, "g", "t"))
+	assert.False(t, Enabled(Transforms, "g", "t"))
+	assert.True(t, Enabled(Transforms, "g", "other"))
+	// Unknown flags dropped by Load stay disabled.
+	assert.False(t, Enabled("bogus", "", ""))
+}
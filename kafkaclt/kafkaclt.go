@@ -0,0 +1,198 @@
+// Package kafkaclt decouples proxy wiring from a concrete Kafka client
+// library. A backend is selected via the `kafka.backend` config parameter and
+// provides the clients used by the producer, consumer, offset manager and
+// admin modules. The produce path is expressed in backend neutral terms: the
+// proxy and the API servers deal with Producer, Encoder and Header values
+// defined here and never see the client library types. The consumer, offset
+// management, group membership and admin machinery is still built directly on
+// sarama's client and broker APIs; NewClient exposes that client and is the
+// seam that remains to be neutralized before an alternative client library,
+// e.g. franz-go, can be plugged in.
+package kafkaclt
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/producer"
+	"github.com/pkg/errors"
+)
+
+// Backend names accepted by the `kafka.backend` config parameter.
+const (
+	BackendSarama = "sarama"
+)
+
+// T creates Kafka clients for the other modules. Implementations wrap a
+// concrete Kafka client library.
+type T interface {
+	// NewProducer creates the backend's implementation of the Producer
+	// contract, configured with the producer specific settings.
+	NewProducer(parentActDesc *actor.Descriptor) (Producer, error)
+
+	// NewClient creates a client suitable for the consumer machinery, offset
+	// management and admin operations. Those modules are built directly on
+	// sarama's client and broker APIs, so this is the remaining backend
+	// specific seam.
+	NewClient() (sarama.Client, error)
+}
+
+// Producer writes messages to Kafka on behalf of the proxy. It is the backend
+// neutral contract of the produce path.
+type Producer interface {
+	// Produce submits a message to the specified topic using key to identify
+	// a destination partition and blocks until the message is acknowledged.
+	// If key is nil the message is placed into a random partition. If
+	// partition is not -1 the message goes exactly there and the key is not
+	// used for placement.
+	Produce(topic string, partition int32, key, message Encoder, headers []Header) (*ProducedMsg, error)
+
+	// AsyncProduce is the asynchronous counterpart of Produce. The produce
+	// result is sent to the returned channel when the broker replies.
+	AsyncProduce(topic string, partition int32, key, message Encoder, headers []Header) <-chan ProduceRs
+
+	// Throttled reports whether produce admission control has engaged
+	// backpressure throttling.
+	Throttled() bool
+
+	// Stop shuts down the producer gracefully, allowing some time for the
+	// buffered messages to be committed to the Kafka cluster.
+	Stop()
+}
+
+// Encoder is a value that can be serialized to bytes for transmission to
+// Kafka in a produce request.
+type Encoder interface {
+	Encode() ([]byte, error)
+	Length() int
+}
+
+// StringEncoder implements Encoder for a string value.
+type StringEncoder string
+
+func (e StringEncoder) Encode() ([]byte, error) { return []byte(e), nil }
+func (e StringEncoder) Length() int             { return len(e) }
+
+// ByteEncoder implements Encoder for a byte slice value.
+type ByteEncoder []byte
+
+func (e ByteEncoder) Encode() ([]byte, error) { return e, nil }
+func (e ByteEncoder) Length() int             { return len(e) }
+
+// Header is a Kafka record header attached to a produced message.
+type Header struct {
+	Key   []byte
+	Value []byte
+}
+
+// ProducedMsg describes where a produced message ended up.
+type ProducedMsg struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	// Timestamp the broker assigned to the message, if the backend reports
+	// it, otherwise the zero value.
+	Timestamp time.Time
+}
+
+// ProduceRs is the outcome of an asynchronous produce request.
+type ProduceRs struct {
+	Msg *ProducedMsg
+	Err error
+}
+
+// New creates a client factory for the backend selected in the proxy config.
+func New(cfg *config.Proxy) (T, error) {
+	switch cfg.Kafka.Backend {
+	case "", BackendSarama:
+		return saramaBackend{cfg: cfg}, nil
+	}
+	return nil, errors.Errorf("unsupported kafka backend %q, only %q is available",
+		cfg.Kafka.Backend, BackendSarama)
+}
+
+// saramaBackend is the default backend implemented on top of Shopify/sarama.
+type saramaBackend struct {
+	cfg *config.Proxy
+}
+
+func (b saramaBackend) NewProducer(parentActDesc *actor.Descriptor) (Producer, error) {
+	p, err := producer.Spawn(parentActDesc, b.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return saramaProducer{p: p}, nil
+}
+
+func (b saramaBackend) NewClient() (sarama.Client, error) {
+	return sarama.NewClient(b.cfg.Kafka.SeedPeers, b.cfg.SaramaClientCfg())
+}
+
+// saramaProducer adapts the sarama based producer to the neutral Producer
+// contract, converting argument and result types on the boundary.
+type saramaProducer struct {
+	p *producer.T
+}
+
+func (sp saramaProducer) Produce(topic string, partition int32, key, message Encoder, headers []Header) (*ProducedMsg, error) {
+	msg, err := sp.p.Produce(topic, partition,
+		saramaEncoder(key), saramaEncoder(message), saramaHeaders(headers))
+	return producedMsg(msg), err
+}
+
+// AsyncProduce converts the produce result in a goroutine that lives only as
+// long as the produce request itself. The proxy already runs a goroutine per
+// API request, so this does not change the concurrency profile.
+func (sp saramaProducer) AsyncProduce(topic string, partition int32, key, message Encoder, headers []Header) <-chan ProduceRs {
+	saramaRsCh := sp.p.AsyncProduce(topic, partition,
+		saramaEncoder(key), saramaEncoder(message), saramaHeaders(headers))
+	rsCh := make(chan ProduceRs, 1)
+	go func() {
+		rs := <-saramaRsCh
+		rsCh <- ProduceRs{Msg: producedMsg(rs.Msg), Err: rs.Err}
+	}()
+	return rsCh
+}
+
+func (sp saramaProducer) Throttled() bool {
+	return sp.p.Throttled()
+}
+
+func (sp saramaProducer) Stop() {
+	sp.p.Stop()
+}
+
+// saramaEncoder converts an encoder to its sarama counterpart, so that the
+// producer internals see the concrete types they know how to render in dead
+// message records and logs.
+func saramaEncoder(e Encoder) sarama.Encoder {
+	switch e := e.(type) {
+	case nil:
+		return nil
+	case StringEncoder:
+		return sarama.StringEncoder(e)
+	case ByteEncoder:
+		return sarama.ByteEncoder(e)
+	}
+	return e
+}
+
+func saramaHeaders(headers []Header) []sarama.RecordHeader {
+	if headers == nil {
+		return nil
+	}
+	saramaHeaders := make([]sarama.RecordHeader, len(headers))
+	for i, h := range headers {
+		saramaHeaders[i] = sarama.RecordHeader{Key: h.Key, Value: h.Value}
+	}
+	return saramaHeaders
+}
+
+func producedMsg(msg *sarama.ProducerMessage) *ProducedMsg {
+	if msg == nil {
+		return nil
+	}
+	return &ProducedMsg{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset, Timestamp: msg.Timestamp}
+}
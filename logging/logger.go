@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Logger is a minimal structured logging interface that applications
+// embedding the service package can implement to route Kafka-Pixy logs
+// through their own logging stack, e.g. zap or slog. Implementations must be
+// safe for concurrent use.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+
+	// WithFields returns a logger that includes the passed fields in every
+	// log record it emits.
+	WithFields(fields map[string]interface{}) Logger
+}
+
+// SetLogger routes all Kafka-Pixy log records to the passed logger and stops
+// writing them to the standard output. It is intended to be called by
+// embedders instead of Init. Internally records are still produced via
+// sirupsen/logrus, so the logger is installed as a forwarding hook.
+func SetLogger(logger Logger) {
+	log.StandardLogger().Out = ioutil.Discard
+	log.AddHook(forwardingHook{logger})
+}
+
+// NewLogrusAdapter wraps a sirupsen/logrus logger in the Logger interface.
+func NewLogrusAdapter(logger log.FieldLogger) Logger {
+	return logrusAdapter{logger}
+}
+
+type logrusAdapter struct {
+	logger log.FieldLogger
+}
+
+func (a logrusAdapter) Debugf(format string, args ...interface{}) {
+	a.logger.Debugf(format, args...)
+}
+
+func (a logrusAdapter) Infof(format string, args ...interface{}) {
+	a.logger.Infof(format, args...)
+}
+
+func (a logrusAdapter) Warnf(format string, args ...interface{}) {
+	a.logger.Warnf(format, args...)
+}
+
+func (a logrusAdapter) Errorf(format string, args ...interface{}) {
+	a.logger.Errorf(format, args...)
+}
+
+func (a logrusAdapter) WithFields(fields map[string]interface{}) Logger {
+	return logrusAdapter{a.logger.WithFields(log.Fields(fields))}
+}
+
+// forwardingHook is a sirupsen/logrus hook that forwards log records to a
+// Logger implementation.
+type forwardingHook struct {
+	logger Logger
+}
+
+func (h forwardingHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h forwardingHook) Fire(entry *log.Entry) error {
+	logger := h.logger
+	if len(entry.Data) > 0 {
+		logger = logger.WithFields(entry.Data)
+	}
+	switch entry.Level {
+	case log.DebugLevel, log.TraceLevel:
+		logger.Debugf("%s", entry.Message)
+	case log.InfoLevel:
+		logger.Infof("%s", entry.Message)
+	case log.WarnLevel:
+		logger.Warnf("%s", entry.Message)
+	default:
+		logger.Errorf("%s", entry.Message)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"fmt"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type capturingLogger struct {
+	fields  map[string]interface{}
+	records *[]string
+}
+
+func (l capturingLogger) Debugf(format string, args ...interface{}) { l.record("debug", format, args) }
+func (l capturingLogger) Infof(format string, args ...interface{})  { l.record("info", format, args) }
+func (l capturingLogger) Warnf(format string, args ...interface{})  { l.record("warn", format, args) }
+func (l capturingLogger) Errorf(format string, args ...interface{}) { l.record("error", format, args) }
+
+func (l capturingLogger) WithFields(fields map[string]interface{}) Logger {
+	return capturingLogger{fields: fields, records: l.records}
+}
+
+func (l capturingLogger) record(level, format string, args []interface{}) {
+	*l.records = append(*l.records,
+		fmt.Sprintf("%s: %s %v", level, fmt.Sprintf(format, args...), l.fields))
+}
+
+func TestForwardingHook(t *testing.T) {
+	var records []string
+	hook := forwardingHook{capturingLogger{records: &records}}
+
+	logger := log.New()
+	logger.Level = log.DebugLevel
+	entry := logger.WithField("foo", "bar")
+
+	for level, expected := range map[log.Level]string{
+		log.DebugLevel: "debug: boom map[foo:bar]",
+		log.InfoLevel:  "info: boom map[foo:bar]",
+		log.WarnLevel:  "warn: boom map[foo:bar]",
+		log.ErrorLevel: "error: boom map[foo:bar]",
+	} {
+		records = records[:0]
+		entry.Level = level
+		entry.Message = "boom"
+		assert.NoError(t, hook.Fire(entry))
+		assert.Equal(t, []string{expected}, records)
+	}
+}
@@ -81,6 +81,14 @@ func Init(jsonCfg string, cfg *config.App) error {
 		}
 	}
 
+	if cfg != nil && cfg.ErrorReporting.SentryDSN != "" {
+		h, err := newSentryHook(cfg.ErrorReporting.SentryDSN)
+		if err != nil {
+			return errors.Wrap(err, "failed to configure error reporting")
+		}
+		hooks = append(hooks, h)
+	}
+
 	// samuel/go-zookeeper/zk is using the standard logger.
 	zk.DefaultLogger = log.WithField("category", "zk")
 
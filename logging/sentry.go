@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Number of events that can be buffered before new ones are dropped.
+	sentryQueueSize = 64
+
+	sentrySendTimeout = 5 * time.Second
+)
+
+// sentryHook is a sirupsen/logrus hook that forwards error, fatal and panic
+// level log records to a Sentry-compatible endpoint. Events are sent
+// asynchronously and are dropped if the send queue is full, for error
+// reporting must never block or break the proxy itself.
+type sentryHook struct {
+	storeURL string
+	authHdr  string
+	httpClt  *http.Client
+	eventCh  chan *sentryEvent
+}
+
+type sentryEvent struct {
+	EventID    string                 `json:"event_id,omitempty"`
+	Message    string                 `json:"message"`
+	Timestamp  string                 `json:"timestamp"`
+	Level      string                 `json:"level"`
+	Logger     string                 `json:"logger"`
+	Platform   string                 `json:"platform"`
+	ServerName string                 `json:"server_name,omitempty"`
+	Extra      map[string]interface{} `json:"extra,omitempty"`
+}
+
+// newSentryHook creates a hook that reports to the specified Sentry DSN, that
+// is a URL of the form `https://<key>@<host>/<project-id>`.
+func newSentryHook(dsn string) (*sentryHook, error) {
+	dsnURL, err := url.Parse(dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad DSN")
+	}
+	if dsnURL.User == nil || dsnURL.User.Username() == "" {
+		return nil, errors.New("bad DSN, key is missing")
+	}
+	projectID := ""
+	if len(dsnURL.Path) > 1 {
+		projectID = dsnURL.Path[1:]
+	}
+	if projectID == "" {
+		return nil, errors.New("bad DSN, project id is missing")
+	}
+	h := sentryHook{
+		storeURL: fmt.Sprintf("%s://%s/api/%s/store/", dsnURL.Scheme, dsnURL.Host, projectID),
+		authHdr: fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=kafka-pixy/1.0",
+			dsnURL.User.Username()),
+		httpClt: &http.Client{Timeout: sentrySendTimeout},
+		eventCh: make(chan *sentryEvent, sentryQueueSize),
+	}
+	go h.runSender()
+	return &h, nil
+}
+
+func (h *sentryHook) Levels() []log.Level {
+	return []log.Level{log.ErrorLevel, log.FatalLevel, log.PanicLevel}
+}
+
+func (h *sentryHook) Fire(entry *log.Entry) error {
+	event := sentryEvent{
+		Message:   entry.Message,
+		Timestamp: entry.Time.UTC().Format("2006-01-02T15:04:05"),
+		Level:     sentryLevel(entry.Level),
+		Logger:    "kafka-pixy",
+		Platform:  "go",
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		event.ServerName = hostname
+	}
+	if len(entry.Data) > 0 {
+		event.Extra = make(map[string]interface{}, len(entry.Data))
+		for k, v := range entry.Data {
+			event.Extra[k] = fmt.Sprint(v)
+		}
+	}
+	select {
+	case h.eventCh <- &event:
+	default:
+		// The queue is full, drop the event.
+	}
+	return nil
+}
+
+func (h *sentryHook) runSender() {
+	for event := range h.eventCh {
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		req, err := http.NewRequest("POST", h.storeURL, bytes.NewReader(encoded))
+		if err != nil {
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Sentry-Auth", h.authHdr)
+		rs, err := h.httpClt.Do(req)
+		if err != nil {
+			continue
+		}
+		rs.Body.Close()
+	}
+}
+
+func sentryLevel(level log.Level) string {
+	switch level {
+	case log.PanicLevel, log.FatalLevel:
+		return "fatal"
+	default:
+		return "error"
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"runtime"
 	"strings"
 	"syscall"
 
@@ -13,6 +15,7 @@ import (
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/logging"
 	"github.com/mailgun/kafka-pixy/service"
+	"github.com/mailgun/kafka-pixy/version"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -30,6 +33,7 @@ var (
 	cmdClientCertFile    string
 	cmdClientCertKeyFile string
 	cmdInsecure          bool
+	cmdEmulate           bool
 )
 
 func init() {
@@ -46,6 +50,7 @@ func init() {
 	flag.StringVar(&cmdClientCertFile, "clientCertFile", "", "Client certificate file")
 	flag.StringVar(&cmdClientCertKeyFile, "clientCertKeyFile", "", "Client certificate key file")
 	flag.BoolVar(&cmdInsecure, "insecureTLS", false, "Disable TLS hostname verification")
+	flag.BoolVar(&cmdEmulate, "emulate", false, "Serve the API from an in-process, in-memory topic store instead of a real Kafka cluster")
 	flag.Parse()
 }
 
@@ -61,6 +66,9 @@ func main() {
 		os.Exit(1)
 	}
 
+	log.Infof("Starting Kafka-Pixy, version=%s, git=%s, go=%s",
+		version.Version, version.GitCommit, runtime.Version())
+
 	if cmdPIDFile != "" {
 		if err := writePID(cmdPIDFile); err != nil {
 			log.Errorf("Failed to write PID file: err=(%s)", err)
@@ -78,7 +86,7 @@ func main() {
 	}
 
 	log.Infof("Starting with config: %+v", cfg)
-	svc, err := service.Spawn(cfg)
+	svc, err := service.Spawn(context.Background(), cfg)
 	if err != nil {
 		log.Errorf("Failed to start service: err=(%s)", err)
 		os.Exit(1)
@@ -90,7 +98,17 @@ func main() {
 
 	// Wait for a quit signal and terminate the service when it is received.
 	<-osSigCh
-	svc.Stop()
+	if cfg.ShutdownTimeout <= 0 {
+		svc.Stop()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := svc.Shutdown(ctx); err != nil {
+		log.Errorf("Graceful shutdown did not complete in %v, terminating anyway",
+			cfg.ShutdownTimeout)
+		os.Exit(1)
+	}
 }
 
 func makeConfig() (*config.App, error) {
@@ -133,6 +151,11 @@ func makeConfig() (*config.App, error) {
 	if cmdInsecure {
 		cfg.Proxies[cfg.DefaultCluster].Kafka.InsecureSkipVerify = cmdInsecure
 	}
+	if cmdEmulate {
+		for _, proxyCfg := range cfg.Proxies {
+			proxyCfg.Emulator = true
+		}
+	}
 	if cmdZookeeperPeers != "" {
 		chrootStartIdx := strings.Index(cmdZookeeperPeers, "/")
 		if chrootStartIdx >= 0 {
@@ -0,0 +1,106 @@
+// Package metrics provides process wide counters for operational events. It
+// deliberately avoids a dependency on an external metrics system: counters
+// are plain named integers that API frontends expose over `GET /_metrics`,
+// and operators can scrape or forward them however they see fit.
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	mu        sync.Mutex
+	counters  = make(map[string]int64)
+	gauges    = make(map[string]int64)
+	durations = make(map[string]*DurationStats)
+)
+
+// DurationStats is a summary of the duration observations made for a name.
+type DurationStats struct {
+	Count int64
+	Sum   time.Duration
+	Min   time.Duration
+	Max   time.Duration
+}
+
+// Inc increments the named counter by 1, creating it if it does not exist.
+func Inc(name string) {
+	Add(name, 1)
+}
+
+// Add increments the named counter by delta, creating it if it does not exist.
+func Add(name string, delta int64) {
+	mu.Lock()
+	counters[name] += delta
+	mu.Unlock()
+}
+
+// Set sets the named gauge to the specified value, creating it if it does not
+// exist.
+func Set(name string, value int64) {
+	mu.Lock()
+	gauges[name] = value
+	mu.Unlock()
+}
+
+// Unset removes the named gauge. It should be called when the entity the
+// gauge describes is gone, so that stale values are not reported forever.
+func Unset(name string) {
+	mu.Lock()
+	delete(gauges, name)
+	mu.Unlock()
+}
+
+// ObserveDuration adds a duration observation to the named summary, creating
+// it if it does not exist.
+func ObserveDuration(name string, d time.Duration) {
+	mu.Lock()
+	ds := durations[name]
+	if ds == nil {
+		ds = &DurationStats{Min: d, Max: d}
+		durations[name] = ds
+	}
+	ds.Count += 1
+	ds.Sum += d
+	if d < ds.Min {
+		ds.Min = d
+	}
+	if d > ds.Max {
+		ds.Max = d
+	}
+	mu.Unlock()
+}
+
+// Snapshot returns a copy of all counters.
+func Snapshot() map[string]int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	snapshot := make(map[string]int64, len(counters))
+	for name, value := range counters {
+		snapshot[name] = value
+	}
+	return snapshot
+}
+
+// GaugeSnapshot returns a copy of all gauges.
+func GaugeSnapshot() map[string]int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	snapshot := make(map[string]int64, len(gauges))
+	for name, value := range gauges {
+		snapshot[name] = value
+	}
+	return snapshot
+}
+
+// DurationSnapshot returns a copy of all duration summaries.
+func DurationSnapshot() map[string]DurationStats {
+	mu.Lock()
+	defer mu.Unlock()
+	snapshot := make(map[string]DurationStats, len(durations))
+	for name, ds := range durations {
+		snapshot[name] = *ds
+	}
+	return snapshot
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounters(t *testing.T) {
+	Inc("foo")
+	Inc("foo")
+	Add("bar", 3)
+
+	snapshot := Snapshot()
+
+	assert.Equal(t, int64(2), snapshot["foo"])
+	assert.Equal(t, int64(3), snapshot["bar"])
+
+	// The snapshot is a copy and does not reflect later changes.
+	Inc("foo")
+	assert.Equal(t, int64(2), snapshot["foo"])
+}
+
+func TestDurations(t *testing.T) {
+	ObserveDuration("rtt", 3*time.Second)
+	ObserveDuration("rtt", time.Second)
+	ObserveDuration("rtt", 2*time.Second)
+
+	ds := DurationSnapshot()["rtt"]
+
+	assert.Equal(t, int64(3), ds.Count)
+	assert.Equal(t, 6*time.Second, ds.Sum)
+	assert.Equal(t, time.Second, ds.Min)
+	assert.Equal(t, 3*time.Second, ds.Max)
+}
+
+func TestTraffic(t *testing.T) {
+	ObserveTraffic("test.traffic.foo", 100)
+	ObserveTraffic("test.traffic.foo", 50)
+
+	snapshot := TrafficSnapshot()
+
+	ts := snapshot["test.traffic.foo"]
+	assert.Equal(t, int64(2), ts.Messages1m)
+	assert.Equal(t, int64(150), ts.Bytes1m)
+	assert.Equal(t, int64(2), ts.Messages5m)
+	assert.Equal(t, int64(150), ts.Bytes5m)
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import "time"
+
+// Length of the traffic sliding window in seconds. Observations older than
+// this are discarded, so 5 minutes is the longest period rates can be
+// reported over.
+const trafficWindowLen = 300
+
+var traffic = make(map[string]*trafficWindow)
+
+// trafficWindow is a ring of per-second buckets. A bucket is lazily reset
+// when it is written to for a new second, so no background ticker is needed.
+type trafficWindow struct {
+	buckets [trafficWindowLen]trafficBucket
+}
+
+type trafficBucket struct {
+	sec   int64
+	msgs  int64
+	bytes int64
+}
+
+// TrafficStats is a summary of the traffic observed for a name over sliding
+// windows of the last 1 and 5 minutes.
+type TrafficStats struct {
+	Messages1m int64
+	Bytes1m    int64
+	Messages5m int64
+	Bytes5m    int64
+}
+
+// ObserveTraffic records that a message of the specified size passed through
+// the point identified by name.
+func ObserveTraffic(name string, bytes int64) {
+	now := time.Now().Unix()
+	mu.Lock()
+	tw := traffic[name]
+	if tw == nil {
+		tw = &trafficWindow{}
+		traffic[name] = tw
+	}
+	b := &tw.buckets[now%trafficWindowLen]
+	if b.sec != now {
+		b.sec, b.msgs, b.bytes = now, 0, 0
+	}
+	b.msgs += 1
+	b.bytes += bytes
+	mu.Unlock()
+}
+
+// TrafficSnapshot returns the traffic observed for every name over the last
+// 1 and 5 minutes.
+func TrafficSnapshot() map[string]TrafficStats {
+	now := time.Now().Unix()
+	mu.Lock()
+	defer mu.Unlock()
+	snapshot := make(map[string]TrafficStats, len(traffic))
+	for name, tw := range traffic {
+		var ts TrafficStats
+		for i := range tw.buckets {
+			b := &tw.buckets[i]
+			age := now - b.sec
+			if age < 0 || age >= trafficWindowLen {
+				continue
+			}
+			if age < 60 {
+				ts.Messages1m += b.msgs
+				ts.Bytes1m += b.bytes
+			}
+			ts.Messages5m += b.msgs
+			ts.Bytes5m += b.bytes
+		}
+		snapshot[name] = ts
+	}
+	return snapshot
+}
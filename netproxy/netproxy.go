@@ -0,0 +1,96 @@
+// Package netproxy builds dialers that establish TCP connections through an
+// egress proxy. It is used for connections to Kafka brokers and ZooKeeper in
+// locked-down environments where Kafka-Pixy runs in a DMZ and can only reach
+// the cluster through a tunnel.
+package netproxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+)
+
+// Dialer returns a dialer that establishes TCP connections through the proxy
+// given as a URL: "socks5://[user:password@]host:port" for a SOCKS5 proxy or
+// "http://[user:password@]host:port" for an HTTP proxy speaking the CONNECT
+// method. The timeout applies to the connection to the proxy itself.
+func Dialer(proxyURL string, timeout time.Duration) (proxy.Dialer, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "bad proxy URL %q", proxyURL)
+	}
+	if u.Host == "" {
+		return nil, errors.Errorf("bad proxy URL %q: missing host", proxyURL)
+	}
+	forward := &net.Dialer{Timeout: timeout}
+	switch u.Scheme {
+	case "socks5":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		return proxy.SOCKS5("tcp", u.Host, auth, forward)
+	case "http":
+		d := &httpConnectDialer{addr: u.Host, forward: forward}
+		if u.User != nil {
+			password, _ := u.User.Password()
+			credentials := u.User.Username() + ":" + password
+			d.authHeader = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+		}
+		return d, nil
+	}
+	return nil, errors.Errorf("bad proxy URL %q: unsupported scheme %q", proxyURL, u.Scheme)
+}
+
+// httpConnectDialer tunnels connections through an HTTP proxy with the
+// CONNECT method.
+type httpConnectDialer struct {
+	addr       string
+	authHeader string
+	forward    *net.Dialer
+}
+
+// implements `proxy.Dialer`
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, errors.Errorf("unsupported network %q", network)
+	}
+	conn, err := d.forward.Dial("tcp", d.addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to proxy %s", d.addr)
+	}
+	rq := http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if d.authHeader != "" {
+		rq.Header.Set("Proxy-Authorization", d.authHeader)
+	}
+	if err := rq.Write(conn); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to send CONNECT")
+	}
+	// The buffered reader cannot swallow tunneled bytes here because both
+	// the Kafka and the ZooKeeper protocols have the client speak first, so
+	// the server has nothing to say until after the handshake below.
+	rs, err := http.ReadResponse(bufio.NewReader(conn), &rq)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to read CONNECT response")
+	}
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errors.Errorf("proxy refused CONNECT to %s: %s", addr, rs.Status)
+	}
+	return conn, nil
+}
@@ -0,0 +1,63 @@
+package offsetmgr
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+)
+
+// auditor appends a JSON record to a file for every offset commit performed,
+// so that "who moved this group's offsets" can be reconstructed during
+// incident reviews. It is shared by all offset managers of a factory and is
+// disabled when no audit file is configured.
+type auditor struct {
+	mu   sync.Mutex
+	path string
+}
+
+// auditRecord describes a single offset commit.
+type auditRecord struct {
+	Time      string `json:"time"`
+	Group     string `json:"group"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	OldOffset int64  `json:"old_offset"`
+	NewOffset int64  `json:"new_offset"`
+	Trigger   string `json:"trigger"`
+}
+
+// record appends a commit record to the audit file. Failures to write are
+// only logged, an audit problem must never get in the way of committing
+// offsets.
+func (a *auditor) record(actDesc *actor.Descriptor, id instanceID, oldOffset, newOffset int64, trigger string) {
+	if a.path == "" {
+		return
+	}
+	encoded, err := json.Marshal(auditRecord{
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		Group:     id.group,
+		Topic:     id.topic,
+		Partition: id.partition,
+		OldOffset: oldOffset,
+		NewOffset: newOffset,
+		Trigger:   trigger,
+	})
+	if err != nil {
+		actDesc.Log().WithError(err).Error("Failed to encode commit audit record")
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		actDesc.Log().WithError(err).Error("Failed to open commit audit file")
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(encoded, '\n')); err != nil {
+		actDesc.Log().WithError(err).Error("Failed to write commit audit record")
+	}
+}
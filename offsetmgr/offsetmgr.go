@@ -8,6 +8,7 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
 	"github.com/mailgun/kafka-pixy/mapper"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/pkg/errors"
@@ -88,10 +89,12 @@ type Offset struct {
 // SpawnFactory creates a new offset manager factory from the given client.
 func SpawnFactory(parentActDesc *actor.Descriptor, cfg *config.Proxy, kafkaClt sarama.Client) Factory {
 	f := &factory{
-		actDesc:  parentActDesc.NewChild("offset_mgr_f"),
-		kafkaClt: kafkaClt,
-		cfg:      cfg,
-		children: make(map[instanceID]*offsetMgr),
+		actDesc:          parentActDesc.NewChild("offset_mgr_f"),
+		kafkaClt:         kafkaClt,
+		cfg:              cfg,
+		children:         make(map[instanceID]*offsetMgr),
+		coordRefreshedAt: make(map[string]time.Time),
+		auditor:          &auditor{path: cfg.Consumer.OffsetCommitAuditFile},
 	}
 	f.mapper = mapper.Spawn(f.actDesc, cfg, f)
 	return f
@@ -107,8 +110,19 @@ type factory struct {
 
 	childrenMu sync.Mutex
 	children   map[instanceID]*offsetMgr
+
+	coordMu          sync.Mutex
+	coordRefreshedAt map[string]time.Time
+
+	auditor *auditor
 }
 
+// Commit triggers recorded in the audit trail.
+const (
+	commitTriggerPeriodic = "periodic"
+	commitTriggerShutdown = "shutdown"
+)
+
 type instanceID struct {
 	group     string
 	topic     string
@@ -151,8 +165,15 @@ func (f *factory) Spawn(namespace *actor.Descriptor, group, topic string, partit
 // implements `mapper.Resolver`.
 func (f *factory) ResolveBroker(worker mapper.Worker) (*sarama.Broker, error) {
 	om := worker.(*offsetMgr)
-	if err := f.kafkaClt.RefreshCoordinator(om.id.group); err != nil {
-		return nil, err
+	// All offset managers of a group share the same coordinator, so when a
+	// group with hundreds of partitions is (re)assigned, forcing a metadata
+	// round trip for every one of them is a waste. One refresh per retry
+	// backoff interval is enough to pick up a coordinator migration, the rest
+	// resolve from the client cache.
+	if f.shouldRefreshCoordinator(om.id.group) {
+		if err := f.kafkaClt.RefreshCoordinator(om.id.group); err != nil {
+			return nil, err
+		}
 	}
 
 	brokerConn, err := f.kafkaClt.Coordinator(om.id.group)
@@ -162,6 +183,19 @@ func (f *factory) ResolveBroker(worker mapper.Worker) (*sarama.Broker, error) {
 	return brokerConn, nil
 }
 
+// shouldRefreshCoordinator tells whether a coordinator refresh should be
+// forced for the group and records the refresh time if it should.
+func (f *factory) shouldRefreshCoordinator(group string) bool {
+	f.coordMu.Lock()
+	defer f.coordMu.Unlock()
+	now := time.Now()
+	if now.Sub(f.coordRefreshedAt[group]) < f.cfg.Consumer.RetryBackoff {
+		return false
+	}
+	f.coordRefreshedAt[group] = now
+	return true
+}
+
 // implements `mapper.Resolver`.
 func (f *factory) SpawnExecutor(brokerConn *sarama.Broker) mapper.Executor {
 	be := &brokerExecutor{
@@ -345,9 +379,17 @@ handleRequests:
 			}
 		case rs := <-responseCh:
 			if err := om.getCommitError(rs); err != nil {
+				groupevents.Record(om.id.group, groupevents.CommitFailure,
+					"topic=%s, partition=%d: %v", om.id.topic, om.id.partition, err)
 				om.triggerReassign(err, "Request failed")
 				continue
 			}
+			trigger := commitTriggerPeriodic
+			if stopping {
+				trigger = commitTriggerShutdown
+			}
+			om.f.auditor.record(om.actDesc, om.id,
+				committedOffset.Val, rs.offset.Val, trigger)
 			committedOffset = rs.offset
 			om.committedOffsetsCh <- committedOffset
 			if stopping && latestRq.offset == committedOffset {
@@ -446,7 +488,9 @@ type submitRs struct {
 }
 
 // brokerExecutor aggregates submitted offsets from partition offset managers
-// and periodically commits them to Kafka.
+// and periodically commits them to Kafka. Offsets submitted between commit
+// ticks are coalesced into a single OffsetCommit request per group, so the
+// commit rate seen by a coordinator does not grow with the partition count.
 //
 // implements `mapper.Executor`.
 type brokerExecutor struct {
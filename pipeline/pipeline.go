@@ -0,0 +1,195 @@
+// Package pipeline implements server-side consume→produce bridges. A
+// pipeline consumes messages from one topic on behalf of a consumer group,
+// optionally filters or transforms them via a hook registered by the
+// embedding application, and produces the result to another topic. Offsets
+// of the source topic are committed only after the message has been
+// successfully produced to the destination topic, so a crash never loses
+// messages, it can only duplicate them.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/features"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/pkg/errors"
+)
+
+const (
+	// How long to wait before retrying after a consume or produce error.
+	retryInterval = 3 * time.Second
+
+	// How long a produce to the destination topic is allowed to take.
+	produceTimeout = 30 * time.Second
+)
+
+// TransformFn inspects a consumed message and returns the key and value to
+// produce to the destination topic. If ok is false then the message is
+// filtered out: it is acknowledged in the source topic but nothing is
+// produced. Implementations must be safe for concurrent use.
+type TransformFn func(msg consumer.Message) (key, value []byte, ok bool)
+
+var (
+	transformsMu sync.Mutex
+	transforms   = make(map[string]TransformFn)
+)
+
+// RegisterTransform makes a filter/transform hook available to pipelines
+// under the given name. Embedding applications are expected to call it before
+// the service is spawned. It panics if the name has already been taken.
+func RegisterTransform(name string, fn TransformFn) {
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	if _, ok := transforms[name]; ok {
+		panic(fmt.Sprintf("transform already registered: %s", name))
+	}
+	transforms[name] = fn
+}
+
+// T is a running consume→produce bridge pipeline.
+type T struct {
+	actDesc   *actor.Descriptor
+	cfg       config.PipelineCfg
+	pxy       *proxy.T
+	transform TransformFn
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// Spawn creates a pipeline instance bridging messages via the specified proxy
+// and starts its goroutine.
+func Spawn(parentActDesc *actor.Descriptor, cfg config.PipelineCfg, pxy *proxy.T) (*T, error) {
+	var transform TransformFn
+	if cfg.Transform != "" {
+		transformsMu.Lock()
+		transform = transforms[cfg.Transform]
+		transformsMu.Unlock()
+		if transform == nil {
+			return nil, errors.Errorf("unknown transform: %s", cfg.Transform)
+		}
+	}
+	p := &T{
+		actDesc:   parentActDesc.NewChild(fmt.Sprintf("pipeline_%s", cfg.Name)),
+		cfg:       cfg,
+		pxy:       pxy,
+		transform: transform,
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	actor.Spawn(p.actDesc, &p.wg, p.run)
+	return p, nil
+}
+
+// Stop signals the pipeline to stop and blocks until it does.
+func (p *T) Stop() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+func (p *T) run() {
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		default:
+		}
+		msg, err := p.pxy.Consume(p.ctx, p.cfg.Group, p.cfg.FromTopic, proxy.NoAck())
+		if err != nil {
+			switch errors.Cause(err) {
+			case consumer.ErrRequestTimeout:
+				// No messages at the moment, keep polling.
+			case proxy.ErrUnavailable, proxy.ErrDraining, context.Canceled:
+				return
+			default:
+				p.actDesc.Log().WithError(err).Error("Failed to consume")
+				metrics.Inc("pipeline.errors")
+				p.sleep(retryInterval)
+			}
+			continue
+		}
+		key, value := msg.Key, msg.Value
+		if p.transform != nil && features.Enabled(features.Transforms, p.cfg.Group, p.cfg.FromTopic) {
+			var ok bool
+			key, value, ok = p.transform(msg)
+			if !ok {
+				// Filtered out messages count as handled.
+				metrics.Inc("pipeline.filtered")
+				p.ack(msg)
+				continue
+			}
+		}
+		if !p.produce(key, value, msg.Headers) {
+			// Do not acknowledge the message, so that it is re-offered by the
+			// offset tracker and the produce is attempted again.
+			continue
+		}
+		metrics.Inc("pipeline.bridged")
+		p.ack(msg)
+	}
+}
+
+// produce synchronously produces a message to the destination topic and
+// reports whether it succeeded. Headers are forwarded when the Kafka version
+// supports them.
+func (p *T) produce(key, value []byte, headers []*sarama.RecordHeader) bool {
+	var fwdHeaders []kafkaclt.Header
+	if p.pxy.Cfg().Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		for _, h := range headers {
+			fwdHeaders = append(fwdHeaders, kafkaclt.Header{Key: h.Key, Value: h.Value})
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), produceTimeout)
+	defer cancel()
+	_, err := p.pxy.Produce(ctx, p.cfg.ToTopic, -1,
+		toEncoderPreservingNil(key), kafkaclt.ByteEncoder(value), fwdHeaders)
+	if err != nil {
+		p.actDesc.Log().WithError(err).Errorf(
+			"Failed to produce: topic=%s", p.cfg.ToTopic)
+		metrics.Inc("pipeline.errors")
+		p.sleep(retryInterval)
+		return false
+	}
+	return true
+}
+
+// ack commits the message offset in the source topic. Ack failures are only
+// logged, for the worst that can happen is that the message is bridged again.
+func (p *T) ack(msg consumer.Message) {
+	ack, err := proxy.NewAck(msg.Partition, msg.Offset)
+	if err != nil {
+		p.actDesc.Log().WithError(err).Error("Failed to create ack")
+		return
+	}
+	if err := p.pxy.Ack(p.cfg.Group, p.cfg.FromTopic, ack); err != nil {
+		p.actDesc.Log().WithError(err).Errorf(
+			"Failed to ack: partition=%d, offset=%d", msg.Partition, msg.Offset)
+	}
+}
+
+// sleep blocks for the specified duration, but returns early if the pipeline
+// is signalled to stop.
+func (p *T) sleep(d time.Duration) {
+	select {
+	case <-p.ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// toEncoderPreservingNil converts a slice of bytes to kafkaclt.Encoder
+// keeping nil values as nil, so that the partitioner treats the key as
+// absent.
+func toEncoderPreservingNil(b []byte) kafkaclt.Encoder {
+	if b != nil {
+		return kafkaclt.ByteEncoder(b)
+	}
+	return nil
+}
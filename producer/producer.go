@@ -1,13 +1,17 @@
 package producer
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/pkg/errors"
 )
 
@@ -20,19 +24,29 @@ const (
 // messages as soon as it is ordered to shutdown. On the contrary, when `T` is
 // ordered to stop it allows some time for the buffered messages to be
 // committed to the Kafka cluster, and only when that time has elapsed it drops
-// uncommitted messages.
-//
-// TODO Consider implementing some sort of dead message processing.
+// uncommitted messages. If producer.dead_message_file is configured, then
+// dropped messages are appended to that file along with the error, so that
+// they can be inspected and replayed.
 type T struct {
 	mergActDesc     *actor.Descriptor
 	dispActDesc     *actor.Descriptor
 	saramaClient    sarama.Client
 	saramaProducer  sarama.AsyncProducer
 	shutdownTimeout time.Duration
+	hedgeTimeout    time.Duration
 	dispatcherCh    chan *sarama.ProducerMessage
 	responseCh      chan Response
+	deadMessageFile string
+	inFlight        map[chan Response]*hedgeState
+	hedgeWg         sync.WaitGroup
 	wg              sync.WaitGroup
 
+	// Produce admission control state, see Throttled.
+	pendingCount    int64
+	throttleEngaged int32
+	throttleHigh    int64
+	throttleLow     int64
+
 	// To be used in tests only
 	testDroppedMsgCh chan<- *sarama.ProducerMessage
 }
@@ -47,7 +61,6 @@ func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy) (*T, error) {
 	saramaCfg := cfg.SaramaProducerCfg()
 	saramaCfg.Producer.Return.Successes = true
 	saramaCfg.Producer.Return.Errors = true
-
 	saramaClient, err := sarama.NewClient(cfg.Kafka.SeedPeers, saramaCfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create sarama.Client")
@@ -63,8 +76,16 @@ func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy) (*T, error) {
 		saramaClient:    saramaClient,
 		saramaProducer:  saramaProducer,
 		shutdownTimeout: cfg.Producer.ShutdownTimeout,
+		hedgeTimeout:    cfg.Producer.HedgeTimeout,
 		dispatcherCh:    make(chan *sarama.ProducerMessage, cfg.Producer.ChannelBufferSize),
 		responseCh:      make(chan Response, cfg.Producer.ChannelBufferSize),
+		deadMessageFile: cfg.Producer.DeadMessageFile,
+		inFlight:        make(map[chan Response]*hedgeState),
+		throttleHigh:    int64(cfg.Producer.ThrottleHighWatermark),
+		throttleLow:     int64(cfg.Producer.ThrottleLowWatermark),
+	}
+	if p.throttleHigh > 0 && p.throttleLow == 0 {
+		p.throttleLow = p.throttleHigh / 2
 	}
 	actor.Spawn(p.mergActDesc, &p.wg, p.runMerger)
 	actor.Spawn(p.dispActDesc, &p.wg, p.runDispatcher)
@@ -75,32 +96,36 @@ func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy) (*T, error) {
 func (p *T) Stop() {
 	close(p.dispatcherCh)
 	p.wg.Wait()
+	p.saramaClient.Close()
 }
 
 // Produce submits a message to the specified `topic` of the Kafka cluster
 // using `key` to identify a destination partition. The exact algorithm used to
 // map keys to partitions is implementation specific but it is guaranteed that
 // it returns consistent results. If `key` is `nil`, then the message is placed
-// into a random partition.
+// into a random partition. If `partition` is not -1, then the message goes
+// exactly there and the key is not used for placement.
 //
 // Errors usually indicate a catastrophic failure of the Kafka cluster, or
 // missing topic if there cluster is not configured to auto create topics.
-func (p *T) Produce(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader) (*sarama.ProducerMessage, error) {
-	rs := <-p.AsyncProduce(topic, key, message, headers)
+func (p *T) Produce(topic string, partition int32, key, message sarama.Encoder, headers []sarama.RecordHeader) (*sarama.ProducerMessage, error) {
+	rs := <-p.AsyncProduce(topic, partition, key, message, headers)
 	return rs.Msg, rs.Err
 }
 
 // AsyncProduce is an asynchronously counterpart of the `Produce` function.
 // Errors are silently ignored.
-func (p *T) AsyncProduce(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader) <-chan Response {
+func (p *T) AsyncProduce(topic string, partition int32, key, message sarama.Encoder, headers []sarama.RecordHeader) <-chan Response {
 	responseCh := make(chan Response, 1)
 	prodMsg := &sarama.ProducerMessage{
-		Topic:    topic,
-		Key:      key,
-		Value:    message,
-		Headers:  headers,
-		Metadata: responseCh,
+		Topic:     topic,
+		Partition: partition,
+		Key:       key,
+		Value:     message,
+		Headers:   headers,
+		Metadata:  responseCh,
 	}
+	atomic.AddInt64(&p.pendingCount, 1)
 	p.dispatcherCh <- prodMsg
 	return responseCh
 }
@@ -155,7 +180,21 @@ func (p *T) runDispatcher() {
 	// it is sent to `prodInputCh`. Note that producer results can be received
 	// at any time.
 	prodMsg := (*sarama.ProducerMessage)(nil)
+	// The partition requested by the client must be captured before the
+	// message is submitted to sarama, for the partitioner overwrites the
+	// Partition field with the chosen partition.
+	reqPartition := int32(-1)
 	channelOpened := true
+	var nilOrHedgeTickerCh <-chan time.Time
+	if p.hedgeTimeout > 0 {
+		checkInterval := p.hedgeTimeout / 2
+		if checkInterval < time.Millisecond {
+			checkInterval = time.Millisecond
+		}
+		hedgeTicker := time.NewTicker(checkInterval)
+		defer hedgeTicker.Stop()
+		nilOrHedgeTickerCh = hedgeTicker.C
+	}
 	for {
 		select {
 		case prodMsg, channelOpened = <-nilOrDispatcherCh:
@@ -163,14 +202,23 @@ func (p *T) runDispatcher() {
 				goto gracefulShutdown
 			}
 			pendingMsgCount += 1
+			reqPartition = prodMsg.Partition
 			nilOrDispatcherCh = nil
 			nilOrProdInputCh = p.saramaProducer.Input()
 		case nilOrProdInputCh <- prodMsg:
+			if p.hedgeTimeout > 0 {
+				if replyCh, ok := prodMsg.Metadata.(chan Response); ok {
+					p.inFlight[replyCh] = &hedgeState{
+						msg: prodMsg, reqPartition: reqPartition, sentAt: time.Now(), attempts: 1,
+					}
+				}
+			}
 			nilOrDispatcherCh = p.dispatcherCh
 			nilOrProdInputCh = nil
+		case <-nilOrHedgeTickerCh:
+			p.hedgeOverdue()
 		case prodResult := <-p.responseCh:
-			pendingMsgCount -= 1
-			p.handleProduceResult(prodResult)
+			pendingMsgCount -= p.onProduceResult(prodResult)
 		}
 	}
 gracefulShutdown:
@@ -182,16 +230,106 @@ gracefulShutdown:
 		case <-shutdownTimeoutCh:
 			goto shutdownNow
 		case prodResult := <-p.responseCh:
-			pendingMsgCount -= 1
-			p.handleProduceResult(prodResult)
+			pendingMsgCount -= p.onProduceResult(prodResult)
 		}
 	}
 shutdownNow:
 	p.dispActDesc.Log().Infof("Stopping producer: pendingMsgCount=%d", pendingMsgCount)
+	// Wait for in-flight hedges to be submitted before the input channel is
+	// closed by AsyncClose.
+	p.hedgeWg.Wait()
 	p.saramaProducer.AsyncClose()
 	for prodResult := range p.responseCh {
-		p.handleProduceResult(prodResult)
+		p.onProduceResult(prodResult)
+	}
+}
+
+// hedgeState tracks a message produced with hedging enabled until all of its
+// in-flight copies are responded to.
+type hedgeState struct {
+	msg *sarama.ProducerMessage
+	// The partition requested by the client, -1 if the partitioner chose.
+	reqPartition int32
+	sentAt       time.Time
+	attempts     int
+	hedged       bool
+	replied      bool
+}
+
+// hedgeOverdue submits a copy of every in-flight message that has not been
+// responded to within the hedge timeout, after refreshing the topic metadata
+// so that a recovered or newly elected leader is picked up. Every message is
+// hedged at most once.
+func (p *T) hedgeOverdue() {
+	deadline := time.Now().Add(-p.hedgeTimeout)
+	for _, hs := range p.inFlight {
+		if hs.hedged || hs.replied || hs.sentAt.After(deadline) {
+			continue
+		}
+		hs.hedged = true
+		hs.attempts += 1
+		hedgedMsg := &sarama.ProducerMessage{
+			Topic:     hs.msg.Topic,
+			Partition: hs.reqPartition,
+			Key:       hs.msg.Key,
+			Value:     hs.msg.Value,
+			Headers:   hs.msg.Headers,
+			Metadata:  hs.msg.Metadata,
+		}
+		metrics.Inc("producer.hedged")
+		p.dispActDesc.Log().Warnf("Hedging produce request: topic=%s", hedgedMsg.Topic)
+		p.hedgeWg.Add(1)
+		go func() {
+			defer p.hedgeWg.Done()
+			if err := p.saramaClient.RefreshMetadata(hedgedMsg.Topic); err != nil {
+				p.dispActDesc.Log().WithError(err).Errorf(
+					"Failed to refresh metadata: topic=%s", hedgedMsg.Topic)
+			}
+			p.saramaProducer.Input() <- hedgedMsg
+		}()
+	}
+}
+
+// onProduceResult routes a produce result to the client and tells whether the
+// respective message is settled, that is no more results are expected for it.
+// Settled messages are subtracted from the pending count that drives produce
+// admission control.
+func (p *T) onProduceResult(result Response) int {
+	n := p.settleProduceResult(result)
+	atomic.AddInt64(&p.pendingCount, int64(-n))
+	return n
+}
+
+// settleProduceResult answers the client of a produce result and tells
+// whether the respective message is settled. Without hedging every message
+// has exactly one in-flight copy, so every result settles a message. With
+// hedging the client is answered with the first result to arrive, results of
+// the remaining copies are dropped, though their errors are still logged.
+func (p *T) settleProduceResult(result Response) int {
+	replyCh, ok := result.Msg.Metadata.(chan Response)
+	if p.hedgeTimeout == 0 || !ok {
+		p.handleProduceResult(result)
+		return 1
 	}
+	hs := p.inFlight[replyCh]
+	if hs == nil {
+		// Must never happen, but better to settle than to hang if it does.
+		p.handleProduceResult(result)
+		return 1
+	}
+	hs.attempts -= 1
+	if !hs.replied {
+		hs.replied = true
+		p.handleProduceResult(result)
+	} else if result.Err != nil {
+		p.dispActDesc.Log().WithError(result.Err).Errorf(
+			"Hedged copy failed after reply: topic=%s", result.Msg.Topic)
+	}
+	if hs.attempts > 0 {
+		return 0
+	}
+	delete(p.inFlight, replyCh)
+	return 1
 }
 
 // handleProduceResult inspects a production results and if it is an error
@@ -206,11 +344,57 @@ func (p *T) handleProduceResult(result Response) {
 	prodMsgRepr := fmt.Sprintf(`{Topic: "%s", Key: "%s", Value: "%s"}`,
 		result.Msg.Topic, encoderRepr(result.Msg.Key), encoderRepr(result.Msg.Value))
 	p.dispActDesc.Log().WithError(result.Err).Errorf("Failed to submit message: msg=%v", prodMsgRepr)
+	p.saveDeadMessage(result)
 	if p.testDroppedMsgCh != nil {
 		p.testDroppedMsgCh <- result.Msg
 	}
 }
 
+type deadMessage struct {
+	Topic string `json:"topic"`
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value,omitempty"`
+	Error string `json:"error"`
+	Time  string `json:"time"`
+}
+
+// saveDeadMessage appends a message that could not be submitted to Kafka to
+// the dead message file as a JSON record along with the error. Failures to
+// save are logged but otherwise ignored, the message is dropped in that case
+// just like it used to be before the dead message file was introduced.
+func (p *T) saveDeadMessage(result Response) {
+	if p.deadMessageFile == "" {
+		return
+	}
+	dm := deadMessage{
+		Topic: result.Msg.Topic,
+		Error: result.Err.Error(),
+		Time:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if result.Msg.Key != nil {
+		dm.Key, _ = result.Msg.Key.Encode()
+	}
+	if result.Msg.Value != nil {
+		dm.Value, _ = result.Msg.Value.Encode()
+	}
+	encoded, err := json.Marshal(dm)
+	if err != nil {
+		p.dispActDesc.Log().WithError(err).Error("Failed to encode dead message")
+		return
+	}
+	f, err := os.OpenFile(p.deadMessageFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		p.dispActDesc.Log().WithError(err).Error("Failed to open dead message file")
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(encoded, '\n')); err != nil {
+		p.dispActDesc.Log().WithError(err).Error("Failed to write dead message")
+		return
+	}
+	metrics.Inc("producer.dead_messages")
+}
+
 // encoderRepr returns the string representation of an encoder value. The value
 // is truncated to `maxEncoderReprLength`.
 func encoderRepr(e sarama.Encoder) string {
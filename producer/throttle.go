@@ -0,0 +1,35 @@
+package producer
+
+import (
+	"sync/atomic"
+)
+
+// Throttled tells whether the producer is currently shedding new produce
+// requests because the count of messages pending broker acknowledgement has
+// crossed producer.throttle_high_watermark. Once engaged, throttling is only
+// lifted after the backlog drains below producer.throttle_low_watermark, so
+// that admission does not flap around a single threshold.
+func (p *T) Throttled() bool {
+	if p.throttleHigh <= 0 {
+		return false
+	}
+	pending := atomic.LoadInt64(&p.pendingCount)
+	if atomic.LoadInt32(&p.throttleEngaged) == 1 {
+		if pending > p.throttleLow {
+			return true
+		}
+		if atomic.CompareAndSwapInt32(&p.throttleEngaged, 1, 0) {
+			p.dispActDesc.Log().Infof("Produce throttling lifted: pending=%d, low_watermark=%d",
+				pending, p.throttleLow)
+		}
+		return false
+	}
+	if pending < p.throttleHigh {
+		return false
+	}
+	if atomic.CompareAndSwapInt32(&p.throttleEngaged, 0, 1) {
+		p.dispActDesc.Log().Errorf("Produce throttling engaged: pending=%d, high_watermark=%d",
+			pending, p.throttleHigh)
+	}
+	return true
+}
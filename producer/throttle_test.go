@@ -0,0 +1,41 @@
+package producer
+
+import (
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottledDisabled(t *testing.T) {
+	p := &T{dispActDesc: actor.Root().NewChild("test")}
+	p.pendingCount = 1000000
+
+	assert.False(t, p.Throttled())
+}
+
+func TestThrottledHysteresis(t *testing.T) {
+	p := &T{
+		dispActDesc:  actor.Root().NewChild("test"),
+		throttleHigh: 100,
+		throttleLow:  50,
+	}
+
+	// Below the high watermark requests are admitted.
+	p.pendingCount = 99
+	assert.False(t, p.Throttled())
+
+	// Crossing the high watermark engages throttling.
+	p.pendingCount = 100
+	assert.True(t, p.Throttled())
+
+	// Draining below the high watermark is not enough to lift it.
+	p.pendingCount = 51
+	assert.True(t, p.Throttled())
+
+	// Only draining to the low watermark lifts it.
+	p.pendingCount = 50
+	assert.False(t, p.Throttled())
+	p.pendingCount = 99
+	assert.False(t, p.Throttled())
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/pkg/errors"
+)
+
+// ErrAccessDenied is returned by produce/consume operations on a topic or a
+// group that the access lists of the proxy do not permit.
+var ErrAccessDenied = errors.New("access denied")
+
+// accessList tells whether a name may be accessed through the proxy based on
+// the allow/deny regular expression lists of the access config section.
+type accessList struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+func newAccessList(allowPatterns, denyPatterns []string) (accessList, error) {
+	var al accessList
+	var err error
+	if al.allow, err = compileAnchored(allowPatterns); err != nil {
+		return accessList{}, err
+	}
+	if al.deny, err = compileAnchored(denyPatterns); err != nil {
+		return accessList{}, err
+	}
+	return al, nil
+}
+
+func compileAnchored(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad pattern %q", pattern)
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+// allowed tells whether the name passes the list. Denial takes precedence
+// and an empty allow list permits everything that is not denied.
+func (al accessList) allowed(name string) bool {
+	for _, re := range al.deny {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(al.allow) == 0 {
+		return true
+	}
+	for _, re := range al.allow {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTopicAccess rejects operations on topics that the access lists of the
+// proxy do not permit, so that an edge-facing instance can expose only a
+// safe subset of the cluster regardless of broker ACLs.
+func (p *T) checkTopicAccess(topic string) error {
+	if p.topicAccess.allowed(topic) {
+		return nil
+	}
+	metrics.Inc("access_denied")
+	return errors.Wrapf(ErrAccessDenied, "topic %s may not be accessed through this proxy", topic)
+}
+
+// checkGroupAccess is a counterpart of checkTopicAccess for consumer groups.
+func (p *T) checkGroupAccess(group string) error {
+	if p.groupAccess.allowed(group) {
+		return nil
+	}
+	metrics.Inc("access_denied")
+	return errors.Wrapf(ErrAccessDenied, "group %s may not be accessed through this proxy", group)
+}
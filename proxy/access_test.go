@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type AccessSuite struct{}
+
+var _ = Suite(&AccessSuite{})
+
+func (s *AccessSuite) TestAllowed(c *C) {
+	for i, tc := range []struct {
+		allow   []string
+		deny    []string
+		name    string
+		allowed bool
+	}{
+		// Empty lists permit everything.
+		{nil, nil, "anything", true},
+		{nil, nil, "", true},
+
+		// Patterns are anchored implicitly, a pattern must match the whole
+		// name, not a substring of it.
+		{[]string{"foo"}, nil, "foo", true},
+		{[]string{"foo"}, nil, "xfoo", false},
+		{[]string{"foo"}, nil, "foox", false},
+		{[]string{"foo"}, nil, "xfoo.bar", false},
+		{[]string{"foo.*"}, nil, "foo.bar", true},
+		{[]string{"foo.*"}, nil, "xfoo.bar", false},
+		{nil, []string{"foo"}, "foo", false},
+		{nil, []string{"foo"}, "xfoox", true},
+
+		// A pattern with explicit anchors still works.
+		{[]string{"^foo$"}, nil, "foo", true},
+		{[]string{"^foo$"}, nil, "xfoo", false},
+
+		// An empty allow list permits everything that is not denied.
+		{nil, []string{"secret-.*"}, "secret-topic", false},
+		{nil, []string{"secret-.*"}, "public-topic", true},
+
+		// A non empty allow list permits only what it matches.
+		{[]string{"public-.*"}, nil, "public-topic", true},
+		{[]string{"public-.*"}, nil, "secret-topic", false},
+		{[]string{"public-.*", "shared-.*"}, nil, "shared-topic", true},
+
+		// Denial takes precedence over a matching allow pattern.
+		{[]string{"public-.*"}, []string{"public-but-not-really"}, "public-topic", true},
+		{[]string{"public-.*"}, []string{"public-but-not-really"}, "public-but-not-really", false},
+		{[]string{".*"}, []string{".*"}, "anything", false},
+	} {
+		al, err := newAccessList(tc.allow, tc.deny)
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Assert(al.allowed(tc.name), Equals, tc.allowed,
+			Commentf("case #%d: allow=%v, deny=%v, name=%s", i, tc.allow, tc.deny, tc.name))
+	}
+}
+
+func (s *AccessSuite) TestBadPattern(c *C) {
+	// An invalid pattern in either list is rejected on creation.
+	_, err := newAccessList([]string{"("}, nil)
+	c.Assert(err, ErrorMatches, `bad pattern "\(".*`)
+	_, err = newAccessList(nil, []string{"("})
+	c.Assert(err, ErrorMatches, `bad pattern "\(".*`)
+}
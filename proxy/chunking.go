@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/pkg/errors"
+)
+
+// Headers that correlate the chunks of a large message split by the produce
+// API when producer.chunking is enabled. Every chunk of a message carries the
+// same randomly generated chunk id along with its zero based chunk number and
+// the total chunk count.
+const (
+	chunkIDHeader    = "kafka-pixy-chunk-id"
+	chunkNoHeader    = "kafka-pixy-chunk-no"
+	chunkCountHeader = "kafka-pixy-chunk-count"
+)
+
+// chunkOverheadBytes is a per record allowance for the key, the chunk
+// correlation headers and the record envelope, subtracted from
+// producer.max_message_bytes when sizing chunk payloads, so that a chunk
+// record as a whole stays within the broker limit.
+const chunkOverheadBytes = 512
+
+// shouldChunk tells if the message is to be split into chunks rather than
+// produced as a single record.
+func (p *T) shouldChunk(key, message kafkaclt.Encoder) bool {
+	maxBytes := p.cfg.Producer.MaxMessageBytes
+	if !p.cfg.Producer.Chunking || maxBytes <= 0 || message == nil {
+		return false
+	}
+	size := message.Length()
+	if key != nil {
+		size += key.Length()
+	}
+	return size > maxBytes
+}
+
+// produceChunked splits the message payload into chunks that fit into
+// producer.max_message_bytes and produces them in order as individual records
+// correlated via the chunk headers. All chunks share the same key, the
+// original one or, if the message has no key, the chunk id, so that they land
+// in the same partition and can be reassembled by the consume API. The
+// producer message of the last chunk is returned.
+func (p *T) produceChunked(ctx context.Context, topic string, partition int32, key, message kafkaclt.Encoder, headers []kafkaclt.Header) (*kafkaclt.ProducedMsg, error) {
+	value, err := message.Encode()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode message")
+	}
+	chunkSize := p.cfg.Producer.MaxMessageBytes - chunkOverheadBytes
+	if key != nil {
+		chunkSize -= key.Length()
+	}
+	if chunkSize <= 0 {
+		return nil, errors.Wrapf(ErrMessageTooLarge,
+			"producer.max_message_bytes %d is too small to fit a chunk payload",
+			p.cfg.Producer.MaxMessageBytes)
+	}
+	chunkID := newChunkID()
+	if key == nil {
+		key = kafkaclt.StringEncoder(chunkID)
+	}
+	count := (len(value) + chunkSize - 1) / chunkSize
+	metrics.Inc("producer.chunked")
+	var lastMsg *kafkaclt.ProducedMsg
+	for no := 0; no < count; no++ {
+		begin := no * chunkSize
+		end := begin + chunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		chunkHeaders := append(headers[:len(headers):len(headers)],
+			kafkaclt.Header{Key: []byte(chunkIDHeader), Value: []byte(chunkID)},
+			kafkaclt.Header{Key: []byte(chunkNoHeader), Value: []byte(strconv.Itoa(no))},
+			kafkaclt.Header{Key: []byte(chunkCountHeader), Value: []byte(strconv.Itoa(count))})
+		lastMsg, err = p.produceMessage(ctx, topic, partition, key, kafkaclt.ByteEncoder(value[begin:end]), chunkHeaders)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to produce chunk %d of %d", no, count)
+		}
+	}
+	return lastMsg, nil
+}
+
+func newChunkID() string {
+	token := make([]byte, 8)
+	_, _ = rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// chunkInfo is the chunk coordinates of a consumed message parsed from the
+// chunk correlation headers.
+type chunkInfo struct {
+	id    string
+	no    int
+	count int
+}
+
+// parseChunkHeaders extracts chunk coordinates from the message headers. A
+// message without a complete and sane set of chunk headers is reported as not
+// chunked and is delivered to the client as is.
+func parseChunkHeaders(msg consumer.Message) (chunkInfo, bool) {
+	var ci chunkInfo
+	var noSeen bool
+	var noErr, countErr error
+	for _, h := range msg.Headers {
+		switch string(h.Key) {
+		case chunkIDHeader:
+			ci.id = string(h.Value)
+		case chunkNoHeader:
+			ci.no, noErr = strconv.Atoi(string(h.Value))
+			noSeen = true
+		case chunkCountHeader:
+			ci.count, countErr = strconv.Atoi(string(h.Value))
+		}
+	}
+	if ci.id == "" || !noSeen || noErr != nil || countErr != nil ||
+		ci.count < 1 || ci.no < 0 || ci.no >= ci.count {
+		return chunkInfo{}, false
+	}
+	return ci, true
+}
+
+// chunkAsmID identifies a chunked message being reassembled. Reassembly is
+// tracked per group so that groups consuming the same topic at different
+// speeds do not share state.
+type chunkAsmID struct {
+	group   string
+	topic   string
+	chunkID string
+}
+
+// chunkAssembly accumulates the chunks of one large message. A completed
+// assembly is kept around until it expires, so that a chunk re-offered after
+// an ack timeout can be answered with the reassembled message again even
+// though its sibling chunks have already been acknowledged.
+type chunkAssembly struct {
+	parts     [][]byte
+	acks      []Ack
+	missing   int
+	createdAt time.Time
+}
+
+// consumeChunked keeps consuming from the group until either the chunked
+// message that msg is a part of is complete, a message that is not a chunk is
+// received, or the consume request fails, e.g. times out. On completion every
+// chunk but the delivered one is acknowledged directly and the reassembled
+// message is returned with the coordinates of the chunk that completed it, so
+// the client acknowledges the whole message by acknowledging that one offset.
+func (p *T) consumeChunked(ctx context.Context, group, topic string, msg consumer.Message, ci chunkInfo) (consumer.Message, error) {
+	for {
+		asm, complete := p.offerChunk(group, topic, msg, ci)
+		if complete {
+			p.ackChunkSiblings(group, topic, msg, asm)
+			metrics.Inc("consumer.reassembled")
+			return assembledMessage(msg, ci, asm), nil
+		}
+		var err error
+		msg, err = p.consumeOne(ctx, group, topic, NoAck())
+		if err != nil {
+			return consumer.Message{}, err
+		}
+		var ok bool
+		if ci, ok = parseChunkHeaders(msg); !ok {
+			return msg, nil
+		}
+	}
+}
+
+// offerChunk adds the chunk to its assembly, creating it if needed, and tells
+// if the assembly is complete. Duplicate chunks, e.g. re-offered after an ack
+// timeout, are counted once. Stale assemblies are pruned along the way.
+func (p *T) offerChunk(group, topic string, msg consumer.Message, ci chunkInfo) (*chunkAssembly, bool) {
+	p.chunkAsmMu.Lock()
+	defer p.chunkAsmMu.Unlock()
+
+	now := time.Now()
+	for id, asm := range p.chunkAsm {
+		if now.Sub(asm.createdAt) > 2*p.cfg.Consumer.AckTimeout {
+			delete(p.chunkAsm, id)
+		}
+	}
+	id := chunkAsmID{group, topic, ci.id}
+	asm := p.chunkAsm[id]
+	if asm == nil {
+		asm = &chunkAssembly{
+			parts:     make([][]byte, ci.count),
+			acks:      make([]Ack, ci.count),
+			missing:   ci.count,
+			createdAt: now,
+		}
+		p.chunkAsm[id] = asm
+	}
+	if ci.count != len(asm.parts) {
+		// A chunk count that disagrees with the rest of the assembly can only
+		// come from a client stamping the correlation headers by hand. Drop
+		// the chunk, the ack timeout deals with its offset.
+		return nil, false
+	}
+	if asm.parts[ci.no] == nil {
+		asm.parts[ci.no] = msg.Value
+		asm.missing--
+	}
+	asm.acks[ci.no] = Ack{partition: msg.Partition, offset: msg.Offset}
+	if asm.missing > 0 {
+		return nil, false
+	}
+	return asm, true
+}
+
+// ackChunkSiblings acknowledges every chunk of the completed assembly except
+// the one that is delivered to the client, whose offset the client is
+// expected to acknowledge itself.
+func (p *T) ackChunkSiblings(group, topic string, delivered consumer.Message, asm *chunkAssembly) {
+	for _, a := range asm.acks {
+		if a.partition == delivered.Partition && a.offset == delivered.Offset {
+			continue
+		}
+		if err := p.Ack(group, topic, a); err != nil {
+			p.actDesc.Log().WithError(err).Errorf(
+				"Failed to ack chunk: group=%s, topic=%s, partition=%d, offset=%d",
+				group, topic, a.partition, a.offset)
+		}
+	}
+}
+
+// assembledMessage returns the consumed message with the value replaced by
+// the concatenated chunk payloads and the chunk correlation headers removed.
+// A key equal to the chunk id was synthesized by produceChunked for a keyless
+// message and is stripped back off.
+func assembledMessage(msg consumer.Message, ci chunkInfo, asm *chunkAssembly) consumer.Message {
+	msg.Value = bytes.Join(asm.parts, nil)
+	var headers []*sarama.RecordHeader
+	for _, h := range msg.Headers {
+		switch string(h.Key) {
+		case chunkIDHeader, chunkNoHeader, chunkCountHeader:
+			continue
+		}
+		headers = append(headers, h)
+	}
+	msg.Headers = headers
+	if string(msg.Key) == ci.id {
+		msg.Key = nil
+	}
+	return msg
+}
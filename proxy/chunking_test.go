@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ChunkingSuite struct {
+	cfg *config.Proxy
+}
+
+var _ = Suite(&ChunkingSuite{})
+
+func (s *ChunkingSuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultProxy()
+	s.cfg.Consumer.AckTimeout = 5 * time.Minute
+}
+
+func (s *ChunkingSuite) newProxy() *T {
+	return &T{
+		cfg:      s.cfg,
+		chunkAsm: make(map[chunkAsmID]*chunkAssembly),
+	}
+}
+
+// chunkMsg builds a consumed message stamped with the chunk correlation
+// headers the way produceChunked stamps them.
+func chunkMsg(partition int32, offset int64, value, id string, no, count int) consumer.Message {
+	return consumer.Message{
+		ConsumerMessage: sarama.ConsumerMessage{
+			Value:     []byte(value),
+			Partition: partition,
+			Offset:    offset,
+			Headers: []*sarama.RecordHeader{
+				{Key: []byte(chunkIDHeader), Value: []byte(id)},
+				{Key: []byte(chunkNoHeader), Value: []byte(strconv.Itoa(no))},
+				{Key: []byte(chunkCountHeader), Value: []byte(strconv.Itoa(count))},
+			},
+		},
+	}
+}
+
+func (s *ChunkingSuite) TestParseChunkHeaders(c *C) {
+	for i, tc := range []struct {
+		headers map[string]string
+		chunked bool
+		ci      chunkInfo
+	}{
+		// A complete and sane set of chunk headers.
+		{map[string]string{chunkIDHeader: "abc", chunkNoHeader: "0", chunkCountHeader: "2"},
+			true, chunkInfo{id: "abc", no: 0, count: 2}},
+		{map[string]string{chunkIDHeader: "abc", chunkNoHeader: "1", chunkCountHeader: "2"},
+			true, chunkInfo{id: "abc", no: 1, count: 2}},
+		// A single chunk message is still a chunk.
+		{map[string]string{chunkIDHeader: "abc", chunkNoHeader: "0", chunkCountHeader: "1"},
+			true, chunkInfo{id: "abc", no: 0, count: 1}},
+		// No chunk headers at all.
+		{map[string]string{"foo": "bar"}, false, chunkInfo{}},
+		// Incomplete sets.
+		{map[string]string{chunkNoHeader: "0", chunkCountHeader: "2"}, false, chunkInfo{}},
+		{map[string]string{chunkIDHeader: "abc", chunkCountHeader: "2"}, false, chunkInfo{}},
+		{map[string]string{chunkIDHeader: "abc", chunkNoHeader: "0"}, false, chunkInfo{}},
+		// Malformed numbers.
+		{map[string]string{chunkIDHeader: "abc", chunkNoHeader: "x", chunkCountHeader: "2"},
+			false, chunkInfo{}},
+		{map[string]string{chunkIDHeader: "abc", chunkNoHeader: "0", chunkCountHeader: "x"},
+			false, chunkInfo{}},
+		// Out of range coordinates.
+		{map[string]string{chunkIDHeader: "abc", chunkNoHeader: "0", chunkCountHeader: "0"},
+			false, chunkInfo{}},
+		{map[string]string{chunkIDHeader: "abc", chunkNoHeader: "-1", chunkCountHeader: "2"},
+			false, chunkInfo{}},
+		{map[string]string{chunkIDHeader: "abc", chunkNoHeader: "2", chunkCountHeader: "2"},
+			false, chunkInfo{}},
+	} {
+		var msg consumer.Message
+		for key, value := range tc.headers {
+			msg.Headers = append(msg.Headers,
+				&sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+		}
+		ci, chunked := parseChunkHeaders(msg)
+		c.Assert(chunked, Equals, tc.chunked, Commentf("case #%d: %v", i, tc.headers))
+		c.Assert(ci, DeepEquals, tc.ci, Commentf("case #%d: %v", i, tc.headers))
+	}
+}
+
+func (s *ChunkingSuite) TestOfferChunkAssembles(c *C) {
+	p := s.newProxy()
+
+	// When: the chunks of a message arrive out of order.
+	for i, tc := range []struct {
+		no       int
+		complete bool
+	}{
+		{2, false},
+		{0, false},
+		{1, true},
+	} {
+		msg := chunkMsg(1, int64(100+tc.no), "part"+strconv.Itoa(tc.no), "abc", tc.no, 3)
+		ci, chunked := parseChunkHeaders(msg)
+		c.Assert(chunked, Equals, true)
+		asm, complete := p.offerChunk("g", "t", msg, ci)
+
+		// Then: the assembly completes with the last missing chunk and the
+		// parts end up in chunk number order regardless of arrival order.
+		c.Assert(complete, Equals, tc.complete, Commentf("case #%d", i))
+		if complete {
+			c.Assert(asm.parts, DeepEquals,
+				[][]byte{[]byte("part0"), []byte("part1"), []byte("part2")})
+			c.Assert(asm.acks, DeepEquals, []Ack{
+				{partition: 1, offset: 100},
+				{partition: 1, offset: 101},
+				{partition: 1, offset: 102},
+			})
+		}
+	}
+}
+
+func (s *ChunkingSuite) TestOfferChunkDuplicate(c *C) {
+	p := s.newProxy()
+
+	// When: the same chunk is offered twice, e.g. re-offered after an ack
+	// timeout, before the assembly is complete.
+	msg := chunkMsg(1, 100, "part0", "abc", 0, 2)
+	ci, _ := parseChunkHeaders(msg)
+	_, complete := p.offerChunk("g", "t", msg, ci)
+	c.Assert(complete, Equals, false)
+	dup := chunkMsg(1, 105, "bogus", "abc", 0, 2)
+	_, complete = p.offerChunk("g", "t", dup, ci)
+
+	// Then: the duplicate is counted once, does not overwrite the payload,
+	// and does not complete the assembly on its own.
+	c.Assert(complete, Equals, false)
+	msg = chunkMsg(1, 106, "part1", "abc", 1, 2)
+	ci, _ = parseChunkHeaders(msg)
+	asm, complete := p.offerChunk("g", "t", msg, ci)
+	c.Assert(complete, Equals, true)
+	c.Assert(asm.parts, DeepEquals, [][]byte{[]byte("part0"), []byte("part1")})
+}
+
+func (s *ChunkingSuite) TestOfferChunkCountMismatch(c *C) {
+	p := s.newProxy()
+
+	// Given: an assembly of two chunks.
+	msg := chunkMsg(1, 100, "part0", "abc", 0, 2)
+	ci, _ := parseChunkHeaders(msg)
+	_, complete := p.offerChunk("g", "t", msg, ci)
+	c.Assert(complete, Equals, false)
+
+	// When: a chunk of the same id claims a different total count.
+	bogus := chunkMsg(1, 101, "bogus", "abc", 0, 3)
+	bogusCI, _ := parseChunkHeaders(bogus)
+	_, complete = p.offerChunk("g", "t", bogus, bogusCI)
+
+	// Then: it is dropped and the assembly is left intact.
+	c.Assert(complete, Equals, false)
+	msg = chunkMsg(1, 102, "part1", "abc", 1, 2)
+	ci, _ = parseChunkHeaders(msg)
+	asm, complete := p.offerChunk("g", "t", msg, ci)
+	c.Assert(complete, Equals, true)
+	c.Assert(asm.parts, DeepEquals, [][]byte{[]byte("part0"), []byte("part1")})
+}
+
+func (s *ChunkingSuite) TestOfferChunkGroupsIsolated(c *C) {
+	p := s.newProxy()
+
+	// When: two groups consume chunks with the same chunk id.
+	msg := chunkMsg(1, 100, "part0", "abc", 0, 2)
+	ci, _ := parseChunkHeaders(msg)
+	_, complete := p.offerChunk("g1", "t", msg, ci)
+	c.Assert(complete, Equals, false)
+	msg = chunkMsg(1, 101, "part1", "abc", 1, 2)
+	ci, _ = parseChunkHeaders(msg)
+	_, complete = p.offerChunk("g2", "t", msg, ci)
+
+	// Then: their assemblies do not share state.
+	c.Assert(complete, Equals, false)
+}
+
+func (s *ChunkingSuite) TestOfferChunkStaleExpiry(c *C) {
+	p := s.newProxy()
+
+	// Given: an incomplete assembly older than twice the ack timeout.
+	msg := chunkMsg(1, 100, "part0", "abc", 0, 2)
+	ci, _ := parseChunkHeaders(msg)
+	_, complete := p.offerChunk("g", "t", msg, ci)
+	c.Assert(complete, Equals, false)
+	p.chunkAsm[chunkAsmID{"g", "t", "abc"}].createdAt =
+		time.Now().Add(-2*s.cfg.Consumer.AckTimeout - time.Second)
+
+	// When: any chunk is offered afterwards.
+	other := chunkMsg(1, 200, "other0", "def", 0, 2)
+	otherCI, _ := parseChunkHeaders(other)
+	_, complete = p.offerChunk("g", "t", other, otherCI)
+	c.Assert(complete, Equals, false)
+
+	// Then: the stale assembly has been pruned, so a re-offered chunk of it
+	// starts the reassembly over.
+	c.Assert(p.chunkAsm[chunkAsmID{"g", "t", "abc"}], IsNil)
+	msg = chunkMsg(1, 101, "part1", "abc", 1, 2)
+	ci, _ = parseChunkHeaders(msg)
+	_, complete = p.offerChunk("g", "t", msg, ci)
+	c.Assert(complete, Equals, false)
+}
+
+func (s *ChunkingSuite) TestAssembledMessage(c *C) {
+	// Given: a complete assembly whose last chunk carries extra user headers
+	// and the key synthesized by produceChunked for a keyless message.
+	msg := chunkMsg(1, 102, "part2", "abc", 2, 3)
+	msg.Key = []byte("abc")
+	msg.Headers = append(msg.Headers,
+		&sarama.RecordHeader{Key: []byte("foo"), Value: []byte("bar")})
+	ci, _ := parseChunkHeaders(msg)
+	asm := &chunkAssembly{
+		parts: [][]byte{[]byte("part0"), []byte("part1"), []byte("part2")},
+	}
+
+	// When
+	assembled := assembledMessage(msg, ci, asm)
+
+	// Then: the value is the concatenation of the chunk payloads, the chunk
+	// correlation headers and the synthesized key are stripped, and the user
+	// headers are kept.
+	c.Assert(string(assembled.Value), Equals, "part0part1part2")
+	c.Assert(assembled.Headers, DeepEquals, []*sarama.RecordHeader{
+		{Key: []byte("foo"), Value: []byte("bar")},
+	})
+	c.Assert(assembled.Key, IsNil)
+}
+
+func (s *ChunkingSuite) TestAssembledMessageKeepsUserKey(c *C) {
+	// Given: a chunked message produced with an explicit key.
+	msg := chunkMsg(1, 101, "part1", "abc", 1, 2)
+	msg.Key = []byte("user-key")
+	ci, _ := parseChunkHeaders(msg)
+	asm := &chunkAssembly{parts: [][]byte{[]byte("part0"), []byte("part1")}}
+
+	// When
+	assembled := assembledMessage(msg, ci, asm)
+
+	// Then: a key that is not the chunk id is delivered as is.
+	c.Assert(string(assembled.Key), Equals, "user-key")
+	c.Assert(string(assembled.Value), Equals, "part0part1")
+	c.Assert(assembled.Headers, IsNil)
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+	"github.com/mailgun/kafka-pixy/metrics"
+)
+
+// Dead letter enrichment header keys. They describe where the message came
+// from and why it was given up on, so that DLQ processors can triage without
+// guessing.
+const (
+	dlqHdrTopic        = "kafka-pixy-dlq-origin-topic"
+	dlqHdrPartition    = "kafka-pixy-dlq-origin-partition"
+	dlqHdrOffset       = "kafka-pixy-dlq-origin-offset"
+	dlqHdrTimestamp    = "kafka-pixy-dlq-origin-timestamp"
+	dlqHdrGroup        = "kafka-pixy-dlq-group"
+	dlqHdrFailureCount = "kafka-pixy-dlq-failure-count"
+	dlqHdrReason       = "kafka-pixy-dlq-reason"
+)
+
+// deadLetterFn routes a message that the consumer machinery has given up on
+// to the configured dead letter topic, attaching headers with the message
+// origin and failure details. Produce errors are not reported back, for there
+// is nothing the consumer machinery could do about them anyway, but they are
+// logged by the producer.
+func (p *T) deadLetterFn(msg consumer.Message, group string, failureCount int, reason string) {
+	headers := make([]kafkaclt.Header, 0, len(msg.Headers)+7)
+	for _, h := range msg.Headers {
+		headers = append(headers, kafkaclt.Header{Key: h.Key, Value: h.Value})
+	}
+	headers = append(headers,
+		kafkaclt.Header{Key: []byte(dlqHdrTopic), Value: []byte(msg.Topic)},
+		kafkaclt.Header{Key: []byte(dlqHdrPartition), Value: []byte(strconv.FormatInt(int64(msg.Partition), 10))},
+		kafkaclt.Header{Key: []byte(dlqHdrOffset), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafkaclt.Header{Key: []byte(dlqHdrTimestamp), Value: []byte(msg.Timestamp.UTC().Format(time.RFC3339))},
+		kafkaclt.Header{Key: []byte(dlqHdrGroup), Value: []byte(group)},
+		kafkaclt.Header{Key: []byte(dlqHdrFailureCount), Value: []byte(strconv.Itoa(failureCount))},
+		kafkaclt.Header{Key: []byte(dlqHdrReason), Value: []byte(reason)})
+
+	// Headers require Kafka 0.11+. Do not lose the message over enrichment.
+	if !p.cfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		headers = nil
+	}
+	metrics.Inc("consumer.dead_lettered")
+	p.AsyncProduce(p.cfg.Consumer.DeadLetterTopic, -1,
+		toEncoderPreservingNil(msg.Key), kafkaclt.ByteEncoder(msg.Value), headers)
+}
+
+// ensureDeadLetterTopic makes sure the dead letter topic exists and matches
+// consumer.dead_letter_topic_template, creating it or repairing its config as
+// necessary, instead of leaving its layout to the broker topic defaults. It
+// is called once at proxy startup. Failures are not fatal: the topic may
+// still be auto-created by the broker when the first message is dead
+// lettered, just not according to the template.
+func (p *T) ensureDeadLetterTopic() {
+	topic := p.cfg.Consumer.DeadLetterTopic
+	tmpl := &p.cfg.Consumer.DeadLetterTopicTemplate
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return
+	}
+	warnings, err := p.admin.EnsureTopic(topic, tmpl.Partitions, tmpl.ReplicationFactor, tmpl.ConfigEntries())
+	if err != nil {
+		p.actDesc.Log().WithError(err).Errorf("Failed to ensure dead letter topic %s", topic)
+		return
+	}
+	for _, warning := range warnings {
+		p.actDesc.Log().Warnf("Dead letter topic %s does not match its template: %s", topic, warning)
+	}
+}
+
+// toEncoderPreservingNil converts a slice of bytes to kafkaclt.Encoder
+// keeping nil values as nil, so that the partitioner treats the key as
+// absent.
+func toEncoderPreservingNil(b []byte) kafkaclt.Encoder {
+	if b != nil {
+		return kafkaclt.ByteEncoder(b)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// Fault kinds that can be injected into API operations.
+const (
+	// FaultDelay delays matching operations by the configured duration.
+	FaultDelay = "delay"
+
+	// FaultError fails matching operations with an injected error.
+	FaultError = "error"
+
+	// FaultRebalance fails matching consume operations the way they would
+	// fail during a consumer group rebalance.
+	FaultRebalance = "rebalance"
+)
+
+// Operations that faults can be applied to.
+const (
+	FaultOpProduce = "produce"
+	FaultOpConsume = "consume"
+)
+
+var errInjectedFault = errors.New("injected fault")
+
+// Fault describes an artificial failure to be injected into API operations.
+// Empty Topic and/or Group match any topic/group. Probability 0 is treated
+// as 1, that is the fault fires on every matching operation.
+type Fault struct {
+	Op          string        `json:"op"`
+	Topic       string        `json:"topic,omitempty"`
+	Group       string        `json:"group,omitempty"`
+	Kind        string        `json:"kind"`
+	Delay       time.Duration `json:"delay,omitempty"`
+	Probability float64       `json:"probability,omitempty"`
+}
+
+func (f Fault) validate() error {
+	switch f.Op {
+	case FaultOpProduce, FaultOpConsume:
+	default:
+		return errors.Errorf("bad op: %s", f.Op)
+	}
+	switch f.Kind {
+	case FaultDelay:
+		if f.Delay <= 0 {
+			return errors.Errorf("delay must be > 0")
+		}
+	case FaultError:
+	case FaultRebalance:
+		if f.Op != FaultOpConsume {
+			return errors.Errorf("rebalance fault only applies to consume")
+		}
+	default:
+		return errors.Errorf("bad kind: %s", f.Kind)
+	}
+	if f.Probability < 0 || f.Probability > 1 {
+		return errors.Errorf("probability must be within [0, 1]")
+	}
+	return nil
+}
+
+func (f Fault) matches(op, topic, group string) bool {
+	if f.Op != op {
+		return false
+	}
+	if f.Topic != "" && f.Topic != topic {
+		return false
+	}
+	if f.Group != "" && f.Group != group {
+		return false
+	}
+	if f.Probability > 0 && f.Probability < 1 && rand.Float64() >= f.Probability {
+		return false
+	}
+	return true
+}
+
+// FaultsEnabled tells whether the fault injection facility is enabled for the
+// proxy. It is disabled by default and has to be explicitly turned on in the
+// config for the fault admin API to have any effect.
+func (p *T) FaultsEnabled() bool {
+	return p.cfg.FaultInjection.Enabled
+}
+
+// AddFault registers a fault to be injected into matching API operations.
+func (p *T) AddFault(f Fault) error {
+	if err := f.validate(); err != nil {
+		return errors.Wrap(err, "invalid fault")
+	}
+	p.faultsMu.Lock()
+	p.faults = append(p.faults, f)
+	p.faultsMu.Unlock()
+	p.actDesc.Log().Warnf("Fault injected: %+v", f)
+	return nil
+}
+
+// ListFaults returns all currently registered faults.
+func (p *T) ListFaults() []Fault {
+	p.faultsMu.RLock()
+	defer p.faultsMu.RUnlock()
+	faults := make([]Fault, len(p.faults))
+	copy(faults, p.faults)
+	return faults
+}
+
+// ClearFaults removes all registered faults.
+func (p *T) ClearFaults() {
+	p.faultsMu.Lock()
+	p.faults = nil
+	p.faultsMu.Unlock()
+	p.actDesc.Log().Warn("All faults cleared")
+}
+
+// injectFault applies registered faults matching the operation. It returns an
+// error for error-kind faults after sleeping through all matching delays.
+func (p *T) injectFault(op, topic, group string) error {
+	if !p.cfg.FaultInjection.Enabled {
+		return nil
+	}
+	p.faultsMu.RLock()
+	var delay time.Duration
+	var err error
+	for _, f := range p.faults {
+		if !f.matches(op, topic, group) {
+			continue
+		}
+		switch f.Kind {
+		case FaultDelay:
+			delay += f.Delay
+		case FaultError:
+			err = errInjectedFault
+		case FaultRebalance:
+			err = sarama.ErrRebalanceInProgress
+		}
+	}
+	p.faultsMu.RUnlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return err
+}
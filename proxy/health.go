@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Valid PartitionHealth.Status values.
+const (
+	PartitionStatusOk              = "ok"
+	PartitionStatusNoLeader        = "no_leader"
+	PartitionStatusUnderReplicated = "under_replicated"
+)
+
+// PartitionHealth describes the replication state of a topic partition as
+// seen in the cluster metadata.
+type PartitionHealth struct {
+	Partition int32
+	Leader    int32
+	Replicas  []int32
+	ISR       []int32
+	Status    string
+}
+
+// GetPartitionsHealth returns the replication state of every partition of a
+// topic: its leader, replicas, in-sync replicas, and a status that is
+// no_leader if the partition has no elected leader, under_replicated if some
+// replicas are not in sync, and ok otherwise. It is meant to speed up triage
+// of consume timeouts caused by broker trouble rather than by absence of
+// messages.
+func (p *T) GetPartitionsHealth(topic string) ([]PartitionHealth, error) {
+	if p.emulator != nil {
+		return nil, ErrUnavailable
+	}
+	partitions, err := p.kafkaClt.Partitions(topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get topic partitions")
+	}
+	healths := make([]PartitionHealth, len(partitions))
+	for i, partition := range partitions {
+		ph := PartitionHealth{Partition: partition, Leader: -1}
+		if broker, err := p.kafkaClt.Leader(topic, partition); err == nil {
+			ph.Leader = broker.ID()
+		}
+		// Replica sets are best effort, they may be unavailable from the
+		// cached metadata in the middle of a leader election.
+		ph.Replicas, _ = p.kafkaClt.Replicas(topic, partition)
+		ph.ISR, _ = p.kafkaClt.InSyncReplicas(topic, partition)
+		switch {
+		case ph.Leader < 0:
+			ph.Status = PartitionStatusNoLeader
+		case len(ph.ISR) < len(ph.Replicas):
+			ph.Status = PartitionStatusUnderReplicated
+		default:
+			ph.Status = PartitionStatusOk
+		}
+		healths[i] = ph
+	}
+	return healths, nil
+}
+
+// UnhealthyPartitions returns a human readable summary of the partitions of
+// a topic that are not in the ok state, or an empty string if all partitions
+// are healthy or the state cannot be determined. It is attached to consume
+// timeout errors so that broker trouble does not present itself as a generic
+// timeout.
+func (p *T) UnhealthyPartitions(topic string) string {
+	healths, err := p.GetPartitionsHealth(topic)
+	if err != nil {
+		return ""
+	}
+	var summaries []string
+	for _, ph := range healths {
+		switch ph.Status {
+		case PartitionStatusNoLeader:
+			summaries = append(summaries, fmt.Sprintf("partition %d has no leader", ph.Partition))
+		case PartitionStatusUnderReplicated:
+			summaries = append(summaries, fmt.Sprintf("partition %d is under replicated (isr %d/%d)",
+				ph.Partition, len(ph.ISR), len(ph.Replicas)))
+		}
+	}
+	return strings.Join(summaries, ", ")
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+	"github.com/mailgun/kafka-pixy/metrics"
+)
+
+// Header that carries the time a message was accepted for production, as
+// nanoseconds since the Unix epoch. It is stamped when
+// producer.timestamp_header is enabled and is used to compute the
+// produce-to-consume latency when the message is later delivered through the
+// same Kafka-Pixy fleet.
+const producedAtHeader = "kafka-pixy-produced-at"
+
+// stampProducedAt appends the produced-at header to the message headers if
+// latency measurement is enabled. Headers require Kafka 0.11+.
+func (p *T) stampProducedAt(headers []kafkaclt.Header) []kafkaclt.Header {
+	if !p.cfg.Producer.TimestampHeader || !p.cfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return headers
+	}
+	return append(headers, kafkaclt.Header{
+		Key:   []byte(producedAtHeader),
+		Value: []byte(strconv.FormatInt(time.Now().UnixNano(), 10)),
+	})
+}
+
+// observeE2ELatency computes the produce-to-consume latency of a consumed
+// message from its produced-at header, if present, and records it in the
+// per topic duration metric.
+func (p *T) observeE2ELatency(msg consumer.Message) {
+	for _, h := range msg.Headers {
+		if string(h.Key) != producedAtHeader {
+			continue
+		}
+		nanos, err := strconv.ParseInt(string(h.Value), 10, 64)
+		if err != nil {
+			return
+		}
+		if latency := time.Since(time.Unix(0, nanos)); latency >= 0 {
+			metrics.ObserveDuration("consumer.e2e_latency."+msg.Topic, latency)
+		}
+		return
+	}
+}
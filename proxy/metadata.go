@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// How often at most an error observed by Produce/Consume may trigger an
+// immediate metadata refresh. It puts an upper bound on the refresh rate
+// when many requests fail at once, e.g. after a leader election.
+const minErrMetadataRefreshInterval = 5 * time.Second
+
+// MetadataRefreshedAt returns the time when the cluster metadata cache was
+// last known to be refreshed. The Kafka client also refreshes metadata in the
+// background every kafka.metadata_refresh_frequency, so the reported age is
+// an upper bound. In emulator mode there is no metadata cache and the current
+// time is returned.
+func (p *T) MetadataRefreshedAt() time.Time {
+	if p.emulator != nil {
+		return time.Now()
+	}
+	p.metadataMu.Lock()
+	defer p.metadataMu.Unlock()
+	return p.metadataRefreshedAt
+}
+
+// maybeRefreshMetadata triggers an immediate metadata refresh if err
+// indicates that the cached metadata may be stale, that is the topic is
+// unknown or a partition leader has moved. Refreshes are rate limited by
+// minErrMetadataRefreshInterval.
+func (p *T) maybeRefreshMetadata(err error) {
+	if err == nil || p.kafkaClt == nil {
+		return
+	}
+	switch errors.Cause(err) {
+	case sarama.ErrUnknownTopicOrPartition, sarama.ErrNotLeaderForPartition, sarama.ErrLeaderNotAvailable:
+	default:
+		return
+	}
+	p.metadataMu.Lock()
+	if time.Since(p.metadataRefreshedAt) < minErrMetadataRefreshInterval {
+		p.metadataMu.Unlock()
+		return
+	}
+	p.metadataRefreshedAt = time.Now()
+	p.metadataMu.Unlock()
+
+	if err := p.kafkaClt.RefreshMetadata(); err != nil {
+		p.actDesc.Log().WithError(err).Warn("Failed to refresh metadata")
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/pkg/errors"
+)
+
+// How long to wait for messages to arrive from a partition while peeking.
+// The messages are known to exist from the offset ranges, so the timeout only
+// guards against unresponsive brokers.
+const peekFetchTimeout = 3 * time.Second
+
+// Peek returns up to count messages that the specified group is yet to
+// consume from the topic, reading directly from the group's committed offsets
+// with a throwaway fetcher. It does not mark messages as offered and does not
+// affect ack tracking in any way, so it is safe for support engineers to
+// inspect what is stuck at the head of the partitions of an ailing group.
+// Messages are returned in partition order, sorted by offset within a
+// partition.
+func (p *T) Peek(group, topic string, count int) ([]consumer.Message, error) {
+	if count <= 0 {
+		return nil, errors.Errorf("bad count: %d", count)
+	}
+	partitionOffsets, err := p.GetGroupOffsets(group, topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch group offsets")
+	}
+	if p.kafkaClt == nil {
+		return nil, ErrUnavailable
+	}
+	cons, err := sarama.NewConsumerFromClient(p.kafkaClt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create fetcher")
+	}
+	defer cons.Close()
+
+	sort.Slice(partitionOffsets, func(i, j int) bool {
+		return partitionOffsets[i].Partition < partitionOffsets[j].Partition
+	})
+	var msgs []consumer.Message
+	for _, po := range partitionOffsets {
+		if len(msgs) >= count {
+			break
+		}
+		offset := po.Offset
+		if offset < po.Begin {
+			offset = po.Begin
+		}
+		if offset >= po.End {
+			// The group is fully caught up with this partition.
+			continue
+		}
+		partitionMsgs, err := peekPartition(cons, topic, po.Partition, offset,
+			po.End, count-len(msgs))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to peek partition %d", po.Partition)
+		}
+		msgs = append(msgs, partitionMsgs...)
+	}
+	return msgs, nil
+}
+
+// peekPartition reads up to count messages from a partition starting with the
+// specified offset, but not past the end offset.
+func peekPartition(cons sarama.Consumer, topic string, partition int32,
+	offset, end int64, count int,
+) ([]consumer.Message, error) {
+	pc, err := cons.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	var msgs []consumer.Message
+	for len(msgs) < count {
+		select {
+		case consMsg := <-pc.Messages():
+			msgs = append(msgs, consumer.Message{
+				ConsumerMessage: *consMsg,
+				HighWaterMark:   pc.HighWaterMarkOffset(),
+				ProducerID:      -1,
+				BaseOffset:      -1,
+				BatchIndex:      -1,
+			})
+			if consMsg.Offset+1 >= end {
+				return msgs, nil
+			}
+		case <-time.After(peekFetchTimeout):
+			return msgs, nil
+		}
+	}
+	return msgs, nil
+}
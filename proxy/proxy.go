@@ -1,6 +1,16 @@
+// Package proxy provides the core per-cluster API of Kafka-Pixy: producing,
+// group based consuming with at-least-once acknowledgements, offset
+// management and cluster administration. It is what the HTTP and gRPC
+// frontends call into, and it can just as well be used directly by Go
+// applications that embed Kafka-Pixy as a library. To do the latter either
+// spawn a proxy with SpawnEmbedded, or spawn a service with no API servers
+// configured and obtain proxies from it, then use Produce, Consume, Ack and
+// friends in-process.
 package proxy
 
 import (
+	"context"
+	"regexp"
 	"sync"
 	"time"
 
@@ -10,20 +20,34 @@ import (
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/consumerimpl"
+	"github.com/mailgun/kafka-pixy/consumer/offsettrk"
+	"github.com/mailgun/kafka-pixy/consumer/partitioncsm"
+	"github.com/mailgun/kafka-pixy/emulator"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
-	"github.com/mailgun/kafka-pixy/producer"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	initEventsChMapCapacity = 256
+
+	// How often the committed group offsets are polled by AckSync while it is
+	// waiting for an acknowledged offset to be confirmed as committed.
+	ackCommitPollInterval = 100 * time.Millisecond
 )
 
 var (
 	ErrUnavailable        = errors.New("service is shutting down")
 	ErrDisabled           = errors.New("service is disabled by configuration")
 	ErrHeadersUnsupported = errors.New("headers are not supported with this version of Kafka. Consider changing `kafka.version` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml#L35)")
+	ErrDeadlineExceeded   = errors.New("deadline expired before the broker acknowledged the message, the message may still be written")
+	ErrDraining           = errors.New("service is draining, re-poll another instance")
+	ErrSchemaMismatch     = errors.New("message schema does not match the schema id pinned for the topic")
+	ErrUnknownTopic       = errors.New("topic does not exist and producer.unknown_topic_policy is reject")
+	ErrMessageTooLarge    = errors.New("message is larger than producer.max_message_bytes")
+	ErrThrottled          = errors.New("producer is overloaded, retry later")
 
 	noAck   = Ack{partition: -1}
 	autoAck = Ack{partition: -2}
@@ -36,11 +60,15 @@ type T struct {
 	kafkaClt   sarama.Client
 	offsetMgrF offsetmgr.Factory
 
+	// If not nil, then the proxy is running in emulator mode and all API
+	// calls are served from the in-memory topic store.
+	emulator *emulator.T
+
 	adminMu sync.RWMutex
 	admin   *admin.T
 
 	producerMu sync.RWMutex
-	producer   *producer.T
+	producer   kafkaclt.Producer
 
 	consumerMu sync.RWMutex
 	consumer   consumer.T
@@ -50,6 +78,30 @@ type T struct {
 	// FIXME: limited and should not cause any significant system memory usage.
 	eventsChMapMu sync.RWMutex
 	eventsChMap   map[eventsChID]chan<- consumer.Event
+
+	faultsMu sync.RWMutex
+	faults   []Fault
+
+	wildcardMu   sync.Mutex
+	wildcardSubs map[wildcardSubID]*wildcardSub
+
+	metadataMu          sync.Mutex
+	metadataRefreshedAt time.Time
+
+	drainingMu sync.RWMutex
+	draining   bool
+
+	subsMu      sync.Mutex
+	subActivity map[subID]time.Time
+
+	knownTopicsMu sync.Mutex
+	knownTopics   map[string]none.T
+
+	topicAccess accessList
+	groupAccess accessList
+
+	chunkAsmMu sync.Mutex
+	chunkAsm   map[chunkAsmID]*chunkAssembly
 }
 
 type Ack struct {
@@ -88,30 +140,69 @@ type eventsChID struct {
 	partition int32
 }
 
+// SpawnEmbedded creates a proxy instance for in-process use by an embedding
+// Go application, without any actor plumbing on the caller side. The name is
+// only used to identify the proxy in log records. Call Stop to shut the proxy
+// down gracefully; all consumed offsets are committed to Kafka before it
+// returns.
+func SpawnEmbedded(name string, cfg *config.Proxy) (*T, error) {
+	return Spawn(actor.Root(), name, cfg)
+}
+
 // Spawn creates a proxy instance and starts its internal goroutines.
 func Spawn(parentActDesc *actor.Descriptor, name string, cfg *config.Proxy) (*T, error) {
 	p := T{
-		actDesc:     parentActDesc.NewChild(name),
-		cfg:         cfg,
-		eventsChMap: make(map[eventsChID]chan<- consumer.Event, initEventsChMapCapacity),
+		actDesc:      parentActDesc.NewChild(name),
+		cfg:          cfg,
+		eventsChMap:  make(map[eventsChID]chan<- consumer.Event, initEventsChMapCapacity),
+		wildcardSubs: make(map[wildcardSubID]*wildcardSub),
+		subActivity:  make(map[subID]time.Time),
+		knownTopics:  make(map[string]none.T),
+		chunkAsm:     make(map[chunkAsmID]*chunkAssembly),
 	}
 	var err error
 
-	if p.kafkaClt, err = sarama.NewClient(cfg.Kafka.SeedPeers, cfg.SaramaClientCfg()); err != nil {
+	if p.topicAccess, err = newAccessList(cfg.Access.AllowedTopics, cfg.Access.DeniedTopics); err != nil {
+		return nil, errors.Wrap(err, "invalid topic access list")
+	}
+	if p.groupAccess, err = newAccessList(cfg.Access.AllowedGroups, cfg.Access.DeniedGroups); err != nil {
+		return nil, errors.Wrap(err, "invalid group access list")
+	}
+
+	if cfg.Emulator {
+		p.actDesc.Log().Warn("Running in emulator mode, no Kafka/ZooKeeper cluster is used")
+		p.emulator = emulator.New(cfg)
+		return &p, nil
+	}
+
+	backend, err := kafkaclt.New(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Kafka backend")
+	}
+	if p.kafkaClt, err = backend.NewClient(); err != nil {
 		return nil, errors.Wrap(err, "failed to create Kafka client")
 	}
+	// The client refreshes metadata as part of its creation.
+	p.metadataRefreshedAt = time.Now()
 	p.offsetMgrF = offsetmgr.SpawnFactory(p.actDesc, cfg, p.kafkaClt)
-	if p.producer, err = producer.Spawn(p.actDesc, cfg); err != nil {
+	if p.producer, err = backend.NewProducer(p.actDesc); err != nil {
 		return nil, errors.Wrap(err, "failed to spawn producer")
 	}
 	if !cfg.Consumer.Disabled {
-		if p.consumer, err = consumerimpl.Spawn(p.actDesc, cfg, p.offsetMgrF); err != nil {
+		var deadLetterFn consumer.DeadLetterFn
+		if cfg.Consumer.DeadLetterTopic != "" {
+			deadLetterFn = p.deadLetterFn
+		}
+		if p.consumer, err = consumerimpl.Spawn(p.actDesc, cfg, p.offsetMgrF, deadLetterFn); err != nil {
 			return nil, errors.Wrap(err, "failed to spawn consumer")
 		}
 	}
 	if p.admin, err = admin.Spawn(p.actDesc, cfg); err != nil {
 		return nil, errors.Wrap(err, "failed to spawn admin")
 	}
+	if cfg.Consumer.DeadLetterTopic != "" {
+		p.ensureDeadLetterTopic()
+	}
 	return &p, nil
 }
 
@@ -174,40 +265,148 @@ func (p *T) stopAdmin() {
 // using `key` to identify a destination partition. The exact algorithm used to
 // map keys to partitions is implementation specific but it is guaranteed that
 // it returns consistent results. If `key` is `nil`, then the message is placed
-// into a random partition.
+// into a random partition. If `partition` is not -1, then the message goes
+// exactly there, regardless of the key and the configured partitioner.
 //
 // Errors usually indicate a catastrophic failure of the Kafka cluster, or
 // missing topic if there cluster is not configured to auto create topics.
-func (p *T) Produce(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader) (*sarama.ProducerMessage, error) {
+//
+// The function blocks until the message is acknowledged by the broker or the
+// passed context expires. In the latter case ErrDeadlineExceeded is returned,
+// but note that by that time the message has already been submitted to the
+// producer machinery and may still be written to Kafka.
+func (p *T) Produce(ctx context.Context, topic string, partition int32, key, message kafkaclt.Encoder, headers []kafkaclt.Header) (*kafkaclt.ProducedMsg, error) {
 	if len(headers) > 0 && !p.cfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
 		return nil, ErrHeadersUnsupported
 	}
+	if err := p.checkTopicAccess(topic); err != nil {
+		return nil, err
+	}
+	if err := p.checkProduceThrottle(topic); err != nil {
+		return nil, err
+	}
+	if err := p.checkSchema(topic, message); err != nil {
+		return nil, err
+	}
+	if p.shouldChunk(key, message) {
+		if err := p.checkUnknownTopic(topic); err != nil {
+			return nil, err
+		}
+		if err := p.injectFault(FaultOpProduce, topic, ""); err != nil {
+			return nil, err
+		}
+		return p.produceChunked(ctx, topic, partition, key, message, headers)
+	}
+	if err := p.checkMessageSize(topic, key, message); err != nil {
+		return nil, err
+	}
+	if err := p.checkUnknownTopic(topic); err != nil {
+		return nil, err
+	}
+
+	if err := p.injectFault(FaultOpProduce, topic, ""); err != nil {
+		return nil, err
+	}
+	return p.produceMessage(ctx, topic, partition, key, message, headers)
+}
+
+// produceMessage stamps and submits a single already vetted message to the
+// producer machinery and waits for the broker acknowledgement.
+func (p *T) produceMessage(ctx context.Context, topic string, partition int32, key, message kafkaclt.Encoder, headers []kafkaclt.Header) (*kafkaclt.ProducedMsg, error) {
+	headers = p.stampProducedAt(headers)
+	p.observeProduceTraffic(topic, key, message)
+
+	if p.emulator != nil {
+		return p.emulator.Produce(topic, key, message, headers)
+	}
 
 	p.producerMu.RLock()
 	if p.producer == nil {
 		p.producerMu.RUnlock()
 		return nil, ErrUnavailable
 	}
-	responseCh := p.producer.AsyncProduce(topic, key, message, headers)
+	responseCh := p.producer.AsyncProduce(topic, partition, key, message, headers)
 	p.producerMu.RUnlock()
 
-	rs := <-responseCh
-	return rs.Msg, rs.Err
+	select {
+	case rs := <-responseCh:
+		if rs.Err != nil {
+			p.maybeRefreshMetadata(rs.Err)
+		}
+		return rs.Msg, rs.Err
+	case <-ctx.Done():
+		return nil, ErrDeadlineExceeded
+	}
+}
+
+// PartitionLeader returns the ID and address of the broker that is currently
+// the leader of the specified partition, so that API frontends can include it
+// in produce responses for debugging of latency and duplicate anomalies. In
+// emulator mode there are no brokers and (-1, "") is returned.
+func (p *T) PartitionLeader(topic string, partition int32) (int32, string, error) {
+	if p.emulator != nil {
+		return -1, "", nil
+	}
+	broker, err := p.kafkaClt.Leader(topic, partition)
+	if err != nil {
+		return -1, "", errors.Wrap(err, "failed to get partition leader")
+	}
+	return broker.ID(), broker.Addr(), nil
 }
 
 // AsyncProduce is an asynchronously counterpart of the `Produce` function.
 // Errors are silently ignored.
-func (p *T) AsyncProduce(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader) {
+func (p *T) AsyncProduce(topic string, partition int32, key, message kafkaclt.Encoder, headers []kafkaclt.Header) {
 	if len(headers) > 0 && !p.cfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
 		return
 	}
+	if err := p.checkTopicAccess(topic); err != nil {
+		p.actDesc.Log().WithError(err).Errorf("Dropping message produced to denied topic %s", topic)
+		return
+	}
+	if err := p.checkProduceThrottle(topic); err != nil {
+		// Counted by the throttle check.
+		return
+	}
+	if err := p.checkSchema(topic, message); err != nil {
+		// Async produce is best effort, the rejection has been counted and
+		// logged by the schema check.
+		return
+	}
+	if p.shouldChunk(key, message) {
+		if err := p.checkUnknownTopic(topic); err != nil {
+			p.actDesc.Log().WithError(err).Errorf("Dropping message produced to unknown topic %s", topic)
+			return
+		}
+		go func() {
+			if _, err := p.produceChunked(context.Background(), topic, partition, key, message, headers); err != nil {
+				p.actDesc.Log().WithError(err).Errorf("Failed to produce chunked message to topic %s", topic)
+			}
+		}()
+		return
+	}
+	if err := p.checkMessageSize(topic, key, message); err != nil {
+		// Counted and logged by the size check.
+		return
+	}
+	if err := p.checkUnknownTopic(topic); err != nil {
+		p.actDesc.Log().WithError(err).Errorf("Dropping message produced to unknown topic %s", topic)
+		return
+	}
+	headers = p.stampProducedAt(headers)
+	p.observeProduceTraffic(topic, key, message)
+
+	if p.emulator != nil {
+		p.emulator.Produce(topic, key, message, headers)
+		return
+	}
 
 	p.producerMu.RLock()
 	if p.producer == nil {
 		p.producerMu.RUnlock()
 		return
 	}
-	p.producer.AsyncProduce(topic, key, message, headers)
+	p.producer.AsyncProduce(topic, partition, key, message, headers)
 	p.producerMu.RUnlock()
 }
 
@@ -215,17 +414,73 @@ func (p *T) AsyncProduce(topic string, key, message sarama.Encoder, headers []sa
 // specified consumer group. If there are no more new messages in the topic
 // at the time of the request then it will block for
 // `Config.Consumer.LongPollingTimeout`. If no new message is produced during
-// that time, then `ErrRequestTimeout` is returned.
+// that time, then `ErrRequestTimeout` is returned. If ctx is cancelled or its
+// deadline expires while the call is blocked then the ctx error is returned.
+// A message that the abandoned request may still yield is re-offered after
+// `Config.Consumer.AckTimeout` as usual.
 //
 // Note that during state transitions topic subscribe<->unsubscribe and
 // consumer group register<->deregister the method may return either
 // `ErrBufferOverflow` or `ErrRequestTimeout` even when there are messages
 // available for consumption. In that case the user should back off a bit
 // and then repeat the request.
-func (p *T) Consume(group, topic string, ack Ack) (consumer.Message, error) {
+//
+// If a message produced with producer.chunking enabled is encountered, then
+// its chunks are transparently reassembled and the original message is
+// delivered instead of the individual chunk records.
+func (p *T) Consume(ctx context.Context, group, topic string, ack Ack) (consumer.Message, error) {
+	msg, err := p.consumeOne(ctx, group, topic, ack)
+	if err != nil {
+		return msg, err
+	}
+	ci, ok := parseChunkHeaders(msg)
+	if !ok {
+		return msg, nil
+	}
+	msg, err = p.consumeChunked(ctx, group, topic, msg, ci)
+	if err == nil && ack == autoAck {
+		// The consume call above auto acked the first chunk only, the rest
+		// were pulled with no ack, so ack whatever message is delivered.
+		if deliveredAck, ackErr := NewAck(msg.Partition, msg.Offset); ackErr == nil {
+			if ackErr = p.Ack(group, topic, deliveredAck); ackErr != nil {
+				p.actDesc.Log().WithError(ackErr).Errorf(
+					"Failed to auto ack: group=%s, topic=%s, partition=%d, offset=%d",
+					group, topic, msg.Partition, msg.Offset)
+			}
+		}
+	}
+	return msg, err
+}
+
+// consumeOne pulls a single raw message from the consumer machinery applying
+// all consume time checks, but without any chunk reassembly.
+func (p *T) consumeOne(ctx context.Context, group, topic string, ack Ack) (consumer.Message, error) {
 	if p.cfg.Consumer.Disabled {
 		return consumer.Message{}, ErrDisabled
 	}
+	if p.isDraining() {
+		return consumer.Message{}, ErrDraining
+	}
+	if err := p.checkGroupAccess(group); err != nil {
+		return consumer.Message{}, err
+	}
+
+	if err := p.injectFault(FaultOpConsume, topic, group); err != nil {
+		return consumer.Message{}, err
+	}
+
+	topic, err := p.resolveTopic(group, topic)
+	if err != nil {
+		return consumer.Message{}, err
+	}
+	if err := p.checkTopicAccess(topic); err != nil {
+		return consumer.Message{}, err
+	}
+	p.touchSubscription(group, topic)
+
+	if p.emulator != nil {
+		return p.emulator.Consume(group, topic)
+	}
 
 	if ack != noAck && ack != autoAck {
 		p.eventsChMapMu.RLock()
@@ -255,8 +510,18 @@ func (p *T) Consume(group, topic string, ack Ack) (consumer.Message, error) {
 	responseCh := p.consumer.AsyncConsume(group, topic)
 	p.consumerMu.RUnlock()
 
-	rs := <-responseCh
+	var rs consumer.Response
+	select {
+	case rs = <-responseCh:
+	case <-ctx.Done():
+		// The request keeps running in the consumer machinery. Drain the
+		// response so that a message it may still yield is left unacked and
+		// gets re-offered after the ack timeout.
+		go func() { <-responseCh }()
+		return consumer.Message{}, ctx.Err()
+	}
 	if rs.Err != nil {
+		p.maybeRefreshMetadata(rs.Err)
 		return consumer.Message{}, rs.Err
 	}
 
@@ -268,10 +533,15 @@ func (p *T) Consume(group, topic string, ack Ack) (consumer.Message, error) {
 	if ack == autoAck {
 		rs.Msg.EventsCh <- consumer.Ack(rs.Msg.Offset)
 	}
+	p.observeE2ELatency(rs.Msg)
+	p.observeConsumeTraffic(rs.Msg)
 	return rs.Msg, nil
 }
 
 func (p *T) Ack(group, topic string, ack Ack) error {
+	if p.emulator != nil {
+		return p.emulator.Ack(group, topic)
+	}
 	eventsChID := eventsChID{group, topic, ack.partition}
 	p.eventsChMapMu.RLock()
 	eventsCh, ok := p.eventsChMap[eventsChID]
@@ -287,10 +557,125 @@ func (p *T) Ack(group, topic string, ack Ack) error {
 	return nil
 }
 
+// OffsetRange is a half open [From, To) range of offsets to be acknowledged.
+type OffsetRange struct {
+	From int64
+	To   int64
+}
+
+// AckRanges acknowledges all offsets in the specified [From, To) ranges of a
+// partition in one call, for batch-processing consumers that ack thousands
+// of offsets at once. The acknowledgements are fed to the offset tracker in
+// offset order and are folded into its sparse ack ranges before the next
+// offset commit, so the whole batch is committed together.
+func (p *T) AckRanges(group, topic string, partition int32, ranges []OffsetRange) error {
+	for _, r := range ranges {
+		if r.From < 0 || r.To <= r.From {
+			return errors.Errorf("bad offset range: [%d, %d)", r.From, r.To)
+		}
+	}
+	if p.emulator != nil {
+		return p.emulator.Ack(group, topic)
+	}
+	eventsChID := eventsChID{group, topic, partition}
+	p.eventsChMapMu.RLock()
+	eventsCh, ok := p.eventsChMap[eventsChID]
+	p.eventsChMapMu.RUnlock()
+	if !ok {
+		return errors.Errorf("acks channel missing for %v", eventsChID)
+	}
+	for _, r := range ranges {
+		for offset := r.From; offset < r.To; offset++ {
+			select {
+			case eventsCh <- consumer.Ack(offset):
+			case <-time.After(p.cfg.Consumer.LongPollingTimeout):
+				return errors.Errorf("ack timeout: offset=%d", offset)
+			}
+		}
+	}
+	return nil
+}
+
+// AckSync does the same as Ack, but in addition it blocks until the
+// acknowledged offset is confirmed to have been committed to the offset
+// broker, for clients that need a hard durability guarantee before triggering
+// side effects. An error is returned if the commit is not confirmed within
+// the long polling timeout; the ack itself may still be committed later. If
+// ctx is cancelled or its deadline expires while the call is blocked then the
+// ctx error is returned.
+func (p *T) AckSync(ctx context.Context, group, topic string, ack Ack) error {
+	if err := p.Ack(group, topic, ack); err != nil {
+		return err
+	}
+	if p.emulator != nil {
+		return nil
+	}
+	timeoutCh := time.After(p.cfg.Consumer.LongPollingTimeout)
+	for {
+		committed, err := p.committedOffset(group, topic, ack.partition)
+		if err == nil {
+			ot := offsettrk.New(p.actDesc, committed, 0)
+			if isAcked, _ := ot.IsAcked(ack.offset); isAcked {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeoutCh:
+			return errors.Errorf("commit confirmation timeout: partition=%d, offset=%d",
+				ack.partition, ack.offset)
+		case <-time.After(ackCommitPollInterval):
+		}
+	}
+}
+
+// committedOffset returns the offset committed by the specified group for a
+// topic partition, along with the sparse ack metadata.
+func (p *T) committedOffset(group, topic string, partition int32) (offsetmgr.Offset, error) {
+	partitionOffsets, err := p.GetGroupOffsets(group, topic)
+	if err != nil {
+		return offsetmgr.Offset{}, err
+	}
+	for _, po := range partitionOffsets {
+		if po.Partition == partition {
+			return offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}, nil
+		}
+	}
+	return offsetmgr.Offset{}, errors.Errorf("unknown partition: %d", partition)
+}
+
+// Cfg returns the proxy configuration. It is used by API frontends to look
+// up response semantics knobs.
+func (p *T) Cfg() *config.Proxy {
+	return p.cfg
+}
+
+// BeginDraining makes subsequent Consume calls fail fast with ErrDraining,
+// that API frontends translate into an explicit signal for clients to re-poll
+// another instance. It is called when the service starts shutting down, before
+// the proxy itself is stopped, so that streaming clients are not cut off
+// abruptly mid-message. Acknowledgements are still accepted while draining.
+func (p *T) BeginDraining() {
+	p.drainingMu.Lock()
+	p.draining = true
+	p.drainingMu.Unlock()
+	p.actDesc.Log().Info("Draining consume requests")
+}
+
+func (p *T) isDraining() bool {
+	p.drainingMu.RLock()
+	defer p.drainingMu.RUnlock()
+	return p.draining
+}
+
 // GetGroupOffsets for every partition of the specified topic it returns the
 // current offset range along with the latest offset and metadata committed by
 // the specified consumer group.
 func (p *T) GetGroupOffsets(group, topic string) ([]admin.PartitionOffset, error) {
+	if p.emulator != nil {
+		return p.emulator.GetGroupOffsets(group, topic)
+	}
 	p.adminMu.RLock()
 	defer p.adminMu.RUnlock()
 	if p.admin == nil {
@@ -299,9 +684,77 @@ func (p *T) GetGroupOffsets(group, topic string) ([]admin.PartitionOffset, error
 	return p.admin.GetGroupOffsets(group, topic)
 }
 
+// CloneGroupOffsets copies the offsets committed by the source group for a
+// topic into the destination group, so that a parallel consumer, e.g. for
+// shadow testing, can be spun up starting exactly where the source group is.
+// The destination group must not have any offsets committed for the topic.
+func (p *T) CloneGroupOffsets(fromGroup, toGroup, topic string) ([]admin.PartitionOffset, error) {
+	if p.emulator != nil {
+		return nil, ErrUnavailable
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return p.admin.CloneGroupOffsets(fromGroup, toGroup, topic)
+}
+
+// PreviewGroupOffsets reports where a reset of the committed offsets of the
+// specified group to the given time would move each partition of a topic and
+// how many messages each move would replay or skip. Nothing is committed, the
+// function is a dry run for SetGroupOffsets that lets operators sanity-check
+// a reset before performing it.
+func (p *T) PreviewGroupOffsets(group, topic string, t time.Time) ([]admin.OffsetTimePreview, error) {
+	if p.emulator != nil {
+		return nil, ErrUnavailable
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return p.admin.PreviewGroupOffsets(group, topic, t)
+}
+
+// ExportGroup snapshots the state of a consumer group, that is subscriptions
+// of its registered members, partition ownership, and committed offsets, to a
+// portable document that can later be fed to RestoreGroup, e.g. on a
+// different ZooKeeper ensemble or Kafka-Pixy fleet.
+func (p *T) ExportGroup(group string) (admin.GroupExport, error) {
+	if p.emulator != nil {
+		return admin.GroupExport{}, ErrUnavailable
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return admin.GroupExport{}, ErrUnavailable
+	}
+	return p.admin.ExportGroup(group)
+}
+
+// RestoreGroup commits the offsets recorded in a group snapshot on behalf of
+// the specified group. Subscriptions and partition ownership are runtime
+// state that consumers recreate when they join the group, so only the
+// offsets part of the snapshot is applied.
+func (p *T) RestoreGroup(group string, exp admin.GroupExport) error {
+	if p.emulator != nil {
+		return ErrUnavailable
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return ErrUnavailable
+	}
+	return p.admin.RestoreGroup(group, exp)
+}
+
 // SetGroupOffsets commits specific offset values along with metadata for a list
 // of partitions of a particular topic on behalf of the specified group.
 func (p *T) SetGroupOffsets(group, topic string, offsets []admin.PartitionOffset) error {
+	if p.emulator != nil {
+		return p.emulator.SetGroupOffsets(group, topic, offsets)
+	}
 	p.adminMu.RLock()
 	defer p.adminMu.RUnlock()
 	if p.admin == nil {
@@ -310,9 +763,51 @@ func (p *T) SetGroupOffsets(group, topic string, offsets []admin.PartitionOffset
 	return p.admin.SetGroupOffsets(group, topic, offsets)
 }
 
+// SkipToLive fast-forwards the offsets committed by the specified group for
+// all partitions of the specified topic to the respective newest offsets,
+// effectively dropping the backlog. It is an incident response tool for cases
+// when processing the backlog is pointless. Group members may keep consuming
+// while the operation is in progress, so some in-flight backlog messages may
+// still be offered after it completes. The committed offsets are returned.
+func (p *T) SkipToLive(group, topic string) ([]admin.PartitionOffset, error) {
+	offsets, err := p.GetGroupOffsets(group, topic)
+	if err != nil {
+		return nil, err
+	}
+	for i := range offsets {
+		offsets[i].Offset = offsets[i].End
+		offsets[i].Metadata = ""
+	}
+	if err := p.SetGroupOffsets(group, topic, offsets); err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// ResetGroupOffsets commits the given offsets on behalf of the specified
+// group and rewinds partition consumers of the group running in this
+// instance, so the reset takes effect without restarting Kafka-Pixy. The
+// partitions that had a local consumer rewound are returned in ascending
+// order. Group members consuming via other instances are not coordinated
+// with: they keep consuming from their in-memory positions until their
+// partitions are rebalanced or they are reset via their own instance.
+func (p *T) ResetGroupOffsets(group, topic string, offsets []admin.PartitionOffset) ([]int32, error) {
+	if err := p.SetGroupOffsets(group, topic, offsets); err != nil {
+		return nil, err
+	}
+	targets := make(map[int32]int64, len(offsets))
+	for _, po := range offsets {
+		targets[po.Partition] = po.Offset
+	}
+	return partitioncsm.ResetOffsets(group, topic, targets), nil
+}
+
 // GetTopicConsumers returns client-id -> consumed-partitions-list mapping
 // for a clients from a particular consumer group and a particular topic.
 func (p *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
+	if p.emulator != nil {
+		return map[string][]int32{}, nil
+	}
 	p.adminMu.RLock()
 	defer p.adminMu.RUnlock()
 	if p.admin == nil {
@@ -325,6 +820,9 @@ func (p *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
 // mapping for a particular topic. Warning, the function performs scan of all
 // consumer groups registered in ZooKeeper and therefore can take a lot of time.
 func (p *T) GetAllTopicConsumers(topic string) (map[string]map[string][]int32, error) {
+	if p.emulator != nil {
+		return map[string]map[string][]int32{}, nil
+	}
 	p.adminMu.RLock()
 	defer p.adminMu.RUnlock()
 	if p.admin == nil {
@@ -333,19 +831,141 @@ func (p *T) GetAllTopicConsumers(topic string) (map[string]map[string][]int32, e
 	return p.admin.GetAllTopicConsumers(topic)
 }
 
-// ListTopics returns a list of all topics existing in the Kafka cluster.
-func (p *T) ListTopics(withPartitions, withConfig bool) ([]admin.TopicMetadata, error) {
+// CreateTopic creates a topic with the given number of partitions,
+// replication factor and optional topic level config entries. Creating a
+// topic that already exists is not an error.
+func (p *T) CreateTopic(topic string, numPartitions int32, replicationFactor int16, config map[string]string) error {
+	if p.emulator != nil {
+		return ErrUnavailable
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return ErrUnavailable
+	}
+	return p.admin.CreateTopic(topic, numPartitions, replicationFactor, config)
+}
+
+// DeleteTopic deletes a topic along with all its messages. The operation is
+// irreversible and must be explicitly enabled for the cluster via the
+// admin.allow_topic_deletion parameter.
+func (p *T) DeleteTopic(topic string) error {
+	if p.emulator != nil {
+		return ErrUnavailable
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return ErrUnavailable
+	}
+	return p.admin.DeleteTopic(topic)
+}
+
+// AlterTopicConfig sets the specified topic level config entries, keeping
+// entries that are not mentioned intact.
+func (p *T) AlterTopicConfig(topic string, config map[string]string) error {
+	if p.emulator != nil {
+		return ErrUnavailable
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return ErrUnavailable
+	}
+	return p.admin.AlterTopicConfig(topic, config)
+}
+
+// ListConsumerGroups returns all consumer groups known to the cluster along
+// with their registered members, keyed by group name and member client id
+// respectively. Warning, the function performs a scan of all consumer groups
+// and therefore can take a lot of time.
+func (p *T) ListConsumerGroups() (map[string]map[string]admin.GroupMemberInfo, error) {
+	if p.emulator != nil {
+		return map[string]map[string]admin.GroupMemberInfo{}, nil
+	}
 	p.adminMu.RLock()
 	defer p.adminMu.RUnlock()
 	if p.admin == nil {
 		return nil, ErrUnavailable
 	}
-	return p.admin.ListTopics(withPartitions, withConfig)
+	return p.admin.ListConsumerGroups()
+}
+
+// DeleteRecords deletes all records of a topic partition with offsets older
+// than beforeOffset and returns the new low watermark of the partition. The
+// operation is irreversible and must be explicitly enabled for the cluster
+// via the admin.allow_records_deletion parameter.
+func (p *T) DeleteRecords(topic string, partition int32, beforeOffset int64) (int64, error) {
+	if p.emulator != nil {
+		return 0, ErrUnavailable
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return 0, ErrUnavailable
+	}
+	return p.admin.DeleteRecords(topic, partition, beforeOffset)
+}
+
+// GetGroupsLag returns group -> topic -> partition-lag-list mapping for every
+// consumer group in the cluster that has committed offsets, not only the
+// groups managed by this Kafka-Pixy instance. If a filter is given then only
+// groups whose name matches it are reported, and offset and limit paginate
+// the alphabetically sorted group list. Warning, without a limit the function
+// queries the coordinator of every group and therefore can take a lot of
+// time.
+func (p *T) GetGroupsLag(filter *regexp.Regexp, offset, limit int) (map[string]map[string][]admin.GroupPartitionLag, error) {
+	if p.emulator != nil {
+		return map[string]map[string][]admin.GroupPartitionLag{}, nil
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return p.admin.GetGroupsLag(filter, offset, limit)
+}
+
+// ListTopics returns a list of topics existing in the Kafka cluster, sorted
+// by name. If a filter is given then only topics whose name matches it are
+// reported, and offset and limit paginate the sorted list.
+func (p *T) ListTopics(filter *regexp.Regexp, offset, limit int, withPartitions, withConfig bool) ([]admin.TopicMetadata, error) {
+	if p.emulator != nil {
+		tms, err := p.emulator.ListTopics()
+		if err != nil {
+			return nil, err
+		}
+		selected := make([]admin.TopicMetadata, 0, len(tms))
+		for _, tm := range tms {
+			if filter == nil || filter.MatchString(tm.Topic) {
+				selected = append(selected, tm)
+			}
+		}
+		if offset > 0 {
+			if offset >= len(selected) {
+				return nil, nil
+			}
+			selected = selected[offset:]
+		}
+		if limit > 0 && len(selected) > limit {
+			selected = selected[:limit]
+		}
+		return selected, nil
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return p.admin.ListTopics(filter, offset, limit, withPartitions, withConfig)
 }
 
 // GetTopicMetadata returns a topic metadata. An optional partition metadata
 // can be requested and/or detailed topic configuration can be requested.
 func (p *T) GetTopicMetadata(topic string, withPartitions, withConfig bool) (admin.TopicMetadata, error) {
+	if p.emulator != nil {
+		return p.emulator.GetTopicMetadata(topic)
+	}
 	p.adminMu.RLock()
 	defer p.adminMu.RUnlock()
 	if p.admin == nil {
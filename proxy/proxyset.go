@@ -1,13 +1,21 @@
 package proxy
 
 import (
+	"sort"
+	"sync"
+
 	"github.com/pkg/errors"
 )
 
-// Set represents a collection of proxy.T instances with a default value.
+// Set represents a collection of proxy.T instances with a default value. In
+// degraded startup mode proxies whose clusters were not reachable at boot are
+// missing from the set and are added via Put as they come online.
 type Set struct {
-	proxies    map[string]*T
-	defaultPxy *T
+	mu             sync.RWMutex
+	proxies        map[string]*T
+	pending        map[string]bool
+	defaultCluster string
+	restartFn      func(cluster string) error
 }
 
 // NewSet creates a proxy.Set from a cluster-to-proxy map and a default proxy.
@@ -18,17 +26,125 @@ func NewSet(proxies map[string]*T, defaultPxy *T) *Set {
 	if defaultPxy == nil {
 		panic("default proxy must be provided")
 	}
-	return &Set{proxies: proxies, defaultPxy: defaultPxy}
+	s := &Set{proxies: proxies, pending: make(map[string]bool)}
+	for cluster, pxy := range proxies {
+		if pxy == defaultPxy {
+			s.defaultCluster = cluster
+		}
+	}
+	return s
+}
+
+// NewDegradedSet creates a proxy.Set that initially contains no proxies at
+// all. Every configured cluster is reported as pending until a proxy is added
+// for it via Put, and API calls addressed to a pending cluster fail.
+func NewDegradedSet(clusters []string, defaultCluster string) *Set {
+	if len(clusters) < 1 {
+		panic("set must serve at least one cluster")
+	}
+	s := &Set{
+		proxies:        make(map[string]*T, len(clusters)),
+		pending:        make(map[string]bool, len(clusters)),
+		defaultCluster: defaultCluster,
+	}
+	for _, cluster := range clusters {
+		s.pending[cluster] = true
+	}
+	return s
+}
+
+// Put adds a proxy for a cluster and marks the cluster as ready. It is called
+// in degraded startup mode when a cluster that was unreachable at boot
+// finally comes online.
+func (s *Set) Put(cluster string, pxy *T) {
+	s.mu.Lock()
+	s.proxies[cluster] = pxy
+	delete(s.pending, cluster)
+	s.mu.Unlock()
+}
+
+// MarkPending removes the proxy of a cluster from the set while a
+// replacement is being spawned, so that API calls addressed to the cluster
+// fail with a not ready error instead of being served by a stopping proxy.
+func (s *Set) MarkPending(cluster string) {
+	s.mu.Lock()
+	delete(s.proxies, cluster)
+	s.pending[cluster] = true
+	s.mu.Unlock()
+}
+
+// SetRestarter registers the callback that Restart delegates to. It is
+// provided by the service that owns the proxy lifecycle, before the API
+// servers are started.
+func (s *Set) SetRestarter(restart func(cluster string) error) {
+	s.restartFn = restart
+}
+
+// Restart stops the proxy serving the cluster and spawns a replacement from
+// the same configuration. If cluster is an empty string then the default
+// cluster proxy is restarted.
+func (s *Set) Restart(cluster string) error {
+	if s.restartFn == nil {
+		return errors.New("proxy restart is not supported")
+	}
+	if cluster == "" {
+		cluster = s.defaultCluster
+	}
+	s.mu.RLock()
+	known := s.proxies[cluster] != nil || s.pending[cluster]
+	s.mu.RUnlock()
+	if !known {
+		return errors.Errorf("proxy `%s` does not exist", cluster)
+	}
+	return s.restartFn(cluster)
 }
 
 // Get returns a proxy for a cluster name. If there is no proxy configured for
 // the cluster name, then the default proxy is returned.
 func (s *Set) Get(cluster string) (*T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if cluster == "" {
-		return s.defaultPxy, nil
+		cluster = s.defaultCluster
 	}
 	if pxy := s.proxies[cluster]; pxy != nil {
 		return pxy, nil
 	}
+	if s.pending[cluster] {
+		return nil, errors.Errorf("proxy `%s` is not ready", cluster)
+	}
 	return nil, errors.Errorf("proxy `%s` does not exist", cluster)
 }
+
+// Clusters returns the names of all clusters served by the set sorted
+// alphabetically, along with the name of the default cluster. Clusters whose
+// proxies are still pending are included.
+func (s *Set) Clusters() ([]string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	clusters := make([]string, 0, len(s.proxies)+len(s.pending))
+	for cluster := range s.proxies {
+		clusters = append(clusters, cluster)
+	}
+	for cluster := range s.pending {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	return clusters, s.defaultCluster
+}
+
+// Readiness reports for every configured cluster whether its proxy is up and
+// running, along with an aggregate flag that is true only when all of them
+// are.
+func (s *Set) Readiness() (map[string]bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	readiness := make(map[string]bool, len(s.proxies)+len(s.pending))
+	for cluster := range s.proxies {
+		readiness[cluster] = true
+	}
+	for cluster := range s.pending {
+		readiness[cluster] = false
+	}
+	return readiness, len(s.pending) == 0
+}
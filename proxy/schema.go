@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"encoding/binary"
+
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+	"github.com/mailgun/kafka-pixy/metrics"
+)
+
+// Size of the Confluent wire format prefix: a zero magic byte followed by a
+// big-endian 32 bit schema id.
+const schemaPrefixLen = 5
+
+// checkSchema verifies that a message produced to a topic pinned to a schema
+// id carries exactly that id in its Confluent wire format prefix. Topics
+// without a pin are not checked. It returns ErrSchemaMismatch if the message
+// is not in the wire format or carries a different schema id, protecting
+// downstream consumers from accidental schema drift through the proxy.
+func (p *T) checkSchema(topic string, message kafkaclt.Encoder) error {
+	pinnedID, ok := p.cfg.Producer.SchemaIDByTopic[topic]
+	if !ok {
+		return nil
+	}
+	schemaID, ok := extractSchemaID(message)
+	if !ok || schemaID != pinnedID {
+		p.actDesc.Log().Errorf(
+			"Schema mismatch: topic=%s, pinned=%d, got=%d, wireFormat=%t",
+			topic, pinnedID, schemaID, ok)
+		metrics.Inc("producer.schema_rejected")
+		return ErrSchemaMismatch
+	}
+	return nil
+}
+
+// extractSchemaID returns the schema id from the Confluent wire format prefix
+// of the encoded message, or false if the message is not in the wire format.
+func extractSchemaID(message kafkaclt.Encoder) (int32, bool) {
+	if message == nil {
+		return 0, false
+	}
+	encoded, err := message.Encode()
+	if err != nil || len(encoded) < schemaPrefixLen || encoded[0] != 0 {
+		return 0, false
+	}
+	return int32(binary.BigEndian.Uint32(encoded[1:schemaPrefixLen])), true
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/pkg/errors"
+)
+
+// checkMessageSize rejects a message whose key and body together exceed
+// producer.max_message_bytes before it is submitted to the broker, so that
+// oversize messages fail fast with a descriptive error instead of bouncing
+// off the broker after buffering and retries. The error mentions the
+// max.message.bytes setting of the topic when it can be determined, since
+// that is the limit the operator most likely wants to compare against.
+func (p *T) checkMessageSize(topic string, key, message kafkaclt.Encoder) error {
+	maxBytes := p.cfg.Producer.MaxMessageBytes
+	if maxBytes <= 0 {
+		return nil
+	}
+	size := 0
+	if key != nil {
+		size += key.Length()
+	}
+	if message != nil {
+		size += message.Length()
+	}
+	if size <= maxBytes {
+		return nil
+	}
+	metrics.Inc("producer.oversize_rejected")
+	p.actDesc.Log().Errorf("Oversize message rejected: topic=%s, size=%d, max=%d",
+		topic, size, maxBytes)
+	if brokerMax := p.topicMaxMessageBytes(topic); brokerMax != "" {
+		return errors.Wrapf(ErrMessageTooLarge,
+			"message is %d bytes, producer.max_message_bytes is %d, topic max.message.bytes is %s",
+			size, maxBytes, brokerMax)
+	}
+	return errors.Wrapf(ErrMessageTooLarge,
+		"message is %d bytes, producer.max_message_bytes is %d", size, maxBytes)
+}
+
+// topicMaxMessageBytes returns the max.message.bytes setting effective for
+// the topic, or an empty string if it cannot be determined, e.g. because the
+// admin backend is not available. The lookup is only performed for messages
+// that are already being rejected, so its cost is not on the produce path.
+func (p *T) topicMaxMessageBytes(topic string) string {
+	tm, err := p.GetTopicMetadata(topic, false, true)
+	if err != nil || tm.Config == nil {
+		return ""
+	}
+	return tm.Config.Config["max.message.bytes"]
+}
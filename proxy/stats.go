@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+	"github.com/mailgun/kafka-pixy/metrics"
+)
+
+// Prefixes of the per-topic traffic metric names that API frontends group
+// stats responses by.
+const (
+	TrafficProducePrefix = "produce."
+	TrafficConsumePrefix = "consume."
+)
+
+// observeProduceTraffic records the size of a message submitted for
+// production in the per-topic traffic sliding windows.
+func (p *T) observeProduceTraffic(topic string, key, message kafkaclt.Encoder) {
+	size := 0
+	if key != nil {
+		size += key.Length()
+	}
+	if message != nil {
+		size += message.Length()
+	}
+	metrics.ObserveTraffic(TrafficProducePrefix+topic, int64(size))
+}
+
+// observeConsumeTraffic records the size of a consumed message in the
+// per-topic traffic sliding windows.
+func (p *T) observeConsumeTraffic(msg consumer.Message) {
+	metrics.ObserveTraffic(TrafficConsumePrefix+msg.Topic, int64(len(msg.Key)+len(msg.Value)))
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"sort"
+	"time"
+)
+
+type subID struct {
+	group string
+	topic string
+}
+
+// SubscriptionInfo describes a group subscription that has been seen by the
+// proxy, and how long ago the latest consume call for it was made.
+type SubscriptionInfo struct {
+	Group   string
+	Topic   string
+	IdleFor time.Duration
+
+	// Expired is true when the subscription has been idle for longer than
+	// consumer.subscription_timeout, meaning the consumer group machinery has
+	// released (or is about to release) the partitions claimed on its behalf.
+	Expired bool
+}
+
+// touchSubscription records a consume call made on behalf of the specified
+// group subscription. It is used for the idle time accounting only, the
+// subscription lifecycle itself is managed by the consumer machinery.
+func (p *T) touchSubscription(group, topic string) {
+	now := time.Now()
+	p.subsMu.Lock()
+	p.subActivity[subID{group, topic}] = now
+	p.subsMu.Unlock()
+}
+
+// ListSubscriptions returns the group subscriptions known to the proxy along
+// with their idle times, sorted by group and then by topic. Subscriptions
+// that stay idle for twice the subscription timeout are dropped from the
+// accounting with a distinct log event, so that abandoned consumers can be
+// told apart from merely slow ones.
+func (p *T) ListSubscriptions() []SubscriptionInfo {
+	now := time.Now()
+	p.subsMu.Lock()
+	subs := make([]SubscriptionInfo, 0, len(p.subActivity))
+	for id, latestRqTime := range p.subActivity {
+		idleFor := now.Sub(latestRqTime)
+		if idleFor >= 2*p.cfg.Consumer.SubscriptionTimeout {
+			p.actDesc.Log().Infof("Idle subscription dropped: group=%s, topic=%s, idle=%v",
+				id.group, id.topic, idleFor)
+			delete(p.subActivity, id)
+			continue
+		}
+		subs = append(subs, SubscriptionInfo{
+			Group:   id.group,
+			Topic:   id.topic,
+			IdleFor: idleFor,
+			Expired: idleFor >= p.cfg.Consumer.SubscriptionTimeout,
+		})
+	}
+	p.subsMu.Unlock()
+
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].Group != subs[j].Group {
+			return subs[i].Group < subs[j].Group
+		}
+		return subs[i].Topic < subs[j].Topic
+	})
+	return subs
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"sort"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/pkg/errors"
+)
+
+// Tail returns up to count of the most recently produced messages of the
+// topic, computed from the high watermarks backwards, for quick "what is
+// flowing right now" checks. If partition is non-negative then only that
+// partition is tailed, otherwise the tails of all partitions are merged by
+// timestamp. Like Peek it reads with a throwaway fetcher and has no effect
+// on any consumer group state.
+func (p *T) Tail(topic string, partition int32, count int) ([]consumer.Message, error) {
+	if count <= 0 {
+		return nil, errors.Errorf("bad count: %d", count)
+	}
+	if p.kafkaClt == nil {
+		return nil, ErrUnavailable
+	}
+	partitions, err := p.kafkaClt.Partitions(topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch partitions")
+	}
+	if partition >= 0 {
+		partitions = []int32{partition}
+	}
+	cons, err := sarama.NewConsumerFromClient(p.kafkaClt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create fetcher")
+	}
+	defer cons.Close()
+
+	var msgs []consumer.Message
+	for _, p2t := range partitions {
+		newest, err := p.kafkaClt.GetOffset(topic, p2t, sarama.OffsetNewest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch newest offset, partition=%d", p2t)
+		}
+		oldest, err := p.kafkaClt.GetOffset(topic, p2t, sarama.OffsetOldest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch oldest offset, partition=%d", p2t)
+		}
+		begin := newest - int64(count)
+		if begin < oldest {
+			begin = oldest
+		}
+		if begin >= newest {
+			// The partition is empty or fully truncated.
+			continue
+		}
+		partitionMsgs, err := peekPartition(cons, topic, p2t, begin, newest, count)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to tail partition %d", p2t)
+		}
+		msgs = append(msgs, partitionMsgs...)
+	}
+	// Merge the partition tails by timestamp and keep the most recent count
+	// messages. The sort is stable so ties preserve partition-offset order.
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Timestamp.Before(msgs[j].Timestamp)
+	})
+	if len(msgs) > count {
+		msgs = msgs[len(msgs)-count:]
+	}
+	return msgs, nil
+}
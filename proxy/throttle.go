@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/pkg/errors"
+)
+
+// checkProduceThrottle rejects the produce request if the producer has
+// engaged backpressure throttling, that is the count of messages pending
+// broker acknowledgement has crossed producer.throttle_high_watermark.
+// Shedding requests up front with an explicit throttle error lets clients
+// back off and retry, instead of the proxy buffering unboundedly while the
+// cluster is slow. Throttle state transitions are logged by the producer, so
+// rejections are only counted here to avoid a log record per request.
+func (p *T) checkProduceThrottle(topic string) error {
+	p.producerMu.RLock()
+	prod := p.producer
+	p.producerMu.RUnlock()
+	if prod == nil || !prod.Throttled() {
+		return nil
+	}
+	metrics.Inc("producer.throttle_rejected")
+	return errors.Wrapf(ErrThrottled, "topic %s", topic)
+}
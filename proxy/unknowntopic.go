@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+// checkUnknownTopic enforces producer.unknown_topic_policy for a message
+// produced to the topic. With the default broker policy it is a no-op and
+// whether the message creates the topic is up to the
+// auto.create.topics.enable setting of the cluster. Otherwise a topic that is
+// missing from the cluster metadata is either rejected with ErrUnknownTopic
+// or created with the configured number of partitions and replication factor.
+// Topics that are seen to exist are remembered for the lifetime of the proxy,
+// so the metadata is only consulted for newcomers.
+func (p *T) checkUnknownTopic(topic string) error {
+	if p.cfg.Producer.UnknownTopicPolicy == config.UnknownTopicBroker || p.emulator != nil {
+		return nil
+	}
+	p.knownTopicsMu.Lock()
+	_, known := p.knownTopics[topic]
+	p.knownTopicsMu.Unlock()
+	if known {
+		return nil
+	}
+	_, err := p.kafkaClt.Partitions(topic)
+	if err == sarama.ErrUnknownTopicOrPartition {
+		if p.cfg.Producer.UnknownTopicPolicy == config.UnknownTopicReject {
+			metrics.Inc("producer.topic_rejected")
+			return ErrUnknownTopic
+		}
+		if err = p.createTopic(topic); err != nil {
+			return errors.Wrapf(err, "failed to create topic %s", topic)
+		}
+		p.actDesc.Log().Infof("Auto-created topic: %s, partitions=%d, replication_factor=%d",
+			topic, p.cfg.Producer.UnknownTopicPartitions, p.cfg.Producer.UnknownTopicReplicationFactor)
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to check that topic %s exists", topic)
+	}
+	p.knownTopicsMu.Lock()
+	p.knownTopics[topic] = none.V
+	p.knownTopicsMu.Unlock()
+	return nil
+}
+
+// createTopic creates the topic with the configured partition count and
+// replication factor and refreshes the client metadata to pick it up.
+func (p *T) createTopic(topic string) error {
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return ErrUnavailable
+	}
+	err := p.admin.CreateTopic(topic,
+		p.cfg.Producer.UnknownTopicPartitions, p.cfg.Producer.UnknownTopicReplicationFactor, nil)
+	if err != nil {
+		return err
+	}
+	return p.kafkaClt.RefreshMetadata(topic)
+}
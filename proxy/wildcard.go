@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Topics passed to Consume that start with this prefix are treated as
+	// regular expressions and are expanded against the topics that exist in
+	// the cluster.
+	wildcardPrefix = "~"
+
+	// How often the matched topic set of a wildcard subscription is refreshed
+	// against the cluster metadata. Newly created topics that match the
+	// pattern are picked up automatically on refresh, mid-subscription.
+	wildcardRefreshInterval = 10 * time.Second
+
+	// A wildcard subscription that has not been used to resolve a topic for
+	// this long is dropped, so that the subscription table does not grow
+	// forever with every (group, pattern) pair ever probed.
+	wildcardSubExpiry = 15 * time.Minute
+)
+
+type wildcardSubID struct {
+	group   string
+	pattern string
+}
+
+type wildcardSub struct {
+	// The compiled pattern, immutable after creation.
+	re *regexp.Regexp
+
+	// lastUsedAt is guarded by T.wildcardMu, the fields below by mu.
+	lastUsedAt time.Time
+
+	mu          sync.Mutex
+	topics      []string
+	rrIdx       int
+	refreshedAt time.Time
+	refreshing  bool
+}
+
+// resolveTopic expands a wildcard topic to a concrete topic to consume from.
+// Non wildcard topics are returned as is. Matched topics are consumed in a
+// round-robin fashion across successive calls, and the matched set is
+// re-discovered every wildcardRefreshInterval, so clients do not need to
+// resubscribe when matching topics are created.
+func (p *T) resolveTopic(group, topic string) (string, error) {
+	if !strings.HasPrefix(topic, wildcardPrefix) {
+		return topic, nil
+	}
+	pattern := topic[len(wildcardPrefix):]
+	sub, err := p.wildcardSub(group, pattern)
+	if err != nil {
+		return "", err
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.refreshedAt.IsZero() {
+		// The very first resolution has no topic set to serve from, so the
+		// initial discovery is awaited. Only this subscription is blocked by
+		// it: the subscription table mutex is not held across the metadata
+		// request, so other groups and patterns are unaffected.
+		matched, err := p.discoverWildcardTopics(sub.re)
+		if err != nil {
+			return "", err
+		}
+		p.logWildcardExpansion(group, pattern, matched, sub.topics)
+		sub.topics = matched
+		sub.refreshedAt = time.Now()
+	} else if time.Since(sub.refreshedAt) >= wildcardRefreshInterval && !sub.refreshing {
+		// Subsequent refreshes are performed in the background, resolutions
+		// keep being served from the current topic set meanwhile, so a slow
+		// metadata request does not stall the consume hot path.
+		sub.refreshing = true
+		actor.Spawn(p.actDesc.NewChild("wildcard_refresh"), nil, func() {
+			matched, err := p.discoverWildcardTopics(sub.re)
+			sub.mu.Lock()
+			defer sub.mu.Unlock()
+			sub.refreshing = false
+			sub.refreshedAt = time.Now()
+			if err != nil {
+				p.actDesc.Log().WithError(err).Warnf(
+					"Failed to refresh wildcard subscription: group=%s, pattern=%s",
+					group, pattern)
+				return
+			}
+			p.logWildcardExpansion(group, pattern, matched, sub.topics)
+			sub.topics = matched
+		})
+	}
+	if len(sub.topics) == 0 {
+		return "", errors.Errorf("no topics match pattern: %s", pattern)
+	}
+	concreteTopic := sub.topics[sub.rrIdx%len(sub.topics)]
+	sub.rrIdx++
+	return concreteTopic, nil
+}
+
+// wildcardSub returns the subscription state of the (group, pattern) pair,
+// creating it if it is seen for the first time, and drops subscriptions that
+// have been idle for wildcardSubExpiry along the way. The subscription table
+// mutex is only held for the map manipulation, never across metadata
+// requests.
+func (p *T) wildcardSub(group, pattern string) (*wildcardSub, error) {
+	p.wildcardMu.Lock()
+	defer p.wildcardMu.Unlock()
+	now := time.Now()
+	for id, sub := range p.wildcardSubs {
+		if now.Sub(sub.lastUsedAt) > wildcardSubExpiry {
+			delete(p.wildcardSubs, id)
+		}
+	}
+	subID := wildcardSubID{group, pattern}
+	sub := p.wildcardSubs[subID]
+	if sub == nil {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad topic pattern: %s", pattern)
+		}
+		sub = &wildcardSub{re: re}
+		p.wildcardSubs[subID] = sub
+	}
+	sub.lastUsedAt = now
+	return sub, nil
+}
+
+// discoverWildcardTopics returns the topics that exist in the cluster and
+// match the pattern.
+func (p *T) discoverWildcardTopics(re *regexp.Regexp) ([]string, error) {
+	topicsMetadata, err := p.ListTopics(nil, 0, 0, false, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list topics")
+	}
+	var matched []string
+	for _, tm := range topicsMetadata {
+		if re.MatchString(tm.Topic) {
+			matched = append(matched, tm.Topic)
+		}
+	}
+	return matched, nil
+}
+
+func (p *T) logWildcardExpansion(group, pattern string, matched, prevTopics []string) {
+	for _, newTopic := range diffTopics(matched, prevTopics) {
+		p.actDesc.Log().Infof("Wildcard subscription expanded: group=%s, pattern=%s, topic=%s",
+			group, pattern, newTopic)
+	}
+}
+
+// diffTopics returns elements of `topics` that are missing from `prevTopics`.
+func diffTopics(topics, prevTopics []string) []string {
+	var diff []string
+	for _, topic := range topics {
+		known := false
+		for _, prevTopic := range prevTopics {
+			if topic == prevTopic {
+				known = true
+				break
+			}
+		}
+		if !known {
+			diff = append(diff, topic)
+		}
+	}
+	return diff
+}
@@ -19,3 +19,9 @@ python -m grpc.tools.protoc -I=$include_dir --python_out=$python_out_dir --grpc_
 # Generate Golang code
 golang_out_dir=$out_dir/golang
 protoc $proto_file --go_out=plugins=grpc:$golang_out_dir
+
+# Generate Java code. Requires the protoc-gen-grpc-java plugin, see
+# https://github.com/grpc/grpc-java/tree/master/compiler for installation.
+java_out_dir=$out_dir/java
+mkdir -p $java_out_dir
+protoc -I=$include_dir --java_out=$java_out_dir --grpc-java_out=$java_out_dir $proto_file
@@ -12,13 +12,17 @@ import (
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/offsettrk"
 	"github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/mailgun/kafka-pixy/tracing"
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -37,9 +41,9 @@ type T struct {
 
 // New creates a gRPC server instance.
 func New(addr string, proxySet *proxy.Set, srvOpts ...grpc.ServerOption) (*T, error) {
-	listener, err := net.Listen("tcp", addr)
+	listener, _, err := server.Listen(addr)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create listener")
+		return nil, err
 	}
 
 	opts := append(srvOpts, grpc.MaxRecvMsgSize(maxRequestSize))
@@ -87,36 +91,53 @@ func (s *T) Produce(ctx context.Context, req *pb.ProdRq) (*pb.ProdRs, error) {
 		return nil, status.Errorf(codes.InvalidArgument, err.Error())
 	}
 
-	var headers []sarama.RecordHeader
+	var headers []kafkaclt.Header
 	if len(req.Headers) > 0 {
-		headers = make([]sarama.RecordHeader, 0, len(req.Headers))
+		headers = make([]kafkaclt.Header, 0, len(req.Headers))
 		for _, h := range req.Headers {
 			if h == nil {
 				continue
 			}
-			headers = append(headers, sarama.RecordHeader{
+			headers = append(headers, kafkaclt.Header{
 				Key:   []byte(h.Key),
 				Value: h.Value,
 			})
 		}
 	}
 
+	// Propagate the caller's trace context, if any, via a Kafka record header.
+	if traceparent := traceparentFromMetadata(ctx); tracing.IsValidTraceparent(traceparent) {
+		headers = tracing.InjectTraceparent(traceparent, headers)
+	}
+
 	if req.AsyncMode {
-		pxy.AsyncProduce(req.Topic, keyEncoderFor(req), sarama.StringEncoder(req.Message), headers)
+		pxy.AsyncProduce(req.Topic, -1, keyEncoderFor(req), kafkaclt.StringEncoder(req.Message), headers)
 		return &pb.ProdRs{Partition: -1, Offset: -1}, nil
 	}
 
-	prodMsg, err := pxy.Produce(req.Topic, keyEncoderFor(req), sarama.StringEncoder(req.Message), headers)
+	prodMsg, err := pxy.Produce(ctx, req.Topic, -1, keyEncoderFor(req), kafkaclt.StringEncoder(req.Message), headers)
 	if err != nil {
-		switch err {
+		switch errors.Cause(err) {
 		case sarama.ErrUnknownTopicOrPartition:
 			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		case proxy.ErrUnknownTopic:
+			return nil, status.Errorf(codes.PermissionDenied, err.Error())
+		case proxy.ErrAccessDenied:
+			return nil, status.Errorf(codes.PermissionDenied, err.Error())
+		case proxy.ErrMessageTooLarge:
+			return nil, status.Errorf(codes.ResourceExhausted, err.Error())
+		case proxy.ErrThrottled:
+			return nil, status.Errorf(codes.ResourceExhausted, err.Error())
 		case proxy.ErrDisabled:
 			fallthrough
 		case proxy.ErrUnavailable:
 			return nil, status.Errorf(codes.Unavailable, err.Error())
 		case proxy.ErrHeadersUnsupported:
 			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		case proxy.ErrSchemaMismatch:
+			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		case proxy.ErrDeadlineExceeded:
+			return nil, status.Errorf(codes.DeadlineExceeded, err.Error())
 		default:
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
@@ -142,13 +163,23 @@ func (s *T) ConsumeNAck(ctx context.Context, req *pb.ConsNAckRq) (*pb.ConsRs, er
 		}
 	}
 
-	consMsg, err := pxy.Consume(req.Group, req.Topic, ack)
+	consMsg, err := pxy.Consume(ctx, req.Group, req.Topic, ack)
 	if err != nil {
-		switch err {
+		switch errors.Cause(err) {
 		case consumer.ErrRequestTimeout:
+			if pxy.Cfg().Consumer.NoMessageGRPCCode == "deadline_exceeded" {
+				return nil, status.Errorf(codes.DeadlineExceeded, err.Error())
+			}
 			return nil, status.Errorf(codes.NotFound, err.Error())
 		case consumer.ErrTooManyRequests:
 			return nil, status.Errorf(codes.ResourceExhausted, err.Error())
+		case proxy.ErrAccessDenied:
+			return nil, status.Errorf(codes.PermissionDenied, err.Error())
+		case proxy.ErrDraining:
+			// An explicit signal for streaming clients to re-poll another
+			// instance rather than an abrupt connection close.
+			_ = grpc.SetHeader(ctx, metadata.Pairs("draining", "true"))
+			return nil, status.Errorf(codes.Unavailable, err.Error())
 		case consumer.ErrUnavailable:
 			fallthrough
 		case proxy.ErrDisabled:
@@ -159,6 +190,12 @@ func (s *T) ConsumeNAck(ctx context.Context, req *pb.ConsNAckRq) (*pb.ConsRs, er
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
 	}
+	// Surface the trace context of the consumed message, if any, so that
+	// clients can start linked spans.
+	if traceparent := tracing.ExtractTraceparent(consMsg.Headers); traceparent != "" {
+		_ = grpc.SetHeader(ctx, metadata.Pairs(tracing.HeaderName, traceparent))
+	}
+
 	res := pb.ConsRs{
 		Partition: consMsg.Partition,
 		Offset:    consMsg.Offset,
@@ -261,7 +298,7 @@ func (s *T) ListTopics(ctx context.Context, req *pb.ListTopicRq) (*pb.ListTopicR
 		return nil, status.Errorf(codes.InvalidArgument, err.Error())
 	}
 
-	tms, err := pxy.ListTopics(req.GetWithPartitions(), true)
+	tms, err := pxy.ListTopics(nil, 0, 0, req.GetWithPartitions(), true)
 	if err != nil {
 		if errors.Cause(err) == zk.ErrNoNode {
 			return nil, status.Errorf(codes.NotFound, err.Error())
@@ -374,9 +411,22 @@ func (s *T) GetTopicMetadata(ctx context.Context, req *pb.GetTopicMetadataRq) (*
 	return &res, nil
 }
 
-func keyEncoderFor(prodReq *pb.ProdRq) sarama.Encoder {
+// traceparentFromMetadata returns the value of the `traceparent` metadata key
+// of an incoming gRPC request, or "" if the request carries no trace context.
+func traceparentFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(tracing.HeaderName); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func keyEncoderFor(prodReq *pb.ProdRq) kafkaclt.Encoder {
 	if prodReq.KeyUndefined {
 		return nil
 	}
-	return sarama.ByteEncoder(prodReq.KeyValue)
+	return kafkaclt.ByteEncoder(prodReq.KeyValue)
 }
@@ -0,0 +1,94 @@
+package httpsrv
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mailru/easyjson/jwriter"
+	. "gopkg.in/check.v1"
+)
+
+type ConsumeJSONSuite struct {
+}
+
+var _ = Suite(&ConsumeJSONSuite{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+func easyJSON(c *C, m interface {
+	MarshalEasyJSON(w *jwriter.Writer)
+}) string {
+	jw := jwriter.Writer{}
+	m.MarshalEasyJSON(&jw)
+	c.Assert(jw.Error, IsNil)
+	buf, err := jw.BuildBytes()
+	c.Assert(err, IsNil)
+	return string(buf)
+}
+
+func stdJSON(c *C, v interface{}) string {
+	buf, err := json.Marshal(v)
+	c.Assert(err, IsNil)
+	return string(buf)
+}
+
+// The hand written easyjson marshaler produces byte for byte what
+// encoding/json produces for the struct tags. Every consumeRs field is
+// populated so that a field added to the view but not to the marshaler
+// fails this test.
+func (s *ConsumeJSONSuite) TestConsumeRsInSync(c *C) {
+	rs := consumeRs{
+		Key:       []byte("foo"),
+		Value:     []byte("bar"),
+		Topic:     "some.topic",
+		Partition: 7,
+		Offset:    1013,
+		Headers: []consumeHeader{
+			{Key: "h1", Value: []byte("v1")},
+			{Key: "h2", Value: []byte("v2")},
+		},
+		OffsetsLost: 42,
+		Batch:       &batchView{ProducerID: 21, BaseOffset: 1001, BatchIndex: 12},
+		Codec:       "gzip",
+		AckDeadline: "2009-11-10T23:00:00Z",
+		AckToken:    "c2lnbmVk",
+	}
+
+	c.Assert(easyJSON(c, rs), Equals, stdJSON(c, rs))
+}
+
+// Omitted optional fields are omitted the same way in both marshalers.
+func (s *ConsumeJSONSuite) TestConsumeRsInSyncMinimal(c *C) {
+	rs := consumeRs{
+		Key:       []byte("foo"),
+		Value:     []byte("bar"),
+		Partition: 7,
+		Offset:    1013,
+	}
+
+	c.Assert(easyJSON(c, rs), Equals, stdJSON(c, rs))
+}
+
+func (s *ConsumeJSONSuite) TestConsumeBatchRsInSync(c *C) {
+	rs := consumeBatchRs{
+		Messages: []consumeRs{
+			{
+				Key:       []byte("foo"),
+				Value:     []byte("bar"),
+				Partition: 7,
+				Offset:    1013,
+				Headers:   []consumeHeader{{Key: "h1", Value: []byte("v1")}},
+				AckToken:  "c2lnbmVk",
+			},
+			{
+				Value:     []byte("bazz"),
+				Partition: 7,
+				Offset:    1014,
+			},
+		},
+	}
+
+	c.Assert(easyJSON(c, rs), Equals, stdJSON(c, rs))
+}
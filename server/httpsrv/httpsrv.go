@@ -1,51 +1,89 @@
 package httpsrv
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
 	"os"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/gorilla/mux"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/acktoken"
+	"github.com/mailgun/kafka-pixy/consumer/groupevents"
+	"github.com/mailgun/kafka-pixy/consumer/kazoo"
 	"github.com/mailgun/kafka-pixy/consumer/offsettrk"
+	"github.com/mailgun/kafka-pixy/consumer/partitioncsm"
+	"github.com/mailgun/kafka-pixy/features"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/mailgun/kafka-pixy/prettyfmt"
 	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/mailgun/kafka-pixy/tracing"
+	"github.com/mailgun/kafka-pixy/version"
+	"github.com/mailru/easyjson"
+	"github.com/mailru/easyjson/jwriter"
 	"github.com/pkg/errors"
 )
 
 const (
-	networkTCP  = "tcp"
-	networkUnix = "unix"
-
 	// HTTP headers used by the API.
 	hdrContentLength = "Content-Length"
 	hdrContentType   = "Content-Type"
 	hdrKafkaPrefix   = "X-Kafka-"
 
+	// Set on consume responses when compressed delivery is requested. It names
+	// the codec that was requested via the codec parameter; whether an
+	// individual value is actually compressed is told by its codec field, for
+	// values that the codec does not shrink are delivered plain.
+	hdrCodec = "X-Kafka-Pixy-Codec"
+
 	// HTTP request parameters.
 	prmCluster              = "cluster"
 	prmTopic                = "topic"
 	prmKey                  = "key"
 	prmSync                 = "sync"
+	hdrDraining             = "X-Kafka-Pixy-Draining"
 	prmGroup                = "group"
 	prmNoAck                = "noAck"
 	prmAckPartition         = "ackPartition"
+	prmAckToken             = "ackToken"
 	prmPartition            = "partition"
 	prmAckOffset            = "ackOffset"
 	prmOffset               = "offset"
+	prmCredits              = "credits"
+	prmSampleRate           = "sampleRate"
+	prmMaxMessages          = "maxMessages"
+	prmMaxBytes             = "maxBytes"
+	prmCount                = "count"
+	prmFilter               = "filter"
+	prmLimit                = "limit"
+	prmBeforeOffset         = "before_offset"
+	prmTime                 = "time"
+	prmToGroup              = "to_group"
+	prmCodec                = "codec"
+	prmEndTime              = "end_time"
+	prmFeature              = "feature"
+	prmEnabled              = "enabled"
 	prmTopicsWithPartitions = "withPartitions"
 	prmTopicsWithConfig     = "withConfig"
 )
@@ -61,6 +99,7 @@ type T struct {
 	listener   net.Listener
 	httpServer *http.Server
 	proxySet   *proxy.Set
+	appCfg     *config.App
 	wg         sync.WaitGroup
 	errorCh    chan error
 
@@ -82,18 +121,14 @@ func init() {
 //
 // It also passes in the provided certificate and key paths for TLS. If
 // empty strings, it is run in non-TLS mode.
-func New(addr string, m []mux.MiddlewareFunc, proxySet *proxy.Set, certPath, keyPath string) (*T, error) {
-	network := networkUnix
-	if strings.Contains(addr, ":") {
-		network = networkTCP
-	}
+func New(addr string, m []mux.MiddlewareFunc, proxySet *proxy.Set, appCfg *config.App, certPath, keyPath string) (*T, error) {
 	// Start listening on the specified network/address.
-	listener, err := net.Listen(network, addr)
+	listener, network, err := server.Listen(addr)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create listener")
+		return nil, err
 	}
 	// If the address is Unix Domain Socket then make it accessible for everyone.
-	if network == networkUnix {
+	if network == server.NetworkUnix {
 		if err := os.Chmod(addr, 0777); err != nil {
 			return nil, errors.Wrap(err, "failed to change socket permissions")
 		}
@@ -112,6 +147,7 @@ func New(addr string, m []mux.MiddlewareFunc, proxySet *proxy.Set, certPath, key
 		listener:   listener,
 		httpServer: httpServer,
 		proxySet:   proxySet,
+		appCfg:     appCfg,
 		errorCh:    make(chan error, 1),
 		certPath:   certPath,
 		keyPath:    keyPath,
@@ -123,25 +159,120 @@ func New(addr string, m []mux.MiddlewareFunc, proxySet *proxy.Set, certPath, key
 	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages", prmCluster, prmTopic), hs.handleConsume).Methods("GET")
 	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", prmTopic), hs.handleConsume).Methods("GET")
 
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages/stream", prmCluster, prmTopic), hs.handleConsumeStream).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages/stream", prmTopic), hs.handleConsumeStream).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/peek", prmCluster, prmTopic), hs.handlePeek).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/peek", prmTopic), hs.handlePeek).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/tail", prmCluster, prmTopic), hs.handleTail).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/tail", prmTopic), hs.handleTail).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/partitions/{%s}/records", prmCluster, prmTopic, prmPartition), hs.handleDeleteRecords).Methods("DELETE")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/partitions/{%s}/records", prmTopic, prmPartition), hs.handleDeleteRecords).Methods("DELETE")
+
 	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/acks", prmCluster, prmTopic), hs.handleAck).Methods("POST")
 	router.HandleFunc(fmt.Sprintf("/topics/{%s}/acks", prmTopic), hs.handleAck).Methods("POST")
 
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/acks/ranges", prmCluster, prmTopic), hs.handleAckRanges).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/acks/ranges", prmTopic), hs.handleAckRanges).Methods("POST")
+
 	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets", prmCluster, prmTopic), hs.handleGetOffsets).Methods("GET")
 	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", prmTopic), hs.handleGetOffsets).Methods("GET")
 
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/partitions/{%s}/gaps", prmCluster, prmTopic, prmPartition), hs.handleGetAckGaps).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/partitions/{%s}/gaps", prmTopic, prmPartition), hs.handleGetAckGaps).Methods("GET")
+
 	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets", prmCluster, prmTopic), hs.handleSetOffsets).Methods("POST")
 	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", prmTopic), hs.handleSetOffsets).Methods("POST")
 
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets/skiptolive", prmCluster, prmTopic), hs.handleSkipToLive).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets/skiptolive", prmTopic), hs.handleSkipToLive).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets/preview", prmCluster, prmTopic), hs.handlePreviewOffsets).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets/preview", prmTopic), hs.handlePreviewOffsets).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets/clone", prmCluster, prmTopic), hs.handleCloneOffsets).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets/clone", prmTopic), hs.handleCloneOffsets).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers/{%s}/offsets:reset", prmCluster, prmTopic, prmGroup), hs.handleResetOffsets).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers/{%s}/offsets:reset", prmTopic, prmGroup), hs.handleResetOffsets).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/partitions/health", prmCluster, prmTopic), hs.handlePartitionsHealth).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/partitions/health", prmTopic), hs.handlePartitionsHealth).Methods("GET")
+
 	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers", prmCluster, prmTopic), hs.handleGetTopicConsumers).Methods("GET")
 	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers", prmTopic), hs.handleGetTopicConsumers).Methods("GET")
 
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers/{%s}/lag", prmCluster, prmTopic, prmGroup), hs.handleGetGroupLag).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers/{%s}/lag", prmTopic, prmGroup), hs.handleGetGroupLag).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers/{%s}/assignment", prmCluster, prmTopic, prmGroup), hs.handleGetGroupAssignment).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers/{%s}/assignment", prmTopic, prmGroup), hs.handleGetGroupAssignment).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/consumers", prmCluster), hs.handleListConsumerGroups).Methods("GET")
+	router.HandleFunc("/consumers", hs.handleListConsumerGroups).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/lag", prmCluster), hs.handleGetGroupsLag).Methods("GET")
+	router.HandleFunc("/groups/lag", hs.handleGetGroupsLag).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/export", prmCluster, prmGroup), hs.handleExportGroup).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/groups/{%s}/export", prmGroup), hs.handleExportGroup).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/restore", prmCluster, prmGroup), hs.handleRestoreGroup).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/groups/{%s}/restore", prmGroup), hs.handleRestoreGroup).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/events", prmCluster, prmGroup), hs.handleGetGroupEvents).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/groups/{%s}/events", prmGroup), hs.handleGetGroupEvents).Methods("GET")
+
+	router.HandleFunc("/features", hs.handleListFeatures).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/features/{%s}", prmFeature), hs.handleSetFeature).Methods("POST")
+
 	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics", prmCluster), hs.handleListTopics).Methods("GET")
 	router.HandleFunc("/topics", hs.handleListTopics).Methods("GET")
 
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics", prmCluster), hs.handleCreateTopic).Methods("POST")
+	router.HandleFunc("/topics", hs.handleCreateTopic).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}", prmCluster, prmTopic), hs.handleDeleteTopic).Methods("DELETE")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}", prmTopic), hs.handleDeleteTopic).Methods("DELETE")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/config", prmCluster, prmTopic), hs.handleAlterTopicConfig).Methods("PUT")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/config", prmTopic), hs.handleAlterTopicConfig).Methods("PUT")
+
 	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}", prmCluster, prmTopic), hs.handleGetTopicMetadata).Methods("GET")
 	router.HandleFunc(fmt.Sprintf("/topics/{%s}", prmTopic), hs.handleGetTopicMetadata).Methods("GET")
 
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_faults", prmCluster), hs.handleAddFault).Methods("POST")
+	router.HandleFunc("/_faults", hs.handleAddFault).Methods("POST")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_faults", prmCluster), hs.handleListFaults).Methods("GET")
+	router.HandleFunc("/_faults", hs.handleListFaults).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_faults", prmCluster), hs.handleClearFaults).Methods("DELETE")
+	router.HandleFunc("/_faults", hs.handleClearFaults).Methods("DELETE")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_metadata", prmCluster), hs.handleGetMetadataInfo).Methods("GET")
+	router.HandleFunc("/_metadata", hs.handleGetMetadataInfo).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_subscriptions", prmCluster), hs.handleListSubscriptions).Methods("GET")
+	router.HandleFunc("/_subscriptions", hs.handleListSubscriptions).Methods("GET")
+
+	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_restart", prmCluster), hs.handleRestartProxy).Methods("POST")
+	router.HandleFunc("/_restart", hs.handleRestartProxy).Methods("POST")
+
+	router.HandleFunc("/_metrics", hs.handleMetrics).Methods("GET")
+
+	router.HandleFunc("/_stats/topics", hs.handleTopicStats).Methods("GET")
+
+	router.HandleFunc("/_zookeeper", hs.handleZookeeperStatus).Methods("GET")
+
 	router.HandleFunc("/_ping", hs.handlePing).Methods("GET")
+	router.HandleFunc("/_ready", hs.handleReady).Methods("GET")
+
+	router.HandleFunc("/_info", hs.handleInfo).Methods("GET")
+
+	router.HandleFunc("/_config", hs.handleGetConfig).Methods("GET")
 	return hs, nil
 }
 
@@ -194,16 +325,26 @@ func (s *T) handleProduce(w http.ResponseWriter, r *http.Request) {
 	topic := mux.Vars(r)[prmTopic]
 	key := getParamBytes(r, prmKey)
 	_, isSync := r.Form[prmSync]
+	partition := int32(-1)
+	if partitionStr := r.FormValue(prmPartition); partitionStr != "" {
+		parsed, err := strconv.ParseInt(partitionStr, 10, 32)
+		if err != nil || parsed < 0 {
+			s.respondWithJSON(w, http.StatusBadRequest,
+				errorRs{fmt.Sprintf("invalid partition: %s", partitionStr)})
+			return
+		}
+		partition = int32(parsed)
+	}
 
 	// Get the message body from the HTTP request.
-	var msg sarama.Encoder
+	var msg kafkaclt.Encoder
 	if msg, err = s.readMsg(r); err != nil {
 		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
 
 	// Look for headers with the "X-Kafka" prefix
-	var headers []sarama.RecordHeader
+	var headers []kafkaclt.Header
 	for header, values := range r.Header {
 		if !strings.HasPrefix(header, hdrKafkaPrefix) {
 			continue
@@ -217,32 +358,49 @@ func (s *T) handleProduce(w http.ResponseWriter, r *http.Request) {
 				s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
 				return
 			}
-			headers = append(headers, sarama.RecordHeader{
+			headers = append(headers, kafkaclt.Header{
 				Key:   headerBytes,
 				Value: decoded,
 			})
 		}
 	}
 
+	// Propagate the caller's trace context, if any, via a Kafka record header.
+	if traceparent := r.Header.Get(tracing.HeaderName); tracing.IsValidTraceparent(traceparent) {
+		headers = tracing.InjectTraceparent(traceparent, headers)
+	}
+
 	// Asynchronously submit the message to the Kafka cluster.
 	if !isSync {
-		pxy.AsyncProduce(topic, toEncoderPreservingNil(key), msg, headers)
+		pxy.AsyncProduce(topic, partition, toEncoderPreservingNil(key), msg, headers)
 		s.respondWithJSON(w, http.StatusOK, EmptyResponse)
 		return
 	}
 
-	prodMsg, err := pxy.Produce(topic, toEncoderPreservingNil(key), msg, headers)
+	prodMsg, err := pxy.Produce(r.Context(), topic, partition, toEncoderPreservingNil(key), msg, headers)
 	if err != nil {
 		var status int
-		switch err {
+		switch errors.Cause(err) {
 		case sarama.ErrUnknownTopicOrPartition:
 			status = http.StatusNotFound
+		case proxy.ErrUnknownTopic:
+			status = http.StatusForbidden
+		case proxy.ErrAccessDenied:
+			status = http.StatusForbidden
+		case proxy.ErrMessageTooLarge:
+			status = http.StatusRequestEntityTooLarge
+		case proxy.ErrThrottled:
+			status = http.StatusTooManyRequests
 		case proxy.ErrDisabled:
 			fallthrough
 		case proxy.ErrUnavailable:
 			status = http.StatusServiceUnavailable
 		case proxy.ErrHeadersUnsupported:
 			status = http.StatusBadRequest
+		case proxy.ErrSchemaMismatch:
+			status = http.StatusBadRequest
+		case proxy.ErrDeadlineExceeded:
+			status = http.StatusGatewayTimeout
 		default:
 			status = http.StatusInternalServerError
 		}
@@ -250,14 +408,23 @@ func (s *T) handleProduce(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.respondWithJSON(w, http.StatusOK, produceRs{
+	rs := produceRs{
 		Partition: prodMsg.Partition,
 		Offset:    prodMsg.Offset,
-	})
+	}
+	if !prodMsg.Timestamp.IsZero() {
+		timestamp := prodMsg.Timestamp.UTC()
+		rs.Timestamp = &timestamp
+	}
+	if leaderID, leaderAddr, err := pxy.PartitionLeader(topic, prodMsg.Partition); err == nil && leaderAddr != "" {
+		rs.LeaderID = &leaderID
+		rs.Leader = leaderAddr
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
 }
 
 // readMsg reads message from the HTTP request based on the Content-Type header.
-func (s *T) readMsg(r *http.Request) (sarama.Encoder, error) {
+func (s *T) readMsg(r *http.Request) (kafkaclt.Encoder, error) {
 	contentType := r.Header.Get(hdrContentType)
 	if contentType == "text/plain" || jsonContentTypePattern.MatchString(contentType) {
 		if _, ok := r.Header[hdrContentLength]; !ok {
@@ -276,19 +443,37 @@ func (s *T) readMsg(r *http.Request) (sarama.Encoder, error) {
 			return nil, errors.Errorf("message size does not match %s: expected=%v, actual=%v",
 				hdrContentLength, msgSize, len(msg))
 		}
-		return sarama.ByteEncoder(msg), nil
+		return kafkaclt.ByteEncoder(msg), nil
 	}
 	if contentType == "application/x-www-form-urlencoded" {
 		msg := r.FormValue("msg")
 		if msg == "" {
 			return nil, errors.Errorf("empty message")
 		}
-		return sarama.StringEncoder(msg), nil
+		return kafkaclt.StringEncoder(msg), nil
 	}
 	return nil, errors.Errorf("unsupported content type %s", contentType)
 }
 
-// handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`
+const (
+	// Upper bound on messages returned by a single batched consume request,
+	// to keep the response size sane.
+	maxBatchMessages = 1000
+
+	// How long a batched consume request waits for each message after the
+	// first one. The first message is awaited with the full long polling
+	// timeout, but once the topic runs dry the batch collected so far is
+	// returned promptly rather than stalling for more.
+	batchFollowUpTimeout = 100 * time.Millisecond
+)
+
+// handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`.
+// If a sampleRate parameter in (0, 1] is given, then only roughly that
+// fraction of messages is delivered and the rest are auto-acknowledged, so
+// debugging tools can observe live traffic shape on a busy topic without
+// needing full throughput. If a maxMessages or maxBytes parameter is given,
+// then up to that many messages/bytes are returned in one response as a
+// batch, each message carrying its own partition/offset ack token.
 func (s *T) handleConsume(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -303,20 +488,80 @@ func (s *T) handleConsume(w http.ResponseWriter, r *http.Request) {
 		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
-	ack, err := parseAck(r, true)
+	ack, err := parseAck(r, true, group, topic, pxy.Cfg().Consumer.RequireAckToken)
 	if err != nil {
 		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
+	sampleRate := 1.0
+	if sampleRateStr := r.FormValue(prmSampleRate); sampleRateStr != "" {
+		sampleRate, err = strconv.ParseFloat(sampleRateStr, 64)
+		if err != nil || sampleRate <= 0 || sampleRate > 1 {
+			s.respondWithJSON(w, http.StatusBadRequest,
+				errorRs{fmt.Sprintf("invalid sample rate: %s", sampleRateStr)})
+			return
+		}
+		// Sampled-out messages are never seen by the caller, so they are
+		// acknowledged on delivery, and so is the sampled one for symmetry.
+		ack = proxy.AutoAck()
+	}
+
+	codec := r.FormValue(prmCodec)
+	if err := validateCodec(codec); err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	maxMessages := 1
+	if maxMessagesStr := r.FormValue(prmMaxMessages); maxMessagesStr != "" {
+		maxMessages, err = strconv.Atoi(maxMessagesStr)
+		if err != nil || maxMessages <= 0 {
+			s.respondWithJSON(w, http.StatusBadRequest,
+				errorRs{fmt.Sprintf("invalid maxMessages: %s", maxMessagesStr)})
+			return
+		}
+		if maxMessages > maxBatchMessages {
+			maxMessages = maxBatchMessages
+		}
+	}
+	maxBytes := 0
+	if maxBytesStr := r.FormValue(prmMaxBytes); maxBytesStr != "" {
+		maxBytes, err = strconv.Atoi(maxBytesStr)
+		if err != nil || maxBytes <= 0 {
+			s.respondWithJSON(w, http.StatusBadRequest,
+				errorRs{fmt.Sprintf("invalid maxBytes: %s", maxBytesStr)})
+			return
+		}
+	}
 
-	consMsg, err := pxy.Consume(group, topic, ack)
+	consMsg, err := pxy.Consume(r.Context(), group, topic, ack)
+	for err == nil && sampleRate < 1 && rand.Float64() >= sampleRate {
+		consMsg, err = pxy.Consume(r.Context(), group, topic, ack)
+	}
 	if err != nil {
 		var status int
-		switch err {
+		switch errors.Cause(err) {
 		case consumer.ErrRequestTimeout:
-			status = http.StatusRequestTimeout
+			status = pxy.Cfg().Consumer.NoMessageStatusCode
+			if status == http.StatusNoContent {
+				// 204 responses must not carry a body.
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			// A timeout on a topic with unhealthy partitions is more likely
+			// caused by broker trouble than by absence of messages, say so.
+			if summary := pxy.UnhealthyPartitions(topic); summary != "" {
+				s.respondWithJSON(w, status, errorRs{fmt.Sprintf("%s: %s", err, summary)})
+				return
+			}
 		case consumer.ErrTooManyRequests:
 			status = http.StatusTooManyRequests
+		case proxy.ErrAccessDenied:
+			status = http.StatusForbidden
+		case proxy.ErrDraining:
+			// An explicit signal for streaming clients to re-poll another
+			// instance rather than an abrupt connection close.
+			w.Header().Set(hdrDraining, "true")
+			status = http.StatusServiceUnavailable
 		case consumer.ErrUnavailable:
 			fallthrough
 		case proxy.ErrDisabled:
@@ -330,25 +575,71 @@ func (s *T) handleConsume(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	headers := make([]consumeHeader, 0, len(consMsg.Headers))
-	for _, h := range consMsg.Headers {
-		headers = append(headers, consumeHeader{
-			Key:   string(h.Key),
-			Value: h.Value,
-		})
+	// Surface the trace context of the consumed message, if any, so that
+	// clients can start linked spans.
+	if traceparent := tracing.ExtractTraceparent(consMsg.Headers); traceparent != "" {
+		w.Header().Set(tracing.HeaderName, traceparent)
 	}
 
-	s.respondWithJSON(w, http.StatusOK, consumeRs{
-		Key:       consMsg.Key,
-		Value:     consMsg.Value,
-		Partition: consMsg.Partition,
-		Offset:    consMsg.Offset,
-		Headers:   headers,
-	})
+	rs := newConsumeRs(consMsg)
+	// Auto-acknowledged messages are never re-offered, so a deadline and an
+	// ack token would be meaningless for them.
+	if ack != proxy.AutoAck() {
+		rs.AckDeadline = time.Now().Add(pxy.Cfg().Consumer.AckTimeout).UTC().Format(time.RFC3339)
+		rs.AckToken = acktoken.Mint(group, consMsg.Topic, consMsg.Partition, consMsg.Offset)
+	}
+	rs.compressValue(codec)
+	if codec != "" {
+		w.Header().Set(hdrCodec, codec)
+	}
+	if maxMessages == 1 && maxBytes == 0 {
+		s.respondWithJSON(w, http.StatusOK, rs)
+		return
+	}
+
+	// Batch mode. An explicit ack from the request parameters has been
+	// applied with the first consume call, the rest of the batch is pulled
+	// without acknowledging anything, unless messages are auto-acknowledged.
+	followAck := proxy.NoAck()
+	if ack == proxy.AutoAck() {
+		followAck = ack
+	}
+	batch := consumeBatchRs{Messages: []consumeRs{rs}}
+	size := len(consMsg.Key) + len(consMsg.Value)
+	for len(batch.Messages) < maxMessages && (maxBytes == 0 || size < maxBytes) {
+		followCtx, cancel := context.WithTimeout(r.Context(), batchFollowUpTimeout)
+		consMsg, err = pxy.Consume(followCtx, group, topic, followAck)
+		cancel()
+		if err != nil {
+			// The topic ran dry or the proxy is going away, either way the
+			// batch collected so far is returned.
+			break
+		}
+		if sampleRate < 1 && rand.Float64() >= sampleRate {
+			// Sampling forces auto ack, so skipped messages are not lost.
+			continue
+		}
+		mRs := newConsumeRs(consMsg)
+		if followAck != proxy.AutoAck() {
+			mRs.AckDeadline = time.Now().Add(pxy.Cfg().Consumer.AckTimeout).UTC().Format(time.RFC3339)
+			mRs.AckToken = acktoken.Mint(group, consMsg.Topic, consMsg.Partition, consMsg.Offset)
+		}
+		mRs.compressValue(codec)
+		batch.Messages = append(batch.Messages, mRs)
+		size += len(consMsg.Key) + len(consMsg.Value)
+	}
+	s.respondWithJSON(w, http.StatusOK, batch)
 }
 
-// handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`
-func (s *T) handleAck(w http.ResponseWriter, r *http.Request) {
+// Upper bound on messages returned by a single peek request, to keep the
+// response size sane.
+const maxPeekCount = 100
+
+// handlePeek is an HTTP request handler for `GET /topic/{topic}/peek`. It
+// returns up to `count` messages that the group is yet to consume from the
+// topic without marking them offered or affecting ack tracking, so support
+// engineers can inspect what is stuck at the head of the partitions.
+func (s *T) handlePeek(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	pxy, err := s.getProxy(r)
@@ -362,22 +653,153 @@ func (s *T) handleAck(w http.ResponseWriter, r *http.Request) {
 		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
-	ack, err := parseAck(r, false)
+	count := 1
+	if countStr := r.FormValue(prmCount); countStr != "" {
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count <= 0 {
+			s.respondWithJSON(w, http.StatusBadRequest,
+				errorRs{fmt.Sprintf("invalid count: %s", countStr)})
+			return
+		}
+		if count > maxPeekCount {
+			count = maxPeekCount
+		}
+	}
+
+	msgs, err := pxy.Peek(group, topic, count)
+	if err != nil {
+		var status int
+		switch err {
+		case proxy.ErrUnavailable:
+			status = http.StatusServiceUnavailable
+		default:
+			status = http.StatusInternalServerError
+		}
+		s.respondWithJSON(w, status, errorRs{err.Error()})
+		return
+	}
+
+	s.respondWithJSON(w, http.StatusOK, peekRs{Messages: newConsumeRsList(msgs)})
+}
+
+// handleTail is an HTTP request handler for `GET /topic/{topic}/tail`. It
+// returns up to `count` of the most recently produced messages of the topic,
+// computed from the high watermarks backwards. A partition parameter narrows
+// the tail down to one partition, otherwise partition tails are merged by
+// timestamp. No consumer group state is affected.
+func (s *T) handleTail(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
 	if err != nil {
 		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
+	topic := mux.Vars(r)[prmTopic]
+	partition := int32(-1)
+	if partitionStr := r.FormValue(prmPartition); partitionStr != "" {
+		parsed, err := strconv.ParseInt(partitionStr, 10, 32)
+		if err != nil || parsed < 0 {
+			s.respondWithJSON(w, http.StatusBadRequest,
+				errorRs{fmt.Sprintf("invalid partition: %s", partitionStr)})
+			return
+		}
+		partition = int32(parsed)
+	}
+	count := 1
+	if countStr := r.FormValue(prmCount); countStr != "" {
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count <= 0 {
+			s.respondWithJSON(w, http.StatusBadRequest,
+				errorRs{fmt.Sprintf("invalid count: %s", countStr)})
+			return
+		}
+		if count > maxPeekCount {
+			count = maxPeekCount
+		}
+	}
 
-	err = pxy.Ack(group, topic, ack)
+	msgs, err := pxy.Tail(topic, partition, count)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		var status int
+		switch err {
+		case proxy.ErrUnavailable:
+			status = http.StatusServiceUnavailable
+		default:
+			status = http.StatusInternalServerError
+		}
+		s.respondWithJSON(w, status, errorRs{err.Error()})
 		return
 	}
-	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+	s.respondWithJSON(w, http.StatusOK, peekRs{Messages: newConsumeRsList(msgs)})
 }
 
-// handleGetOffsets is an HTTP request handler for `GET /topic/{topic}/offsets`
-func (s *T) handleGetOffsets(w http.ResponseWriter, r *http.Request) {
+// parseTimeParam parses a time parameter that is either an RFC3339 timestamp
+// or the number of milliseconds since the Unix epoch.
+func parseTimeParam(timeStr string) (time.Time, error) {
+	ts, err := time.Parse(time.RFC3339, timeStr)
+	if err == nil {
+		return ts, nil
+	}
+	timeMs, err := strconv.ParseInt(timeStr, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Errorf("bad time: %s", timeStr)
+	}
+	return time.Unix(0, timeMs*int64(time.Millisecond)), nil
+}
+
+// newConsumeRs renders a consumed message as an API response.
+func newConsumeRs(msg consumer.Message) consumeRs {
+	headers := make([]consumeHeader, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, consumeHeader{
+			Key:   string(h.Key),
+			Value: h.Value,
+		})
+	}
+	rs := consumeRs{
+		Key:         msg.Key,
+		Value:       msg.Value,
+		Topic:       msg.Topic,
+		Partition:   msg.Partition,
+		Offset:      msg.Offset,
+		Headers:     headers,
+		OffsetsLost: msg.OffsetsLost,
+	}
+	if msg.ProducerID >= 0 {
+		rs.Batch = &batchView{
+			ProducerID: msg.ProducerID,
+			BaseOffset: msg.BaseOffset,
+			BatchIndex: msg.BatchIndex,
+		}
+	}
+	return rs
+}
+
+// newConsumeRsList renders consumed messages as API responses.
+func newConsumeRsList(msgs []consumer.Message) []consumeRs {
+	msgViews := make([]consumeRs, 0, len(msgs))
+	for _, msg := range msgs {
+		msgViews = append(msgViews, newConsumeRs(msg))
+	}
+	return msgViews
+}
+
+// Upper bound on credits a client may grant in one stream request, to keep
+// the time a single stream can monopolize a connection bounded.
+const maxStreamCredits = 1000
+
+// handleConsumeStream is an HTTP request handler for
+// `GET /topic/{topic}/messages/stream`. It streams up to `credits` messages
+// as newline-delimited JSON and then ends the response. Clients grant more
+// credits by issuing the next request, so a slow client never has more than
+// one message in flight and server memory per stream is strictly bounded.
+// Messages are acknowledged as they are sent. An optional end_time parameter
+// bounds the stream: once a message produced after that time is reached, or
+// the stream catches up with the live edge past it, an end_of_range record
+// is emitted and the stream completes, which is how backfill jobs stop at
+// "now".
+func (s *T) handleConsumeStream(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	pxy, err := s.getProxy(r)
@@ -391,40 +813,134 @@ func (s *T) handleGetOffsets(w http.ResponseWriter, r *http.Request) {
 		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
-
-	partitionOffsets, err := pxy.GetGroupOffsets(group, topic)
-	if err != nil {
-		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
-			s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
+	credits := 1
+	if creditsStr := r.FormValue(prmCredits); creditsStr != "" {
+		credits, err = strconv.Atoi(creditsStr)
+		if err != nil || credits <= 0 {
+			s.respondWithJSON(w, http.StatusBadRequest,
+				errorRs{fmt.Sprintf("invalid credits: %s", creditsStr)})
 			return
 		}
-		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		if credits > maxStreamCredits {
+			credits = maxStreamCredits
+		}
+	}
+
+	codec := r.FormValue(prmCodec)
+	if err := validateCodec(codec); err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
+	var endTime time.Time
+	if endTimeStr := r.FormValue(prmEndTime); endTimeStr != "" {
+		endTime, err = parseTimeParam(endTimeStr)
+		if err != nil {
+			s.respondWithJSON(w, http.StatusBadRequest,
+				errorRs{fmt.Sprintf("invalid end_time: %s", endTimeStr)})
+			return
+		}
+	}
 
-	offsetViews := make([]partitionInfo, len(partitionOffsets))
-	for i, po := range partitionOffsets {
-		offsetViews[i].Partition = po.Partition
-		offsetViews[i].Begin = po.Begin
-		offsetViews[i].End = po.End
-		offsetViews[i].Count = po.End - po.Begin
-		offsetViews[i].Offset = po.Offset
-		if po.Offset == sarama.OffsetNewest {
-			offsetViews[i].Lag = 0
-		} else if po.Offset == sarama.OffsetOldest {
-			offsetViews[i].Lag = po.End - po.Begin
-		} else {
-			offsetViews[i].Lag = po.End - po.Offset
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	streaming := false
+	endStream := func() {
+		if !streaming {
+			w.Header().Set(hdrContentType, "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+		}
+		_ = encoder.Encode(endOfRangeRs{EndOfRange: true})
+	}
+	for i := 0; i < credits; i++ {
+		// A bounded stream acknowledges explicitly, so that a message past
+		// the end of the range can be left for unbounded consumers.
+		ack := proxy.AutoAck()
+		if !endTime.IsZero() {
+			ack = proxy.NoAck()
+		}
+		consMsg, err := pxy.Consume(r.Context(), group, topic, ack)
+		if err != nil {
+			// A bounded stream that has caught up with the live edge past
+			// the end of its range is complete rather than timed out.
+			if err == consumer.ErrRequestTimeout && !endTime.IsZero() && !endTime.After(time.Now()) {
+				endStream()
+				return
+			}
+			// Once the response status has been written all we can do about
+			// an error is end the stream, the client will grant new credits
+			// with a follow up request anyway.
+			if streaming {
+				return
+			}
+			var status int
+			switch errors.Cause(err) {
+			case consumer.ErrRequestTimeout:
+				status = pxy.Cfg().Consumer.NoMessageStatusCode
+				if status == http.StatusNoContent {
+					// 204 responses must not carry a body.
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				if summary := pxy.UnhealthyPartitions(topic); summary != "" {
+					s.respondWithJSON(w, status, errorRs{fmt.Sprintf("%s: %s", err, summary)})
+					return
+				}
+			case consumer.ErrTooManyRequests:
+				status = http.StatusTooManyRequests
+			case proxy.ErrAccessDenied:
+				status = http.StatusForbidden
+			case proxy.ErrDraining:
+				w.Header().Set(hdrDraining, "true")
+				status = http.StatusServiceUnavailable
+			case consumer.ErrUnavailable, proxy.ErrDisabled, proxy.ErrUnavailable:
+				status = http.StatusServiceUnavailable
+			default:
+				status = http.StatusInternalServerError
+			}
+			s.respondWithJSON(w, status, errorRs{err.Error()})
+			return
+		}
+		if !endTime.IsZero() {
+			if !consMsg.Timestamp.IsZero() && consMsg.Timestamp.After(endTime) {
+				// The message is past the end of the range. Leave it
+				// unacknowledged, so it is re-offered once the ack timeout
+				// expires, and complete the stream.
+				endStream()
+				return
+			}
+			if msgAck, err := proxy.NewAck(consMsg.Partition, consMsg.Offset); err == nil {
+				if err := pxy.Ack(group, topic, msgAck); err != nil {
+					s.actDesc.Log().WithError(err).Errorf(
+						"Failed to ack streamed message: topic=%s, partition=%d, offset=%d",
+						topic, consMsg.Partition, consMsg.Offset)
+				}
+			}
+		}
+		if !streaming {
+			if codec != "" {
+				w.Header().Set(hdrCodec, codec)
+			}
+			w.Header().Set(hdrContentType, "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			streaming = true
+		}
+		rs := newConsumeRs(consMsg)
+		rs.compressValue(codec)
+		jw := jwriter.Writer{}
+		rs.MarshalEasyJSON(&jw)
+		jw.RawByte('\n')
+		if _, err := jw.DumpTo(w); err != nil {
+			// The client went away, unused credits are simply forgotten.
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
 		}
-		offsetViews[i].Metadata = po.Metadata
-		offset := offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}
-		offsetViews[i].SparseAcks = offsettrk.SparseAcks2Str(offset)
 	}
-	s.respondWithJSON(w, http.StatusOK, offsetViews)
 }
 
-// handleGetOffsets is an HTTP request handler for `POST /topic/{topic}/offsets`
-func (s *T) handleSetOffsets(w http.ResponseWriter, r *http.Request) {
+// handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`
+func (s *T) handleAck(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	pxy, err := s.getProxy(r)
@@ -438,45 +954,31 @@ func (s *T) handleSetOffsets(w http.ResponseWriter, r *http.Request) {
 		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
-
-	body, err := ioutil.ReadAll(r.Body)
+	ack, err := parseAck(r, false, group, topic, pxy.Cfg().Consumer.RequireAckToken)
 	if err != nil {
-		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
-		return
-	}
-
-	var partitionOffsetViews []partitionInfo
-	if err := json.Unmarshal(body, &partitionOffsetViews); err != nil {
-		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
+	_, isSync := r.Form[prmSync]
 
-	partitionOffsets := make([]admin.PartitionOffset, len(partitionOffsetViews))
-	for i, pov := range partitionOffsetViews {
-		partitionOffsets[i].Partition = pov.Partition
-		partitionOffsets[i].Offset = pov.Offset
-		partitionOffsets[i].Metadata = pov.Metadata
+	if isSync {
+		err = pxy.AckSync(r.Context(), group, topic, ack)
+	} else {
+		err = pxy.Ack(group, topic, ack)
 	}
-
-	err = pxy.SetGroupOffsets(group, topic, partitionOffsets)
 	if err != nil {
-		if err = errors.Cause(err); err == sarama.ErrUnknownTopicOrPartition {
-			s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
-			return
-		}
 		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
 		return
 	}
-
 	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
 }
 
-// handleGetTopicConsumers is an HTTP request handler for `GET /topic/{topic}/consumers`
-func (s *T) handleGetTopicConsumers(w http.ResponseWriter, r *http.Request) {
+// handleAckRanges is an HTTP request handler for
+// `POST /topic/{topic}/acks/ranges`. The request body is a JSON list of
+// partitions, each with a list of half open [from, to) offset ranges to
+// acknowledge, e.g. `[{"partition": 0, "ranges": [{"from": 10, "to": 20}]}]`.
+func (s *T) handleAckRanges(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	var err error
 
 	pxy, err := s.getProxy(r)
 	if err != nil {
@@ -484,21 +986,504 @@ func (s *T) handleGetTopicConsumers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	topic := mux.Vars(r)[prmTopic]
-
-	group, err := getGroupParam(r, true)
+	group, err := getGroupParam(r, false)
 	if err != nil {
 		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+	// Ranges are inherently raw offsets, so they cannot be allowed when acks
+	// are required to present a token issued with the message.
+	if pxy.Cfg().Consumer.RequireAckToken {
+		s.respondWithJSON(w, http.StatusForbidden,
+			errorRs{"ack ranges are disabled because consumer.require_ack_token is enabled"})
+		return
+	}
+	var partitionAcks []ackRangesRq
+	if err := json.Unmarshal(body, &partitionAcks); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
 
-	var consumers map[string]map[string][]int32
-	if group == "" {
-		consumers, err = pxy.GetAllTopicConsumers(topic)
-		if err != nil {
+	for _, pa := range partitionAcks {
+		ranges := make([]proxy.OffsetRange, len(pa.Ranges))
+		for i, ar := range pa.Ranges {
+			ranges[i] = proxy.OffsetRange{From: ar.From, To: ar.To}
+		}
+		if err := pxy.AckRanges(group, topic, pa.Partition, ranges); err != nil {
 			s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
 			return
 		}
-	} else {
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleGetOffsets is an HTTP request handler for `GET /topic/{topic}/offsets`.
+// The `offset` and `limit` parameters paginate the partition list of the
+// response.
+func (s *T) handleGetOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	pageOffset, pageLimit, err := parsePageParams(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	partitionOffsets, err := pxy.GetGroupOffsets(group, topic)
+	if err != nil {
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
+			return
+		}
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+	// Paginate over partitions, so that topics with thousands of partitions
+	// can be inspected page by page.
+	if pageOffset > 0 {
+		if pageOffset >= len(partitionOffsets) {
+			partitionOffsets = nil
+		} else {
+			partitionOffsets = partitionOffsets[pageOffset:]
+		}
+	}
+	if pageLimit > 0 && len(partitionOffsets) > pageLimit {
+		partitionOffsets = partitionOffsets[:pageLimit]
+	}
+
+	offsetViews := make([]partitionInfo, len(partitionOffsets))
+	for i, po := range partitionOffsets {
+		offsetViews[i].Partition = po.Partition
+		offsetViews[i].Begin = po.Begin
+		offsetViews[i].End = po.End
+		offsetViews[i].Count = po.End - po.Begin
+		offsetViews[i].Offset = po.Offset
+		if po.Offset == sarama.OffsetNewest {
+			offsetViews[i].Lag = 0
+		} else if po.Offset == sarama.OffsetOldest {
+			offsetViews[i].Lag = po.End - po.Begin
+		} else {
+			offsetViews[i].Lag = po.End - po.Offset
+		}
+		offsetViews[i].Metadata = po.Metadata
+		offset := offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}
+		offsetViews[i].SparseAcks = offsettrk.SparseAcks2Str(offset)
+	}
+	s.respondWithJSON(w, http.StatusOK, offsetViews)
+}
+
+// handleGetOffsets is an HTTP request handler for `POST /topic/{topic}/offsets`
+func (s *T) handleSetOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+
+	var partitionOffsetViews []partitionInfo
+	if err := json.Unmarshal(body, &partitionOffsetViews); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+
+	partitionOffsets := make([]admin.PartitionOffset, len(partitionOffsetViews))
+	for i, pov := range partitionOffsetViews {
+		partitionOffsets[i].Partition = pov.Partition
+		partitionOffsets[i].Offset = pov.Offset
+		partitionOffsets[i].Metadata = pov.Metadata
+	}
+
+	err = pxy.SetGroupOffsets(group, topic, partitionOffsets)
+	if err != nil {
+		if err = errors.Cause(err); err == sarama.ErrUnknownTopicOrPartition {
+			s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
+			return
+		}
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handlePreviewOffsets is an HTTP request handler for
+// `GET /topic/{topic}/offsets/preview`. It is a dry run of a reset of the
+// group offsets to a point in time: it reports the offset each partition
+// would be moved to and how many messages the move would replay or skip,
+// without committing anything. The time parameter is either an RFC3339
+// timestamp or the number of milliseconds since the Unix epoch.
+func (s *T) handlePreviewOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	timeStr := r.FormValue(prmTime)
+	if timeStr == "" {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{"time must be specified"})
+		return
+	}
+	ts, err := parseTimeParam(timeStr)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest,
+			errorRs{fmt.Sprintf("invalid time: %s", timeStr)})
+		return
+	}
+
+	previews, err := pxy.PreviewGroupOffsets(group, topic, ts)
+	if err != nil {
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
+			return
+		}
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+
+	rs := offsetPreviewRs{Time: ts.UTC().Format(time.RFC3339)}
+	for _, pv := range previews {
+		rs.Partitions = append(rs.Partitions, offsetPreviewView{
+			Partition: pv.Partition,
+			Begin:     pv.Begin,
+			End:       pv.End,
+			Offset:    pv.Offset,
+			Target:    pv.Target,
+			Replayed:  pv.Replayed,
+			Skipped:   pv.Skipped,
+		})
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handlePartitionsHealth is an HTTP request handler for
+// `GET /topic/{topic}/partitions/health`. It reports the replication state
+// of every partition of the topic: leader, replicas, in-sync replicas and a
+// status of ok, no_leader or under_replicated.
+func (s *T) handlePartitionsHealth(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+
+	healths, err := pxy.GetPartitionsHealth(topic)
+	if err != nil {
+		switch errors.Cause(err) {
+		case sarama.ErrUnknownTopicOrPartition:
+			s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
+		case proxy.ErrUnavailable:
+			s.respondWithJSON(w, http.StatusServiceUnavailable, errorRs{err.Error()})
+		default:
+			s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		}
+		return
+	}
+
+	healthViews := make([]partitionHealthView, len(healths))
+	for i, ph := range healths {
+		healthViews[i] = partitionHealthView{
+			Partition: ph.Partition,
+			Leader:    ph.Leader,
+			Replicas:  ph.Replicas,
+			ISR:       ph.ISR,
+			Status:    ph.Status,
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, healthViews)
+}
+
+// handleCloneOffsets is an HTTP request handler for
+// `POST /topic/{topic}/offsets/clone`. It copies the offsets committed by
+// the group given in the group parameter for the topic into the group given
+// in the to_group parameter, so a parallel consumer can be spun up starting
+// exactly where the source group is. The destination group must not have any
+// offsets committed for the topic yet.
+func (s *T) handleCloneOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	toGroup := r.FormValue(prmToGroup)
+	if toGroup == "" {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{"to_group must be specified"})
+		return
+	}
+	if toGroup == group {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{"to_group must differ from group"})
+		return
+	}
+
+	clonedOffsets, err := pxy.CloneGroupOffsets(group, toGroup, topic)
+	if err != nil {
+		switch errors.Cause(err) {
+		case sarama.ErrUnknownTopicOrPartition:
+			s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
+		case admin.ErrGroupHasOffsets:
+			s.respondWithJSON(w, http.StatusConflict, errorRs{err.Error()})
+		default:
+			s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		}
+		return
+	}
+
+	offsetViews := make([]partitionInfo, len(clonedOffsets))
+	for i, po := range clonedOffsets {
+		offsetViews[i].Partition = po.Partition
+		offsetViews[i].Begin = po.Begin
+		offsetViews[i].End = po.End
+		offsetViews[i].Count = po.End - po.Begin
+		offsetViews[i].Offset = po.Offset
+		offsetViews[i].Lag = po.End - po.Offset
+		offsetViews[i].Metadata = po.Metadata
+	}
+	s.respondWithJSON(w, http.StatusOK, offsetViews)
+}
+
+// handleSkipToLive is an HTTP request handler for
+// `POST /topic/{topic}/offsets/skiptolive`
+func (s *T) handleSkipToLive(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	partitionOffsets, err := pxy.SkipToLive(group, topic)
+	if err != nil {
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
+			return
+		}
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+
+	offsetViews := make([]partitionInfo, len(partitionOffsets))
+	for i, po := range partitionOffsets {
+		offsetViews[i].Partition = po.Partition
+		offsetViews[i].Begin = po.Begin
+		offsetViews[i].End = po.End
+		offsetViews[i].Count = po.End - po.Begin
+		offsetViews[i].Offset = po.Offset
+		offsetViews[i].Lag = po.End - po.Offset
+	}
+	s.respondWithJSON(w, http.StatusOK, offsetViews)
+}
+
+// handleResetOffsets is an HTTP request handler for
+// `POST /topics/{topic}/consumers/{group}/offsets:reset`. It moves the
+// offsets committed by the group for the partitions of the topic to the
+// position given in the request body, which is one of `{"to": "earliest"}`,
+// `{"to": "latest"}`, `{"to": <RFC3339 time or Unix milliseconds>}` and
+// `{"offsets": [{"partition": <p>, "offset": <o>}, ...]}`. Unlike a plain
+// offset commit via `POST /topics/{topic}/offsets` it also rewinds partition
+// consumers of the group running in this instance, so the reset takes effect
+// without stopping Kafka-Pixy. Group members consuming via other instances
+// keep their in-memory positions until their partitions are rebalanced.
+func (s *T) handleResetOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+	group := mux.Vars(r)[prmGroup]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+	var rq resetOffsetsRq
+	if err := json.Unmarshal(body, &rq); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+	if (rq.To == "") == (len(rq.Offsets) == 0) {
+		s.respondWithJSON(w, http.StatusBadRequest,
+			errorRs{"either to or offsets must be specified, but not both"})
+		return
+	}
+
+	// Resolve the requested position into explicit per-partition offsets.
+	var targets []admin.PartitionOffset
+	switch {
+	case len(rq.Offsets) > 0:
+		current, err := pxy.GetGroupOffsets(group, topic)
+		if err != nil {
+			s.respondResetOffsetsErr(w, err)
+			return
+		}
+		partitions := make(map[int32]bool, len(current))
+		for _, po := range current {
+			partitions[po.Partition] = true
+		}
+		for _, ov := range rq.Offsets {
+			if !partitions[ov.Partition] {
+				s.respondWithJSON(w, http.StatusBadRequest,
+					errorRs{fmt.Sprintf("unknown partition %d", ov.Partition)})
+				return
+			}
+			targets = append(targets, admin.PartitionOffset{
+				Partition: ov.Partition,
+				Offset:    ov.Offset,
+			})
+		}
+	case rq.To == "earliest" || rq.To == "latest":
+		current, err := pxy.GetGroupOffsets(group, topic)
+		if err != nil {
+			s.respondResetOffsetsErr(w, err)
+			return
+		}
+		for _, po := range current {
+			target := po.Begin
+			if rq.To == "latest" {
+				target = po.End
+			}
+			targets = append(targets, admin.PartitionOffset{
+				Partition: po.Partition,
+				Offset:    target,
+			})
+		}
+	default:
+		ts, err := parseTimeParam(rq.To)
+		if err != nil {
+			s.respondWithJSON(w, http.StatusBadRequest,
+				errorRs{fmt.Sprintf("invalid to: %s", rq.To)})
+			return
+		}
+		previews, err := pxy.PreviewGroupOffsets(group, topic, ts)
+		if err != nil {
+			s.respondResetOffsetsErr(w, err)
+			return
+		}
+		for _, pv := range previews {
+			targets = append(targets, admin.PartitionOffset{
+				Partition: pv.Partition,
+				Offset:    pv.Target,
+			})
+		}
+	}
+
+	rewound, err := pxy.ResetGroupOffsets(group, topic, targets)
+	if err != nil {
+		s.respondResetOffsetsErr(w, err)
+		return
+	}
+
+	rs := resetOffsetsRs{Rewound: rewound}
+	for _, po := range targets {
+		rs.Offsets = append(rs.Offsets, resetOffsetView{
+			Partition: po.Partition,
+			Offset:    po.Offset,
+		})
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// respondResetOffsetsErr renders an offset reset failure with the status
+// code appropriate for its cause.
+func (s *T) respondResetOffsetsErr(w http.ResponseWriter, err error) {
+	if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+		s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
+		return
+	}
+	s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+}
+
+// handleGetTopicConsumers is an HTTP request handler for `GET /topic/{topic}/consumers`
+func (s *T) handleGetTopicConsumers(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var err error
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+
+	group, err := getGroupParam(r, true)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	var consumers map[string]map[string][]int32
+	if group == "" {
+		consumers, err = pxy.GetAllTopicConsumers(topic)
+		if err != nil {
+			s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+			return
+		}
+	} else {
 		groupConsumers, err := pxy.GetTopicConsumers(group, topic)
 		if err != nil {
 			if _, ok := err.(admin.ErrInvalidParam); ok {
@@ -508,110 +1493,1207 @@ func (s *T) handleGetTopicConsumers(w http.ResponseWriter, r *http.Request) {
 			s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
 			return
 		}
-		consumers = make(map[string]map[string][]int32)
-		if len(groupConsumers) != 0 {
-			consumers[group] = groupConsumers
+		consumers = make(map[string]map[string][]int32)
+		if len(groupConsumers) != 0 {
+			consumers[group] = groupConsumers
+		}
+	}
+
+	encodedRes, err := json.MarshalIndent(consumers, "", "  ")
+	if err != nil {
+		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", http.StatusOK, encodedRes)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encodedRes = prettyfmt.CollapseJSON(encodedRes)
+
+	w.Header().Add(hdrContentType, "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(encodedRes); err != nil {
+		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", http.StatusOK, encodedRes)
+	}
+}
+
+// handleListConsumerGroups is an HTTP request handler for `GET /consumers`.
+// It reports all consumer groups known to the cluster along with their
+// registered members: the client id, subscribed topics, claimed partitions,
+// and the subscription timestamp of each, so that group membership health
+// can be inspected without querying one topic at a time.
+func (s *T) handleListConsumerGroups(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	groups, err := pxy.ListConsumerGroups()
+	if err != nil {
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, listConsumerGroupsRs{Groups: groups})
+}
+
+// handleListTopics is an HTTP request handler for `GET /topics`. A `filter`
+// regular expression narrows the listing down to matching topic names, and
+// the `offset` and `limit` parameters paginate the sorted result, so that
+// clusters with tens of thousands of topics can be listed page by page.
+func (s *T) handleListTopics(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var err error
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	_, withConfig := r.Form[prmTopicsWithConfig]
+	_, withPartitions := r.Form[prmTopicsWithPartitions]
+
+	var filter *regexp.Regexp
+	if filterStr := r.FormValue(prmFilter); filterStr != "" {
+		if filter, err = regexp.Compile(filterStr); err != nil {
+			err = errors.Wrapf(err, "invalid %s", prmFilter)
+			s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+			return
+		}
+	}
+	offset, limit, err := parsePageParams(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	topicsMetadata, err := pxy.ListTopics(filter, offset, limit, withPartitions, withConfig)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+
+	if withPartitions || withConfig {
+		topicMetadataViews := make(map[string]*topicMetadata)
+		for _, tm := range topicsMetadata {
+			topicMetadataView := newTopicMetadataView(withPartitions, withConfig, tm)
+			topicMetadataViews[tm.Topic] = &topicMetadataView
+		}
+		s.respondWithJSON(w, http.StatusOK, topicMetadataViews)
+		return
+	}
+
+	topics := make([]string, 0, len(topicsMetadata))
+	for _, tm := range topicsMetadata {
+		topics = append(topics, tm.Topic)
+	}
+	s.respondWithJSON(w, http.StatusOK, topics)
+}
+
+// handleGetTopicMetadata is an HTTP request handler for `GET /topics/{topic}`
+func (s *T) handleGetTopicMetadata(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var err error
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+
+	err = r.ParseForm()
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	withConfig := true
+	_, withPartitions := r.Form[prmTopicsWithPartitions]
+
+	tm, err := pxy.GetTopicMetadata(topic, withPartitions, withConfig)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+
+	tm_view := newTopicMetadataView(withPartitions, withConfig, tm)
+	s.respondWithJSON(w, http.StatusOK, tm_view)
+}
+
+// handleCreateTopic is an HTTP request handler for `POST /topics`. The
+// request body is a JSON object specifying the topic name, partition count,
+// replication factor and optional topic level config entries, e.g.
+// `{"topic": "foo", "partitions": 8, "replication_factor": 3,
+// "config": {"retention.ms": "86400000"}}`. Creating a topic that already
+// exists is not an error.
+func (s *T) handleCreateTopic(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+	var rq createTopicRq
+	if err := json.Unmarshal(body, &rq); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+	switch {
+	case rq.Topic == "":
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{"topic must not be empty"})
+		return
+	case rq.Partitions < 1:
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{"partitions must be > 0"})
+		return
+	case rq.ReplicationFactor < 1:
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{"replication_factor must be > 0"})
+		return
+	}
+
+	if err := pxy.CreateTopic(rq.Topic, rq.Partitions, rq.ReplicationFactor, rq.Config); err != nil {
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleDeleteTopic is an HTTP request handler for `DELETE /topics/{topic}`.
+// It deletes the topic along with all its messages. The operation is
+// irreversible and the API has to be explicitly enabled for the cluster via
+// the admin.allow_topic_deletion parameter.
+func (s *T) handleDeleteTopic(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+
+	if err := pxy.DeleteTopic(topic); err != nil {
+		status := http.StatusInternalServerError
+		switch errors.Cause(err) {
+		case admin.ErrTopicDeletionDisabled:
+			status = http.StatusForbidden
+		case sarama.ErrUnknownTopicOrPartition:
+			status = http.StatusNotFound
+		}
+		s.respondWithJSON(w, status, errorRs{err.Error()})
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleAlterTopicConfig is an HTTP request handler for
+// `PUT /topics/{topic}/config`. The request body is a JSON object with the
+// topic level config entries to set, e.g.
+// `{"config": {"retention.ms": "86400000"}}`. Entries that are not mentioned
+// are kept intact.
+func (s *T) handleAlterTopicConfig(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+	var rq alterTopicConfigRq
+	if err := json.Unmarshal(body, &rq); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+	if len(rq.Config) == 0 {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{"config must not be empty"})
+		return
+	}
+
+	if err := pxy.AlterTopicConfig(topic, rq.Config); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			status = http.StatusNotFound
+		}
+		s.respondWithJSON(w, status, errorRs{err.Error()})
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleAddFault is an HTTP request handler for `POST /_faults`
+func (s *T) handleAddFault(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	if !pxy.FaultsEnabled() {
+		s.respondWithJSON(w, http.StatusForbidden, errorRs{"fault injection is disabled by configuration"})
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+	var fv faultView
+	if err := json.Unmarshal(body, &fv); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+	fault, err := fv.toFault()
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	if err := pxy.AddFault(fault); err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleListFaults is an HTTP request handler for `GET /_faults`
+func (s *T) handleListFaults(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	if !pxy.FaultsEnabled() {
+		s.respondWithJSON(w, http.StatusForbidden, errorRs{"fault injection is disabled by configuration"})
+		return
+	}
+
+	faults := pxy.ListFaults()
+	faultViews := make([]faultView, len(faults))
+	for i, f := range faults {
+		faultViews[i] = newFaultView(f)
+	}
+	s.respondWithJSON(w, http.StatusOK, faultViews)
+}
+
+// handleClearFaults is an HTTP request handler for `DELETE /_faults`
+func (s *T) handleClearFaults(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	if !pxy.FaultsEnabled() {
+		s.respondWithJSON(w, http.StatusForbidden, errorRs{"fault injection is disabled by configuration"})
+		return
+	}
+
+	pxy.ClearFaults()
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleDeleteRecords is an HTTP request handler for
+// `DELETE /topics/{topic}/partitions/{partition}/records?before_offset=N`. It
+// deletes all records of the partition with offsets older than the specified
+// one and reports the new low watermark. The operation is irreversible and
+// the API has to be explicitly enabled for the cluster via the
+// admin.allow_records_deletion parameter.
+func (s *T) handleDeleteRecords(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var err error
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+
+	partition, err := strconv.Atoi(mux.Vars(r)[prmPartition])
+	if err != nil {
+		err = errors.Wrapf(err, "invalid %s", prmPartition)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	beforeOffsetStr := r.FormValue(prmBeforeOffset)
+	if beforeOffsetStr == "" {
+		err = errors.Errorf("missing %s", prmBeforeOffset)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	beforeOffset, err := strconv.ParseInt(beforeOffsetStr, 10, 64)
+	if err != nil || beforeOffset < 0 {
+		err = errors.Errorf("invalid %s: %s", prmBeforeOffset, beforeOffsetStr)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	lowWatermark, err := pxy.DeleteRecords(topic, int32(partition), beforeOffset)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Cause(err) == admin.ErrRecordsDeletionDisabled {
+			status = http.StatusForbidden
+		}
+		s.respondWithJSON(w, status, errorRs{err.Error()})
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, deleteRecordsRs{
+		Partition:    int32(partition),
+		LowWatermark: lowWatermark,
+	})
+}
+
+// handleGetAckGaps is an HTTP request handler for
+// `GET /topics/{topic}/partitions/{partition}/gaps`. It dumps the not acked
+// offset ranges that hold the committed offset of a group-topic-partition
+// back, as of the last offset tracker report, to diagnose cases where lag is
+// not going down although consumers keep acking. Only partitions consumed by
+// this instance can be inspected.
+func (s *T) handleGetAckGaps(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var err error
+
+	if _, err = s.getProxy(r); err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+	partition, err := strconv.Atoi(mux.Vars(r)[prmPartition])
+	if err != nil {
+		err = errors.Wrapf(err, "invalid %s", prmPartition)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	sn, ok := partitioncsm.TrackerSnapshot(group, topic, int32(partition))
+	if !ok {
+		s.respondWithJSON(w, http.StatusNotFound, errorRs{"partition is not consumed by this instance"})
+		return
+	}
+	gapViews := make([]gapView, len(sn.Gaps))
+	for i, gap := range sn.Gaps {
+		gapViews[i] = gapView{From: gap.From, To: gap.To}
+	}
+	rs := ackGapsRs{
+		Partition:    int32(partition),
+		Offset:       sn.Offset,
+		WindowWidth:  sn.WindowWidth,
+		SparseRanges: sn.SparseRanges,
+		Gaps:         gapViews,
+		OldestOffset: sn.OldestOffset,
+	}
+	if sn.OldestOffset >= 0 {
+		rs.OldestAge = sn.OldestAge.Truncate(time.Millisecond).String()
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleGetGroupLag is an HTTP request handler for
+// `GET /topics/{topic}/consumers/{group}/lag`. It reports the committed
+// offset, high water mark and lag of every partition of the topic for the
+// group, plus the total lag, so operators can see whether a group is falling
+// behind without running external tooling.
+func (s *T) handleGetGroupLag(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+	group := mux.Vars(r)[prmGroup]
+
+	partitionOffsets, err := pxy.GetGroupOffsets(group, topic)
+	if err != nil {
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
+			return
+		}
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+
+	rs := groupLagRs{
+		Topic:      topic,
+		Group:      group,
+		Partitions: make([]partitionLagView, 0, len(partitionOffsets)),
+	}
+	for _, po := range partitionOffsets {
+		var lag int64
+		switch po.Offset {
+		case sarama.OffsetNewest:
+			lag = 0
+		case sarama.OffsetOldest:
+			lag = po.End - po.Begin
+		default:
+			lag = po.End - po.Offset
+		}
+		rs.TotalLag += lag
+		rs.Partitions = append(rs.Partitions, partitionLagView{
+			Partition: po.Partition,
+			Offset:    po.Offset,
+			End:       po.End,
+			Lag:       lag,
+		})
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleGetGroupAssignment is an HTTP request handler for
+// `GET /topics/{topic}/consumers/{group}/assignment`. It reports the topic
+// partitions the group has assigned to this Kafka-Pixy instance along with a
+// suggested concurrency, so that a worker pool consuming via this instance
+// can size itself dynamically as rebalances happen.
+func (s *T) handleGetGroupAssignment(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	topic := mux.Vars(r)[prmTopic]
+	group := mux.Vars(r)[prmGroup]
+
+	consumers, err := pxy.GetTopicConsumers(group, topic)
+	if err != nil {
+		if _, ok := err.(admin.ErrInvalidParam); ok {
+			s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+			return
+		}
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+
+	clientID := pxy.Cfg().ClientID
+	partitions := make([]int32, 0, len(consumers[clientID]))
+	partitions = append(partitions, consumers[clientID]...)
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	s.respondWithJSON(w, http.StatusOK, groupAssignmentRs{
+		Topic:          topic,
+		Group:          group,
+		ClientID:       clientID,
+		Partitions:     partitions,
+		PartitionCount: len(partitions),
+		// One worker per assigned partition keeps every partition busy
+		// without workers idling on partitions this instance does not own.
+		SuggestedConcurrency: len(partitions),
+	})
+}
+
+// handleGetGroupsLag is an HTTP request handler for `GET /groups/lag`. It
+// reports the committed-offset vs log-end-offset lag of every consumer group
+// in the cluster that has committed offsets, not only the groups managed by
+// this Kafka-Pixy instance, optionally narrowed down to the groups whose name
+// matches the `filter` regular expression. The `offset` and `limit`
+// parameters paginate the alphabetically sorted group list, so that one
+// request does not have to query the coordinator of every group in a large
+// cluster.
+func (s *T) handleGetGroupsLag(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var err error
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	var filter *regexp.Regexp
+	if filterStr := r.FormValue(prmFilter); filterStr != "" {
+		if filter, err = regexp.Compile(filterStr); err != nil {
+			err = errors.Wrapf(err, "invalid %s", prmFilter)
+			s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+			return
+		}
+	}
+
+	offset, limit, err := parsePageParams(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+
+	lags, err := pxy.GetGroupsLag(filter, offset, limit)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+
+	groups := make(map[string]map[string][]partitionLagView, len(lags))
+	for group, topicLags := range lags {
+		topics := make(map[string][]partitionLagView, len(topicLags))
+		for topic, partitionLags := range topicLags {
+			views := make([]partitionLagView, 0, len(partitionLags))
+			for _, pl := range partitionLags {
+				views = append(views, partitionLagView{
+					Partition: pl.Partition,
+					Offset:    pl.Offset,
+					End:       pl.End,
+					Lag:       pl.Lag,
+				})
+			}
+			topics[topic] = views
+		}
+		groups[group] = topics
+	}
+	s.respondWithJSON(w, http.StatusOK, groupsLagRs{Groups: groups})
+}
+
+// handleExportGroup is an HTTP request handler for `GET /groups/{group}/export`.
+// It snapshots the state of a consumer group - member subscriptions,
+// partition ownership, committed offsets, and the ack windows of partitions
+// consumed by this instance - to a portable JSON document that can be posted
+// to the restore endpoint, e.g. on another ZooKeeper ensemble or Kafka-Pixy
+// fleet.
+func (s *T) handleExportGroup(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	group := mux.Vars(r)[prmGroup]
+
+	exp, err := pxy.ExportGroup(group)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
+	}
+	rs := groupExportRs{
+		Group:      exp.Group,
+		ExportedAt: exp.ExportedAt.Format(time.RFC3339),
+		Members:    exp.Members,
+		Consumers:  exp.Consumers,
+		Offsets:    make(map[string][]groupOffsetView, len(exp.Offsets)),
+	}
+	for topic, offsets := range exp.Offsets {
+		views := make([]groupOffsetView, len(offsets))
+		for i, po := range offsets {
+			views[i] = groupOffsetView{
+				Partition: po.Partition,
+				Offset:    po.Offset,
+				Metadata:  po.Metadata,
+			}
+		}
+		rs.Offsets[topic] = views
+	}
+	for topic, sns := range partitioncsm.GroupTrackerSnapshots(group) {
+		views := make([]ackWindowView, 0, len(sns))
+		for partition, sn := range sns {
+			views = append(views, ackWindowView{
+				Partition:    partition,
+				Offset:       sn.Offset,
+				WindowWidth:  sn.WindowWidth,
+				SparseRanges: sn.SparseRanges,
+			})
+		}
+		sort.Slice(views, func(i, j int) bool { return views[i].Partition < views[j].Partition })
+		if rs.AckWindows == nil {
+			rs.AckWindows = make(map[string][]ackWindowView)
+		}
+		rs.AckWindows[topic] = views
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleRestoreGroup is an HTTP request handler for
+// `POST /groups/{group}/restore`. It applies the committed offsets recorded
+// in an export document to the group given in the URL, which does not have
+// to be the group the document was exported from. Member subscriptions,
+// partition ownership and ack windows are runtime state that consumers
+// recreate when they join the group, so those parts of the document are
+// ignored.
+func (s *T) handleRestoreGroup(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		return
+	}
+	group := mux.Vars(r)[prmGroup]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+	var expView groupExportRs
+	if err := json.Unmarshal(body, &expView); err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+		return
+	}
+
+	exp := admin.GroupExport{Offsets: make(map[string][]admin.PartitionOffset, len(expView.Offsets))}
+	for topic, views := range expView.Offsets {
+		offsets := make([]admin.PartitionOffset, len(views))
+		for i, view := range views {
+			offsets[i].Partition = view.Partition
+			offsets[i].Offset = view.Offset
+			offsets[i].Metadata = view.Metadata
 		}
+		exp.Offsets[topic] = offsets
+	}
+
+	if err := pxy.RestoreGroup(group, exp); err != nil {
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		return
 	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
 
-	encodedRes, err := json.MarshalIndent(consumers, "", "  ")
-	if err != nil {
-		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", http.StatusOK, encodedRes)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// handleGetGroupEvents is an HTTP request handler for
+// `GET /groups/{group}/events`. It returns the bounded in-memory log of
+// recent group lifecycle events - member joins and leaves, subscription
+// expiries, partition assignments and offset commit failures - recorded by
+// this instance, so the last hour of group churn can be reconstructed without
+// trawling log files.
+func (s *T) handleGetGroupEvents(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, err := s.getProxy(r); err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
-	encodedRes = prettyfmt.CollapseJSON(encodedRes)
+	group := mux.Vars(r)[prmGroup]
+
+	events := groupevents.Events(group)
+	rs := groupEventsRs{Group: group, Events: make([]groupEventView, len(events))}
+	for i, e := range events {
+		rs.Events[i] = groupEventView{
+			At:      e.At.Format(time.RFC3339),
+			Kind:    string(e.Kind),
+			Details: e.Details,
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
 
-	w.Header().Add(hdrContentType, "application/json")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write(encodedRes); err != nil {
-		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", http.StatusOK, encodedRes)
+// handleListFeatures is an HTTP request handler for `GET /features`. It
+// reports the effective global state of all feature flags along with per
+// topic and per group overrides.
+func (s *T) handleListFeatures(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	flags, overrides := features.Snapshot()
+	rs := featuresRs{Flags: flags, Overrides: make([]featureOverrideView, len(overrides))}
+	for i, o := range overrides {
+		rs.Overrides[i] = featureOverrideView{
+			Flag:    o.Flag,
+			Topic:   o.Topic,
+			Group:   o.Group,
+			Enabled: o.Enabled,
+		}
 	}
+	sort.Slice(rs.Overrides, func(i, j int) bool {
+		l, r := rs.Overrides[i], rs.Overrides[j]
+		if l.Flag != r.Flag {
+			return l.Flag < r.Flag
+		}
+		if l.Topic != r.Topic {
+			return l.Topic < r.Topic
+		}
+		return l.Group < r.Group
+	})
+	s.respondWithJSON(w, http.StatusOK, rs)
 }
 
-// handleListTopics is an HTTP request handler for `GET /topics`
-func (s *T) handleListTopics(w http.ResponseWriter, r *http.Request) {
+// handleSetFeature is an HTTP request handler for `POST /features/<feature>`.
+// It toggles a feature flag at run time, globally or for a particular topic
+// or group if the respective parameter is given.
+func (s *T) handleSetFeature(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	var err error
 
-	pxy, err := s.getProxy(r)
+	feature := mux.Vars(r)[prmFeature]
+	enabledStr := r.FormValue(prmEnabled)
+	enabled, err := strconv.ParseBool(enabledStr)
 	if err != nil {
+		s.respondWithJSON(w, http.StatusBadRequest,
+			errorRs{fmt.Sprintf("invalid %s: %s", prmEnabled, enabledStr)})
+		return
+	}
+	topic := r.FormValue(prmTopic)
+	group := r.FormValue(prmGroup)
+	if err := features.Set(feature, topic, group, enabled); err != nil {
 		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
+	s.actDesc.Log().Infof("Feature flag set: flag=%s, topic=%s, group=%s, enabled=%t",
+		feature, topic, group, enabled)
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
 
-	err = r.ParseForm()
+// handleListSubscriptions is an HTTP request handler for `GET /_subscriptions`
+func (s *T) handleListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
 	if err != nil {
 		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
 
-	_, withConfig := r.Form[prmTopicsWithConfig]
-	_, withPartitions := r.Form[prmTopicsWithPartitions]
+	subs := pxy.ListSubscriptions()
+	subViews := make([]subscriptionView, len(subs))
+	for i, sub := range subs {
+		subViews[i] = subscriptionView{
+			Group:   sub.Group,
+			Topic:   sub.Topic,
+			IdleFor: sub.IdleFor.Truncate(time.Millisecond).String(),
+			Expired: sub.Expired,
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, subViews)
+}
+
+// handleGetMetadataInfo is an HTTP request handler for `GET /_metadata`
+func (s *T) handleGetMetadataInfo(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
 
-	topicsMetadata, err := pxy.ListTopics(withPartitions, withConfig)
+	pxy, err := s.getProxy(r)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
 		return
 	}
 
-	if withPartitions || withConfig {
-		topicMetadataViews := make(map[string]*topicMetadata)
-		for _, tm := range topicsMetadata {
-			topicMetadataView := newTopicMetadataView(withPartitions, withConfig, tm)
-			topicMetadataViews[tm.Topic] = &topicMetadataView
+	refreshedAt := pxy.MetadataRefreshedAt()
+	s.respondWithJSON(w, http.StatusOK, metadataInfoRs{
+		RefreshedAt: refreshedAt.UTC().Format(time.RFC3339),
+		Age:         time.Since(refreshedAt).Truncate(time.Millisecond).String(),
+	})
+}
+
+// handleTopicStats is an HTTP request handler for `GET /_stats/topics`. It
+// reports per-topic message and byte counts observed by this instance over
+// sliding windows of the last 1 and 5 minutes, in both produce and consume
+// directions, so platform dashboards can show usage without broker JMX
+// access. The stats are process wide and are not scoped to a cluster.
+func (s *T) handleTopicStats(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	topicStats := make(map[string]*topicStatsView)
+	statsFor := func(topic string) *topicStatsView {
+		tsv := topicStats[topic]
+		if tsv == nil {
+			tsv = &topicStatsView{}
+			topicStats[topic] = tsv
 		}
-		s.respondWithJSON(w, http.StatusOK, topicMetadataViews)
-		return
+		return tsv
 	}
+	for name, ts := range metrics.TrafficSnapshot() {
+		view := trafficView{
+			Messages1m: ts.Messages1m,
+			Bytes1m:    ts.Bytes1m,
+			Messages5m: ts.Messages5m,
+			Bytes5m:    ts.Bytes5m,
+		}
+		switch {
+		case strings.HasPrefix(name, proxy.TrafficProducePrefix):
+			statsFor(name[len(proxy.TrafficProducePrefix):]).Produce = &view
+		case strings.HasPrefix(name, proxy.TrafficConsumePrefix):
+			statsFor(name[len(proxy.TrafficConsumePrefix):]).Consume = &view
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, topicStatsRs{Topics: topicStats})
+}
 
-	topics := make([]string, 0, len(topicsMetadata))
-	for _, tm := range topicsMetadata {
-		topics = append(topics, tm.Topic)
+// handleZookeeperStatus is an HTTP request handler for `GET /_zookeeper`. It
+// reports the current state, the total number of state transitions and the
+// number of reconnects of every ZooKeeper session held by this instance, so
+// that session flapping can be told apart from genuine group membership
+// changes.
+func (s *T) handleZookeeperStatus(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	sessions := kazoo.SessionSnapshot()
+	sessionViews := make([]zkSessionView, 0, len(sessions))
+	for _, si := range sessions {
+		sessionViews = append(sessionViews, zkSessionView{
+			Name:           si.Name,
+			State:          si.State,
+			Transitions:    si.Transitions,
+			Reconnects:     si.Reconnects,
+			LastTransition: si.LastTransition.Format(time.RFC3339),
+		})
 	}
-	s.respondWithJSON(w, http.StatusOK, topics)
+	sort.Slice(sessionViews, func(i, j int) bool {
+		return sessionViews[i].Name < sessionViews[j].Name
+	})
+	s.respondWithJSON(w, http.StatusOK, zookeeperRs{Sessions: sessionViews})
 }
 
-// handleGetTopicMetadata is an HTTP request handler for `GET /topics/{topic}`
-func (s *T) handleGetTopicMetadata(w http.ResponseWriter, r *http.Request) {
+// handleMetrics is an HTTP request handler for `GET /_metrics`. The counters
+// are process wide and are not scoped to a cluster.
+func (s *T) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	var err error
 
-	pxy, err := s.getProxy(r)
-	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
-		return
+	durations := metrics.DurationSnapshot()
+	durationViews := make(map[string]durationView, len(durations))
+	for name, ds := range durations {
+		durationViews[name] = durationView{
+			Count: ds.Count,
+			Avg:   (ds.Sum / time.Duration(ds.Count)).Truncate(time.Microsecond).String(),
+			Min:   ds.Min.Truncate(time.Microsecond).String(),
+			Max:   ds.Max.Truncate(time.Microsecond).String(),
+		}
 	}
-	topic := mux.Vars(r)[prmTopic]
+	s.respondWithJSON(w, http.StatusOK, metricsRs{
+		Counters:  metrics.Snapshot(),
+		Gauges:    metrics.GaugeSnapshot(),
+		Durations: durationViews,
+	})
+}
 
-	err = r.ParseForm()
-	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+func (s *T) handlePing(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("pong"))
+}
+
+// handleRestartProxy is an HTTP request handler for `POST /_restart`. It
+// stops the proxy serving the cluster and spawns a replacement from the same
+// configuration, so that a single misbehaving proxy can be bounced without
+// restarting the whole process and disturbing the other clusters. The call
+// blocks until the replacement is up; if it fails to connect, then 500 is
+// returned and the cluster keeps being retried in the background, showing up
+// as not ready in `GET /_ready` until it recovers.
+func (s *T) handleRestartProxy(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	cluster := mux.Vars(r)[prmCluster]
+	if cluster != "" {
+		readiness, _ := s.proxySet.Readiness()
+		if _, ok := readiness[cluster]; !ok {
+			s.respondWithJSON(w, http.StatusBadRequest,
+				errorRs{fmt.Sprintf("proxy `%s` does not exist", cluster)})
+			return
+		}
+	}
+	if err := s.proxySet.Restart(cluster); err != nil {
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
 		return
 	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleReady is an HTTP request handler for `GET /_ready`. It reports for
+// every configured cluster whether its proxy is connected. In the degraded
+// startup mode proxies whose clusters were unreachable at boot connect in the
+// background, and until all of them do the endpoint responds with 503, so it
+// can back a readiness probe while `GET /_ping` backs a liveness probe.
+func (s *T) handleReady(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	proxies, allReady := s.proxySet.Readiness()
+	status := http.StatusOK
+	if !allReady {
+		status = http.StatusServiceUnavailable
+	}
+	s.respondWithJSON(w, status, readyRs{Ready: allReady, Proxies: proxies})
+}
 
-	withConfig := true
-	_, withPartitions := r.Form[prmTopicsWithPartitions]
+var startedAt = time.Now()
+
+// apiFeatures lists optional capabilities of the HTTP API, so that fleet
+// automation can check for a capability instead of comparing version numbers.
+var apiFeatures = []string{
+	"ack-gaps",
+	"ack-tokens",
+	"batch-consume",
+	"config-dump",
+	"consume-stream",
+	"delete-records",
+	"fault-injection",
+	"feature-flags",
+	"group-assignment",
+	"group-export",
+	"group-lag",
+	"groups-lag",
+	"list-groups",
+	"offsets-clone",
+	"offsets-preview",
+	"offsets-reset",
+	"partitions-health",
+	"peek",
+	"proxy-restart",
+	"readiness",
+	"skip-to-live",
+	"tail",
+	"topic-management",
+}
 
-	tm, err := pxy.GetTopicMetadata(topic, withPartitions, withConfig)
+// handleGetConfig is an HTTP request handler for `GET /_config`. It dumps
+// the fully resolved runtime configuration, defaults included and secrets
+// redacted, along with a diff against the configuration file on disk, so
+// operators can confirm exactly what a running instance is using and whether
+// the file has drifted since it was loaded.
+func (s *T) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if s.appCfg == nil {
+		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{"config is not available"})
+		return
+	}
+	runtimeMap, err := s.appCfg.RedactedMap()
 	if err != nil {
 		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
 		return
 	}
-
-	tm_view := newTopicMetadataView(withPartitions, withConfig, tm)
-	s.respondWithJSON(w, http.StatusOK, tm_view)
+	rs := configRs{Config: runtimeMap, SourceFile: s.appCfg.SourceFile()}
+	if rs.SourceFile != "" {
+		fileCfg, err := config.FromYAMLFile(rs.SourceFile)
+		if err != nil {
+			// The file may have been deleted or broken since the instance
+			// started, the runtime dump is still good.
+			rs.FileError = err.Error()
+		} else if fileMap, err := fileCfg.RedactedMap(); err != nil {
+			rs.FileError = err.Error()
+		} else {
+			rs.FileDiff = config.DiffMaps(runtimeMap, fileMap)
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
 }
 
-func (s *T) handlePing(w http.ResponseWriter, r *http.Request) {
+// handleInfo is an HTTP request handler for `GET /_info`. It reports the
+// version and git commit the binary was built from, Go runtime statistics,
+// the configured clusters and the optional API capabilities of this build,
+// so that fleet automation can verify exactly what is deployed.
+func (s *T) handleInfo(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("pong"))
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	clusters, defaultCluster := s.proxySet.Clusters()
+	s.respondWithJSON(w, http.StatusOK, infoRs{
+		Version:        version.Version,
+		GitCommit:      version.GitCommit,
+		GoVersion:      runtime.Version(),
+		Uptime:         time.Since(startedAt).Truncate(time.Second).String(),
+		NumGoroutine:   runtime.NumGoroutine(),
+		NumCPU:         runtime.NumCPU(),
+		HeapAllocBytes: memStats.HeapAlloc,
+		Clusters:       clusters,
+		DefaultCluster: defaultCluster,
+		Features:       apiFeatures,
+	})
+}
+
+type readyRs struct {
+	Ready   bool            `json:"ready"`
+	Proxies map[string]bool `json:"proxies"`
+}
+
+type ackRangesRq struct {
+	Partition int32 `json:"partition"`
+	Ranges    []struct {
+		From int64 `json:"from"`
+		To   int64 `json:"to"`
+	} `json:"ranges"`
+}
+
+type subscriptionView struct {
+	Group   string `json:"group"`
+	Topic   string `json:"topic"`
+	IdleFor string `json:"idle_for"`
+	Expired bool   `json:"expired"`
+}
+
+type deleteRecordsRs struct {
+	Partition    int32 `json:"partition"`
+	LowWatermark int64 `json:"low_watermark"`
+}
+
+type ackGapsRs struct {
+	Partition    int32     `json:"partition"`
+	Offset       int64     `json:"offset"`
+	WindowWidth  int64     `json:"window_width"`
+	SparseRanges int       `json:"sparse_ranges"`
+	Gaps         []gapView `json:"gaps"`
+	OldestOffset int64     `json:"oldest_offset"`
+	OldestAge    string    `json:"oldest_age,omitempty"`
+}
+
+type gapView struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// groupExportRs is a portable snapshot of consumer group state produced by
+// the export endpoint. The very same document is accepted by the restore
+// endpoint, of which only the offsets part is applied.
+type groupExportRs struct {
+	Group      string                        `json:"group"`
+	ExportedAt string                        `json:"exported_at"`
+	Members    map[string][]string           `json:"members,omitempty"`
+	Consumers  map[string]map[string][]int32 `json:"consumers,omitempty"`
+	Offsets    map[string][]groupOffsetView  `json:"offsets"`
+	AckWindows map[string][]ackWindowView    `json:"ack_windows,omitempty"`
+}
+
+type groupOffsetView struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Metadata  string `json:"metadata,omitempty"`
+}
+
+type ackWindowView struct {
+	Partition    int32 `json:"partition"`
+	Offset       int64 `json:"offset"`
+	WindowWidth  int64 `json:"window_width"`
+	SparseRanges int   `json:"sparse_ranges"`
+}
+
+type groupEventsRs struct {
+	Group  string           `json:"group"`
+	Events []groupEventView `json:"events"`
+}
+
+type groupEventView struct {
+	At      string `json:"at"`
+	Kind    string `json:"kind"`
+	Details string `json:"details"`
+}
+
+type featuresRs struct {
+	Flags     map[string]bool       `json:"flags"`
+	Overrides []featureOverrideView `json:"overrides"`
+}
+
+type featureOverrideView struct {
+	Flag    string `json:"flag"`
+	Topic   string `json:"topic,omitempty"`
+	Group   string `json:"group,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+type createTopicRq struct {
+	Topic             string            `json:"topic"`
+	Partitions        int32             `json:"partitions"`
+	ReplicationFactor int16             `json:"replication_factor"`
+	Config            map[string]string `json:"config,omitempty"`
+}
+
+type alterTopicConfigRq struct {
+	Config map[string]string `json:"config"`
+}
+
+type listConsumerGroupsRs struct {
+	Groups map[string]map[string]admin.GroupMemberInfo `json:"groups"`
+}
+
+type groupAssignmentRs struct {
+	Topic                string  `json:"topic"`
+	Group                string  `json:"group"`
+	ClientID             string  `json:"client_id"`
+	Partitions           []int32 `json:"partitions"`
+	PartitionCount       int     `json:"partition_count"`
+	SuggestedConcurrency int     `json:"suggested_concurrency"`
+}
+
+type groupLagRs struct {
+	Topic      string             `json:"topic"`
+	Group      string             `json:"group"`
+	TotalLag   int64              `json:"total_lag"`
+	Partitions []partitionLagView `json:"partitions"`
+}
+
+type groupsLagRs struct {
+	Groups map[string]map[string][]partitionLagView `json:"groups"`
+}
+
+type partitionLagView struct {
+	Partition int32 `json:"partition"`
+	Offset    int64 `json:"offset"`
+	End       int64 `json:"end"`
+	Lag       int64 `json:"lag"`
+}
+
+type zookeeperRs struct {
+	Sessions []zkSessionView `json:"sessions"`
+}
+
+type zkSessionView struct {
+	Name           string `json:"name"`
+	State          string `json:"state"`
+	Transitions    int64  `json:"transitions"`
+	Reconnects     int64  `json:"reconnects"`
+	LastTransition string `json:"last_transition"`
+}
+
+type metricsRs struct {
+	Counters  map[string]int64        `json:"counters"`
+	Gauges    map[string]int64        `json:"gauges"`
+	Durations map[string]durationView `json:"durations"`
+}
+
+type durationView struct {
+	Count int64  `json:"count"`
+	Avg   string `json:"avg"`
+	Min   string `json:"min"`
+	Max   string `json:"max"`
+}
+
+type metadataInfoRs struct {
+	// When the cluster metadata cache was last known to be refreshed. The
+	// reported age is an upper bound, for the Kafka client also refreshes
+	// metadata in the background.
+	RefreshedAt string `json:"refreshed_at"`
+	Age         string `json:"age"`
 }
 
 type produceRs struct {
 	Partition int32 `json:"partition"`
 	Offset    int64 `json:"offset"`
+
+	// Timestamp assigned to the message, present if the broker reported one.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+
+	// Address and ID of the broker that was the leader of the destination
+	// partition at the time the message was produced.
+	Leader   string `json:"leader,omitempty"`
+	LeaderID *int32 `json:"leader_id,omitempty"`
 }
 
 type consumeHeader struct {
@@ -619,12 +2701,120 @@ type consumeHeader struct {
 	Value []byte `json:"value"`
 }
 
+type topicStatsRs struct {
+	Topics map[string]*topicStatsView `json:"topics"`
+}
+
+type topicStatsView struct {
+	Produce *trafficView `json:"produce,omitempty"`
+	Consume *trafficView `json:"consume,omitempty"`
+}
+
+type trafficView struct {
+	Messages1m int64 `json:"messages_1m"`
+	Bytes1m    int64 `json:"bytes_1m"`
+	Messages5m int64 `json:"messages_5m"`
+	Bytes5m    int64 `json:"bytes_5m"`
+}
+
+type peekRs struct {
+	Messages []consumeRs `json:"messages"`
+}
+
+// consumeBatchRs is the response of a consume request with the maxMessages or
+// maxBytes parameter set.
+type consumeBatchRs struct {
+	Messages []consumeRs `json:"messages"`
+}
+
 type consumeRs struct {
-	Key       []byte          `json:"key"`
-	Value     []byte          `json:"value"`
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+
+	// The topic the message was read from. It is of interest to wildcard
+	// subscriptions where the concrete topic is not known upfront, and it
+	// must be used in the ack request for the message.
+	Topic     string          `json:"topic,omitempty"`
 	Partition int32           `json:"partition"`
 	Offset    int64           `json:"offset"`
 	Headers   []consumeHeader `json:"headers"`
+
+	// The number of messages that were skipped right before this one because
+	// the broker retention policy deleted them before they could be consumed.
+	// The deleted messages are gone, so the exact time span of the loss is
+	// unknowable, but all of them were produced before this message was.
+	// Omitted when no messages were lost, i.e. almost always.
+	OffsetsLost int64 `json:"offsets_lost,omitempty"`
+
+	// Coordinates of the record batch the message was fetched in, for
+	// correlation with broker-side tooling. Omitted for messages read from
+	// the legacy message set format.
+	Batch *batchView `json:"batch,omitempty"`
+
+	// Codec the value is compressed with, if compressed delivery was
+	// requested via the codec parameter. Omitted when the value is plain.
+	Codec string `json:"codec,omitempty"`
+
+	// Time by which the message must be acknowledged before it is re-offered,
+	// RFC3339. It is computed from the consumer.ack_timeout setting, so
+	// clients can skip work on messages that are already due for redelivery.
+	// Omitted for auto-acknowledged messages.
+	AckDeadline string `json:"ack_deadline,omitempty"`
+
+	// Opaque signed token that authorizes acknowledging exactly this message
+	// on behalf of the subscription it was offered to, passed back via the
+	// ackToken parameter. When consumer.require_ack_token is enabled it is
+	// the only accepted form of acknowledgement. Omitted for
+	// auto-acknowledged messages.
+	AckToken string `json:"ack_token,omitempty"`
+}
+
+// validateCodec tells whether a value of the codec parameter is supported.
+func validateCodec(codec string) error {
+	switch codec {
+	case "", "gzip":
+		return nil
+	}
+	return errors.Errorf("invalid codec: %s", codec)
+}
+
+// compressValue compresses the message value with the requested codec for
+// delivery, saving network on the client side of the proxy at the cost of
+// extra proxy CPU per message. It is deliberately not a pass-through of the
+// compressed broker batches: the Kafka client library decompresses record
+// batches while decoding the fetch response and only exposes individual
+// records, so the compressed batch bytes are not available at this layer
+// without reimplementing the fetch protocol. An empty codec, an empty value,
+// or a value that gzip does not shrink leaves the response unchanged.
+func (rs *consumeRs) compressValue(codec string) {
+	if codec == "" || len(rs.Value) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(rs.Value); err != nil {
+		return
+	}
+	if err := gz.Close(); err != nil {
+		return
+	}
+	if buf.Len() >= len(rs.Value) {
+		return
+	}
+	rs.Value = buf.Bytes()
+	rs.Codec = codec
+}
+
+// endOfRangeRs terminates a bounded consume stream, telling the client that
+// all messages within the requested range have been delivered.
+type endOfRangeRs struct {
+	EndOfRange bool `json:"end_of_range"`
+}
+
+type batchView struct {
+	ProducerID int64 `json:"producer_id"`
+	BaseOffset int64 `json:"base_offset"`
+	BatchIndex int32 `json:"batch_index"`
 }
 
 type partitionInfo struct {
@@ -638,10 +2828,119 @@ type partitionInfo struct {
 	SparseAcks string `json:"sparse_acks,omitempty"`
 }
 
+// resetOffsetsRq is the body of an offset reset request. Exactly one of To
+// and Offsets must be given: To is "earliest", "latest", an RFC3339
+// timestamp or the number of milliseconds since the Unix epoch, and Offsets
+// lists explicit target offsets per partition.
+type resetOffsetsRq struct {
+	To      string            `json:"to"`
+	Offsets []resetOffsetView `json:"offsets"`
+}
+
+type resetOffsetsRs struct {
+	// The offsets that were committed on behalf of the group.
+	Offsets []resetOffsetView `json:"offsets"`
+
+	// Partitions consumed by this instance whose partition consumers were
+	// rewound to the reset offsets in flight.
+	Rewound []int32 `json:"rewound,omitempty"`
+}
+
+type resetOffsetView struct {
+	Partition int32 `json:"partition"`
+	Offset    int64 `json:"offset"`
+}
+
+type offsetPreviewRs struct {
+	Time       string              `json:"time"`
+	Partitions []offsetPreviewView `json:"partitions"`
+}
+
+type offsetPreviewView struct {
+	Partition int32 `json:"partition"`
+	Begin     int64 `json:"begin"`
+	End       int64 `json:"end"`
+	Offset    int64 `json:"offset"`
+	Target    int64 `json:"target"`
+	Replayed  int64 `json:"replayed"`
+	Skipped   int64 `json:"skipped"`
+}
+
+type partitionHealthView struct {
+	Partition int32   `json:"partition"`
+	Leader    int32   `json:"leader"`
+	Replicas  []int32 `json:"replicas"`
+	ISR       []int32 `json:"isr"`
+	Status    string  `json:"status"`
+}
+
 type errorRs struct {
 	Error string `json:"error"`
 }
 
+type configRs struct {
+	Config     map[string]interface{} `json:"config"`
+	SourceFile string                 `json:"source_file,omitempty"`
+	FileError  string                 `json:"file_error,omitempty"`
+	FileDiff   []config.Diff          `json:"file_diff,omitempty"`
+}
+
+type infoRs struct {
+	Version        string   `json:"version"`
+	GitCommit      string   `json:"git_commit,omitempty"`
+	GoVersion      string   `json:"go_version"`
+	Uptime         string   `json:"uptime"`
+	NumGoroutine   int      `json:"num_goroutine"`
+	NumCPU         int      `json:"num_cpu"`
+	HeapAllocBytes uint64   `json:"heap_alloc_bytes"`
+	Clusters       []string `json:"clusters"`
+	DefaultCluster string   `json:"default_cluster"`
+	Features       []string `json:"features"`
+}
+
+// faultView is a JSON representation of proxy.Fault with the delay expressed
+// in the human friendly `time.Duration` string format, e.g. "150ms".
+type faultView struct {
+	Op          string  `json:"op"`
+	Topic       string  `json:"topic,omitempty"`
+	Group       string  `json:"group,omitempty"`
+	Kind        string  `json:"kind"`
+	Delay       string  `json:"delay,omitempty"`
+	Probability float64 `json:"probability,omitempty"`
+}
+
+func (fv faultView) toFault() (proxy.Fault, error) {
+	f := proxy.Fault{
+		Op:          fv.Op,
+		Topic:       fv.Topic,
+		Group:       fv.Group,
+		Kind:        fv.Kind,
+		Probability: fv.Probability,
+	}
+	if fv.Delay != "" {
+		delay, err := time.ParseDuration(fv.Delay)
+		if err != nil {
+			return proxy.Fault{}, errors.Wrapf(err, "bad delay: %s", fv.Delay)
+		}
+		f.Delay = delay
+	}
+	return f, nil
+}
+
+func newFaultView(f proxy.Fault) faultView {
+	fv := faultView{
+		Op:          f.Op,
+		Topic:       f.Topic,
+		Group:       f.Group,
+		Kind:        f.Kind,
+		Probability: f.Probability,
+	}
+	if f.Delay > 0 {
+		fv.Delay = f.Delay.String()
+	}
+	return fv
+}
+
 type topicConfig struct {
 	Version int32             `json:"version"`
 	Config  map[string]string `json:"config"`
@@ -674,6 +2973,22 @@ func getParamBytes(r *http.Request, name string) []byte {
 // respondWithJSON marshals `body` to a JSON string and sends it s an HTTP
 // response body along with the specified `status` code.
 func (s *T) respondWithJSON(w http.ResponseWriter, status int, body interface{}) {
+	// Hot path response types come with hand written easyjson marshalers, see
+	// consumejson.go. They are serialized without reflection, and without
+	// indentation for there is no human in the loop at those rates.
+	if m, ok := body.(easyjson.Marshaler); ok {
+		jw := jwriter.Writer{}
+		m.MarshalEasyJSON(&jw)
+		if jw.Error == nil {
+			w.Header().Add(hdrContentType, "application/json")
+			w.WriteHeader(status)
+			if _, err := jw.DumpTo(w); err != nil {
+				s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d", status)
+			}
+			return
+		}
+		s.actDesc.Log().WithError(jw.Error).Errorf("Failed to marshal HTTP response: status=%d, body=%v", status, body)
+	}
 	encodedRes, err := json.MarshalIndent(body, "", "  ")
 	if err != nil {
 		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", status, body)
@@ -688,6 +3003,25 @@ func (s *T) respondWithJSON(w http.ResponseWriter, status int, body interface{})
 	}
 }
 
+// parsePageParams parses the offset/limit pagination parameters of a list
+// request. Missing parameters default to 0, meaning nothing is skipped and
+// the result size is unbounded respectively.
+func parsePageParams(r *http.Request) (offset, limit int, err error) {
+	if offsetStr := r.FormValue(prmOffset); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.Errorf("invalid %s: %s", prmOffset, offsetStr)
+		}
+	}
+	if limitStr := r.FormValue(prmLimit); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			return 0, 0, errors.Errorf("invalid %s: %s", prmLimit, limitStr)
+		}
+	}
+	return offset, limit, nil
+}
+
 func getGroupParam(r *http.Request, opt bool) (string, error) {
 	r.ParseForm()
 	groups := r.Form[prmGroup]
@@ -700,16 +3034,16 @@ func getGroupParam(r *http.Request, opt bool) (string, error) {
 	return groups[0], nil
 }
 
-// toEncoderPreservingNil converts a slice of bytes to `sarama.Encoder` but
+// toEncoderPreservingNil converts a slice of bytes to `kafkaclt.Encoder` but
 // returns `nil` if the passed slice is `nil`.
-func toEncoderPreservingNil(b []byte) sarama.Encoder {
+func toEncoderPreservingNil(b []byte) kafkaclt.Encoder {
 	if b != nil {
-		return sarama.StringEncoder(b)
+		return kafkaclt.StringEncoder(b)
 	}
 	return nil
 }
 
-func parseAck(r *http.Request, isConsReq bool) (proxy.Ack, error) {
+func parseAck(r *http.Request, isConsReq bool, group, topic string, requireToken bool) (proxy.Ack, error) {
 	var partitionPrmName, offsetPrmName string
 	if isConsReq {
 		partitionPrmName = prmAckPartition
@@ -724,6 +3058,13 @@ func parseAck(r *http.Request, isConsReq bool) (proxy.Ack, error) {
 	if noAck {
 		return proxy.NoAck(), nil
 	}
+	if tokenStr, ok := r.Form[prmAckToken]; ok {
+		partition, offset, err := acktoken.Parse(tokenStr[0], group, topic)
+		if err != nil {
+			return proxy.NoAck(), err
+		}
+		return proxy.NewAck(partition, offset)
+	}
 	var err error
 	var partition int64
 	partitionStr, partitionOk := r.Form[partitionPrmName]
@@ -742,6 +3083,10 @@ func parseAck(r *http.Request, isConsReq bool) (proxy.Ack, error) {
 		}
 	}
 	if partitionOk && offsetOk {
+		if requireToken {
+			return proxy.NoAck(), errors.Errorf(
+				"%s must be used to acknowledge, consumer.require_ack_token is enabled", prmAckToken)
+		}
 		return proxy.NewAck(int32(partition), offset)
 	}
 	if !partitionOk && !offsetOk {
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	NetworkTCP  = "tcp"
+	NetworkUnix = "unix"
+)
+
+// Listen creates a listener for the given API server address. Addresses
+// without a colon are treated as Unix domain socket paths, everything else is
+// listened on as TCP. IPv6 literals are supported both in the canonical
+// bracketed form, e.g. `[::]:19092`, and unbracketed, e.g. `::1:19092`, in
+// which case everything up to the last colon must parse as an IPv6 address.
+// Binding a wildcard IPv6 address yields a dual-stack listener on hosts that
+// allow it. The network the listener was created on is returned along with it.
+func Listen(addr string) (net.Listener, string, error) {
+	if !strings.Contains(addr, ":") {
+		listener, err := net.Listen(NetworkUnix, addr)
+		return listener, NetworkUnix, errors.Wrap(err, "failed to create listener")
+	}
+	listener, err := net.Listen(NetworkTCP, normalizeTCPAddr(addr))
+	return listener, NetworkTCP, errors.Wrap(err, "failed to create listener")
+}
+
+// normalizeTCPAddr brackets the host part of an unbracketed IPv6
+// `host:port` address, so that the net package does not reject it as
+// having too many colons. Addresses that are already well formed are
+// returned unchanged.
+func normalizeTCPAddr(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	i := strings.LastIndex(addr, ":")
+	host, port := addr[:i], addr[i+1:]
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return net.JoinHostPort(host, port)
+	}
+	return addr
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ListenerSuite struct{}
+
+var _ = Suite(&ListenerSuite{})
+
+func (s *ListenerSuite) TestNormalizeTCPAddr(c *C) {
+	for i, tc := range []struct {
+		addr       string
+		normalized string
+	}{
+		0: {"0.0.0.0:19092", "0.0.0.0:19092"},
+		1: {":19092", ":19092"},
+		2: {"[::]:19092", "[::]:19092"},
+		3: {"[::1]:19092", "[::1]:19092"},
+		// Unbracketed IPv6 literals are bracketed.
+		4: {":::19092", "[::]:19092"},
+		5: {"::1:19092", "[::1]:19092"},
+		6: {"2001:db8::1:19092", "[2001:db8::1]:19092"},
+		// Everything else is passed through for net.Listen to reject.
+		7: {"foo:bar:19092", "foo:bar:19092"},
+	} {
+		c.Assert(normalizeTCPAddr(tc.addr), Equals, tc.normalized,
+			Commentf("case #%d: %s", i, tc.addr))
+	}
+}
+
+func (s *ListenerSuite) TestListenIPv6(c *C) {
+	listener, network, err := Listen("[::1]:0")
+	if err != nil {
+		c.Skip("IPv6 is not available: " + err.Error())
+	}
+	defer listener.Close()
+
+	c.Assert(network, Equals, NetworkTCP)
+	c.Assert(listener.Addr().String()[:5], Equals, "[::1]")
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+)
+
+// runDNSWatch periodically re-resolves the hostnames of the Kafka and
+// ZooKeeper seed peers of a cluster and restarts its proxy when the set of
+// addresses a hostname resolves to changes. Without it a node replacement
+// behind a DNS name, common with Kubernetes headless services, leaves the
+// proxy dialing addresses that no longer exist until it is restarted by
+// hand. The check interval is configured via net.re_resolve_interval.
+func (s *T) runDNSWatch(actDesc *actor.Descriptor, cluster string, pxyCfg *config.Proxy) {
+	peers := append([]string{}, pxyCfg.Kafka.SeedPeers...)
+	peers = append(peers, pxyCfg.ZooKeeper.SeedPeers...)
+	resolved := resolveSeedPeers(peers)
+	ticker := time.NewTicker(pxyCfg.Net.ReResolveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stopCh:
+			return
+		}
+		fresh := resolveSeedPeers(peers)
+		changed := diffResolved(resolved, fresh)
+		for host, addrs := range fresh {
+			resolved[host] = addrs
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		actDesc.Log().Infof("Seed peer addresses changed, restarting proxy: hosts=%s",
+			strings.Join(changed, ","))
+		if err := s.RestartProxy(cluster); err != nil {
+			actDesc.Log().WithError(err).Error("Failed to restart proxy")
+		}
+	}
+}
+
+// resolveSeedPeers resolves the hostnames of the given host:port peers to
+// sorted address lists. Peers given as IP addresses are skipped, they cannot
+// change. Hostnames that fail to resolve are omitted, so that a transient
+// DNS failure is not mistaken for a topology change.
+func resolveSeedPeers(peers []string) map[string][]string {
+	resolved := make(map[string][]string)
+	for _, peer := range peers {
+		host, _, err := net.SplitHostPort(peer)
+		if err != nil {
+			host = peer
+		}
+		if net.ParseIP(host) != nil {
+			continue
+		}
+		if _, ok := resolved[host]; ok {
+			continue
+		}
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			continue
+		}
+		sort.Strings(addrs)
+		resolved[host] = addrs
+	}
+	return resolved
+}
+
+// diffResolved returns the sorted list of hosts whose address set differs
+// between two resolution snapshots. Hosts missing from either snapshot,
+// i.e. ones that failed to resolve at the time, are not counted as changed.
+func diffResolved(old, fresh map[string][]string) []string {
+	var changed []string
+	for host, freshAddrs := range fresh {
+		oldAddrs, ok := old[host]
+		if ok && !reflect.DeepEqual(oldAddrs, freshAddrs) {
+			changed = append(changed, host)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
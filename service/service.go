@@ -1,44 +1,154 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/features"
+	"github.com/mailgun/kafka-pixy/pipeline"
 	"github.com/mailgun/kafka-pixy/proxy"
 	"github.com/mailgun/kafka-pixy/server"
 	"github.com/mailgun/kafka-pixy/server/grpcsrv"
 	"github.com/mailgun/kafka-pixy/server/httpsrv"
+	"github.com/mailgun/kafka-pixy/webhook"
 	"github.com/pkg/errors"
 )
 
+const (
+	// In wait and degraded startup modes failed proxy spawns are retried with
+	// exponential backoff between these bounds.
+	startupMinBackoff = 1 * time.Second
+	startupMaxBackoff = 30 * time.Second
+)
+
 type T struct {
-	actDesc *actor.Descriptor
-	proxies map[string]*proxy.T
-	servers []server.T
-	stopCh  chan struct{}
-	wg      sync.WaitGroup
+	actDesc        *actor.Descriptor
+	defaultCluster string
+	proxiesMu      sync.Mutex
+	proxies        map[string]*proxy.T
+	proxyCfgs      map[string]*config.Proxy
+	proxySet       *proxy.Set
+	restartMu      sync.Mutex
+	pipelines      []*pipeline.T
+	webhooks       []*webhook.T
+	servers        []server.T
+	stopCh         chan struct{}
+	stopOnce       sync.Once
+	wg             sync.WaitGroup
 }
 
-func Spawn(cfg *config.App) (*T, error) {
+// Spawn creates a service instance and starts all its goroutines. When ctx is
+// cancelled the service begins a graceful shutdown, equivalent to calling
+// Stop, which makes it easy to tie the service lifecycle to that of an
+// embedding application.
+func Spawn(ctx context.Context, cfg *config.App) (*T, error) {
+	features.Load(cfg.Features.Flags, cfg.Features.FlagsByTopic, cfg.Features.FlagsByGroup)
+
 	s := &T{
-		actDesc: actor.Root().NewChild("service"),
-		proxies: make(map[string]*proxy.T, len(cfg.Proxies)),
-		stopCh:  make(chan struct{}),
+		actDesc:        actor.Root().NewChild("service"),
+		defaultCluster: cfg.DefaultCluster,
+		proxies:        make(map[string]*proxy.T, len(cfg.Proxies)),
+		stopCh:         make(chan struct{}),
+	}
+
+	var proxySet *proxy.Set
+	switch cfg.Startup.Mode {
+	case config.StartupWait:
+		deadline := time.Now().Add(cfg.Startup.MaxWait)
+		for cluster, pxyCfg := range cfg.Proxies {
+			pxy, err := s.awaitProxy(ctx, cluster, pxyCfg, deadline)
+			if err != nil {
+				s.stopProxies()
+				return nil, errors.Wrapf(err, "failed to spawn proxy, name=%s", cluster)
+			}
+			s.proxies[cluster] = pxy
+		}
+		proxySet = proxy.NewSet(s.proxies, s.proxies[cfg.DefaultCluster])
+
+	case config.StartupDegraded:
+		clusters := make([]string, 0, len(cfg.Proxies))
+		for cluster := range cfg.Proxies {
+			clusters = append(clusters, cluster)
+		}
+		proxySet = proxy.NewDegradedSet(clusters, cfg.DefaultCluster)
+		for cluster, pxyCfg := range cfg.Proxies {
+			pxy, err := proxy.Spawn(actor.Root(), cluster, pxyCfg)
+			if err != nil {
+				s.actDesc.Log().WithError(err).Errorf(
+					"Proxy is not ready, retrying in the background: name=%s", cluster)
+				cluster, pxyCfg := cluster, pxyCfg
+				actor.Spawn(s.actDesc.NewChild(fmt.Sprintf("%s_pxy_spawn", cluster)), &s.wg,
+					func() { s.retrySpawnProxy(cluster, pxyCfg, proxySet) })
+				continue
+			}
+			s.proxies[cluster] = pxy
+			proxySet.Put(cluster, pxy)
+		}
+
+	default:
+		for cluster, pxyCfg := range cfg.Proxies {
+			pxy, err := proxy.Spawn(actor.Root(), cluster, pxyCfg)
+			if err != nil {
+				s.stopProxies()
+				return nil, errors.Wrapf(err, "failed to spawn proxy, name=%s", cluster)
+			}
+			s.proxies[cluster] = pxy
+		}
+		proxySet = proxy.NewSet(s.proxies, s.proxies[cfg.DefaultCluster])
 	}
+	s.proxyCfgs = cfg.Proxies
+	s.proxySet = proxySet
+	proxySet.SetRestarter(s.RestartProxy)
 
 	for cluster, pxyCfg := range cfg.Proxies {
-		pxy, err := proxy.Spawn(actor.Root(), cluster, pxyCfg)
+		if pxyCfg.Net.ReResolveInterval <= 0 {
+			continue
+		}
+		cluster, pxyCfg := cluster, pxyCfg
+		actDesc := s.actDesc.NewChild(fmt.Sprintf("%s_dns_watch", cluster))
+		actor.Spawn(actDesc, &s.wg, func() { s.runDNSWatch(actDesc, cluster, pxyCfg) })
+	}
+
+	for _, plCfg := range cfg.Pipelines {
+		cluster := plCfg.Cluster
+		if cluster == "" {
+			cluster = cfg.DefaultCluster
+		}
+		pxy := s.proxyFor(cluster)
+		if pxy == nil {
+			// Only possible in the degraded startup mode: the cluster is
+			// configured but its proxy has not connected yet.
+			s.actDesc.Log().Errorf("Pipeline is not started, proxy is not ready: name=%s, cluster=%s",
+				plCfg.Name, cluster)
+			continue
+		}
+		pl, err := pipeline.Spawn(s.actDesc, plCfg, pxy)
 		if err != nil {
+			s.stopPipelines()
 			s.stopProxies()
-			return nil, errors.Wrapf(err, "failed to spawn proxy, name=%s", cluster)
+			return nil, errors.Wrapf(err, "failed to spawn pipeline, name=%s", plCfg.Name)
 		}
-		s.proxies[cluster] = pxy
+		s.pipelines = append(s.pipelines, pl)
 	}
 
-	proxySet := proxy.NewSet(s.proxies, s.proxies[cfg.DefaultCluster])
+	for _, whCfg := range cfg.Webhooks {
+		cluster := whCfg.Cluster
+		if cluster == "" {
+			cluster = cfg.DefaultCluster
+		}
+		pxy := s.proxyFor(cluster)
+		if pxy == nil {
+			s.actDesc.Log().Errorf("Webhook is not started, proxy is not ready: name=%s, cluster=%s",
+				whCfg.Name, cluster)
+			continue
+		}
+		s.webhooks = append(s.webhooks, webhook.Spawn(s.actDesc, whCfg, pxy))
+	}
 
 	if cfg.GRPCAddr != "" {
 		opts := cfg.GRPCServerOpts
@@ -57,7 +167,7 @@ func Spawn(cfg *config.App) (*T, error) {
 		s.servers = append(s.servers, grpcSrv)
 	}
 	if cfg.TCPAddr != "" {
-		tcpSrv, err := httpsrv.New(cfg.TCPAddr, cfg.HTTPMiddlewareFuncs, proxySet, cfg.TLS.CertPath, cfg.TLS.KeyPath)
+		tcpSrv, err := httpsrv.New(cfg.TCPAddr, cfg.HTTPMiddlewareFuncs, proxySet, cfg, cfg.TLS.CertPath, cfg.TLS.KeyPath)
 		if err != nil {
 			s.stopProxies()
 			return nil, errors.Wrap(err, "failed to start TCP socket based HTTP API server")
@@ -65,7 +175,7 @@ func Spawn(cfg *config.App) (*T, error) {
 		s.servers = append(s.servers, tcpSrv)
 	}
 	if cfg.UnixAddr != "" {
-		unixSrv, err := httpsrv.New(cfg.UnixAddr, cfg.HTTPMiddlewareFuncs, proxySet, "", "")
+		unixSrv, err := httpsrv.New(cfg.UnixAddr, cfg.HTTPMiddlewareFuncs, proxySet, cfg, "", "")
 		if err != nil {
 			s.stopProxies()
 			return nil, errors.Wrapf(err, "failed to start Unix socket based HTTP API server")
@@ -73,19 +183,168 @@ func Spawn(cfg *config.App) (*T, error) {
 		s.servers = append(s.servers, unixSrv)
 	}
 
+	// If no API servers are configured then the service runs in library mode,
+	// serving only in-process calls made via the Proxy accessor.
 	if len(s.servers) == 0 {
-		return nil, errors.Errorf("at least one API server should be configured")
+		s.actDesc.Log().Info("No API servers configured, running in library mode")
 	}
 
 	actor.Spawn(s.actDesc, &s.wg, s.run)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.beginStop()
+		case <-s.stopCh:
+		}
+	}()
 	return s, nil
 }
 
+// Proxy returns the proxy serving the specified cluster for in-process use
+// by an embedding application, or the default cluster proxy if cluster is an
+// empty string. Nil is returned if the cluster is not configured.
+func (s *T) Proxy(cluster string) *proxy.T {
+	if cluster == "" {
+		cluster = s.defaultCluster
+	}
+	return s.proxyFor(cluster)
+}
+
+func (s *T) proxyFor(cluster string) *proxy.T {
+	s.proxiesMu.Lock()
+	defer s.proxiesMu.Unlock()
+	return s.proxies[cluster]
+}
+
+// RestartProxy stops the proxy serving the cluster and spawns a replacement
+// from the same configuration. Only the one proxy is affected, other clusters
+// keep being served while the restart is in progress. If the replacement
+// fails to connect, then the cluster is reported as not ready and spawning
+// keeps being retried in the background, just like in the degraded startup
+// mode.
+func (s *T) RestartProxy(cluster string) error {
+	pxyCfg, ok := s.proxyCfgs[cluster]
+	if !ok {
+		return errors.Errorf("proxy `%s` does not exist", cluster)
+	}
+	s.restartMu.Lock()
+	defer s.restartMu.Unlock()
+
+	s.proxiesMu.Lock()
+	pxy := s.proxies[cluster]
+	delete(s.proxies, cluster)
+	s.proxiesMu.Unlock()
+	s.proxySet.MarkPending(cluster)
+	if pxy != nil {
+		s.actDesc.Log().Infof("Restarting proxy: name=%s", cluster)
+		pxy.BeginDraining()
+		pxy.Stop()
+	}
+
+	newPxy, err := proxy.Spawn(actor.Root(), cluster, pxyCfg)
+	if err != nil {
+		actor.Spawn(s.actDesc.NewChild(fmt.Sprintf("%s_pxy_spawn", cluster)), &s.wg,
+			func() { s.retrySpawnProxy(cluster, pxyCfg, s.proxySet) })
+		return errors.Wrapf(err, "failed to respawn proxy, name=%s", cluster)
+	}
+	s.proxiesMu.Lock()
+	s.proxies[cluster] = newPxy
+	s.proxiesMu.Unlock()
+	s.proxySet.Put(cluster, newPxy)
+	return nil
+}
+
+// awaitProxy implements the wait startup mode for a single proxy: failed
+// spawns are retried with exponential backoff until the deadline is reached
+// or ctx is cancelled.
+func (s *T) awaitProxy(ctx context.Context, cluster string, pxyCfg *config.Proxy, deadline time.Time) (*proxy.T, error) {
+	backoff := startupMinBackoff
+	for {
+		pxy, err := proxy.Spawn(actor.Root(), cluster, pxyCfg)
+		if err == nil {
+			return pxy, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		s.actDesc.Log().WithError(err).Warnf("Proxy is not ready, retrying in %v: name=%s",
+			backoff, cluster)
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(err, "startup cancelled")
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > startupMaxBackoff {
+			backoff = startupMaxBackoff
+		}
+	}
+}
+
+// retrySpawnProxy implements the degraded startup mode for a single proxy
+// that failed to spawn at boot: it keeps retrying in the background until the
+// proxy connects, adding it to the proxy set on success, or until the service
+// is stopped.
+func (s *T) retrySpawnProxy(cluster string, pxyCfg *config.Proxy, proxySet *proxy.Set) {
+	backoff := startupMinBackoff
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-time.After(backoff):
+		}
+		pxy, err := proxy.Spawn(actor.Root(), cluster, pxyCfg)
+		if err == nil {
+			s.proxiesMu.Lock()
+			select {
+			case <-s.stopCh:
+				// The service began to stop while the proxy was connecting,
+				// it is too late to join the set.
+				s.proxiesMu.Unlock()
+				pxy.Stop()
+			default:
+				s.proxies[cluster] = pxy
+				s.proxiesMu.Unlock()
+				proxySet.Put(cluster, pxy)
+				s.actDesc.Log().Infof("Proxy is ready: name=%s", cluster)
+			}
+			return
+		}
+		s.actDesc.Log().WithError(err).Warnf("Proxy is still not ready, retrying in %v: name=%s",
+			backoff, cluster)
+		if backoff *= 2; backoff > startupMaxBackoff {
+			backoff = startupMaxBackoff
+		}
+	}
+}
+
+// Stop begins a graceful shutdown and blocks until it completes.
 func (s *T) Stop() {
-	close(s.stopCh)
+	s.beginStop()
 	s.wg.Wait()
 }
 
+// Shutdown begins a graceful shutdown and blocks until it completes or ctx
+// expires, in which case the ctx error is returned while the shutdown keeps
+// going in the background.
+func (s *T) Shutdown(ctx context.Context) error {
+	s.beginStop()
+	doneCh := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *T) beginStop() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+}
+
 // run implements main supervisor loop, that boils down to starting all
 // configured API servers, waiting for a stop signal and terminating everything
 // gracefully.
@@ -112,6 +371,20 @@ func (s *T) run() {
 
 	s.actDesc.Log().Info("Shutting down")
 
+	// Tell consumers to re-poll another instance before proxies are stopped,
+	// so that open long polling requests are not cut off abruptly.
+	s.proxiesMu.Lock()
+	for _, pxy := range s.proxies {
+		pxy.BeginDraining()
+	}
+	s.proxiesMu.Unlock()
+
+	// Stop bridge pipelines and webhooks, for they are proxy clients
+	// themselves and must not be consuming while the proxies are being
+	// stopped.
+	s.stopPipelines()
+	s.stopWebhooks()
+
 	// Stop all proxies first. It is important to keep API servers running
 	// so that offered messages can be acknowledged by consumers.
 	s.stopProxies()
@@ -126,10 +399,29 @@ func (s *T) run() {
 	s.actDesc.Log().Info("All API servers shutdown")
 }
 
+func (s *T) stopPipelines() {
+	for _, pl := range s.pipelines {
+		pl.Stop()
+	}
+	s.pipelines = nil
+}
+
+func (s *T) stopWebhooks() {
+	for _, wh := range s.webhooks {
+		wh.Stop()
+	}
+	s.webhooks = nil
+}
+
 func (s *T) stopProxies() {
+	// Make background proxy spawn retries of the degraded startup mode give
+	// up, so that no new proxy joins the set while it is being stopped.
+	s.beginStop()
 	var wg sync.WaitGroup
+	s.proxiesMu.Lock()
 	for pxyAlias, pxy := range s.proxies {
 		actor.Spawn(s.actDesc.NewChild(fmt.Sprintf("%s_pxy_stop", pxyAlias)), &wg, pxy.Stop)
 	}
+	s.proxiesMu.Unlock()
 	wg.Wait()
 }
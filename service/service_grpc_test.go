@@ -57,7 +57,7 @@ func (s *ServiceGRPCSuite) TearDownTest(c *C) {
 // If `key` is explicitly specified produced messages are deterministically
 // distributed between partitions.
 func (s *ServiceGRPCSuite) TestProduceWithKey(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	s.waitSvcUp(c, 5*time.Second)
 
@@ -91,7 +91,7 @@ func (s *ServiceGRPCSuite) TestProduceWithKey(c *C) {
 
 // If `key` is undefined then a message is submitted to a random partition.
 func (s *ServiceGRPCSuite) TestProduceKeyUndefined(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	s.waitSvcUp(c, 5*time.Second)
 
@@ -126,7 +126,7 @@ func (s *ServiceGRPCSuite) TestProduceKeyUndefined(c *C) {
 // If `key` is explicitly specified produced messages are deterministically
 // distributed between partitions.
 func (s *ServiceGRPCSuite) TestProduceDefaultKey(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	s.waitSvcUp(c, 5*time.Second)
 
@@ -160,7 +160,7 @@ func (s *ServiceGRPCSuite) TestProduceDefaultKey(c *C) {
 // If a message is produced in synchronous mode then partition and offset
 // returned in response are set to proper values.
 func (s *ServiceGRPCSuite) TestProduceSync(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 	s.waitSvcUp(c, 5*time.Second)
@@ -184,7 +184,7 @@ func (s *ServiceGRPCSuite) TestProduceSync(c *C) {
 }
 
 func (s *ServiceGRPCSuite) TestProduceInvalidProxy(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 	s.waitSvcUp(c, 5*time.Second)
@@ -214,7 +214,7 @@ func (s *ServiceGRPCSuite) TestProduceHeadersUnsupported(c *C) {
 		c.Skip("Headers are supported on new Kafka")
 	}
 
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 	s.waitSvcUp(c, 5*time.Second)
@@ -243,7 +243,7 @@ func (s *ServiceGRPCSuite) TestProduceHeadersUnsupported(c *C) {
 
 // Offsets of messages consumed in auto-ack mode are properly committed.
 func (s *ServiceGRPCSuite) TestConsumeAutoAck(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	s.waitSvcUp(c, 5*time.Second)
 
@@ -284,7 +284,7 @@ func (s *ServiceGRPCSuite) TestConsumeAutoAck(c *C) {
 func (s *ServiceGRPCSuite) TestConsumeNoAck(c *C) {
 	s.proxyCfg.Consumer.AckTimeout = 500 * time.Millisecond
 	s.proxyCfg.Consumer.SubscriptionTimeout = 500 * time.Millisecond
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	s.waitSvcUp(c, 5*time.Second)
 
@@ -312,7 +312,7 @@ func (s *ServiceGRPCSuite) TestConsumeNoAck(c *C) {
 
 // Offsets of messages consumed in auto-ack mode are properly committed.
 func (s *ServiceGRPCSuite) TestGetOffsets(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	s.waitSvcUp(c, 5*time.Second)
 
@@ -352,7 +352,7 @@ func (s *ServiceGRPCSuite) TestGetOffsets(c *C) {
 // This test shows how message consumption loop with explicit acks should look
 // like.
 func (s *ServiceGRPCSuite) TestConsumeExplicitAck(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	s.waitSvcUp(c, 5*time.Second)
 
@@ -412,7 +412,7 @@ func (s *ServiceGRPCSuite) TestConsumeExplicitAck(c *C) {
 }
 
 func (s *ServiceGRPCSuite) TestConsumeExplicitProxy(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 	s.waitSvcUp(c, 5*time.Second)
@@ -447,7 +447,7 @@ func (s *ServiceGRPCSuite) TestConsumeExplicitProxy(c *C) {
 // When a message that was produced with undefined key is consumed, then
 // KeyUndefined is set in the consume response.
 func (s *ServiceGRPCSuite) TestConsumeKeyUndefined(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 	s.waitSvcUp(c, 5*time.Second)
@@ -486,7 +486,7 @@ func (s *ServiceGRPCSuite) TestConsumeHeaders(c *C) {
 		c.Skip("Headers not supported before Kafka v0.11")
 	}
 
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 	s.waitSvcUp(c, 5*time.Second)
@@ -524,7 +524,7 @@ func (s *ServiceGRPCSuite) TestConsumeHeaders(c *C) {
 
 func (s *ServiceGRPCSuite) TestConsumeInvalidProxy(c *C) {
 	s.cfg.Proxies[s.cfg.DefaultCluster].Consumer.LongPollingTimeout = 100 * time.Millisecond
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 	s.waitSvcUp(c, 5*time.Second)
@@ -549,7 +549,7 @@ func (s *ServiceGRPCSuite) TestConsumeInvalidProxy(c *C) {
 
 func (s *ServiceGRPCSuite) TestConsumeDisabled(c *C) {
 	s.proxyCfg.Consumer.Disabled = true
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 	s.waitSvcUp(c, 5*time.Second)
@@ -644,7 +644,7 @@ func spawnGRPCSvc(c *C, index int, memberID string) (*T, pb.KafkaPixyClient) {
 	proxyCfg.Consumer.OffsetsCommitInterval = 50 * time.Millisecond
 	cfg.Proxies["default"] = proxyCfg
 	cfg.DefaultCluster = "default"
-	svc, err := Spawn(cfg)
+	svc, err := Spawn(context.Background(), cfg)
 	c.Assert(err, IsNil)
 
 	cltConn, err := grpc.Dial(cfg.GRPCAddr, grpc.WithInsecure())
@@ -656,7 +656,7 @@ func spawnGRPCSvc(c *C, index int, memberID string) (*T, pb.KafkaPixyClient) {
 // Attempts to consume from a missing topic do not disrupt consumption from an
 // existing one (Issue #54)[https://github.com/mailgun/kafka-pixy/issues/54].
 func (s *ServiceGRPCSuite) TestConsumeMissingTopic(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	s.waitSvcUp(c, 5*time.Second)
 
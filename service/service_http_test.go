@@ -2,6 +2,7 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -79,7 +80,7 @@ func (s *ServiceHTTPSuite) TestInvalidUnixAddr(c *C) {
 	s.cfg.UnixAddr = "/tmp"
 
 	// When
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 
 	// Then
 	c.Check(err.Error(), Equals, "failed to start Unix socket based HTTP API server: "+
@@ -93,7 +94,7 @@ func (s *ServiceHTTPSuite) TestInvalidKafkaPeers(c *C) {
 	s.cfg.Proxies[s.cfg.DefaultCluster].Kafka.SeedPeers = []string{"localhost:12345"}
 
 	// When
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 
 	// Then
 	c.Check(err.Error(), Equals, "failed to spawn proxy, name=pxyH: "+
@@ -105,7 +106,7 @@ func (s *ServiceHTTPSuite) TestInvalidKafkaPeers(c *C) {
 // If `key` is not `nil` then produced messages are deterministically
 // distributed between partitions based on the `key` hash.
 func (s *ServiceHTTPSuite) TestProduce(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 
@@ -136,7 +137,7 @@ func (s *ServiceHTTPSuite) TestProduce(c *C) {
 // partition. Therefore a batch of such messages is evenly distributed among
 // all available partitions.
 func (s *ServiceHTTPSuite) TestProduceNilKey(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 
@@ -159,7 +160,7 @@ func (s *ServiceHTTPSuite) TestProduceNilKey(c *C) {
 // If `key` of a produced message is empty then it is deterministically
 // submitted to a particular partition determined by the empty key hash.
 func (s *ServiceHTTPSuite) TestProduceEmptyKey(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 
@@ -180,7 +181,7 @@ func (s *ServiceHTTPSuite) TestProduceEmptyKey(c *C) {
 
 // Utf8 messages are submitted without a problem.
 func (s *ServiceHTTPSuite) TestUtf8Message(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 
@@ -202,7 +203,7 @@ func (s *ServiceHTTPSuite) TestProduceHeaders(c *C) {
 		c.Skip("Headers not supported before Kafka v0.11")
 	}
 
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	offsetsBefore := s.kh.GetNewestOffsets("test.1")
 
@@ -229,7 +230,7 @@ func (s *ServiceHTTPSuite) TestProduceInvalidHeaders(c *C) {
 		c.Skip("Headers not supported before Kafka v0.11")
 	}
 
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -256,7 +257,7 @@ func (s *ServiceHTTPSuite) TestProduceHeadersUnsupported(c *C) {
 		c.Skip("Headers are supported after Kafka v0.11")
 	}
 
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -278,7 +279,7 @@ func (s *ServiceHTTPSuite) TestProduceHeadersUnsupported(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestProduceXWWWFormUrlencoded(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	offsetsBefore := s.kh.GetNewestOffsets("test.1")
 
@@ -299,7 +300,7 @@ func (s *ServiceHTTPSuite) TestProduceXWWWFormUrlencoded(c *C) {
 func (s *ServiceHTTPSuite) TestBothAPI(c *C) {
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 	s.cfg.TCPAddr = "127.0.0.1:55501"
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 
 	// When
@@ -322,7 +323,7 @@ func (s *ServiceHTTPSuite) TestBothAPI(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestStoppedServerCall(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	_, err = s.unixClient.Post("http://_/topics/test.4/messages?key=foo",
 		"text/plain", strings.NewReader("Hello"))
@@ -359,7 +360,7 @@ func (s *ServiceHTTPSuite) TestLargestMessage(c *C) {
 
 	msg := GenMessage(maxMsgSize)
 	s.cfg.TCPAddr = "127.0.0.1:55501"
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 
 	// When
@@ -383,7 +384,7 @@ func (s *ServiceHTTPSuite) TestMessageTooLarge(c *C) {
 	maxMsgSize := sarama.NewConfig().Producer.MaxMessageBytes - ProdMsgMetadataSize([]byte("foo")) + 1
 	msg := GenMessage(maxMsgSize)
 	s.cfg.TCPAddr = "127.0.0.1:55501"
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 
 	// When
@@ -398,7 +399,7 @@ func (s *ServiceHTTPSuite) TestMessageTooLarge(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestSyncProduce(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 
@@ -418,7 +419,7 @@ func (s *ServiceHTTPSuite) TestSyncProduce(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestSyncProduceInvalidTopic(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -434,7 +435,7 @@ func (s *ServiceHTTPSuite) TestSyncProduceInvalidTopic(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestConsumeNoGroup(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -449,7 +450,7 @@ func (s *ServiceHTTPSuite) TestConsumeNoGroup(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestConsumeManyGroups(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -464,7 +465,7 @@ func (s *ServiceHTTPSuite) TestConsumeManyGroups(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestConsumeInvalidTopic(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -480,7 +481,7 @@ func (s *ServiceHTTPSuite) TestConsumeInvalidTopic(c *C) {
 
 // By default auto-ack mode is assumed when consuming.
 func (s *ServiceHTTPSuite) TestConsumeAutoAck(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 
 	s.kh.ResetOffsets("foo", "test.4")
@@ -513,7 +514,7 @@ func (s *ServiceHTTPSuite) TestConsumeAutoAck(c *C) {
 func (s *ServiceHTTPSuite) TestConsumeNoAck(c *C) {
 	s.proxyCfg.Consumer.AckTimeout = 500 * time.Millisecond
 	s.proxyCfg.Consumer.SubscriptionTimeout = 500 * time.Millisecond
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 
 	s.kh.ResetOffsets("foo", "test.1")
@@ -531,7 +532,7 @@ func (s *ServiceHTTPSuite) TestConsumeNoAck(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestConsumeExplicitAck(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 
 	s.kh.ResetOffsets("foo", "test.4")
@@ -580,7 +581,7 @@ func (s *ServiceHTTPSuite) TestConsumeExplicitAck(c *C) {
 
 func (s *ServiceHTTPSuite) TestConsumeDisabled(c *C) {
 	s.proxyCfg.Consumer.Disabled = true
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -601,7 +602,7 @@ func (s *ServiceHTTPSuite) TestConsumeHeaders(c *C) {
 		c.Skip("Headers not supported before Kafka v0.11")
 	}
 
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 
 	s.kh.ResetOffsets("foo", "test.4")
@@ -628,7 +629,7 @@ func (s *ServiceHTTPSuite) TestConsumeHeaders(c *C) {
 // If offsets for a group that does not exist are requested then -1 is returned
 // as the next offset to be consumed for all topic partitions.
 func (s *ServiceHTTPSuite) TestGetOffsetsNoSuchGroup(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -648,7 +649,7 @@ func (s *ServiceHTTPSuite) TestGetOffsetsNoSuchGroup(c *C) {
 
 // An attempt to retrieve offsets for a topic that does not exist fails with 404.
 func (s *ServiceHTTPSuite) TestGetOffsetsNoSuchTopic(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -664,7 +665,7 @@ func (s *ServiceHTTPSuite) TestGetOffsetsNoSuchTopic(c *C) {
 
 // Committed offsets are returned in a following GET request.
 func (s *ServiceHTTPSuite) TestSetOffsets(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -696,7 +697,7 @@ func (s *ServiceHTTPSuite) TestSetOffsets(c *C) {
 // Result of setting offsets for a non-existent topic depends on the Kafka
 // version. It is ok for 0.8, but error for 0.9.x and higher.
 func (s *ServiceHTTPSuite) TestSetOffsetsNoSuchTopic(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -721,7 +722,7 @@ func (s *ServiceHTTPSuite) TestSetOffsetsNoSuchTopic(c *C) {
 
 // Invalid body is detected and properly reported.
 func (s *ServiceHTTPSuite) TestSetOffsetsInvalidBody(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -738,7 +739,7 @@ func (s *ServiceHTTPSuite) TestSetOffsetsInvalidBody(c *C) {
 
 // It is not an error to set an offset for a missing partition.
 func (s *ServiceHTTPSuite) TestSetOffsetsInvalidPartition(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -761,7 +762,7 @@ func (s *ServiceHTTPSuite) TestSetOffsetsInvalidPartition(c *C) {
 // Reported partition lags are correct, including those corresponding to -1 and
 // -2 special case offset values.
 func (s *ServiceHTTPSuite) TestGetOffsetsLag(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 	r, err := s.unixClient.Post("http://_/topics/test.4/offsets?group=foo",
@@ -787,7 +788,7 @@ func (s *ServiceHTTPSuite) TestGetOffsetsLag(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestGetConsumersTopicNotConsumed(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -806,7 +807,7 @@ func (s *ServiceHTTPSuite) TestGetConsumersTopicNotConsumed(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestGetConsumersUnknownGroup(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -823,7 +824,7 @@ func (s *ServiceHTTPSuite) TestGetConsumersUnknownGroup(c *C) {
 func (s *ServiceHTTPSuite) TestGetTopicConsumersOne(c *C) {
 	s.kh.ResetOffsets("foo", "test.4")
 	s.kh.PutMessages("get.consumers", "test.4", map[string]int{"A": 1, "B": 1, "C": 1, "D": 1})
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 	for i := 0; i < 4; i++ {
@@ -942,7 +943,7 @@ func (s *ServiceHTTPSuite) TestGetTopicConsumers(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestGetTopics(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -958,7 +959,7 @@ func (s *ServiceHTTPSuite) TestGetTopics(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestGetTopicsWithPartitions(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -1019,7 +1020,7 @@ func (s *ServiceHTTPSuite) TestGetTopicsWithPartitions(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestGetTopicsWithConfig(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -1063,7 +1064,7 @@ func (s *ServiceHTTPSuite) TestGetTopicsWithConfig(c *C) {
 }
 
 func (s *ServiceHTTPSuite) TestGetTopicsWithPartitionsAndWithConfig(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -1099,7 +1100,7 @@ func (s *ServiceHTTPSuite) TestGetTopicsWithPartitionsAndWithConfig(c *C) {
 // Reported partition lags are correct, including those corresponding to -1 and
 // -2 special case offset values.
 func (s *ServiceHTTPSuite) TestHealthCheck(c *C) {
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -1117,7 +1118,7 @@ func (s *ServiceHTTPSuite) TestHealthCheck(c *C) {
 // Ensure that API endpoints that explicitly select a proxy to operate on work.
 func (s *ServiceHTTPSuite) TestExplicitProxyAPIEndpoints(c *C) {
 	s.kh.ResetOffsets("foo", "test.1")
-	svc, err := Spawn(s.cfg)
+	svc, err := Spawn(context.Background(), s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
@@ -1145,7 +1146,7 @@ func spawnHTTPSvc(c *C, port int) *T {
 	cfg.DefaultCluster = cluster
 	os.Remove(cfg.UnixAddr)
 	cfg.TCPAddr = fmt.Sprintf("127.0.0.1:%d", port)
-	svc, err := Spawn(cfg)
+	svc, err := Spawn(context.Background(), cfg)
 	c.Assert(err, IsNil)
 	return svc
 }
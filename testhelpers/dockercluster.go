@@ -0,0 +1,126 @@
+package testhelpers
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	dockerZookeeperImage = "zookeeper:3.4"
+	dockerKafkaImage     = "wurstmeister/kafka:2.12-2.0.1"
+	dockerBrokerBasePort = 19092
+)
+
+// DockerCluster spins up a ZooKeeper node and a multi-broker Kafka cluster in
+// Docker containers, as an alternative to the vagrant environment, so that
+// consumer-group failover tests can run in CI against real brokers. Brokers
+// can be killed and restarted individually to simulate broker outages.
+//
+// Point the test suite at the cluster by exporting KAFKA_PEERS and
+// ZOOKEEPER_PEERS with the values returned by KafkaPeers and ZookeeperPeers
+// respectively.
+type DockerCluster struct {
+	prefix      string
+	brokerCount int
+}
+
+// NewDockerCluster creates a Docker cluster handle with the specified number
+// of Kafka brokers. The cluster is not started until Start is called.
+func NewDockerCluster(prefix string, brokerCount int) *DockerCluster {
+	return &DockerCluster{prefix: prefix, brokerCount: brokerCount}
+}
+
+// Start creates a Docker network, starts a ZooKeeper container and the Kafka
+// broker containers on it, and waits for the brokers to register with
+// ZooKeeper. It is the caller's responsibility to call Stop when done.
+func (dc *DockerCluster) Start() error {
+	if err := dockerCmd("network", "create", dc.networkName()); err != nil {
+		return errors.Wrap(err, "failed to create network")
+	}
+	err := dockerCmd("run", "-d", "--name", dc.zookeeperName(),
+		"--network", dc.networkName(),
+		"-p", "12181:2181",
+		dockerZookeeperImage)
+	if err != nil {
+		return errors.Wrap(err, "failed to start zookeeper")
+	}
+	for i := 0; i < dc.brokerCount; i++ {
+		port := dockerBrokerBasePort + i
+		err := dockerCmd("run", "-d", "--name", dc.brokerName(i),
+			"--network", dc.networkName(),
+			"-p", fmt.Sprintf("%d:%d", port, port),
+			"-e", fmt.Sprintf("KAFKA_BROKER_ID=%d", i),
+			"-e", fmt.Sprintf("KAFKA_ZOOKEEPER_CONNECT=%s:2181", dc.zookeeperName()),
+			"-e", fmt.Sprintf("KAFKA_LISTENERS=PLAINTEXT://:%d", port),
+			"-e", fmt.Sprintf("KAFKA_ADVERTISED_LISTENERS=PLAINTEXT://localhost:%d", port),
+			"-e", "KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR=1",
+			dockerKafkaImage)
+		if err != nil {
+			return errors.Wrapf(err, "failed to start broker %d", i)
+		}
+	}
+	// Give the brokers some time to register with ZooKeeper. Tests verify
+	// actual readiness by connecting anyway.
+	time.Sleep(5 * time.Second)
+	return nil
+}
+
+// Stop removes all cluster containers and the network. Errors are ignored,
+// for the containers may have been killed by the test already.
+func (dc *DockerCluster) Stop() {
+	for i := 0; i < dc.brokerCount; i++ {
+		dockerCmd("rm", "-f", dc.brokerName(i))
+	}
+	dockerCmd("rm", "-f", dc.zookeeperName())
+	dockerCmd("network", "rm", dc.networkName())
+}
+
+// KillBroker abruptly terminates the i-th broker container, simulating a
+// broker crash. The broker can be brought back with RestartBroker.
+func (dc *DockerCluster) KillBroker(i int) error {
+	return dockerCmd("kill", dc.brokerName(i))
+}
+
+// RestartBroker starts the i-th broker container again after a KillBroker.
+func (dc *DockerCluster) RestartBroker(i int) error {
+	return dockerCmd("start", dc.brokerName(i))
+}
+
+// KafkaPeers returns the advertised addresses of all brokers in the cluster.
+func (dc *DockerCluster) KafkaPeers() []string {
+	peers := make([]string, dc.brokerCount)
+	for i := 0; i < dc.brokerCount; i++ {
+		peers[i] = fmt.Sprintf("localhost:%d", dockerBrokerBasePort+i)
+	}
+	return peers
+}
+
+// ZookeeperPeers returns the address of the ZooKeeper node.
+func (dc *DockerCluster) ZookeeperPeers() []string {
+	return []string{"localhost:12181"}
+}
+
+func (dc *DockerCluster) networkName() string {
+	return dc.prefix + "-net"
+}
+
+func (dc *DockerCluster) zookeeperName() string {
+	return dc.prefix + "-zk"
+}
+
+func (dc *DockerCluster) brokerName(i int) string {
+	return fmt.Sprintf("%s-kafka-%d", dc.prefix, i)
+}
+
+func dockerCmd(args ...string) error {
+	out, err := exec.Command("docker", args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "docker %s: %s",
+			strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Package tracing provides helpers to propagate W3C trace context through
+// Kafka record headers. On produce the caller's traceparent is injected as a
+// `traceparent` record header, and on consume it is extracted from the record
+// headers so that API frontends can surface it to clients. See
+// https://www.w3.org/TR/trace-context/ for the header format specification.
+package tracing
+
+import (
+	"regexp"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+)
+
+// HeaderName is the name of both the W3C HTTP header and the Kafka record
+// header that carry trace context.
+const HeaderName = "traceparent"
+
+// traceparentRe matches the version 00 traceparent format, that is
+// `<version>-<trace-id>-<parent-id>-<trace-flags>` in lower case hex.
+var traceparentRe = regexp.MustCompile("^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$")
+
+// IsValidTraceparent tells whether the passed value is a well formed
+// traceparent header value.
+func IsValidTraceparent(traceparent string) bool {
+	return traceparentRe.MatchString(traceparent)
+}
+
+// InjectTraceparent returns headers with a `traceparent` record header added.
+// If the headers already contain a traceparent, e.g. explicitly provided by
+// the client, then they are returned unmodified, for the client-provided
+// value takes precedence over the transport-derived one.
+func InjectTraceparent(traceparent string, headers []kafkaclt.Header) []kafkaclt.Header {
+	for _, h := range headers {
+		if string(h.Key) == HeaderName {
+			return headers
+		}
+	}
+	return append(headers, kafkaclt.Header{
+		Key:   []byte(HeaderName),
+		Value: []byte(traceparent),
+	})
+}
+
+// ExtractTraceparent returns the value of the `traceparent` record header of a
+// consumed message, or "" if the message does not carry trace context.
+func ExtractTraceparent(headers []*sarama.RecordHeader) string {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == HeaderName {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/kafkaclt"
+	. "gopkg.in/check.v1"
+)
+
+type TracingSuite struct {
+}
+
+var _ = Suite(&TracingSuite{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+func (s *TracingSuite) TestIsValidTraceparent(c *C) {
+	for i, tc := range []struct {
+		traceparent string
+		valid       bool
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", true},
+		{"00-0AF7651916CD43DD8448EB211C80319C-B7AD6B7169203331-01", false},
+		{"0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331", false},
+		{"", false},
+		{"bogus", false},
+	} {
+		c.Assert(IsValidTraceparent(tc.traceparent), Equals, tc.valid,
+			Commentf("case #%d: %s", i, tc.traceparent))
+	}
+}
+
+func (s *TracingSuite) TestInjectTraceparent(c *C) {
+	traceparent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+	// When: injected into headers that carry no trace context.
+	headers := InjectTraceparent(traceparent, []kafkaclt.Header{
+		{Key: []byte("foo"), Value: []byte("bar")},
+	})
+
+	// Then: a traceparent header is appended.
+	c.Assert(headers, DeepEquals, []kafkaclt.Header{
+		{Key: []byte("foo"), Value: []byte("bar")},
+		{Key: []byte(HeaderName), Value: []byte(traceparent)},
+	})
+}
+
+func (s *TracingSuite) TestInjectTraceparentExisting(c *C) {
+	explicit := "00-11111111111111111111111111111111-2222222222222222-01"
+
+	// When: injected into headers that already carry trace context.
+	headers := InjectTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		[]kafkaclt.Header{
+			{Key: []byte(HeaderName), Value: []byte(explicit)},
+		})
+
+	// Then: the explicitly provided value is preserved.
+	c.Assert(headers, DeepEquals, []kafkaclt.Header{
+		{Key: []byte(HeaderName), Value: []byte(explicit)},
+	})
+}
+
+func (s *TracingSuite) TestExtractTraceparent(c *C) {
+	traceparent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	c.Assert(ExtractTraceparent([]*sarama.RecordHeader{
+		{Key: []byte("foo"), Value: []byte("bar")},
+		{Key: []byte(HeaderName), Value: []byte(traceparent)},
+	}), Equals, traceparent)
+	c.Assert(ExtractTraceparent(nil), Equals, "")
+}
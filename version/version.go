@@ -0,0 +1,14 @@
+// Package version carries the build identity of the kafka-pixy binary. The
+// variables are overridden at build time via:
+//
+//	-ldflags "-X github.com/mailgun/kafka-pixy/version.Version=v1.2.3 \
+//	          -X github.com/mailgun/kafka-pixy/version.GitCommit=0123abc"
+package version
+
+var (
+	// Version is the release the binary was built from.
+	Version = "dev-build"
+
+	// GitCommit is the git commit hash the binary was built from.
+	GitCommit = ""
+)
@@ -0,0 +1,156 @@
+// Package webhook implements push delivery of consumed messages over HTTP.
+// A webhook consumes messages from a topic on behalf of a consumer group and
+// POSTs each of them to a configured endpoint. A 2xx response acknowledges
+// the message; any other outcome leaves it unacknowledged, so the consumer
+// machinery re-offers it and, if a dead letter topic is configured, routes it
+// there after max_retries expired offers. That way serverless and
+// webhook-only consumers receive Kafka messages without polling.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/pkg/errors"
+)
+
+// How long to wait before polling again after a consume or delivery error.
+const retryInterval = 3 * time.Second
+
+// Header keys attached to delivery requests to describe the message origin.
+const (
+	hdrTopic     = "X-Kafka-Pixy-Topic"
+	hdrPartition = "X-Kafka-Pixy-Partition"
+	hdrOffset    = "X-Kafka-Pixy-Offset"
+	hdrKey       = "X-Kafka-Pixy-Key"
+)
+
+// T is a running webhook push delivery subscription.
+type T struct {
+	actDesc *actor.Descriptor
+	cfg     config.WebhookCfg
+	pxy     *proxy.T
+	htCli   *http.Client
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// Spawn creates a webhook instance delivering messages consumed via the
+// specified proxy and starts its goroutine.
+func Spawn(parentActDesc *actor.Descriptor, cfg config.WebhookCfg, pxy *proxy.T) *T {
+	w := &T{
+		actDesc: parentActDesc.NewChild(fmt.Sprintf("webhook_%s", cfg.Name)),
+		cfg:     cfg,
+		pxy:     pxy,
+		htCli:   &http.Client{Timeout: cfg.Timeout},
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	actor.Spawn(w.actDesc, &w.wg, w.run)
+	return w
+}
+
+// Stop signals the webhook to stop and blocks until it does.
+func (w *T) Stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+func (w *T) run() {
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		default:
+		}
+		msg, err := w.pxy.Consume(w.ctx, w.cfg.Group, w.cfg.Topic, proxy.NoAck())
+		if err != nil {
+			switch errors.Cause(err) {
+			case consumer.ErrRequestTimeout:
+				// No messages at the moment, keep polling.
+			case proxy.ErrUnavailable, proxy.ErrDraining, context.Canceled:
+				return
+			default:
+				w.actDesc.Log().WithError(err).Error("Failed to consume")
+				metrics.Inc("webhook.errors")
+				w.sleep(retryInterval)
+			}
+			continue
+		}
+		if !w.deliver(msg) {
+			// Do not acknowledge the message, so that it is re-offered by
+			// the offset tracker and delivery is attempted again.
+			continue
+		}
+		metrics.Inc("webhook.delivered")
+		w.ack(msg)
+	}
+}
+
+// deliver POSTs the message to the configured endpoint and reports whether
+// the endpoint accepted it with a 2xx status.
+func (w *T) deliver(msg consumer.Message) bool {
+	rq, err := http.NewRequest(http.MethodPost, w.cfg.URL, bytes.NewReader(msg.Value))
+	if err != nil {
+		w.actDesc.Log().WithError(err).Error("Failed to create delivery request")
+		metrics.Inc("webhook.errors")
+		w.sleep(retryInterval)
+		return false
+	}
+	rq.Header.Set("Content-Type", w.cfg.ContentType)
+	rq.Header.Set(hdrTopic, msg.Topic)
+	rq.Header.Set(hdrPartition, strconv.FormatInt(int64(msg.Partition), 10))
+	rq.Header.Set(hdrOffset, strconv.FormatInt(msg.Offset, 10))
+	if msg.Key != nil {
+		rq.Header.Set(hdrKey, string(msg.Key))
+	}
+	rs, err := w.htCli.Do(rq)
+	if err != nil {
+		w.actDesc.Log().WithError(err).Errorf("Delivery failed: url=%s", w.cfg.URL)
+		metrics.Inc("webhook.errors")
+		w.sleep(retryInterval)
+		return false
+	}
+	rs.Body.Close()
+	if rs.StatusCode < 200 || rs.StatusCode > 299 {
+		w.actDesc.Log().Errorf("Delivery rejected: url=%s, status=%d",
+			w.cfg.URL, rs.StatusCode)
+		metrics.Inc("webhook.rejected")
+		w.sleep(retryInterval)
+		return false
+	}
+	return true
+}
+
+// ack commits the message offset. Ack failures are only logged, for the worst
+// that can happen is that the message is delivered again.
+func (w *T) ack(msg consumer.Message) {
+	ack, err := proxy.NewAck(msg.Partition, msg.Offset)
+	if err != nil {
+		w.actDesc.Log().WithError(err).Error("Failed to create ack")
+		return
+	}
+	if err := w.pxy.Ack(w.cfg.Group, w.cfg.Topic, ack); err != nil {
+		w.actDesc.Log().WithError(err).Errorf(
+			"Failed to ack: partition=%d, offset=%d", msg.Partition, msg.Offset)
+	}
+}
+
+// sleep blocks for the specified duration, but returns early if the webhook
+// is signalled to stop.
+func (w *T) sleep(d time.Duration) {
+	select {
+	case <-w.ctx.Done():
+	case <-time.After(d):
+	}
+}